@@ -0,0 +1,143 @@
+// Command mockplatform stands in for a delivery platform while developing and
+// testing the platform webhooks. It sends a signed sample order to a platform's
+// webhook, then serves the platform's callback URL and logs the status updates
+// it receives, checking their signatures.
+//
+//	go run ./cmd/mockplatform -platform-id <id> -secret <secret> -items burger:2+cheese,cola
+//
+// Point the platform's callback URL at http://localhost:9090/callback.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"nano_food_api/platforms"
+)
+
+// parseItems reads items written as id[:quantity][+option...], separated by
+// commas.
+func parseItems(value string) ([]platforms.ExternalItem, error) {
+	items := []platforms.ExternalItem{}
+	for _, part := range strings.Split(value, ",") {
+		fields := strings.Split(strings.TrimSpace(part), "+")
+		id, quantity := fields[0], 1
+		if i := strings.Index(id, ":"); i >= 0 {
+			var err error
+			if quantity, err = strconv.Atoi(id[i+1:]); err != nil {
+				return nil, fmt.Errorf("invalid quantity in %s", part)
+			}
+			id = id[:i]
+		}
+		if id == "" {
+			return nil, fmt.Errorf("missing item ID in %q", part)
+		}
+
+		item := platforms.ExternalItem{External_ID: id, Quantity: quantity}
+		for _, option := range fields[1:] {
+			item.Options = append(item.Options, platforms.ExternalItem{External_ID: option, Quantity: 1})
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func main() {
+	apiURL := flag.String("api", "http://localhost:8000", "base URL of the API")
+	platformName := flag.String("platform", "generic", "adapter to speak: "+strings.Join(platforms.Names(), ", "))
+	platformID := flag.String("platform-id", "", "ID of the delivery platform in the API")
+	secret := flag.String("secret", "", "webhook secret of the delivery platform")
+	listen := flag.String("listen", ":9090", "address to serve callbacks on")
+	itemsFlag := flag.String("items", "", "items to order, e.g. burger:2+cheese,cola")
+	orderType := flag.String("type", platforms.OrderTypeDelivery, "delivery or pickup")
+	orderID := flag.String("order-id", "", "platform order ID, random by default")
+	cancelOrder := flag.Bool("cancel", false, "send a cancellation of -order-id instead of an order")
+	flag.Parse()
+
+	adapter, ok := platforms.Get(*platformName)
+	if !ok {
+		log.Fatalf("Unknown platform %s", *platformName)
+	}
+	if *platformID == "" || *secret == "" {
+		log.Fatalf("-platform-id and -secret are required")
+	}
+
+	http.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !platforms.VerifySignature(*secret, r.Header.Get(adapter.TimestampHeader()), body, r.Header.Get(adapter.SignatureHeader()), time.Now()) {
+			log.Printf("Rejected callback with an invalid signature: %s", body)
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		externalID, status, err := adapter.ParseStatus(body)
+		if err != nil {
+			log.Printf("Rejected callback %s: %v", body, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Order %s is now %s", externalID, status)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	go func() {
+		log.Printf("Serving callbacks on %s/callback", *listen)
+		log.Fatal(http.ListenAndServe(*listen, nil))
+	}()
+
+	order := platforms.ExternalOrder{
+		External_ID:    *orderID,
+		Cancelled:      *cancelOrder,
+		Order_Type:     *orderType,
+		Customer_Name:  "Test Customer",
+		Customer_Phone: "0123456789",
+		Address:        "1 Test Street",
+		Latitude:       11.5564,
+		Longitude:      104.9282,
+		Delivery_Fee:   1.5,
+	}
+	if order.External_ID == "" {
+		order.External_ID = fmt.Sprintf("MOCK-%d", time.Now().UnixNano())
+	}
+	if !order.Cancelled {
+		items, err := parseItems(*itemsFlag)
+		if err != nil {
+			log.Fatalf("Invalid -items: %v", err)
+		}
+		order.Items = items
+	}
+
+	body, err := adapter.EncodeOrder(order)
+	if err != nil {
+		log.Fatalf("Error encoding order: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(*apiURL, "/")+"/webhooks/platform/"+*platformID, bytes.NewReader(body))
+	if err != nil {
+		log.Fatalf("Error creating request: %v", err)
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(adapter.TimestampHeader(), strconv.FormatInt(timestamp, 10))
+	req.Header.Set(adapter.SignatureHeader(), platforms.Sign(*secret, timestamp, body))
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Fatalf("Error sending order: %v", err)
+	}
+	response, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	log.Printf("Sent order %s, API responded %d: %s", order.External_ID, resp.StatusCode, response)
+
+	select {}
+}
@@ -2,7 +2,6 @@ package controllers
 
 import (
 	"context"
-	"fmt"
 	"net/http"
 	"time"
 
@@ -18,6 +17,16 @@ import (
 
 var AddOnCollection *mongo.Collection = database.AddOnCollection
 
+// addOnBranchID returns the branch of the menu an add-on belongs to, or an empty
+// string for add-ons whose menu was deleted.
+func addOnBranchID(ctx context.Context, addOn models.AddOn) string {
+	var menu models.Menu
+	if err := MenuCollection.FindOne(ctx, bson.M{"_id": addOn.Menu_ID}).Decode(&menu); err != nil {
+		return ""
+	}
+	return menu.Branch_ID
+}
+
 func AddMenuAddOn() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
@@ -44,38 +53,16 @@ func AddMenuAddOn() gin.HandlerFunc {
 			return
 		}
 
-		// Upload add-on image
-		app, err := helpers.InitializeFirebaseApp()
-		if err != nil {
-			c.JSON(
-				http.StatusInternalServerError,
-				gin.H{
-					"success": false,
-					"error":   "Failed to initialize Firebase",
-					"details": err.Error(),
-				},
-			)
-			return
-		}
-
-		client, err := app.Storage(context.Background())
-		if err != nil {
-			c.JSON(
-				http.StatusInternalServerError,
-				gin.H{
-					"success": false,
-					"error":   "Failed to connect to Firebase Storage",
-					"details": err.Error(),
-				},
-			)
+		if !authorizeBranch(c, existingMenu.Branch_ID) {
 			return
 		}
 
+		// Upload add-on image
 		var addOnImageURL string
 		addOnImageFile, addOnImageHeader, err := c.Request.FormFile("cover")
 		if err == nil {
 			defer addOnImageFile.Close()
-			addOnImageURL, err = helpers.UploadFileToFirebase(client, addOnImageFile, fmt.Sprintf("addons/%d_%s", time.Now().Unix(), addOnImageHeader.Filename))
+			addOnImageURL, err = storeUpload("addons", addOnImageFile, addOnImageHeader)
 			if err != nil {
 				c.JSON(
 					http.StatusInternalServerError,
@@ -122,39 +109,13 @@ func AddMenuAddOn() gin.HandlerFunc {
 
 func UpdateMenuAddOn() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		app, err := helpers.InitializeFirebaseApp()
-		if err != nil {
-			c.JSON(
-				http.StatusInternalServerError,
-				gin.H{
-					"success": false,
-					"error":   "Failed to initialize Firebase",
-					"details": err.Error(),
-				},
-			)
-			return
-		}
-
-		client, err := app.Storage(context.Background())
-		if err != nil {
-			c.JSON(
-				http.StatusInternalServerError,
-				gin.H{
-					"success": false,
-					"error":   "Failed to connect to Firebase Storage",
-					"details": err.Error(),
-				},
-			)
-			return
-		}
-
 		addOnID := c.Param("add_on_id")
 
 		// Find existing addon
 		var existingAddOn models.AddOn
 		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
-		err = AddOnCollection.FindOne(ctx, bson.M{"_id": addOnID}).Decode(&existingAddOn)
+		err := AddOnCollection.FindOne(ctx, bson.M{"_id": addOnID}).Decode(&existingAddOn)
 		if err != nil {
 			c.JSON(
 				http.StatusNotFound,
@@ -167,6 +128,11 @@ func UpdateMenuAddOn() gin.HandlerFunc {
 			return
 		}
 
+		branchID := addOnBranchID(ctx, existingAddOn)
+		if !authorizeBranch(c, branchID) {
+			return
+		}
+
 		var addOnUpdate models.AddOn
 
 		addOnUpdate.Menu_ID = c.PostForm("menu_id")
@@ -179,7 +145,7 @@ func UpdateMenuAddOn() gin.HandlerFunc {
 		}
 		addOnUpdate.IsAvailable = isAvaliable
 
-		menuExists, err := helpers.CheckDataExist(ctx, database.MenuCollection, bson.M{"_id": addOnUpdate.Menu_ID})
+		menuExists, err := helpers.CheckDataExist(ctx, database.MenuCollection, bson.M{"_id": addOnUpdate.Menu_ID, "branch_id": branchID})
 		if err != nil {
 			c.JSON(
 				http.StatusInternalServerError,
@@ -207,7 +173,7 @@ func UpdateMenuAddOn() gin.HandlerFunc {
 			"is_available": addOnUpdate.IsAvailable,
 		}
 		if addOnUpdate.Menu_ID != "" {
-			updateFields["category_id"] = addOnUpdate.Menu_ID
+			updateFields["menu_id"] = addOnUpdate.Menu_ID
 		}
 		if addOnUpdate.Title != "" {
 			updateFields["title"] = addOnUpdate.Title
@@ -219,21 +185,20 @@ func UpdateMenuAddOn() gin.HandlerFunc {
 			updateFields["price"] = addOnUpdate.Price
 		}
 
+		// Cover replaced by this update, deleted once it is saved
+		var replacedImages []string
+
 		// Handle add on cover update
 		addOnCoverFile, addOnCoverHeader, err := c.Request.FormFile("cover")
 		if err == nil {
 			defer addOnCoverFile.Close()
 
-			// Delete old add on cover if it exists
 			if existingAddOn.Cover != "" {
-				deleteErr := helpers.DeleteFileFromFirebase(client, existingAddOn.Cover)
-				if deleteErr != nil {
-					fmt.Println("Failed to delete old add on cover image", deleteErr)
-				}
+				replacedImages = append(replacedImages, existingAddOn.Cover)
 			}
 
 			// Upload new menu cover
-			addOnCoverURL, uploadErr := helpers.UploadFileToFirebase(client, addOnCoverFile, fmt.Sprintf("addons/%d_%s", time.Now().Unix(), addOnCoverHeader.Filename))
+			addOnCoverURL, uploadErr := storeUpload("addons", addOnCoverFile, addOnCoverHeader)
 			if uploadErr != nil {
 				c.JSON(
 					http.StatusInternalServerError,
@@ -249,7 +214,12 @@ func UpdateMenuAddOn() gin.HandlerFunc {
 		}
 
 		// Update add on in MongoDB
-		_, err = AddOnCollection.UpdateOne(ctx, bson.M{"_id": addOnID}, bson.M{"$set": updateFields})
+		err = helpers.RunInTransaction(ctx, database.Client, func(ctx context.Context) error {
+			if _, err := AddOnCollection.UpdateOne(ctx, bson.M{"_id": addOnID}, bson.M{"$set": updateFields}); err != nil {
+				return err
+			}
+			return enqueueImageDeletion(ctx, replacedImages)
+		})
 		if err != nil {
 			c.JSON(
 				http.StatusInternalServerError,
@@ -270,39 +240,13 @@ func UpdateMenuAddOn() gin.HandlerFunc {
 
 func RemoveMenuAddOn() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		app, err := helpers.InitializeFirebaseApp()
-		if err != nil {
-			c.JSON(
-				http.StatusInternalServerError,
-				gin.H{
-					"success": false,
-					"error":   "Failed to initialize Firebase",
-					"details": err.Error(),
-				},
-			)
-			return
-		}
-
-		client, err := app.Storage(context.Background())
-		if err != nil {
-			c.JSON(
-				http.StatusInternalServerError,
-				gin.H{
-					"success": false,
-					"error":   "Failed to connect to Firebase Storage",
-					"details": err.Error(),
-				},
-			)
-			return
-		}
-
 		addOnID := c.Param("add_on_id")
 
 		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
 		var addOn models.AddOn
-		err = AddOnCollection.FindOne(ctx, bson.M{"_id": addOnID}).Decode(&addOn)
+		err := AddOnCollection.FindOne(ctx, bson.M{"_id": addOnID}).Decode(&addOn)
 		if err != nil {
 			c.JSON(
 				http.StatusNotFound,
@@ -315,11 +259,20 @@ func RemoveMenuAddOn() gin.HandlerFunc {
 			return
 		}
 
-		if addOn.Cover != "" {
-			helpers.DeleteFileFromFirebase(client, addOn.Cover)
+		if !authorizeBranch(c, addOnBranchID(ctx, addOn)) {
+			return
 		}
 
-		_, err = AddOnCollection.DeleteOne(ctx, bson.M{"_id": addOnID})
+		// Delete the add-on and queue the removal of its cover together
+		err = helpers.RunInTransaction(ctx, database.Client, func(ctx context.Context) error {
+			if _, err := AddOnCollection.DeleteOne(ctx, bson.M{"_id": addOnID}); err != nil {
+				return err
+			}
+			if addOn.Cover == "" {
+				return nil
+			}
+			return enqueueImageDeletion(ctx, []string{addOn.Cover})
+		})
 		if err != nil {
 			c.JSON(
 				http.StatusInternalServerError,
@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	database "nano_food_api/database"
+	helpers "nano_food_api/helpers"
+	models "nano_food_api/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuditLogCollection is only ever read here; entries are appended by the Audit
+// middleware and there is deliberately no way to edit or delete them.
+var AuditLogCollection *mongo.Collection = database.AuditLogCollection
+
+// GetAuditLogs lists audit entries newest first, filtered by ?branch_id=,
+// ?actor_id=, ?entity=, ?entity_id=, ?action= and ?from=&to= (YYYY-MM-DD), in
+// pages of ?limit= (100 by default, at most 500) entries.
+func GetAuditLogs() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		for _, key := range []string{"branch_id", "actor_id", "entity", "entity_id", "action"} {
+			if value := c.Query(key); value != "" {
+				filter[key] = value
+			}
+		}
+
+		if c.Query("from") != "" || c.Query("to") != "" {
+			from, to, err := parseDateRange(c.Query("from"), c.Query("to"))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid date format, use YYYY-MM-DD", "details": err.Error()})
+				return
+			}
+			filter["created_at"] = bson.M{"$gte": from, "$lt": to}
+		}
+
+		filter, ok := aggregateFilter(ctx, c, filter, helpers.PermAuditView)
+		if !ok {
+			return
+		}
+
+		limit, err := strconv.Atoi(c.Query("limit"))
+		if err != nil || limit <= 0 {
+			limit = 100
+		}
+		if limit > 500 {
+			limit = 500
+		}
+		page, err := strconv.Atoi(c.Query("page"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+
+		total, err := AuditLogCollection.CountDocuments(ctx, filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error counting audit logs", "details": err.Error()})
+			return
+		}
+
+		findOptions := options.Find().
+			SetSort(bson.M{"seq": -1}).
+			SetSkip(int64((page - 1) * limit)).
+			SetLimit(int64(limit))
+		cursor, err := AuditLogCollection.Find(ctx, filter, findOptions)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving audit logs", "details": err.Error()})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		logs := []models.AuditLog{}
+		if err := cursor.All(ctx, &logs); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error decoding audit logs", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Audit logs retrieved successfully",
+			"data":    logs,
+			"page":    page,
+			"limit":   limit,
+			"total":   total,
+		})
+	}
+}
+
+// VerifyAuditLogs checks the hash chain of the whole audit log and reports the
+// first entry that was tampered with. Root admin only.
+func VerifyAuditLogs() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		scope, err := helpers.GetBranchScope(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		if !scope.AllBranches {
+			c.JSON(http.StatusForbidden, gin.H{"success": false, "error": "Unauthorized Access"})
+			return
+		}
+
+		status, err := helpers.VerifyAuditChain(ctx, AuditLogCollection)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error verifying audit logs", "details": err.Error()})
+			return
+		}
+
+		message := "Audit log is intact"
+		if !status.Valid {
+			message = "Audit log has been tampered with"
+		}
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": message, "data": status})
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"net/http"
 	"time"
 
-	database "nano_food_api/database"
 	helpers "nano_food_api/helpers"
 	models "nano_food_api/models"
 
@@ -15,7 +14,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-// CreateBranch: Root Admin only
+// CreateBranch: Root Admin and Owner can create branches, owners become members of theirs
 func CreateBranch() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
@@ -33,6 +32,20 @@ func CreateBranch() gin.HandlerFunc {
 			return
 		}
 
+		if err := helpers.ValidateTipPolicy(branch.Tip_Policy); err != nil {
+			c.JSON(
+				http.StatusBadRequest,
+				gin.H{
+					"success": false,
+					"error":   err.Error(),
+				},
+			)
+			return
+		}
+		if branch.Tip_Policy.Mode == "" {
+			branch.Tip_Policy.Mode = helpers.TipModeIndividual
+		}
+
 		branch.Branch_ID = primitive.NewObjectID().Hex()
 		branch.Created_At = time.Now()
 		branch.Updated_At = time.Now()
@@ -50,11 +63,24 @@ func CreateBranch() gin.HandlerFunc {
 			return
 		}
 
+		userInfo, err := helpers.GetCurrentUser(c, UserCollection)
+		if err == nil && userInfo.Role != 100 {
+			_, err = UserCollection.UpdateOne(
+				ctx,
+				bson.M{"_id": userInfo.User_ID},
+				bson.M{"$push": bson.M{"memberships": models.BranchMembership{Branch_ID: branch.Branch_ID, Role: userInfo.Role}}},
+			)
+		}
+		if err != nil {
+			log.Printf("Error adding branch membership: %v", err)
+		}
+
 		c.JSON(
 			http.StatusCreated,
 			gin.H{
 				"success": true,
 				"message": "Branch created successfully",
+				"data":    branch,
 			},
 		)
 	}
@@ -66,8 +92,41 @@ func GetBranches() gin.HandlerFunc {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
+		scope, err := helpers.GetBranchScope(c)
+		if err != nil {
+			c.JSON(
+				http.StatusUnauthorized,
+				gin.H{
+					"success": false,
+					"error":   err.Error(),
+				},
+			)
+			return
+		}
+
+		filter := bson.M{}
+		if !scope.AllBranches {
+			userInfo, err := helpers.GetCurrentUser(c, UserCollection)
+			if err != nil {
+				c.JSON(
+					http.StatusUnauthorized,
+					gin.H{
+						"success": false,
+						"error":   err.Error(),
+					},
+				)
+				return
+			}
+
+			branchIDs := []string{}
+			for _, membership := range helpers.UserBranches(userInfo) {
+				branchIDs = append(branchIDs, membership.Branch_ID)
+			}
+			filter["_id"] = bson.M{"$in": branchIDs}
+		}
+
 		var branches []models.Branch
-		cursor, err := BranchCollection.Find(ctx, bson.M{})
+		cursor, err := BranchCollection.Find(ctx, filter)
 		if err != nil {
 			c.JSON(
 				http.StatusInternalServerError,
@@ -141,18 +200,6 @@ func UpdateBranch() gin.HandlerFunc {
 
 		branchID := c.Param("branch_id")
 
-		userInfo, err := helpers.GetCurrentUser(c, database.UserCollection)
-		if err != nil {
-			c.JSON(
-				http.StatusInternalServerError,
-				gin.H{
-					"success": false,
-					"error":   err.Error(),
-				},
-			)
-			return
-		}
-
 		var branch models.Branch
 		if err := c.BindJSON(&branch); err != nil {
 			c.JSON(
@@ -165,14 +212,7 @@ func UpdateBranch() gin.HandlerFunc {
 			return
 		}
 
-		if userInfo.Role != 100 && userInfo.Branch_ID != branchID {
-			c.JSON(
-				http.StatusBadRequest,
-				gin.H{
-					"success": false,
-					"error":   "Unauthorized Access",
-				},
-			)
+		if !authorizeBranch(c, branchID) {
 			return
 		}
 
@@ -231,6 +271,15 @@ func DeleteBranch() gin.HandlerFunc {
 			return
 		}
 
+		_, err = UserCollection.UpdateMany(
+			ctx,
+			bson.M{"memberships.branch_id": branchID},
+			bson.M{"$pull": bson.M{"memberships": bson.M{"branch_id": branchID}}},
+		)
+		if err != nil {
+			log.Printf("Error removing branch memberships: %v", err)
+		}
+
 		c.JSON(
 			http.StatusOK,
 			gin.H{
@@ -240,3 +289,314 @@ func DeleteBranch() gin.HandlerFunc {
 		)
 	}
 }
+
+// UpdateBranchTipPolicy sets how the branch shares out tips. It applies to every
+// tip report, including those of past periods.
+func UpdateBranchTipPolicy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		branchID := c.Param("branch_id")
+
+		var policy models.TipPolicy
+		if err := c.BindJSON(&policy); err != nil {
+			c.JSON(
+				http.StatusBadRequest,
+				gin.H{
+					"success": false,
+					"error":   err.Error(),
+				},
+			)
+			return
+		}
+
+		if !authorizeBranch(c, branchID) {
+			return
+		}
+
+		if err := helpers.ValidateTipPolicy(policy); err != nil {
+			c.JSON(
+				http.StatusBadRequest,
+				gin.H{
+					"success": false,
+					"error":   err.Error(),
+				},
+			)
+			return
+		}
+		if policy.Mode == "" {
+			policy.Mode = helpers.TipModeIndividual
+		}
+
+		result, err := BranchCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": branchID},
+			bson.M{"$set": bson.M{"tip_policy": policy, "updated_at": time.Now()}},
+		)
+		if err != nil {
+			c.JSON(
+				http.StatusInternalServerError,
+				gin.H{
+					"success": false,
+					"error":   "Error updating tip policy",
+					"details": err.Error(),
+				},
+			)
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(
+				http.StatusNotFound,
+				gin.H{
+					"success": false,
+					"error":   "Branch not found",
+				},
+			)
+			return
+		}
+
+		c.JSON(
+			http.StatusOK,
+			gin.H{
+				"success": true,
+				"message": "Tip policy updated successfully",
+				"data":    policy,
+			},
+		)
+	}
+}
+
+func UpdateBranchPreorderPolicy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		branchID := c.Param("branch_id")
+
+		var policy models.PreorderPolicy
+		if err := c.BindJSON(&policy); err != nil {
+			c.JSON(
+				http.StatusBadRequest,
+				gin.H{
+					"success": false,
+					"error":   err.Error(),
+				},
+			)
+			return
+		}
+
+		if !authorizeBranch(c, branchID) {
+			return
+		}
+
+		if err := helpers.ValidatePreorderPolicy(policy); err != nil {
+			c.JSON(
+				http.StatusBadRequest,
+				gin.H{
+					"success": false,
+					"error":   err.Error(),
+				},
+			)
+			return
+		}
+
+		result, err := BranchCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": branchID},
+			bson.M{"$set": bson.M{"preorder_policy": policy, "updated_at": time.Now()}},
+		)
+		if err != nil {
+			c.JSON(
+				http.StatusInternalServerError,
+				gin.H{
+					"success": false,
+					"error":   "Error updating pre-order policy",
+					"details": err.Error(),
+				},
+			)
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(
+				http.StatusNotFound,
+				gin.H{
+					"success": false,
+					"error":   "Branch not found",
+				},
+			)
+			return
+		}
+
+		c.JSON(
+			http.StatusOK,
+			gin.H{
+				"success": true,
+				"message": "Pre-order policy updated successfully",
+				"data":    helpers.EffectivePreorderPolicy(policy),
+			},
+		)
+	}
+}
+
+// UpdateBranchDeliverySettings sets where a branch is and the zones it delivers to.
+func UpdateBranchDeliverySettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		branchID := c.Param("branch_id")
+
+		var reqBody struct {
+			Latitude        float64               `json:"latitude"`
+			Longitude       float64               `json:"longitude"`
+			Delivery_Radius float64               `json:"delivery_radius_km"`
+			Delivery_Zones  []models.DeliveryZone `json:"delivery_zones"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(
+				http.StatusBadRequest,
+				gin.H{
+					"success": false,
+					"error":   err.Error(),
+				},
+			)
+			return
+		}
+
+		if !authorizeBranch(c, branchID) {
+			return
+		}
+
+		branchPoint := models.GeoPoint{Latitude: reqBody.Latitude, Longitude: reqBody.Longitude}
+		if err := helpers.ValidateDeliveryZones(branchPoint, reqBody.Delivery_Radius, reqBody.Delivery_Zones); err != nil {
+			c.JSON(
+				http.StatusBadRequest,
+				gin.H{
+					"success": false,
+					"error":   err.Error(),
+				},
+			)
+			return
+		}
+		for i := range reqBody.Delivery_Zones {
+			if reqBody.Delivery_Zones[i].Zone_ID == "" {
+				reqBody.Delivery_Zones[i].Zone_ID = primitive.NewObjectID().Hex()
+			}
+		}
+
+		result, err := BranchCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": branchID},
+			bson.M{"$set": bson.M{
+				"latitude":           reqBody.Latitude,
+				"longitude":          reqBody.Longitude,
+				"delivery_radius_km": reqBody.Delivery_Radius,
+				"delivery_zones":     reqBody.Delivery_Zones,
+				"updated_at":         time.Now(),
+			}},
+		)
+		if err != nil {
+			c.JSON(
+				http.StatusInternalServerError,
+				gin.H{
+					"success": false,
+					"error":   "Error updating delivery settings",
+					"details": err.Error(),
+				},
+			)
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(
+				http.StatusNotFound,
+				gin.H{
+					"success": false,
+					"error":   "Branch not found",
+				},
+			)
+			return
+		}
+
+		c.JSON(
+			http.StatusOK,
+			gin.H{
+				"success": true,
+				"message": "Delivery settings updated successfully",
+				"data":    reqBody,
+			},
+		)
+	}
+}
+
+// GetDeliveryQuote tells whether a branch delivers to an address and what it
+// costs, for an order worth subtotal.
+func GetDeliveryQuote() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		branchID := c.Param("branch_id")
+
+		var reqBody struct {
+			Latitude  float64 `json:"latitude"`
+			Longitude float64 `json:"longitude"`
+			Subtotal  float64 `json:"subtotal"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(
+				http.StatusBadRequest,
+				gin.H{
+					"success": false,
+					"error":   err.Error(),
+				},
+			)
+			return
+		}
+
+		var branch models.Branch
+		if err := BranchCollection.FindOne(ctx, bson.M{"_id": branchID}).Decode(&branch); err != nil {
+			c.JSON(
+				http.StatusNotFound,
+				gin.H{
+					"success": false,
+					"error":   "Branch not found",
+				},
+			)
+			return
+		}
+		if len(branch.Delivery_Zones) == 0 {
+			c.JSON(
+				http.StatusBadRequest,
+				gin.H{
+					"success": false,
+					"error":   "This branch has no delivery zones",
+				},
+			)
+			return
+		}
+
+		address := models.GeoPoint{Latitude: reqBody.Latitude, Longitude: reqBody.Longitude}
+		quote, err := helpers.QuoteDelivery(branch, address, reqBody.Subtotal, time.Now())
+		if err != nil {
+			c.JSON(
+				http.StatusBadRequest,
+				gin.H{
+					"success": false,
+					"error":   "Address cannot be delivered to",
+					"details": err.Error(),
+				},
+			)
+			return
+		}
+
+		c.JSON(
+			http.StatusOK,
+			gin.H{
+				"success": true,
+				"message": "Delivery quote retrieved successfully",
+				"data":    quote,
+			},
+		)
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"time"
 
 	database "nano_food_api/database"
-	helpers "nano_food_api/helpers"
 	models "nano_food_api/models"
 
 	"github.com/gin-gonic/gin"
@@ -29,6 +28,10 @@ func CreateCategory() gin.HandlerFunc {
 			return
 		}
 
+		if !authorizeBranch(c, category.Branch_ID) {
+			return
+		}
+
 		var branch models.Branch
 		err := BranchCollection.FindOne(ctx, bson.M{"_id": category.Branch_ID}).Decode(&branch)
 		if err != nil {
@@ -47,7 +50,7 @@ func CreateCategory() gin.HandlerFunc {
 			return
 		}
 
-		c.JSON(http.StatusCreated, gin.H{"success": true, "message": "Category created successfully"})
+		c.JSON(http.StatusCreated, gin.H{"success": true, "message": "Category created successfully", "data": category})
 	}
 }
 
@@ -143,24 +146,16 @@ func UpdateCategory() gin.HandlerFunc {
 
 		categoryID := c.Param("category_id")
 
-		userInfo, err := helpers.GetCurrentUser(c, database.UserCollection)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
-			return
-		}
-
 		var category models.Category
 		if err := c.BindJSON(&category); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
 			return
 		}
 
-		if userInfo.Role != 100 && userInfo.Branch_ID != category.Branch_ID {
-			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Unauthorized Access"})
+		filter, ok := scopedFilter(c, bson.M{"_id": categoryID})
+		if !ok {
 			return
 		}
-
-		filter := bson.M{"_id": categoryID}
 		update := bson.M{
 			"$set": bson.M{
 				"title":       category.Title,
@@ -170,10 +165,14 @@ func UpdateCategory() gin.HandlerFunc {
 		}
 
 		result, err := CategoryCollection.UpdateOne(ctx, filter, update)
-		if err != nil || result.MatchedCount == 0 {
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error updating category", "details": err.Error()})
 			return
 		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Category not found"})
+			return
+		}
 
 		c.JSON(http.StatusOK, gin.H{"success": true, "message": "category updated successfully"})
 	}
@@ -186,25 +185,23 @@ func DeleteCategory() gin.HandlerFunc {
 
 		categoryID := c.Param("category_id")
 
-		userInfo, err := helpers.GetCurrentUser(c, database.UserCollection)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		filter, ok := scopedFilter(c, bson.M{"_id": categoryID})
+		if !ok {
 			return
 		}
 
 		var category models.Category
-		err = CategoryCollection.FindOne(ctx, bson.M{"_id": categoryID}).Decode(&category)
+		err := CategoryCollection.FindOne(ctx, filter).Decode(&category)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Category not found"})
+			return
+		}
 		if err != nil {
 			log.Printf("Error retrieving category: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving category", "details": err.Error()})
 			return
 		}
 
-		if userInfo.Role != 100 && userInfo.Branch_ID != category.Branch_ID {
-			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Unauthorized Access"})
-			return
-		}
-
 		_, err = MenuCollection.UpdateMany(ctx, bson.M{"category_id": categoryID}, bson.M{"$set": bson.M{"category_id": ""}})
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to update menus", "details": err.Error()})
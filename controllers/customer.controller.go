@@ -0,0 +1,826 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	database "nano_food_api/database"
+	helpers "nano_food_api/helpers"
+	models "nano_food_api/models"
+	token "nano_food_api/tokens"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var CustomerCollection *mongo.Collection = database.CustomerCollection
+var CustomerOTPCollection *mongo.Collection = database.CustomerOTPCollection
+
+var phonePattern = regexp.MustCompile(`^\+?[0-9]{7,15}$`)
+
+// maxOTPAttempts wrong codes invalidate a one-time code.
+const maxOTPAttempts = 5
+
+// normalizePhone strips the spaces, dashes and brackets people type in numbers.
+func normalizePhone(phone string) string {
+	return strings.NewReplacer(" ", "", "-", "", "(", "", ")", "").Replace(strings.TrimSpace(phone))
+}
+
+// customerIdentity validates that exactly one of phone or email is given and
+// returns the filter that finds the customer by it.
+func customerIdentity(phone string, email string) (bson.M, string, error) {
+	phone = normalizePhone(phone)
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	switch {
+	case phone != "" && email != "":
+		return nil, "", fmt.Errorf("provide either a phone number or an email, not both")
+	case phone != "":
+		if !phonePattern.MatchString(phone) {
+			return nil, "", fmt.Errorf("invalid phone number")
+		}
+		return bson.M{"phone": phone}, phone, nil
+	case email != "":
+		if !govalidator.IsEmail(email) {
+			return nil, "", fmt.Errorf("invalid email format")
+		}
+		return bson.M{"email": email}, email, nil
+	}
+	return nil, "", fmt.Errorf("a phone number or an email is required")
+}
+
+// sendCustomerOTP queues a sign-in code by SMS or email, whichever identifies
+// the customer.
+func sendCustomerOTP(ctx context.Context, filter bson.M, identity string, code string) error {
+	if _, ok := filter["phone"]; ok {
+		return enqueueSMS(ctx, identity, fmt.Sprintf("Your NanoFood sign-in code is %s", code))
+	}
+	return enqueueEmail(ctx, identity, "Your NanoFood Sign-in Code", fmt.Sprintf("Your sign-in code is: <b>%s</b>", code))
+}
+
+// RequestCustomerOTP sends a one-time sign-in code to a phone number or email.
+// The customer is only created once they verify the code.
+func RequestCustomerOTP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var reqBody struct {
+			Phone string `json:"phone"`
+			Email string `json:"email"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		filter, identity, err := customerIdentity(reqBody.Phone, reqBody.Email)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		// Every code sent counts against the phone or email and the caller's IP,
+		// so this public endpoint cannot be used to flood someone with messages
+		sendKey, sendIPKey := attemptKeys("customer-otp-send", identity, c.ClientIP())
+		if !checkAttemptsAllowed(ctx, c, sendKey, sendIPKey) {
+			return
+		}
+
+		var pending models.CustomerOTP
+		err = CustomerOTPCollection.FindOne(ctx, bson.M{"_id": identity}).Decode(&pending)
+		if err != nil && err != mongo.ErrNoDocuments {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving code", "details": err.Error()})
+			return
+		}
+
+		cooldown, err := strconv.Atoi(os.Getenv("VERIFICATION_RESEND_COOLDOWN"))
+		if err != nil {
+			cooldown = 60
+		}
+		nextAllowed := pending.Sent_At.Add(time.Duration(cooldown) * time.Second)
+		if time.Now().Before(nextAllowed) {
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"success":     false,
+				"error":       "Please wait before requesting another code",
+				"retry_after": int(time.Until(nextAllowed).Seconds()) + 1,
+			})
+			return
+		}
+
+		code, err := helpers.GenerateNumericCode(6)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error generating code", "details": err.Error()})
+			return
+		}
+		hashedCode, err := helpers.HashPassword(code)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error generating code", "details": err.Error()})
+			return
+		}
+
+		otpExpire, err := strconv.Atoi(os.Getenv("CUSTOMER_OTP_EXPIRE"))
+		if err != nil {
+			otpExpire = 5
+		}
+
+		// The code is only saved if its message is queued, and the other way round
+		err = helpers.RunInTransaction(ctx, database.Client, func(ctx context.Context) error {
+			_, err := CustomerOTPCollection.UpdateOne(
+				ctx,
+				bson.M{"_id": identity},
+				bson.M{"$set": bson.M{
+					"code":       hashedCode,
+					"attempts":   0,
+					"sent_at":    time.Now(),
+					"expires_at": time.Now().Add(time.Duration(otpExpire) * time.Minute),
+				}},
+				options.Update().SetUpsert(true),
+			)
+			if err != nil {
+				return err
+			}
+			return sendCustomerOTP(ctx, filter, identity, code)
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error sending code", "details": err.Error()})
+			return
+		}
+
+		recordFailedAttempts(ctx, sendKey, sendIPKey, nil)
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "A sign-in code has been sent"})
+	}
+}
+
+// VerifyCustomerOTP signs a customer in with the code they received, creating
+// the customer on their first sign-in.
+func VerifyCustomerOTP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var reqBody struct {
+			Phone string `json:"phone"`
+			Email string `json:"email"`
+			Code  string `json:"code" binding:"required"`
+			Name  string `json:"name"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		filter, identity, err := customerIdentity(reqBody.Phone, reqBody.Email)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		accountKey, ipKey := attemptKeys("customer-otp", identity, c.ClientIP())
+		if !checkAttemptsAllowed(ctx, c, accountKey, ipKey) {
+			return
+		}
+
+		if err := consumeCustomerOTP(ctx, identity, reqBody.Code); err != nil {
+			recordFailedAttempts(ctx, accountKey, ipKey, nil)
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid or expired code"})
+			return
+		}
+
+		name := strings.TrimSpace(reqBody.Name)
+
+		var customer models.Customer
+		err = CustomerCollection.FindOne(ctx, filter).Decode(&customer)
+		if err == mongo.ErrNoDocuments {
+			customer = models.Customer{
+				Customer_ID: primitive.NewObjectID().Hex(),
+				Name:        name,
+				Favourites:  []string{},
+				Addresses:   []models.CustomerAddress{},
+				IsVerified:  true,
+				Created_At:  time.Now(),
+				Updated_At:  time.Now(),
+			}
+			if _, ok := filter["phone"]; ok {
+				customer.Phone = identity
+			} else {
+				customer.Email = identity
+			}
+			if _, err := CustomerCollection.InsertOne(ctx, customer); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error creating customer", "details": err.Error()})
+				return
+			}
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving customer", "details": err.Error()})
+			return
+		} else {
+			update := bson.M{"is_verified": true, "updated_at": time.Now()}
+			if customer.Name == "" && name != "" {
+				customer.Name = name
+				update["name"] = customer.Name
+			}
+			_, err = CustomerCollection.UpdateOne(ctx, bson.M{"_id": customer.Customer_ID}, bson.M{"$set": update})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error signing in", "details": err.Error()})
+				return
+			}
+			customer.IsVerified = true
+		}
+
+		sendKey, _ := attemptKeys("customer-otp-send", identity, "")
+		if err := helpers.ResetAttempts(ctx, LoginAttemptCollection, accountKey, sendKey); err != nil {
+			log.Printf("Error resetting failed attempts: %v", err)
+		}
+
+		customerToken, expiresAt, err := token.CustomerTokenGenerator(customer.Customer_ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error generating token", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success":    true,
+			"message":    "Signed in successfully",
+			"data":       customer,
+			"token":      customerToken,
+			"expires_at": expiresAt,
+		})
+	}
+}
+
+// consumeCustomerOTP checks a sign-in code sent to the phone or email and deletes
+// it, so it cannot be used twice. Wrong codes count towards maxOTPAttempts.
+func consumeCustomerOTP(ctx context.Context, identity string, code string) error {
+	var pending models.CustomerOTP
+	err := CustomerOTPCollection.FindOne(ctx, bson.M{"_id": identity}).Decode(&pending)
+	if err != nil {
+		return err
+	}
+	if time.Now().After(pending.Expires_At) || pending.Attempts >= maxOTPAttempts {
+		return fmt.Errorf("code has expired")
+	}
+
+	if !helpers.CheckPassword(pending.Code, code) {
+		if _, err := CustomerOTPCollection.UpdateOne(ctx, bson.M{"_id": identity}, bson.M{"$inc": bson.M{"attempts": 1}}); err != nil {
+			log.Printf("Error counting OTP attempt: %v", err)
+		}
+		return fmt.Errorf("invalid code")
+	}
+
+	result, err := CustomerOTPCollection.DeleteOne(ctx, bson.M{"_id": identity, "code": pending.Code})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("code has already been used")
+	}
+	return nil
+}
+
+// verifyCustomerCode responds with 400 and returns false unless code is the
+// sign-in code just sent to the customer, proving they are at the counter.
+func verifyCustomerCode(ctx context.Context, c *gin.Context, customerID string, code string) bool {
+	if code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "The code sent to the customer is required to pay from their wallet"})
+		return false
+	}
+
+	var customer models.Customer
+	if err := CustomerCollection.FindOne(ctx, bson.M{"_id": customerID}).Decode(&customer); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid customer ID"})
+		return false
+	}
+	identity := customer.Phone
+	if identity == "" {
+		identity = customer.Email
+	}
+
+	accountKey, ipKey := attemptKeys("customer-otp", identity, c.ClientIP())
+	if !checkAttemptsAllowed(ctx, c, accountKey, ipKey) {
+		return false
+	}
+	if err := consumeCustomerOTP(ctx, identity, code); err != nil {
+		recordFailedAttempts(ctx, accountKey, ipKey, nil)
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid or expired customer code"})
+		return false
+	}
+	if err := helpers.ResetAttempts(ctx, LoginAttemptCollection, accountKey); err != nil {
+		log.Printf("Error resetting failed attempts: %v", err)
+	}
+	return true
+}
+
+// getCurrentCustomer loads the customer CustomerAuthentication signed in.
+func getCurrentCustomer(ctx context.Context, c *gin.Context) (models.Customer, bool) {
+	var customer models.Customer
+	err := CustomerCollection.FindOne(ctx, bson.M{"_id": c.GetString("customerId")}).Decode(&customer)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Customer not found", "details": err.Error()})
+		return customer, false
+	}
+	return customer, true
+}
+
+func GetCustomerProfile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		customer, ok := getCurrentCustomer(ctx, c)
+		if !ok {
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Profile retrieved successfully", "data": customer})
+	}
+}
+
+// UpdateCustomerProfile changes the customer's name. The phone or email they
+// sign in with cannot be changed here.
+func UpdateCustomerProfile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var reqBody struct {
+			Name string `json:"name" binding:"required"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		_, err := CustomerCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": c.GetString("customerId")},
+			bson.M{"$set": bson.M{"name": strings.TrimSpace(reqBody.Name), "updated_at": time.Now()}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error updating profile", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Profile updated successfully"})
+	}
+}
+
+// CustomerLogoutAll invalidates every token the customer was issued so far.
+func CustomerLogoutAll() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		_, err := CustomerCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": c.GetString("customerId")},
+			bson.M{"$set": bson.M{"tokens_valid_after": time.Now().Add(time.Second), "updated_at": time.Now()}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error signing out", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Signed out of all devices"})
+	}
+}
+
+// findCustomerOrders returns the orders matching the filter, newest first.
+func findCustomerOrders(ctx context.Context, filter bson.M, limit int64) ([]bson.M, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$sort", Value: bson.M{"created_at": -1}}},
+		{{Key: "$limit", Value: limit}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         "branches",
+			"localField":   "branch_id",
+			"foreignField": "_id",
+			"as":           "branch_details",
+		}}},
+		{{Key: "$unwind", Value: bson.M{"path": "$branch_details", "preserveNullAndEmptyArrays": true}}},
+	}
+
+	cursor, err := OrderCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	orders := []bson.M{}
+	if err := cursor.All(ctx, &orders); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+func GetCustomerOrders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		orders, err := findCustomerOrders(ctx, bson.M{"customer_id": c.GetString("customerId")}, 100)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving orders", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Orders retrieved successfully", "data": orders})
+	}
+}
+
+func GetCustomerFavourites() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		customer, ok := getCurrentCustomer(ctx, c)
+		if !ok {
+			return
+		}
+
+		cursor, err := MenuCollection.Find(ctx, bson.M{"_id": bson.M{"$in": customer.Favourites}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving menus", "details": err.Error()})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		menus := []models.Menu{}
+		if err := cursor.All(ctx, &menus); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error decoding menus", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Favourites retrieved successfully", "data": menus})
+	}
+}
+
+func AddCustomerFavourite() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		menuID := c.Param("menu_id")
+
+		menuExists, err := helpers.CheckDataExist(ctx, MenuCollection, bson.M{"_id": menuID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to validate menu", "details": err.Error()})
+			return
+		}
+		if !menuExists {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Menu not found"})
+			return
+		}
+
+		_, err = CustomerCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": c.GetString("customerId")},
+			bson.M{"$addToSet": bson.M{"favourites": menuID}, "$set": bson.M{"updated_at": time.Now()}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error adding favourite", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Favourite added successfully"})
+	}
+}
+
+func RemoveCustomerFavourite() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		_, err := CustomerCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": c.GetString("customerId")},
+			bson.M{"$pull": bson.M{"favourites": c.Param("menu_id")}, "$set": bson.M{"updated_at": time.Now()}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error removing favourite", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Favourite removed successfully"})
+	}
+}
+
+func AddCustomerAddress() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var address models.CustomerAddress
+		if err := c.BindJSON(&address); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		if strings.TrimSpace(address.Address) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Address is required"})
+			return
+		}
+		address.Address_ID = primitive.NewObjectID().Hex()
+
+		_, err := CustomerCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": c.GetString("customerId")},
+			bson.M{"$push": bson.M{"addresses": address}, "$set": bson.M{"updated_at": time.Now()}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error adding address", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"success": true, "message": "Address added successfully", "data": address})
+	}
+}
+
+func UpdateCustomerAddress() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		addressID := c.Param("address_id")
+
+		var address models.CustomerAddress
+		if err := c.BindJSON(&address); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		if strings.TrimSpace(address.Address) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Address is required"})
+			return
+		}
+		address.Address_ID = addressID
+
+		result, err := CustomerCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": c.GetString("customerId"), "addresses._id": addressID},
+			bson.M{"$set": bson.M{"addresses.$": address, "updated_at": time.Now()}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error updating address", "details": err.Error()})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Address not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Address updated successfully", "data": address})
+	}
+}
+
+func DeleteCustomerAddress() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		result, err := CustomerCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": c.GetString("customerId")},
+			bson.M{"$pull": bson.M{"addresses": bson.M{"_id": c.Param("address_id")}}, "$set": bson.M{"updated_at": time.Now()}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error deleting address", "details": err.Error()})
+			return
+		}
+		if result.ModifiedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Address not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Address deleted successfully"})
+	}
+}
+
+// CreateCustomer adds a customer at the POS so they can be attached to orders
+// before they ever sign in themselves.
+func CreateCustomer() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var reqBody struct {
+			Name  string `json:"name" binding:"required"`
+			Phone string `json:"phone"`
+			Email string `json:"email"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		filter, identity, err := customerIdentity(reqBody.Phone, reqBody.Email)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		var existing models.Customer
+		err = CustomerCollection.FindOne(ctx, filter).Decode(&existing)
+		if err == nil {
+			c.JSON(http.StatusConflict, gin.H{"success": false, "error": "Customer already exists", "data": existing})
+			return
+		}
+		if err != mongo.ErrNoDocuments {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving customer", "details": err.Error()})
+			return
+		}
+
+		createdBy, err := helpers.GetUserIDFromMdw(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		customer := models.Customer{
+			Customer_ID: primitive.NewObjectID().Hex(),
+			Name:        strings.TrimSpace(reqBody.Name),
+			Favourites:  []string{},
+			Addresses:   []models.CustomerAddress{},
+			Created_By:  createdBy,
+			Created_At:  time.Now(),
+			Updated_At:  time.Now(),
+		}
+		if _, ok := filter["phone"]; ok {
+			customer.Phone = identity
+		} else {
+			customer.Email = identity
+		}
+
+		if _, err := CustomerCollection.InsertOne(ctx, customer); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error creating customer", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"success": true, "message": "Customer created successfully", "data": customer})
+	}
+}
+
+// SearchCustomers finds customers at the POS by the start of their phone
+// number, email or name (?q=).
+func SearchCustomers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		query := strings.TrimSpace(c.Query("q"))
+		if len(query) < 3 {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Search needs at least 3 characters"})
+			return
+		}
+
+		scope, err := helpers.GetBranchScope(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		// A full phone number or email finds any customer, so a guest from another
+		// branch can be attached at the POS. Partial searches only browse customers
+		// who have ordered at the caller's branch.
+		filter, _, err := customerIdentity(query, "")
+		if err != nil {
+			filter, _, err = customerIdentity("", query)
+		}
+		if err != nil {
+			prefix := bson.M{"$regex": "^" + regexp.QuoteMeta(query), "$options": "i"}
+			filter = bson.M{"$or": []bson.M{
+				{"phone": bson.M{"$regex": "^" + regexp.QuoteMeta(normalizePhone(query))}},
+				{"email": prefix},
+				{"name": prefix},
+			}}
+
+			if !scope.AllBranches {
+				customerIDs, err := OrderCollection.Distinct(ctx, "customer_id", bson.M{"branch_id": scope.Branch_ID, "customer_id": bson.M{"$exists": true}})
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving customers", "details": err.Error()})
+					return
+				}
+				filter["_id"] = bson.M{"$in": customerIDs}
+			}
+		}
+
+		cursor, err := CustomerCollection.Find(ctx, filter, options.Find().SetLimit(20))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving customers", "details": err.Error()})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		customers := []models.Customer{}
+		if err := cursor.All(ctx, &customers); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error decoding customers", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Customers retrieved successfully", "data": customers})
+	}
+}
+
+// GetCustomer shows staff a customer's profile with their recent orders.
+func GetCustomer() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		customerID := c.Param("customer_id")
+
+		var customer models.Customer
+		err := CustomerCollection.FindOne(ctx, bson.M{"_id": customerID}).Decode(&customer)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Customer not found", "details": err.Error()})
+			return
+		}
+
+		// Staff only see the customer's orders at their own branch
+		filter, ok := scopedFilter(c, bson.M{"customer_id": customerID})
+		if !ok {
+			return
+		}
+
+		orders, err := findCustomerOrders(ctx, filter, 20)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving orders", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Customer retrieved successfully", "data": customer, "orders": orders})
+	}
+}
+
+// validateCustomerID responds with 400 and returns false when a customer ID given
+// on an order or sale does not exist. An empty ID is valid.
+func validateCustomerID(ctx context.Context, c *gin.Context, customerID string) bool {
+	if customerID == "" {
+		return true
+	}
+
+	customerExists, err := helpers.CheckDataExist(ctx, CustomerCollection, bson.M{"_id": customerID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to validate customer", "details": err.Error()})
+		return false
+	}
+	if !customerExists {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid customer ID"})
+		return false
+	}
+	return true
+}
+
+// AttachOrderCustomer links an existing order to a customer at the POS, or
+// detaches it when customer_id is empty.
+func AttachOrderCustomer() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		orderID := c.Param("order_id")
+
+		var reqBody struct {
+			Customer_ID string `json:"customer_id"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		filter, ok := scopedFilter(c, bson.M{"_id": orderID})
+		if !ok {
+			return
+		}
+
+		if !validateCustomerID(ctx, c, reqBody.Customer_ID) {
+			return
+		}
+
+		update := bson.M{"$unset": bson.M{"customer_id": ""}, "$set": bson.M{"updated_at": time.Now()}}
+		if reqBody.Customer_ID != "" {
+			update = bson.M{"$set": bson.M{"customer_id": reqBody.Customer_ID, "updated_at": time.Now()}}
+		}
+
+		result, err := OrderCollection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error updating order", "details": err.Error()})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Order not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Order customer updated successfully"})
+	}
+}
@@ -0,0 +1,429 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	database "nano_food_api/database"
+	helpers "nano_food_api/helpers"
+	models "nano_food_api/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var GiftCardCollection *mongo.Collection = database.GiftCardCollection
+var GiftCardTransactionCollection *mongo.Collection = database.GiftCardTransactionCollection
+
+var errInsufficientGiftCardBalance = fmt.Errorf("gift card is disabled or its balance is too low")
+
+// findGiftCardByCode looks up an active or disabled gift card by its code.
+func findGiftCardByCode(ctx context.Context, code string) (models.GiftCard, error) {
+	var card models.GiftCard
+	err := GiftCardCollection.FindOne(ctx, bson.M{"code_hash": helpers.HashGiftCardCode(code)}).Decode(&card)
+	return card, err
+}
+
+// recordGiftCardTransaction appends an entry to a card's ledger.
+func recordGiftCardTransaction(ctx context.Context, card models.GiftCard, transaction models.GiftCardTransaction) error {
+	transaction.Transaction_ID = primitive.NewObjectID().Hex()
+	transaction.GiftCard_ID = card.GiftCard_ID
+	transaction.Balance_After = card.Balance
+	transaction.Created_At = time.Now()
+	_, err := GiftCardTransactionCollection.InsertOne(ctx, transaction)
+	return err
+}
+
+// issueGiftCard creates a card loaded with amount and returns it with its code,
+// which is not stored and cannot be shown again.
+func issueGiftCard(ctx context.Context, card models.GiftCard, amount float64) (models.GiftCard, string, error) {
+	amount = helpers.RoundAmount(amount)
+	card.GiftCard_ID = primitive.NewObjectID().Hex()
+	card.Initial_Balance = amount
+	card.Balance = amount
+	card.Status = helpers.GiftCardActive
+	card.Created_At = time.Now()
+	card.Updated_At = card.Created_At
+
+	var code string
+	for attempt := 0; ; attempt++ {
+		var err error
+		code, err = helpers.GenerateGiftCardCode()
+		if err != nil {
+			return card, "", err
+		}
+		card.Code_Hash = helpers.HashGiftCardCode(code)
+		card.Code_Last4 = helpers.GiftCardLast4(code)
+
+		_, err = GiftCardCollection.InsertOne(ctx, card)
+		if err == nil {
+			break
+		}
+		if !mongo.IsDuplicateKeyError(err) || attempt >= 4 {
+			return card, "", err
+		}
+	}
+
+	err := recordGiftCardTransaction(ctx, card, models.GiftCardTransaction{
+		Branch_ID:  card.Branch_ID,
+		Sale_ID:    card.Sale_ID,
+		Type:       helpers.GiftCardLoaded,
+		Amount:     amount,
+		Note:       card.Note,
+		Created_By: card.Created_By,
+	})
+	return card, code, err
+}
+
+// changeGiftCardBalance adds amount to an active card's balance, or takes it
+// away when negative. It fails with errInsufficientGiftCardBalance when the card
+// is disabled or the balance would go below zero.
+func changeGiftCardBalance(ctx context.Context, cardID string, amount float64, transaction models.GiftCardTransaction) (models.GiftCard, error) {
+	amount = helpers.RoundAmount(amount)
+	filter := bson.M{"_id": cardID, "status": helpers.GiftCardActive}
+	if amount < 0 {
+		filter["balance"] = bson.M{"$gte": -amount}
+	}
+
+	var card models.GiftCard
+	err := GiftCardCollection.FindOneAndUpdate(ctx, filter,
+		bson.M{"$inc": bson.M{"balance": amount}, "$set": bson.M{"updated_at": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&card)
+	if err == mongo.ErrNoDocuments {
+		return card, errInsufficientGiftCardBalance
+	}
+	if err != nil {
+		return card, err
+	}
+
+	transaction.Amount = amount
+	return card, recordGiftCardTransaction(ctx, card, transaction)
+}
+
+// findGiftCardTransactions returns a card's ledger, newest first.
+func findGiftCardTransactions(ctx context.Context, cardID string) ([]models.GiftCardTransaction, error) {
+	cursor, err := GiftCardTransactionCollection.Find(ctx, bson.M{"gift_card_id": cardID}, options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	transactions := []models.GiftCardTransaction{}
+	if err := cursor.All(ctx, &transactions); err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+// reverseSaleGiftCards undoes the gift card effects of a refunded sale: payments
+// go back onto their cards, cards the sale issued are voided and top-ups of
+// existing cards are taken back, leaving what was on them before. A card that
+// was already partly spent only loses what is left on it.
+func reverseSaleGiftCards(ctx context.Context, sale models.Sale, userID string) error {
+	for _, payment := range sale.GiftCard_Payments {
+		_, err := changeGiftCardBalance(ctx, payment.GiftCard_ID, payment.Amount, models.GiftCardTransaction{
+			Branch_ID:  sale.Branch_ID,
+			Sale_ID:    sale.Sale_ID,
+			Type:       helpers.GiftCardReturned,
+			Note:       "Payment of a refunded sale",
+			Created_By: userID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, item := range sale.GiftCard_Items {
+		var card models.GiftCard
+		if err := GiftCardCollection.FindOne(ctx, bson.M{"_id": item.GiftCard_ID}).Decode(&card); err != nil {
+			return err
+		}
+
+		issued := card.Sale_ID == sale.Sale_ID
+		voided := helpers.RoundAmount(math.Min(card.Balance, item.Amount))
+
+		set := bson.M{"updated_at": time.Now()}
+		if issued {
+			set["status"] = helpers.GiftCardDisabled
+		}
+		update := bson.M{"$set": set}
+		if voided > 0 {
+			update["$inc"] = bson.M{"balance": -voided}
+		}
+
+		err := GiftCardCollection.FindOneAndUpdate(ctx,
+			bson.M{"_id": item.GiftCard_ID, "balance": bson.M{"$gte": voided}},
+			update,
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&card)
+		if err == mongo.ErrNoDocuments {
+			return fmt.Errorf("gift card ending in %s was spent while the refund was made, try again", card.Code_Last4)
+		}
+		if err != nil {
+			return err
+		}
+		if voided <= 0 {
+			continue
+		}
+
+		note := "Sold on a refunded sale"
+		if !issued {
+			note = "Top-up of a refunded sale"
+		}
+		err = recordGiftCardTransaction(ctx, card, models.GiftCardTransaction{
+			Branch_ID:  sale.Branch_ID,
+			Sale_ID:    sale.Sale_ID,
+			Type:       helpers.GiftCardVoided,
+			Amount:     -voided,
+			Note:       note,
+			Created_By: userID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IssueGiftCard issues a gift card outside of a sale, e.g. for promotions or
+// compensation. Cards bought by customers are sold through CreateSale.
+func IssueGiftCard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var reqBody struct {
+			Branch_ID   string  `json:"branch_id"`
+			Customer_ID string  `json:"customer_id"`
+			Amount      float64 `json:"amount"`
+			Note        string  `json:"note"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid request body", "details": err.Error()})
+			return
+		}
+		if reqBody.Amount <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Amount must be positive"})
+			return
+		}
+
+		if !authorizeBranch(c, reqBody.Branch_ID) {
+			return
+		}
+		if !validateCustomerID(ctx, c, reqBody.Customer_ID) {
+			return
+		}
+
+		card, code, err := issueGiftCard(ctx, models.GiftCard{
+			Branch_ID:   reqBody.Branch_ID,
+			Customer_ID: reqBody.Customer_ID,
+			Note:        strings.TrimSpace(reqBody.Note),
+			Created_By:  c.GetString("userId"),
+		}, reqBody.Amount)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error issuing gift card", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"success": true, "message": "Gift card issued successfully", "data": card, "code": code})
+	}
+}
+
+// GetGiftCardBalance is the balance inquiry at the till: it finds a card by the
+// code in the request body, so that codes do not end up in access logs.
+func GetGiftCardBalance() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var reqBody struct {
+			Code string `json:"code"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid request body", "details": err.Error()})
+			return
+		}
+
+		card, err := findGiftCardByCode(ctx, reqBody.Code)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Gift card not found", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Gift card balance retrieved successfully",
+			"data": gin.H{
+				"_id":        card.GiftCard_ID,
+				"code_last4": card.Code_Last4,
+				"balance":    card.Balance,
+				"status":     card.Status,
+			},
+		})
+	}
+}
+
+// GetGiftCards lists the gift cards issued by a branch, filtered by
+// ?customer_id= and ?status=.
+func GetGiftCards() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		for _, key := range []string{"branch_id", "customer_id", "status"} {
+			if value := c.Query(key); value != "" {
+				filter[key] = value
+			}
+		}
+
+		filter, ok := scopedFilter(c, filter)
+		if !ok {
+			return
+		}
+
+		cursor, err := GiftCardCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": -1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving gift cards", "details": err.Error()})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		cards := []models.GiftCard{}
+		if err := cursor.All(ctx, &cards); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error decoding gift cards", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Gift cards retrieved successfully", "data": cards})
+	}
+}
+
+// GetGiftCard shows a gift card with its ledger of loads and redemptions.
+func GetGiftCard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter, ok := scopedFilter(c, bson.M{"_id": c.Param("gift_card_id")})
+		if !ok {
+			return
+		}
+
+		var card models.GiftCard
+		if err := GiftCardCollection.FindOne(ctx, filter).Decode(&card); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Gift card not found", "details": err.Error()})
+			return
+		}
+
+		transactions, err := findGiftCardTransactions(ctx, card.GiftCard_ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving gift card ledger", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Gift card retrieved successfully", "data": card, "ledger": transactions})
+	}
+}
+
+// UpdateGiftCardStatus disables a lost or stolen card, or enables it again.
+func UpdateGiftCardStatus() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var reqBody struct {
+			Status string `json:"status"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid request body", "details": err.Error()})
+			return
+		}
+		if reqBody.Status != helpers.GiftCardActive && reqBody.Status != helpers.GiftCardDisabled {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid status: must be '001' or '002'"})
+			return
+		}
+
+		filter, ok := scopedFilter(c, bson.M{"_id": c.Param("gift_card_id")})
+		if !ok {
+			return
+		}
+
+		result, err := GiftCardCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"status": reqBody.Status, "updated_at": time.Now()}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error updating gift card", "details": err.Error()})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Gift card not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Gift card updated successfully"})
+	}
+}
+
+// GetMyGiftCards lists the gift cards in the signed-in customer's wallet.
+func GetMyGiftCards() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		cursor, err := GiftCardCollection.Find(ctx, bson.M{"customer_id": c.GetString("customerId")}, options.Find().SetSort(bson.M{"created_at": -1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving gift cards", "details": err.Error()})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		cards := []models.GiftCard{}
+		if err := cursor.All(ctx, &cards); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error decoding gift cards", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Gift cards retrieved successfully", "data": cards})
+	}
+}
+
+// AddMyGiftCard puts a gift card the customer has the code of into their
+// wallet, so it can be paid with without typing the code again.
+func AddMyGiftCard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var reqBody struct {
+			Code string `json:"code"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid request body", "details": err.Error()})
+			return
+		}
+
+		customerID := c.GetString("customerId")
+		var card models.GiftCard
+		err := GiftCardCollection.FindOneAndUpdate(ctx,
+			bson.M{
+				"code_hash": helpers.HashGiftCardCode(reqBody.Code),
+				"$or":       []bson.M{{"customer_id": bson.M{"$exists": false}}, {"customer_id": customerID}},
+			},
+			bson.M{"$set": bson.M{"customer_id": customerID, "updated_at": time.Now()}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&card)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Gift card not found or already in another wallet"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Gift card added to wallet successfully", "data": card})
+	}
+}
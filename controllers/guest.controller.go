@@ -0,0 +1,244 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	database "nano_food_api/database"
+	helpers "nano_food_api/helpers"
+	models "nano_food_api/models"
+	token "nano_food_api/tokens"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// tableFromQRToken resolves the signed token printed on a table's QR code.
+// Tokens from before the last rotation are rejected.
+func tableFromQRToken(ctx context.Context, qrToken string) (models.Table, error) {
+	claims, msg := token.ValidateTableToken(qrToken)
+	if msg != "" {
+		return models.Table{}, fmt.Errorf("%s", msg)
+	}
+
+	var table models.Table
+	err := TableCollection.FindOne(ctx, bson.M{"_id": claims.Table_ID}).Decode(&table)
+	if err != nil {
+		return models.Table{}, fmt.Errorf("table not found")
+	}
+
+	if table.QR_Nonce != claims.Nonce || table.Branch_ID != claims.Branch_ID {
+		return models.Table{}, fmt.Errorf("table QR code is no longer valid")
+	}
+
+	return table, nil
+}
+
+// validateGuestOrderItems makes sure guests only order available items of the
+// table's own branch.
+func validateGuestOrderItems(ctx context.Context, branchID string, menuItems []models.OrderItem) error {
+	if len(menuItems) == 0 {
+		return fmt.Errorf("order must contain at least one menu item")
+	}
+
+	for _, menuItem := range menuItems {
+		if menuItem.Quantity <= 0 {
+			return fmt.Errorf("invalid quantity for menu %s", menuItem.Menu_ID)
+		}
+
+		var menu models.Menu
+		err := MenuCollection.FindOne(ctx, bson.M{"_id": menuItem.Menu_ID, "branch_id": branchID}).Decode(&menu)
+		if err != nil {
+			return fmt.Errorf("invalid menu ID %s", menuItem.Menu_ID)
+		}
+		if !menu.IsAvailable {
+			return fmt.Errorf("%s is not available", menu.Title)
+		}
+
+		for _, addOnItem := range menuItem.AddOnItems {
+			if addOnItem.Quantity <= 0 {
+				return fmt.Errorf("invalid quantity for add-on %s", addOnItem.AddOnID)
+			}
+
+			var addOn models.AddOn
+			err := AddOnCollection.FindOne(ctx, bson.M{"_id": addOnItem.AddOnID, "menu_id": menu.Menu_ID}).Decode(&addOn)
+			if err != nil {
+				return fmt.Errorf("invalid add-on ID %s", addOnItem.AddOnID)
+			}
+			if !addOn.IsAvailable {
+				return fmt.Errorf("%s is not available", addOn.Title)
+			}
+		}
+	}
+
+	return nil
+}
+
+func GetGuestMenu() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		table, err := tableFromQRToken(ctx, c.Param("table_token"))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": "Invalid table token", "details": err.Error()})
+			return
+		}
+
+		var branch models.Branch
+		err = database.BranchCollection.FindOne(ctx, bson.M{"_id": table.Branch_ID}).Decode(&branch)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Branch not found", "details": err.Error()})
+			return
+		}
+
+		var categories []models.Category
+		cursor, err := CategoryCollection.Find(ctx, bson.M{"branch_id": table.Branch_ID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving categories", "details": err.Error()})
+			return
+		}
+		if err := cursor.All(ctx, &categories); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error decoding categories", "details": err.Error()})
+			return
+		}
+
+		pipeline := mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{"branch_id": table.Branch_ID, "is_available": true}}},
+			{{Key: "$lookup", Value: bson.M{
+				"from": "add_ons",
+				"let":  bson.M{"menu_id": "$_id"},
+				"pipeline": bson.A{
+					bson.M{"$match": bson.M{"$expr": bson.M{"$and": bson.A{
+						bson.M{"$eq": bson.A{"$menu_id", "$$menu_id"}},
+						bson.M{"$eq": bson.A{"$is_available", true}},
+					}}}},
+				},
+				"as": "add_on_details",
+			}}},
+		}
+
+		menuCursor, err := MenuCollection.Aggregate(ctx, pipeline)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to retrieve menus", "details": err.Error()})
+			return
+		}
+		defer menuCursor.Close(ctx)
+
+		var menus []bson.M
+		if err := menuCursor.All(ctx, &menus); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to parse menus", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Menu retrieved successfully",
+			"data": gin.H{
+				"branch":     branch,
+				"table":      gin.H{"_id": table.Table_ID, "name": table.Name},
+				"categories": categories,
+				"menus":      menus,
+			},
+		})
+	}
+}
+
+func CreateGuestOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		table, err := tableFromQRToken(ctx, c.Param("table_token"))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": "Invalid table token", "details": err.Error()})
+			return
+		}
+
+		var reqBody struct {
+			MenuItems []models.OrderItem `json:"menu_items"`
+			Note      string             `json:"note"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		if err := validateGuestOrderItems(ctx, table.Branch_ID, reqBody.MenuItems); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid order items", "details": err.Error()})
+			return
+		}
+
+		totalAmount, err := calculateOrderTotal(ctx, reqBody.MenuItems)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error calculating order total", "details": err.Error()})
+			return
+		}
+
+		order := models.Order{
+			Order_ID:    primitive.NewObjectID().Hex(),
+			Order_Type:  helpers.OrderTypeDineIn,
+			Table_ID:    table.Table_ID,
+			Branch_ID:   table.Branch_ID,
+			MenuItems:   reqBody.MenuItems,
+			TotalAmount: totalAmount,
+			Status:      "000",
+			Source:      "guest",
+			Note:        reqBody.Note,
+			IsPaid:      false,
+			Created_At:  time.Now(),
+			Updated_At:  time.Now(),
+		}
+
+		err = helpers.RunInTransaction(ctx, database.Client, func(ctx context.Context) error {
+			if _, err := OrderCollection.InsertOne(ctx, order); err != nil {
+				return err
+			}
+			return publishWebhookEvent(ctx, order.Branch_ID, helpers.WebhookOrderCreated, order)
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error creating order", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{
+			"success": true,
+			"message": "Order submitted successfully. A staff member will confirm it shortly.",
+			"data":    order,
+		})
+	}
+}
+
+func GetGuestOrders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		table, err := tableFromQRToken(ctx, c.Param("table_token"))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": "Invalid table token", "details": err.Error()})
+			return
+		}
+
+		filter := bson.M{"table_id": table.Table_ID, "is_paid": false, "status": bson.M{"$ne": "004"}}
+
+		cursor, err := OrderCollection.Aggregate(ctx, orderPipeline(filter))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving orders", "details": err.Error()})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		var orders []bson.M
+		if err := cursor.All(ctx, &orders); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error decoding orders", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Orders retrieved successfully", "data": orders})
+	}
+}
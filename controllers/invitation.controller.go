@@ -0,0 +1,422 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	database "nano_food_api/database"
+	helpers "nano_food_api/helpers"
+	models "nano_food_api/models"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var InvitationCollection *mongo.Collection = database.InvitationCollection
+
+// invitationExpiry is INVITE_EXPIRE hours from now, three days by default.
+func invitationExpiry() time.Time {
+	inviteExpire, err := strconv.Atoi(os.Getenv("INVITE_EXPIRE"))
+	if err != nil {
+		inviteExpire = 72
+	}
+	return time.Now().Add(time.Duration(inviteExpire) * time.Hour)
+}
+
+// sendInvitationEmail queues the email with the invite link. The link points
+// at INVITE_URL when configured, otherwise the bare token is sent.
+func sendInvitationEmail(ctx context.Context, invitation models.Invitation, branchName string, inviteToken string) error {
+	inviteLink := inviteToken
+	if inviteURL := os.Getenv("INVITE_URL"); inviteURL != "" {
+		inviteLink = inviteURL + "?token=" + inviteToken
+	}
+	subject := "You're Invited to Join " + branchName + " on NanoFood"
+	body := fmt.Sprintf("Hi %s,<br/>You have been invited to join <b>%s</b> on NanoFood. Use the following link to set your password and activate your account: <b>%s</b><br/>The invitation expires on %s.", invitation.Name, branchName, inviteLink, invitation.Expires_At.Format("2 Jan 2006 15:04"))
+
+	return enqueueEmail(ctx, invitation.Email, subject, body)
+}
+
+// findPendingInvitation loads an invitation that can still be resent or revoked
+// by the caller.
+func findPendingInvitation(ctx context.Context, c *gin.Context, invitationID string) (models.Invitation, bool) {
+	var invitation models.Invitation
+	err := InvitationCollection.FindOne(ctx, bson.M{"_id": invitationID}).Decode(&invitation)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Invitation not found", "details": err.Error()})
+		return invitation, false
+	}
+
+	if !authorizeBranch(c, invitation.Branch_ID) {
+		return invitation, false
+	}
+
+	if invitation.Status != "001" {
+		c.JSON(http.StatusConflict, gin.H{"success": false, "error": "Invitation is no longer pending"})
+		return invitation, false
+	}
+	return invitation, true
+}
+
+func InviteUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var reqBody struct {
+			Email     string `json:"email" binding:"required"`
+			Name      string `json:"name" binding:"required"`
+			Branch_ID string `json:"branch_id" binding:"required"`
+			Role      int    `json:"role"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		reqBody.Email = helpers.NormalizeEmail(reqBody.Email)
+
+		if !govalidator.IsEmail(reqBody.Email) {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid email format!"})
+			return
+		}
+
+		if !helpers.Contains([]int{0, 1, 2}, reqBody.Role) {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid role! Allowed roles are 0, 1, or 2."})
+			return
+		}
+
+		if !authorizeBranch(c, reqBody.Branch_ID) {
+			return
+		}
+
+		scope, err := helpers.GetBranchScope(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		if !helpers.Contains(assignableRoleLevels(scope.Role), reqBody.Role) {
+			c.JSON(http.StatusForbidden, gin.H{"success": false, "error": "You are not authorized to invite users with this role"})
+			return
+		}
+
+		var branch models.Branch
+		err = BranchCollection.FindOne(ctx, bson.M{"_id": reqBody.Branch_ID}).Decode(&branch)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Branch not found", "details": err.Error()})
+			return
+		}
+
+		userExists, err := helpers.CheckDataExist(ctx, UserCollection, bson.M{"email": reqBody.Email})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving user", "details": err.Error()})
+			return
+		}
+		if userExists {
+			c.JSON(http.StatusConflict, gin.H{"success": false, "error": "User already exists! Add them to the branch instead."})
+			return
+		}
+
+		inviteExists, err := helpers.CheckDataExist(ctx, InvitationCollection, bson.M{"email": reqBody.Email, "status": "001"})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving invitations", "details": err.Error()})
+			return
+		}
+		if inviteExists {
+			c.JSON(http.StatusConflict, gin.H{"success": false, "error": "This email already has a pending invitation. Resend or revoke it instead."})
+			return
+		}
+
+		inviteToken, err := helpers.GenerateRandomToken(32)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error generating invitation token", "details": err.Error()})
+			return
+		}
+
+		invitation := models.Invitation{
+			Invitation_ID: primitive.NewObjectID().Hex(),
+			Email:         reqBody.Email,
+			Name:          strings.TrimSpace(reqBody.Name),
+			Branch_ID:     reqBody.Branch_ID,
+			Role:          reqBody.Role,
+			Token:         helpers.HashToken(inviteToken),
+			Status:        "001",
+			Invited_By:    scope.User_ID,
+			Expires_At:    invitationExpiry(),
+			Created_At:    time.Now(),
+			Updated_At:    time.Now(),
+		}
+
+		err = helpers.RunInTransaction(ctx, database.Client, func(ctx context.Context) error {
+			if _, err := InvitationCollection.InsertOne(ctx, invitation); err != nil {
+				return err
+			}
+			return sendInvitationEmail(ctx, invitation, branch.Name, inviteToken)
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error creating invitation", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"success": true, "message": "Invitation sent successfully", "data": invitation})
+	}
+}
+
+func GetBranchInvitations() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		branchID := c.Param("branch_id")
+
+		if !authorizeBranch(c, branchID) {
+			return
+		}
+
+		filter := bson.M{"branch_id": branchID}
+		if status := c.Query("status"); status != "" {
+			filter["status"] = status
+		}
+
+		cursor, err := InvitationCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": -1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving invitations", "details": err.Error()})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		var invitations []models.Invitation
+		if err := cursor.All(ctx, &invitations); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error decoding invitations", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Invitations retrieved successfully", "data": invitations})
+	}
+}
+
+// ResendInvitation mails a fresh link and extends the expiry. Links sent before
+// stop working.
+func ResendInvitation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		invitation, ok := findPendingInvitation(ctx, c, c.Param("invitation_id"))
+		if !ok {
+			return
+		}
+
+		var branch models.Branch
+		err := BranchCollection.FindOne(ctx, bson.M{"_id": invitation.Branch_ID}).Decode(&branch)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Branch not found", "details": err.Error()})
+			return
+		}
+
+		inviteToken, err := helpers.GenerateRandomToken(32)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error generating invitation token", "details": err.Error()})
+			return
+		}
+
+		invitation.Token = helpers.HashToken(inviteToken)
+		invitation.Expires_At = invitationExpiry()
+		err = helpers.RunInTransaction(ctx, database.Client, func(ctx context.Context) error {
+			_, err := InvitationCollection.UpdateOne(
+				ctx,
+				bson.M{"_id": invitation.Invitation_ID, "status": "001"},
+				bson.M{"$set": bson.M{"token": invitation.Token, "expires_at": invitation.Expires_At, "updated_at": time.Now()}},
+			)
+			if err != nil {
+				return err
+			}
+			return sendInvitationEmail(ctx, invitation, branch.Name, inviteToken)
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error updating invitation", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Invitation resent successfully", "data": invitation})
+	}
+}
+
+func RevokeInvitation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		invitation, ok := findPendingInvitation(ctx, c, c.Param("invitation_id"))
+		if !ok {
+			return
+		}
+
+		_, err := InvitationCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": invitation.Invitation_ID, "status": "001"},
+			bson.M{"$set": bson.M{"status": "003", "updated_at": time.Now()}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error revoking invitation", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Invitation revoked successfully"})
+	}
+}
+
+// GetInvitation shows the invitee who invited them where, before they set a password.
+func GetInvitation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		inviteToken := c.Query("token")
+		if inviteToken == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invitation token is required"})
+			return
+		}
+
+		var invitation models.Invitation
+		err := InvitationCollection.FindOne(ctx, bson.M{
+			"token":      helpers.HashToken(inviteToken),
+			"status":     "001",
+			"expires_at": bson.M{"$gt": time.Now()},
+		}).Decode(&invitation)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid or expired invitation"})
+			return
+		}
+
+		var branch models.Branch
+		err = BranchCollection.FindOne(ctx, bson.M{"_id": invitation.Branch_ID}).Decode(&branch)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Branch not found", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Invitation retrieved successfully",
+			"data": gin.H{
+				"email":      invitation.Email,
+				"name":       invitation.Name,
+				"role":       invitation.Role,
+				"branch":     gin.H{"_id": branch.Branch_ID, "name": branch.Name},
+				"expires_at": invitation.Expires_At,
+			},
+		})
+	}
+}
+
+// reopenInvitation hands a claimed invitation back so the invitee can try again.
+func reopenInvitation(ctx context.Context, invitationID string) {
+	_, err := InvitationCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": invitationID},
+		bson.M{"$set": bson.M{"status": "001", "updated_at": time.Now()}, "$unset": bson.M{"user_id": "", "accepted_at": ""}},
+	)
+	if err != nil {
+		log.Printf("Error reopening invitation: %v", err)
+	}
+}
+
+// AcceptInvitation creates the invitee's account with the password they chose.
+// The email address is considered verified since the link was sent to it.
+func AcceptInvitation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var reqBody struct {
+			Token    string `json:"token" binding:"required"`
+			Password string `json:"password" binding:"required"`
+			Name     string `json:"name"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		if len(reqBody.Password) < 6 {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Password must be at least 6 characters!"})
+			return
+		}
+
+		hashedPassword, err := helpers.HashPassword(reqBody.Password)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error hashing password", "details": err.Error()})
+			return
+		}
+
+		userID := primitive.NewObjectID().Hex()
+
+		// Claim the invitation first so the same link cannot create two accounts
+		var invitation models.Invitation
+		err = InvitationCollection.FindOneAndUpdate(
+			ctx,
+			bson.M{
+				"token":      helpers.HashToken(reqBody.Token),
+				"status":     "001",
+				"expires_at": bson.M{"$gt": time.Now()},
+			},
+			bson.M{"$set": bson.M{"status": "002", "user_id": userID, "accepted_at": time.Now(), "updated_at": time.Now()}},
+		).Decode(&invitation)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid or expired invitation"})
+			return
+		}
+
+		// Someone may have registered with the invited email since it was sent
+		userExists, err := helpers.CheckDataExist(ctx, UserCollection, bson.M{"email": invitation.Email})
+		if err != nil || userExists {
+			reopenInvitation(ctx, invitation.Invitation_ID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to validate email", "details": err.Error()})
+				return
+			}
+			c.JSON(http.StatusConflict, gin.H{"success": false, "error": "An account with this email already exists"})
+			return
+		}
+
+		name := invitation.Name
+		if strings.TrimSpace(reqBody.Name) != "" {
+			name = strings.TrimSpace(reqBody.Name)
+		}
+
+		user := models.User{
+			User_ID:          userID,
+			Branch_ID:        invitation.Branch_ID,
+			Name:             name,
+			Email:            invitation.Email,
+			Password:         hashedPassword,
+			Role:             invitation.Role,
+			IsVerified:       true,
+			TwoFactorEnabled: false,
+			Created_At:       time.Now(),
+			Updated_At:       time.Now(),
+		}
+
+		_, err = UserCollection.InsertOne(ctx, user)
+		if err != nil {
+			reopenInvitation(ctx, invitation.Invitation_ID)
+			if mongo.IsDuplicateKeyError(err) {
+				c.JSON(http.StatusConflict, gin.H{"success": false, "error": "An account with this email already exists"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error creating user", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"success": true, "message": "Your account is ready. You can now log in."})
+	}
+}
@@ -0,0 +1,526 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	database "nano_food_api/database"
+	helpers "nano_food_api/helpers"
+	models "nano_food_api/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var JobCollection *mongo.Collection = database.JobCollection
+
+// errJobNoRetry marks job failures that retrying cannot fix.
+var errJobNoRetry = fmt.Errorf("not retried")
+
+const jobPollInterval = time.Second
+
+// jobHandler runs the jobs of one type. At most concurrency of them run at
+// once in each process, and a run is cut off after timeout; a job whose
+// worker died is picked up again once its timeout has passed.
+type jobHandler struct {
+	run         func(ctx context.Context, job models.Job) (interface{}, error)
+	concurrency int
+	maxAttempts int
+	timeout     time.Duration
+	backoff     func(attempts int) time.Duration
+	secret      bool // the payload is cleared once the job finishes
+}
+
+var jobHandlers = map[string]jobHandler{}
+
+// jobSchedule queues a job of its type every interval.
+type jobSchedule struct {
+	jobType string
+	every   time.Duration
+}
+
+var jobSchedules = []jobSchedule{
+	{jobType: helpers.JobPreorderReminders, every: time.Minute},
+}
+
+func init() {
+	jobHandlers[helpers.JobSendEmail] = jobHandler{run: runSendEmailJob, concurrency: 2, maxAttempts: 5, timeout: time.Minute, backoff: helpers.JobRetryDelay, secret: true}
+	jobHandlers[helpers.JobSendSMS] = jobHandler{run: runSendSMSJob, concurrency: 2, maxAttempts: 5, timeout: time.Minute, backoff: helpers.JobRetryDelay, secret: true}
+	jobHandlers[helpers.JobDeliverWebhook] = jobHandler{run: runDeliverWebhookJob, concurrency: 4, maxAttempts: helpers.MaxWebhookAttempts, timeout: time.Minute, backoff: helpers.WebhookRetryDelay}
+	jobHandlers[helpers.JobDeleteImages] = jobHandler{run: runDeleteImagesJob, concurrency: 1, maxAttempts: 5, timeout: 5 * time.Minute, backoff: helpers.JobRetryDelay}
+	jobHandlers[helpers.JobGenerateReport] = jobHandler{run: runSalesReportJob, concurrency: 1, maxAttempts: 3, timeout: 10 * time.Minute, backoff: helpers.JobRetryDelay}
+	jobHandlers[helpers.JobPreorderReminders] = jobHandler{run: runPreorderRemindersJob, concurrency: 1, maxAttempts: 1, timeout: time.Minute, backoff: helpers.JobRetryDelay}
+}
+
+// redactJob hides the payload of jobs that carry secrets, such as the codes and
+// links of queued emails, from API responses.
+func redactJob(job models.Job) models.Job {
+	if handler, ok := jobHandlers[job.Type]; !ok || handler.secret {
+		job.Payload = ""
+	}
+	return job
+}
+
+// jobWake tells an idle worker that a job was queued in this process.
+var jobWake = make(chan struct{}, 1)
+
+// jobSlots holds one token per running job of each type.
+var jobSlots = map[string]chan struct{}{}
+
+// enqueueJob queues a job with its type's attempt limit. Pass the context of a
+// transaction to queue it together with a business change.
+func enqueueJob(ctx context.Context, job models.Job) (models.Job, error) {
+	if handler, ok := jobHandlers[job.Type]; ok && job.Max_Attempts == 0 {
+		job.Max_Attempts = handler.maxAttempts
+	}
+	job, err := helpers.EnqueueJob(ctx, JobCollection, job)
+	if err == nil {
+		select {
+		case jobWake <- struct{}{}:
+		default:
+		}
+	}
+	return job, err
+}
+
+// enqueueEmail queues an email, so that a slow or failing mail server neither
+// holds up nor fails the request that sends it.
+func enqueueEmail(ctx context.Context, to string, subject string, body string) error {
+	job, err := helpers.NewJob(helpers.JobSendEmail, emailJob{To: to, Subject: subject, Body: body})
+	if err != nil {
+		return err
+	}
+	_, err = enqueueJob(ctx, job)
+	return err
+}
+
+type emailJob struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+func runSendEmailJob(ctx context.Context, job models.Job) (interface{}, error) {
+	var email emailJob
+	if err := json.Unmarshal([]byte(job.Payload), &email); err != nil {
+		return nil, fmt.Errorf("%v: %w", err, errJobNoRetry)
+	}
+	return nil, helpers.SendEmail(email.To, email.Subject, email.Body)
+}
+
+// enqueueSMS queues a text message, like enqueueEmail.
+func enqueueSMS(ctx context.Context, phone string, message string) error {
+	job, err := helpers.NewJob(helpers.JobSendSMS, smsJob{Phone: phone, Message: message})
+	if err != nil {
+		return err
+	}
+	_, err = enqueueJob(ctx, job)
+	return err
+}
+
+type smsJob struct {
+	Phone   string `json:"phone"`
+	Message string `json:"message"`
+}
+
+func runSendSMSJob(ctx context.Context, job models.Job) (interface{}, error) {
+	var sms smsJob
+	if err := json.Unmarshal([]byte(job.Payload), &sms); err != nil {
+		return nil, fmt.Errorf("%v: %w", err, errJobNoRetry)
+	}
+	return nil, helpers.SendSMS(sms.Phone, sms.Message)
+}
+
+// enqueueImageDeletion queues the removal of images that are no longer used.
+func enqueueImageDeletion(ctx context.Context, paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	job, err := helpers.NewJob(helpers.JobDeleteImages, imageDeletionJob{Paths: paths})
+	if err != nil {
+		return err
+	}
+	_, err = enqueueJob(ctx, job)
+	return err
+}
+
+type imageDeletionJob struct {
+	Paths []string `json:"paths"`
+}
+
+func runDeleteImagesJob(ctx context.Context, job models.Job) (interface{}, error) {
+	var deletion imageDeletionJob
+	if err := json.Unmarshal([]byte(job.Payload), &deletion); err != nil {
+		return nil, fmt.Errorf("%v: %w", err, errJobNoRetry)
+	}
+
+	failed := []string{}
+	for _, path := range deletion.Paths {
+		if err := FileStorage.Delete(ctx, path); err != nil {
+			log.Printf("Error deleting image %s: %v", path, err)
+			failed = append(failed, path)
+		}
+	}
+	if len(failed) > 0 {
+		return nil, fmt.Errorf("could not delete %d of %d images", len(failed), len(deletion.Paths))
+	}
+	return nil, nil
+}
+
+func runPreorderRemindersJob(ctx context.Context, job models.Job) (interface{}, error) {
+	return nil, sendPreorderReminders(ctx)
+}
+
+// claimJob marks the next due job of a type as running on this worker. Jobs
+// left running by a worker that died count as due once their lock expires.
+func claimJob(ctx context.Context, workerID string, jobType string) (models.Job, error) {
+	now := time.Now()
+	handler := jobHandlers[jobType]
+
+	var job models.Job
+	err := JobCollection.FindOneAndUpdate(ctx,
+		bson.M{"type": jobType, "$or": []bson.M{
+			{"status": helpers.JobQueued, "run_at": bson.M{"$lte": now}},
+			{"status": helpers.JobRunning, "locked_until": bson.M{"$lt": now}},
+		}},
+		bson.M{
+			"$set": bson.M{
+				"status":       helpers.JobRunning,
+				"worker_id":    workerID,
+				"locked_until": now.Add(handler.timeout),
+				"started_at":   now,
+				"updated_at":   now,
+			},
+			"$inc": bson.M{"attempts": 1},
+		},
+		options.FindOneAndUpdate().SetSort(bson.M{"run_at": 1}).SetReturnDocument(options.After),
+	).Decode(&job)
+	return job, err
+}
+
+// runJob runs a claimed job and records how it went. Failed jobs are queued
+// again after their type's backoff until they run out of attempts.
+func runJob(workerID string, job models.Job) {
+	handler := jobHandlers[job.Type]
+
+	ctx, cancel := context.WithTimeout(context.Background(), handler.timeout)
+	result, err := func() (result interface{}, err error) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				err = fmt.Errorf("panic: %v", recovered)
+			}
+		}()
+		return handler.run(ctx, job)
+	}()
+	cancel()
+
+	now := time.Now()
+	set := bson.M{"updated_at": now}
+	unset := bson.M{"locked_until": "", "worker_id": ""}
+	switch {
+	case err == nil:
+		set["status"] = helpers.JobCompleted
+		set["finished_at"] = now
+		unset["last_error"] = ""
+		if result != nil {
+			set["result"] = result
+		}
+	case job.Attempts >= job.Max_Attempts || errors.Is(err, errJobNoRetry):
+		set["status"] = helpers.JobFailed
+		set["finished_at"] = now
+		set["last_error"] = err.Error()
+		log.Printf("Job %s (%s) failed after %d attempts: %v", job.Job_ID, job.Type, job.Attempts, err)
+	default:
+		set["status"] = helpers.JobQueued
+		set["run_at"] = now.Add(handler.backoff(job.Attempts))
+		set["last_error"] = err.Error()
+	}
+	if handler.secret && set["status"] != helpers.JobQueued {
+		unset["payload"] = ""
+	}
+
+	// A job cancelled or reclaimed in the meantime is left as it is
+	writeCtx, writeCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer writeCancel()
+	_, updateErr := JobCollection.UpdateOne(writeCtx,
+		bson.M{"_id": job.Job_ID, "status": helpers.JobRunning, "worker_id": workerID},
+		bson.M{"$set": set, "$unset": unset},
+	)
+	if updateErr != nil {
+		log.Printf("Error recording the outcome of job %s: %v", job.Job_ID, updateErr)
+	}
+}
+
+// runNextJob runs one due job of a type with a free slot, trying the types
+// from offset on so that no type starves the others. It returns false when
+// there was nothing to run.
+func runNextJob(workerID string, jobTypes []string, offset int) bool {
+	for i := range jobTypes {
+		jobType := jobTypes[(offset+i)%len(jobTypes)]
+		slots := jobSlots[jobType]
+		select {
+		case slots <- struct{}{}:
+		default:
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		job, err := claimJob(ctx, workerID, jobType)
+		cancel()
+		if err != nil {
+			<-slots
+			if err != mongo.ErrNoDocuments {
+				log.Printf("Error claiming %s job: %v", jobType, err)
+			}
+			continue
+		}
+
+		runJob(workerID, job)
+		<-slots
+		return true
+	}
+	return false
+}
+
+func runJobWorker(workerID string, jobTypes []string) {
+	for offset := 0; ; offset++ {
+		if runNextJob(workerID, jobTypes, offset) {
+			continue
+		}
+		select {
+		case <-jobWake:
+		case <-time.After(jobPollInterval):
+		}
+	}
+}
+
+// runJobSchedule queues a job every interval. The job's unique key is the start
+// of the interval, so several instances queue it only once.
+func runJobSchedule(schedule jobSchedule) {
+	ticker := time.NewTicker(schedule.every)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		job := models.Job{
+			Type:       schedule.jobType,
+			Payload:    "{}",
+			Unique_Key: schedule.jobType + "@" + now.Truncate(schedule.every).UTC().Format(time.RFC3339),
+		}
+		if _, err := enqueueJob(ctx, job); err != nil {
+			log.Printf("Error queueing scheduled %s job: %v", schedule.jobType, err)
+		}
+		cancel()
+	}
+}
+
+// StartJobWorkers starts JOB_WORKERS workers (4 by default) and the recurring
+// job schedules.
+func StartJobWorkers() {
+	workers, err := strconv.Atoi(os.Getenv("JOB_WORKERS"))
+	if err != nil || workers <= 0 {
+		workers = 4
+	}
+
+	jobTypes := []string{}
+	for jobType, handler := range jobHandlers {
+		jobTypes = append(jobTypes, jobType)
+		jobSlots[jobType] = make(chan struct{}, handler.concurrency)
+	}
+	sort.Strings(jobTypes)
+
+	hostname, _ := os.Hostname()
+	for i := 0; i < workers; i++ {
+		go runJobWorker(fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), i), jobTypes)
+	}
+	for _, schedule := range jobSchedules {
+		go runJobSchedule(schedule)
+	}
+}
+
+// GetJobs lists jobs newest first, filtered by ?type=, ?status= and
+// ?branch_id=, in pages of ?limit= (100 by default, at most 500).
+func GetJobs() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		for _, key := range []string{"type", "status", "branch_id"} {
+			if value := c.Query(key); value != "" {
+				filter[key] = value
+			}
+		}
+
+		limit, err := strconv.Atoi(c.Query("limit"))
+		if err != nil || limit <= 0 {
+			limit = 100
+		}
+		if limit > 500 {
+			limit = 500
+		}
+		page, err := strconv.Atoi(c.Query("page"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+
+		total, err := JobCollection.CountDocuments(ctx, filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error counting jobs", "details": err.Error()})
+			return
+		}
+
+		findOptions := options.Find().
+			SetSort(bson.M{"created_at": -1}).
+			SetSkip(int64((page - 1) * limit)).
+			SetLimit(int64(limit))
+		cursor, err := JobCollection.Find(ctx, filter, findOptions)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving jobs", "details": err.Error()})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		jobs := []models.Job{}
+		if err := cursor.All(ctx, &jobs); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error decoding jobs", "details": err.Error()})
+			return
+		}
+		for i := range jobs {
+			jobs[i] = redactJob(jobs[i])
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Jobs retrieved successfully",
+			"data":    jobs,
+			"page":    page,
+			"limit":   limit,
+			"total":   total,
+		})
+	}
+}
+
+// GetJobStats counts the jobs of each type by status.
+func GetJobStats() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		pipeline := mongo.Pipeline{
+			{{Key: "$group", Value: bson.M{
+				"_id":   bson.M{"type": "$type", "status": "$status"},
+				"count": bson.M{"$sum": 1},
+			}}},
+		}
+		cursor, err := JobCollection.Aggregate(ctx, pipeline)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error counting jobs", "details": err.Error()})
+			return
+		}
+		var counts []struct {
+			Key struct {
+				Type   string `bson:"type"`
+				Status string `bson:"status"`
+			} `bson:"_id"`
+			Count int `bson:"count"`
+		}
+		if err := cursor.All(ctx, &counts); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error decoding job counts", "details": err.Error()})
+			return
+		}
+
+		stats := map[string]map[string]int{}
+		for _, count := range counts {
+			if stats[count.Key.Type] == nil {
+				stats[count.Key.Type] = map[string]int{}
+			}
+			stats[count.Key.Type][count.Key.Status] = count.Count
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Job stats retrieved successfully", "data": stats})
+	}
+}
+
+func GetJob() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var job models.Job
+		if err := JobCollection.FindOne(ctx, bson.M{"_id": c.Param("job_id")}).Decode(&job); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Job not found", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Job retrieved successfully", "data": redactJob(job)})
+	}
+}
+
+// RetryJob queues a failed or cancelled job again with a fresh set of
+// attempts. Jobs whose payload was cleared cannot be retried.
+func RetryJob() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		result, err := JobCollection.UpdateOne(ctx,
+			bson.M{
+				"_id":     c.Param("job_id"),
+				"status":  bson.M{"$in": []string{helpers.JobFailed, helpers.JobCancelled}},
+				"payload": bson.M{"$exists": true},
+			},
+			bson.M{
+				"$set":   bson.M{"status": helpers.JobQueued, "attempts": 0, "run_at": time.Now(), "updated_at": time.Now()},
+				"$unset": bson.M{"finished_at": "", "last_error": ""},
+			},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrying job", "details": err.Error()})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusConflict, gin.H{"success": false, "error": "Job not found, not failed or cancelled, or its payload was cleared"})
+			return
+		}
+
+		select {
+		case jobWake <- struct{}{}:
+		default:
+		}
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Job queued again successfully"})
+	}
+}
+
+// CancelJob cancels a job that has not started yet.
+func CancelJob() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		result, err := JobCollection.UpdateOne(ctx,
+			bson.M{"_id": c.Param("job_id"), "status": helpers.JobQueued},
+			bson.M{"$set": bson.M{"status": helpers.JobCancelled, "finished_at": time.Now(), "updated_at": time.Now()}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error cancelling job", "details": err.Error()})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusConflict, gin.H{"success": false, "error": "Job not found or not queued"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Job cancelled successfully"})
+	}
+}
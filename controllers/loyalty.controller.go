@@ -0,0 +1,495 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	database "nano_food_api/database"
+	helpers "nano_food_api/helpers"
+	models "nano_food_api/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var LoyaltySettingsCollection *mongo.Collection = database.LoyaltySettingsCollection
+var LoyaltyTransactionCollection *mongo.Collection = database.LoyaltyTransactionCollection
+
+var errInsufficientPoints = fmt.Errorf("not enough loyalty points")
+
+// maxPointsAdjustment reads LOYALTY_MAX_ADJUSTMENT, the most points staff may add
+// or take away in one manual adjustment.
+func maxPointsAdjustment() int {
+	limit, err := strconv.Atoi(os.Getenv("LOYALTY_MAX_ADJUSTMENT"))
+	if err != nil || limit <= 0 {
+		return 1000
+	}
+	return limit
+}
+
+// loadLoyaltySettings returns the loyalty programme, or the default one while
+// none has been saved.
+func loadLoyaltySettings(ctx context.Context) (models.LoyaltySettings, error) {
+	var settings models.LoyaltySettings
+	err := LoyaltySettingsCollection.FindOne(ctx, bson.M{"_id": helpers.LoyaltySettingsID}).Decode(&settings)
+	if err == mongo.ErrNoDocuments {
+		return helpers.DefaultLoyaltySettings(), nil
+	}
+	if err != nil {
+		return settings, err
+	}
+	helpers.SortLoyaltyTiers(settings.Tiers)
+	return settings, nil
+}
+
+// updateCustomerTier moves a customer to the tier their lifetime points reach.
+func updateCustomerTier(ctx context.Context, settings models.LoyaltySettings, customerID string) error {
+	var customer models.Customer
+	if err := CustomerCollection.FindOne(ctx, bson.M{"_id": customerID}).Decode(&customer); err != nil {
+		return err
+	}
+
+	tier, _ := helpers.LoyaltyTierFor(settings, customer.Lifetime_Points)
+	if tier.Name == customer.Tier {
+		return nil
+	}
+	_, err := CustomerCollection.UpdateOne(ctx, bson.M{"_id": customerID}, bson.M{"$set": bson.M{"tier": tier.Name, "updated_at": time.Now()}})
+	return err
+}
+
+// creditPoints records points given to a customer and adds them to their
+// balance. Earned points also count towards their tier.
+func creditPoints(ctx context.Context, settings models.LoyaltySettings, transaction models.LoyaltyTransaction) error {
+	transaction.Transaction_ID = primitive.NewObjectID().Hex()
+	transaction.Remaining = transaction.Points
+	transaction.Created_At = time.Now()
+	transaction.Expires_At = helpers.PointsExpiry(settings, transaction.Created_At)
+
+	if _, err := LoyaltyTransactionCollection.InsertOne(ctx, transaction); err != nil {
+		return err
+	}
+
+	inc := bson.M{"loyalty_points": transaction.Points}
+	if transaction.Type == helpers.LoyaltyEarned {
+		inc["lifetime_points"] = transaction.Points
+	}
+	if _, err := CustomerCollection.UpdateOne(ctx, bson.M{"_id": transaction.Customer_ID}, bson.M{"$inc": inc}); err != nil {
+		return err
+	}
+
+	if transaction.Type == helpers.LoyaltyEarned {
+		return updateCustomerTier(ctx, settings, transaction.Customer_ID)
+	}
+	return nil
+}
+
+// debitPoints takes transaction.Points from a customer's balance, oldest points
+// first, and records it as a negative entry. It fails with errInsufficientPoints
+// without changing anything when the balance is too low.
+func debitPoints(ctx context.Context, transaction models.LoyaltyTransaction) error {
+	if err := expireCustomerPoints(ctx, transaction.Customer_ID); err != nil {
+		return err
+	}
+
+	points := transaction.Points
+	result, err := CustomerCollection.UpdateOne(ctx,
+		bson.M{"_id": transaction.Customer_ID, "loyalty_points": bson.M{"$gte": points}},
+		bson.M{"$inc": bson.M{"loyalty_points": -points}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errInsufficientPoints
+	}
+
+	if err := consumePoints(ctx, transaction.Customer_ID, points); err != nil {
+		return err
+	}
+
+	transaction.Transaction_ID = primitive.NewObjectID().Hex()
+	transaction.Points = -points
+	transaction.Created_At = time.Now()
+	_, err = LoyaltyTransactionCollection.InsertOne(ctx, transaction)
+	return err
+}
+
+// consumePoints lowers what is left of a customer's credited points, oldest
+// first, so that redeemed points are not expired again later.
+func consumePoints(ctx context.Context, customerID string, points int) error {
+	cursor, err := LoyaltyTransactionCollection.Find(ctx,
+		bson.M{"customer_id": customerID, "remaining": bson.M{"$gt": 0}},
+		options.Find().SetSort(bson.M{"created_at": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var credits []models.LoyaltyTransaction
+	if err := cursor.All(ctx, &credits); err != nil {
+		return err
+	}
+
+	for _, credit := range credits {
+		if points <= 0 {
+			break
+		}
+		take := credit.Remaining
+		if take > points {
+			take = points
+		}
+		result, err := LoyaltyTransactionCollection.UpdateOne(ctx,
+			bson.M{"_id": credit.Transaction_ID, "remaining": credit.Remaining},
+			bson.M{"$inc": bson.M{"remaining": -take}},
+		)
+		if err != nil {
+			return err
+		}
+		if result.ModifiedCount > 0 {
+			points -= take
+		}
+	}
+	return nil
+}
+
+// expireCustomerPoints takes the unspent points that are past their expiry off a
+// customer's balance. Expiry is applied whenever the balance is read or spent.
+func expireCustomerPoints(ctx context.Context, customerID string) error {
+	now := time.Now()
+	cursor, err := LoyaltyTransactionCollection.Find(ctx, bson.M{
+		"customer_id": customerID,
+		"remaining":   bson.M{"$gt": 0},
+		"expires_at":  bson.M{"$lte": now},
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var expired []models.LoyaltyTransaction
+	if err := cursor.All(ctx, &expired); err != nil {
+		return err
+	}
+
+	for _, credit := range expired {
+		// Claim the points first so that concurrent reads expire them only once
+		result, err := LoyaltyTransactionCollection.UpdateOne(ctx,
+			bson.M{"_id": credit.Transaction_ID, "remaining": credit.Remaining},
+			bson.M{"$set": bson.M{"remaining": 0}},
+		)
+		if err != nil {
+			return err
+		}
+		if result.ModifiedCount == 0 {
+			continue
+		}
+
+		if _, err := CustomerCollection.UpdateOne(ctx, bson.M{"_id": customerID}, bson.M{"$inc": bson.M{"loyalty_points": -credit.Remaining}}); err != nil {
+			return err
+		}
+		_, err = LoyaltyTransactionCollection.InsertOne(ctx, models.LoyaltyTransaction{
+			Transaction_ID: primitive.NewObjectID().Hex(),
+			Customer_ID:    customerID,
+			Type:           helpers.LoyaltyExpired,
+			Points:         -credit.Remaining,
+			Note:           fmt.Sprintf("Points earned on %s expired", credit.Created_At.Format("2006-01-02")),
+			Created_At:     now,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reverseSalePoints undoes the loyalty effects of a refunded sale: earned points
+// are taken back, even if that leaves a negative balance because they were
+// already spent, and redeemed points are given back.
+func reverseSalePoints(ctx context.Context, settings models.LoyaltySettings, sale models.Sale, userID string) error {
+	if sale.Customer_ID == "" {
+		return nil
+	}
+
+	if sale.Points_Earned > 0 {
+		_, err := LoyaltyTransactionCollection.UpdateMany(ctx,
+			bson.M{"sale_id": sale.Sale_ID, "type": helpers.LoyaltyEarned},
+			bson.M{"$set": bson.M{"remaining": 0}},
+		)
+		if err != nil {
+			return err
+		}
+
+		_, err = CustomerCollection.UpdateOne(ctx, bson.M{"_id": sale.Customer_ID}, bson.M{"$inc": bson.M{
+			"loyalty_points":  -sale.Points_Earned,
+			"lifetime_points": -sale.Points_Earned,
+		}})
+		if err != nil {
+			return err
+		}
+
+		_, err = LoyaltyTransactionCollection.InsertOne(ctx, models.LoyaltyTransaction{
+			Transaction_ID: primitive.NewObjectID().Hex(),
+			Customer_ID:    sale.Customer_ID,
+			Branch_ID:      sale.Branch_ID,
+			Sale_ID:        sale.Sale_ID,
+			Type:           helpers.LoyaltyReversed,
+			Points:         -sale.Points_Earned,
+			Note:           "Points earned on a refunded sale",
+			Created_By:     userID,
+			Created_At:     time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := updateCustomerTier(ctx, settings, sale.Customer_ID); err != nil {
+			return err
+		}
+	}
+
+	if sale.Points_Redeemed > 0 {
+		return creditPoints(ctx, settings, models.LoyaltyTransaction{
+			Customer_ID: sale.Customer_ID,
+			Branch_ID:   sale.Branch_ID,
+			Sale_ID:     sale.Sale_ID,
+			Type:        helpers.LoyaltyReversed,
+			Points:      sale.Points_Redeemed,
+			Note:        "Points redeemed on a refunded sale",
+			Created_By:  userID,
+		})
+	}
+	return nil
+}
+
+// respondCustomerLoyalty responds with a customer's balance, tier, progress to
+// the next tier and their latest ?limit= (50 by default) ledger entries.
+func respondCustomerLoyalty(ctx context.Context, c *gin.Context, customerID string) {
+	settings, err := loadLoyaltySettings(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving loyalty settings", "details": err.Error()})
+		return
+	}
+
+	if err := expireCustomerPoints(ctx, customerID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error expiring loyalty points", "details": err.Error()})
+		return
+	}
+
+	var customer models.Customer
+	if err := CustomerCollection.FindOne(ctx, bson.M{"_id": customerID}).Decode(&customer); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Customer not found", "details": err.Error()})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+	if limit > 500 {
+		limit = 500
+	}
+	cursor, err := LoyaltyTransactionCollection.Find(ctx,
+		bson.M{"customer_id": customerID},
+		options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(int64(limit)),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving loyalty ledger", "details": err.Error()})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	ledger := []models.LoyaltyTransaction{}
+	if err := cursor.All(ctx, &ledger); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error decoding loyalty ledger", "details": err.Error()})
+		return
+	}
+
+	data := gin.H{
+		"customer_id":     customer.Customer_ID,
+		"loyalty_points":  customer.Loyalty_Points,
+		"points_value":    float64(customer.Loyalty_Points) * settings.Redeem_Value,
+		"lifetime_points": customer.Lifetime_Points,
+		"enabled":         settings.Enabled,
+	}
+	if tier, ok := helpers.LoyaltyTierFor(settings, customer.Lifetime_Points); ok {
+		data["tier"] = tier
+	}
+	if next, ok := helpers.NextLoyaltyTier(settings, customer.Lifetime_Points); ok {
+		data["next_tier"] = next
+		data["points_to_next_tier"] = next.Min_Lifetime_Points - customer.Lifetime_Points
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Loyalty retrieved successfully", "data": data, "ledger": ledger})
+}
+
+// GetMyLoyalty shows the signed-in customer their points, tier and ledger.
+func GetMyLoyalty() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		respondCustomerLoyalty(ctx, c, c.GetString("customerId"))
+	}
+}
+
+// GetCustomerLoyalty shows staff a customer's points, tier and ledger.
+func GetCustomerLoyalty() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		respondCustomerLoyalty(ctx, c, c.Param("customer_id"))
+	}
+}
+
+// AdjustCustomerPoints adds points to a customer, or takes them away when
+// negative, e.g. as a goodwill gesture at a branch. Adjustments do not count
+// towards tiers, and only the root admin may adjust by more than
+// maxPointsAdjustment at once.
+func AdjustCustomerPoints() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		customerID := c.Param("customer_id")
+
+		var reqBody struct {
+			Branch_ID string `json:"branch_id" binding:"required"`
+			Points    int    `json:"points"`
+			Note      string `json:"note"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid request body", "details": err.Error()})
+			return
+		}
+		reqBody.Note = strings.TrimSpace(reqBody.Note)
+		if reqBody.Points == 0 || reqBody.Note == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Points and a note are required"})
+			return
+		}
+
+		if !authorizeBranch(c, reqBody.Branch_ID) {
+			return
+		}
+
+		scope, err := helpers.GetBranchScope(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		if limit := maxPointsAdjustment(); !scope.AllBranches && (reqBody.Points > limit || reqBody.Points < -limit) {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": fmt.Sprintf("A manual adjustment cannot exceed %d points", limit)})
+			return
+		}
+
+		if !validateCustomerID(ctx, c, customerID) {
+			return
+		}
+
+		userID, err := helpers.GetUserIDFromMdw(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		settings, err := loadLoyaltySettings(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving loyalty settings", "details": err.Error()})
+			return
+		}
+
+		transaction := models.LoyaltyTransaction{
+			Customer_ID: customerID,
+			Branch_ID:   reqBody.Branch_ID,
+			Type:        helpers.LoyaltyAdjustment,
+			Points:      reqBody.Points,
+			Note:        reqBody.Note,
+			Created_By:  userID,
+		}
+		if reqBody.Points > 0 {
+			err = creditPoints(ctx, settings, transaction)
+		} else {
+			transaction.Points = -reqBody.Points
+			err = debitPoints(ctx, transaction)
+		}
+		if err == errInsufficientPoints {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "The customer does not have enough points"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error adjusting loyalty points", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Loyalty points adjusted successfully"})
+	}
+}
+
+func GetLoyaltySettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		settings, err := loadLoyaltySettings(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving loyalty settings", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Loyalty settings retrieved successfully", "data": settings})
+	}
+}
+
+// UpdateLoyaltySettings replaces the loyalty programme. It applies to every
+// branch, so only the root admin may change it.
+func UpdateLoyaltySettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		scope, err := helpers.GetBranchScope(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		if !scope.AllBranches {
+			c.JSON(http.StatusForbidden, gin.H{"success": false, "error": "Unauthorized Access"})
+			return
+		}
+
+		var settings models.LoyaltySettings
+		if err := c.BindJSON(&settings); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid request body", "details": err.Error()})
+			return
+		}
+		if err := helpers.ValidateLoyaltySettings(settings); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		for i := range settings.Tiers {
+			settings.Tiers[i].Name = strings.ToLower(strings.TrimSpace(settings.Tiers[i].Name))
+		}
+		helpers.SortLoyaltyTiers(settings.Tiers)
+		settings.Settings_ID = helpers.LoyaltySettingsID
+		settings.Updated_By = c.GetString("userId")
+		settings.Updated_At = time.Now()
+
+		_, err = LoyaltySettingsCollection.ReplaceOne(ctx, bson.M{"_id": helpers.LoyaltySettingsID}, settings, options.Replace().SetUpsert(true))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error updating loyalty settings", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Loyalty settings updated successfully", "data": settings})
+	}
+}
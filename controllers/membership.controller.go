@@ -0,0 +1,432 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	helpers "nano_food_api/helpers"
+	models "nano_food_api/models"
+	token "nano_food_api/tokens"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// GetMyBranches lists the branches the current user can switch to, with their
+// role in each.
+func GetMyBranches() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		userInfo, err := helpers.GetCurrentUser(c, UserCollection)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		memberships := helpers.UserBranches(userInfo)
+		roleByBranch := map[string]models.BranchMembership{}
+		branchIDs := []string{}
+		for _, membership := range memberships {
+			roleByBranch[membership.Branch_ID] = membership
+			branchIDs = append(branchIDs, membership.Branch_ID)
+		}
+
+		filter := bson.M{"_id": bson.M{"$in": branchIDs}}
+		if userInfo.Role == 100 {
+			filter = bson.M{}
+		}
+
+		cursor, err := BranchCollection.Find(ctx, filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving branches", "details": err.Error()})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		var branches []models.Branch
+		if err := cursor.All(ctx, &branches); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error decoding branches", "details": err.Error()})
+			return
+		}
+
+		activeBranchID := c.GetString("branchId")
+		data := []gin.H{}
+		for _, branch := range branches {
+			membership, ok := roleByBranch[branch.Branch_ID]
+			if !ok {
+				membership = models.BranchMembership{Branch_ID: branch.Branch_ID, Role: userInfo.Role}
+			}
+			data = append(data, gin.H{
+				"branch":    branch,
+				"role":      membership.Role,
+				"role_id":   membership.Role_ID,
+				"is_home":   branch.Branch_ID == userInfo.Branch_ID,
+				"is_active": branch.Branch_ID == activeBranchID,
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Branches retrieved successfully", "data": data})
+	}
+}
+
+// SwitchBranch moves the current session to another branch the user belongs to
+// and issues an access token scoped to it. Later refreshes stay on that branch.
+func SwitchBranch() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var reqBody struct {
+			Branch_ID string `json:"branch_id" binding:"required"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		userInfo, err := helpers.GetCurrentUser(c, UserCollection)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		membership, ok := helpers.MembershipFor(userInfo, reqBody.Branch_ID)
+		if !ok && userInfo.Role == 100 {
+			membership, ok = models.BranchMembership{Branch_ID: reqBody.Branch_ID, Role: 100}, true
+		}
+		if !ok {
+			c.JSON(http.StatusForbidden, gin.H{"success": false, "error": "You are not a member of this branch"})
+			return
+		}
+
+		var branch models.Branch
+		err = BranchCollection.FindOne(ctx, bson.M{"_id": reqBody.Branch_ID}).Decode(&branch)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Branch not found", "details": err.Error()})
+			return
+		}
+
+		sessionID, err := helpers.GetSessionIDFromMdw(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		// Terminal sessions stay on the terminal's branch
+		result, err := SessionCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": sessionID, "user_id": userInfo.User_ID, "terminal_id": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"branch_id": branch.Branch_ID, "updated_at": time.Now()}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error switching branch", "details": err.Error()})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "This session cannot switch branches"})
+			return
+		}
+
+		accessToken, err := token.TokenGenerator(userInfo.Email, userInfo.User_ID, membership.Role, branch.Branch_ID, sessionID)
+		if err != nil {
+			log.Printf("Error generating token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error generating token", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success":     true,
+			"message":     "Branch switched successfully",
+			"accessToken": accessToken,
+			"data":        gin.H{"branch": branch, "role": membership.Role},
+		})
+	}
+}
+
+// AddBranchMembership gives a user a role in one more branch, or changes their
+// role there. The home branch is changed with UpdateUserBranch instead.
+func AddBranchMembership() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var reqBody struct {
+			User_ID   string `json:"user_id" binding:"required"`
+			Branch_ID string `json:"branch_id" binding:"required"`
+			Role      int    `json:"role"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		if !authorizeBranch(c, reqBody.Branch_ID) {
+			return
+		}
+
+		scope, err := helpers.GetBranchScope(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		if !helpers.Contains(assignableRoleLevels(scope.Role), reqBody.Role) {
+			c.JSON(http.StatusForbidden, gin.H{"success": false, "error": "You are not authorized to assign this role"})
+			return
+		}
+
+		branchExists, err := helpers.CheckDataExist(ctx, BranchCollection, bson.M{"_id": reqBody.Branch_ID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to validate branch", "details": err.Error()})
+			return
+		}
+		if !branchExists {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid branch ID"})
+			return
+		}
+
+		var user models.User
+		err = UserCollection.FindOne(ctx, bson.M{"_id": reqBody.User_ID}).Decode(&user)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "User not found", "details": err.Error()})
+			return
+		}
+
+		if user.Branch_ID == reqBody.Branch_ID {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "This is already the user's home branch"})
+			return
+		}
+
+		existing, wasMember := helpers.MembershipFor(user, reqBody.Branch_ID)
+		if wasMember && !helpers.Contains(assignableRoleLevels(scope.Role), existing.Role) {
+			c.JSON(http.StatusForbidden, gin.H{"success": false, "error": "You are not authorized to change this user's role"})
+			return
+		}
+
+		// A single conditional update, so that the membership is never missing and
+		// a concurrent request cannot add it twice
+		membership := models.BranchMembership{Branch_ID: reqBody.Branch_ID, Role: reqBody.Role}
+		filter := bson.M{"_id": user.User_ID, "memberships.branch_id": bson.M{"$ne": reqBody.Branch_ID}}
+		update := bson.M{
+			"$push": bson.M{"memberships": membership},
+			"$set":  bson.M{"updated_at": time.Now()},
+		}
+		if wasMember {
+			filter = bson.M{"_id": user.User_ID, "memberships": bson.M{"$elemMatch": bson.M{"branch_id": reqBody.Branch_ID, "role": existing.Role}}}
+			update = bson.M{"$set": bson.M{"memberships.$.role": reqBody.Role, "updated_at": time.Now()}}
+		}
+		result, err := UserCollection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error updating membership", "details": err.Error()})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusConflict, gin.H{"success": false, "error": "The membership was changed in the meantime, please try again"})
+			return
+		}
+
+		// The role in a branch is part of access tokens issued for it
+		if wasMember {
+			if err := helpers.RevokeUserSessions(ctx, SessionCollection, user.User_ID, ""); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error revoking sessions", "details": err.Error()})
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Branch membership saved successfully", "data": membership})
+	}
+}
+
+func RemoveBranchMembership() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		userID := c.Param("user_id")
+		branchID := c.Param("branch_id")
+
+		if !authorizeBranch(c, branchID) {
+			return
+		}
+
+		// The caller's role comes from the token, which carries their role in the
+		// active branch rather than their home branch
+		scope, err := helpers.GetBranchScope(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		var user models.User
+		err = UserCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "User not found", "details": err.Error()})
+			return
+		}
+
+		membership, ok := helpers.MembershipFor(user, branchID)
+		if !ok || membership.Branch_ID == user.Branch_ID {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Membership not found"})
+			return
+		}
+		if !helpers.Contains(assignableRoleLevels(scope.Role), membership.Role) {
+			c.JSON(http.StatusForbidden, gin.H{"success": false, "error": "You are not authorized to remove this user"})
+			return
+		}
+
+		result, err := UserCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": userID, "memberships.branch_id": branchID},
+			bson.M{
+				"$pull": bson.M{"memberships": bson.M{"branch_id": branchID}},
+				"$set":  bson.M{"updated_at": time.Now()},
+			},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error removing membership", "details": err.Error()})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Membership not found"})
+			return
+		}
+
+		if err := helpers.RevokeUserSessions(ctx, SessionCollection, userID, ""); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error revoking sessions", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Branch membership removed successfully"})
+	}
+}
+
+// GetBranchesSummary totals orders and sales per branch over a date range
+// (?from=YYYY-MM-DD&to=YYYY-MM-DD, default today) across every branch where the
+// caller may view reports.
+func GetBranchesSummary() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		from, to, err := parseDateRange(c.Query("from"), c.Query("to"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid date range", "details": err.Error()})
+			return
+		}
+
+		filter, ok := aggregateFilter(ctx, c, bson.M{}, helpers.PermReportView)
+		if !ok {
+			return
+		}
+		filter["created_at"] = bson.M{"$gte": from, "$lt": to}
+
+		orderPipeline := mongo.Pipeline{
+			{{Key: "$match", Value: filter}},
+			{{Key: "$group", Value: bson.M{
+				"_id":          "$branch_id",
+				"order_count":  bson.M{"$sum": 1},
+				"open_orders":  bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$in": bson.A{"$status", bson.A{"000", "001", "002"}}}, 1, 0}}},
+				"order_amount": bson.M{"$sum": "$total_amount"},
+			}}},
+		}
+		var orderTotals []bson.M
+		cursor, err := OrderCollection.Aggregate(ctx, orderPipeline)
+		if err == nil {
+			err = cursor.All(ctx, &orderTotals)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error summarizing orders", "details": err.Error()})
+			return
+		}
+
+		// Refunded sales are not revenue
+		saleFilter := bson.M{"is_refunded": bson.M{"$ne": true}}
+		for key, value := range filter {
+			saleFilter[key] = value
+		}
+		salePipeline := mongo.Pipeline{
+			{{Key: "$match", Value: saleFilter}},
+			{{Key: "$group", Value: bson.M{
+				"_id":         "$branch_id",
+				"sale_count":  bson.M{"$sum": 1},
+				"grand_total": bson.M{"$sum": "$grand_total"},
+				"discount":    bson.M{"$sum": "$discount"},
+				"tax":         bson.M{"$sum": "$tax"},
+			}}},
+		}
+		var saleTotals []bson.M
+		cursor, err = SaleCollection.Aggregate(ctx, salePipeline)
+		if err == nil {
+			err = cursor.All(ctx, &saleTotals)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error summarizing sales", "details": err.Error()})
+			return
+		}
+
+		summary := map[string]gin.H{}
+		entry := func(branchID string) gin.H {
+			if _, exists := summary[branchID]; !exists {
+				summary[branchID] = gin.H{"branch_id": branchID}
+			}
+			return summary[branchID]
+		}
+		for _, total := range orderTotals {
+			branchID, _ := total["_id"].(string)
+			branchSummary := entry(branchID)
+			for key, value := range total {
+				if key != "_id" {
+					branchSummary[key] = value
+				}
+			}
+		}
+		for _, total := range saleTotals {
+			branchID, _ := total["_id"].(string)
+			branchSummary := entry(branchID)
+			for key, value := range total {
+				if key != "_id" {
+					branchSummary[key] = value
+				}
+			}
+		}
+
+		data := []gin.H{}
+		for _, branchSummary := range summary {
+			data = append(data, branchSummary)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Branch summary retrieved successfully",
+			"data":    data,
+			"from":    from,
+			"to":      to,
+		})
+	}
+}
+
+// parseDateRange reads inclusive YYYY-MM-DD dates and returns [from, to) in
+// local time. Both default to today.
+func parseDateRange(fromDate string, toDate string) (time.Time, time.Time, error) {
+	today := time.Now().Format("2006-01-02")
+	if fromDate == "" {
+		fromDate = today
+	}
+	if toDate == "" {
+		toDate = today
+	}
+
+	from, err := time.ParseInLocation("2006-01-02", fromDate, time.Local)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	to, err := time.ParseInLocation("2006-01-02", toDate, time.Local)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return from, to.AddDate(0, 0, 1), nil
+}
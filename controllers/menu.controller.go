@@ -2,7 +2,6 @@ package controllers
 
 import (
 	"context"
-	"fmt"
 	"net/http"
 	"strconv"
 	"time"
@@ -15,6 +14,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var MenuCollection *mongo.Collection = database.MenuCollection
@@ -34,18 +34,6 @@ menu_images: <file[]>      # Multiple menu images
 
 func CreateMenu() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		app, err := helpers.InitializeFirebaseApp()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to initialize Firebase", "details": err.Error()})
-			return
-		}
-
-		client, err := app.Storage(context.Background())
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to connect to Firebase Storage", "details": err.Error()})
-			return
-		}
-
 		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
@@ -64,6 +52,10 @@ func CreateMenu() gin.HandlerFunc {
 			return
 		}
 
+		if !authorizeBranch(c, menu.Branch_ID) {
+			return
+		}
+
 		branchExists, err := helpers.CheckDataExist(ctx, database.BranchCollection, bson.M{"_id": menu.Branch_ID})
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to validate branch", "details": err.Error()})
@@ -74,7 +66,7 @@ func CreateMenu() gin.HandlerFunc {
 			return
 		}
 
-		categoryExists, err := helpers.CheckDataExist(ctx, database.CategoryCollection, bson.M{"_id": menu.Category_ID})
+		categoryExists, err := helpers.CheckDataExist(ctx, database.CategoryCollection, bson.M{"_id": menu.Category_ID, "branch_id": menu.Branch_ID})
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to validate category", "details": err.Error()})
 			return
@@ -92,7 +84,7 @@ func CreateMenu() gin.HandlerFunc {
 		menuCoverFile, menuCoverHeader, err := c.Request.FormFile("menu_cover")
 		if err == nil {
 			defer menuCoverFile.Close()
-			menuCoverURL, uploadErr := helpers.UploadFileToFirebase(client, menuCoverFile, fmt.Sprintf("menu_covers/%d_%s", time.Now().Unix(), menuCoverHeader.Filename))
+			menuCoverURL, uploadErr := storeUpload("menu_covers", menuCoverFile, menuCoverHeader)
 			if uploadErr != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to upload menu cover image", "details": uploadErr.Error()})
 				return
@@ -112,7 +104,7 @@ func CreateMenu() gin.HandlerFunc {
 				}
 				defer file.Close()
 
-				imageURL, uploadErr := helpers.UploadFileToFirebase(client, file, fmt.Sprintf("menu_images/%d_%s", time.Now().Unix(), fileHeader.Filename))
+				imageURL, uploadErr := storeUpload("menu_images", file, fileHeader)
 				if uploadErr != nil {
 					c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to upload menu image", "details": uploadErr.Error()})
 					return
@@ -139,25 +131,18 @@ func CreateMenu() gin.HandlerFunc {
 
 func UpdateMenu() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		app, err := helpers.InitializeFirebaseApp()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to initialize Firebase", "details": err.Error()})
-			return
-		}
+		menuID := c.Param("menu_id")
 
-		client, err := app.Storage(context.Background())
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to connect to Firebase Storage", "details": err.Error()})
+		filter, ok := scopedFilter(c, bson.M{"_id": menuID})
+		if !ok {
 			return
 		}
 
-		menuID := c.Param("menu_id")
-
 		// Find existing menu
 		var existingMenu models.Menu
 		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
-		err = MenuCollection.FindOne(ctx, bson.M{"_id": menuID}).Decode(&existingMenu)
+		err := MenuCollection.FindOne(ctx, filter).Decode(&existingMenu)
 		if err != nil {
 			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Menu not found", "details": err.Error()})
 			return
@@ -188,7 +173,7 @@ func UpdateMenu() gin.HandlerFunc {
 			return
 		}
 
-		categoryExists, err := helpers.CheckDataExist(ctx, database.CategoryCollection, bson.M{"_id": menuUpdate.Category_ID})
+		categoryExists, err := helpers.CheckDataExist(ctx, database.CategoryCollection, bson.M{"_id": menuUpdate.Category_ID, "branch_id": existingMenu.Branch_ID})
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to validate category", "details": err.Error()})
 			return
@@ -221,21 +206,20 @@ func UpdateMenu() gin.HandlerFunc {
 			updateFields["discount"] = menuUpdate.Discount
 		}
 
+		// Images replaced by this update, deleted once it is saved
+		var replacedImages []string
+
 		// Handle menu cover update
 		menuCoverFile, menuCoverHeader, err := c.Request.FormFile("menu_cover")
 		if err == nil {
 			defer menuCoverFile.Close()
 
-			// Delete old menu cover if it exists
 			if existingMenu.Cover != "" {
-				deleteErr := helpers.DeleteFileFromFirebase(client, existingMenu.Cover)
-				if deleteErr != nil {
-					fmt.Println("Failed to delete old menu cover image", deleteErr)
-				}
+				replacedImages = append(replacedImages, existingMenu.Cover)
 			}
 
 			// Upload new menu cover
-			menuCoverURL, uploadErr := helpers.UploadFileToFirebase(client, menuCoverFile, fmt.Sprintf("menu_covers/%d_%s", time.Now().Unix(), menuCoverHeader.Filename))
+			menuCoverURL, uploadErr := storeUpload("menu_covers", menuCoverFile, menuCoverHeader)
 			if uploadErr != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to upload menu cover image", "details": uploadErr.Error()})
 				return
@@ -249,13 +233,7 @@ func UpdateMenu() gin.HandlerFunc {
 			var menuImageURLs []string
 			files := menuImages.File["menu_images"]
 
-			// Delete old menu images
-			for _, oldImage := range existingMenu.Images {
-				deleteErr := helpers.DeleteFileFromFirebase(client, oldImage)
-				if deleteErr != nil {
-					fmt.Println("Failed to delete old menu images", deleteErr)
-				}
-			}
+			replacedImages = append(replacedImages, existingMenu.Images...)
 
 			// Upload new menu images
 			for _, fileHeader := range files {
@@ -265,7 +243,7 @@ func UpdateMenu() gin.HandlerFunc {
 				}
 				defer file.Close()
 
-				imageURL, uploadErr := helpers.UploadFileToFirebase(client, file, fmt.Sprintf("menu_images/%d_%s", time.Now().Unix(), fileHeader.Filename))
+				imageURL, uploadErr := storeUpload("menu_images", file, fileHeader)
 				if uploadErr != nil {
 					c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to upload menu image", "details": uploadErr.Error()})
 					return
@@ -276,7 +254,18 @@ func UpdateMenu() gin.HandlerFunc {
 		}
 
 		// Update menu in MongoDB
-		_, err = MenuCollection.UpdateOne(ctx, bson.M{"_id": menuID}, bson.M{"$set": updateFields})
+		err = helpers.RunInTransaction(ctx, database.Client, func(ctx context.Context) error {
+			var updatedMenu models.Menu
+			err := MenuCollection.FindOneAndUpdate(ctx, bson.M{"_id": menuID}, bson.M{"$set": updateFields},
+				options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&updatedMenu)
+			if err != nil {
+				return err
+			}
+			if err := enqueueImageDeletion(ctx, replacedImages); err != nil {
+				return err
+			}
+			return publishWebhookEvent(ctx, updatedMenu.Branch_ID, helpers.WebhookMenuUpdated, updatedMenu)
+		})
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to update menu", "details": err.Error()})
 			return
@@ -296,31 +285,39 @@ func DeleteMenu() gin.HandlerFunc {
 		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
-		// Find and delete the menu
+		filter, ok := scopedFilter(c, bson.M{"_id": menuID})
+		if !ok {
+			return
+		}
+
+		// Delete the menu, detach its add-ons and queue the removal of its
+		// images together
 		var menu models.Menu
-		err := MenuCollection.FindOneAndDelete(ctx, bson.M{"_id": menuID}).Decode(&menu)
-		if err != nil {
+		err := helpers.RunInTransaction(ctx, database.Client, func(ctx context.Context) error {
+			if err := MenuCollection.FindOneAndDelete(ctx, filter).Decode(&menu); err != nil {
+				return err
+			}
+
+			// Update add-ons to set menu_id to an empty string
+			if _, err := AddOnCollection.UpdateMany(ctx, bson.M{"menu_id": menuID}, bson.M{"$set": bson.M{"menu_id": ""}}); err != nil {
+				return err
+			}
+
+			images := menu.Images
+			if menu.Cover != "" {
+				images = append([]string{menu.Cover}, images...)
+			}
+			return enqueueImageDeletion(ctx, images)
+		})
+		if err == mongo.ErrNoDocuments {
 			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Menu not found", "details": err.Error()})
 			return
 		}
-
-		// Update add-ons to set menu_id to an empty string
-		_, err = AddOnCollection.UpdateMany(ctx, bson.M{"menu_id": menuID}, bson.M{"$set": bson.M{"menu_id": ""}})
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to update add-ons", "details": err.Error()})
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to delete menu", "details": err.Error()})
 			return
 		}
 
-		// Optionally delete associated images (menu cover, menu images, add-on images)
-		app, _ := helpers.InitializeFirebaseApp()
-		client, _ := app.Storage(context.Background())
-		if menu.Cover != "" {
-			helpers.DeleteFileFromFirebase(client, menu.Cover)
-		}
-		for _, image := range menu.Images {
-			helpers.DeleteFileFromFirebase(client, image)
-		}
-
 		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Menu deleted successfully"})
 	}
 }
@@ -2,7 +2,9 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"math"
 	"nano_food_api/database"
 	"nano_food_api/helpers"
 	"nano_food_api/models"
@@ -79,6 +81,36 @@ func orderPipeline(filter bson.M) mongo.Pipeline {
 	return pipeline
 }
 
+// calculateOrderTotal prices the order items from the current menu and add-on prices.
+func calculateOrderTotal(ctx context.Context, menuItems []models.OrderItem) (float64, error) {
+	totalAmount := 0.0
+	for _, menuItem := range menuItems {
+		var menu models.Menu
+		err := MenuCollection.FindOne(ctx, bson.M{"_id": menuItem.Menu_ID}).Decode(&menu)
+		if err != nil {
+			log.Printf("Error retrieving menu: %v", err)
+			return 0, fmt.Errorf("error retrieving menu: %v", err)
+		}
+		menuPrice := (menu.Price - menu.Discount) * float64(menuItem.Quantity)
+
+		addOnSubTotal := 0.0
+		for _, addOnItem := range menuItem.AddOnItems {
+			var addOn models.AddOn
+			err := AddOnCollection.FindOne(ctx, bson.M{"_id": addOnItem.AddOnID}).Decode(&addOn)
+			if err != nil {
+				log.Printf("Error retrieving addon: %v", err)
+				return 0, fmt.Errorf("error retrieving addon: %v", err)
+			}
+			addOnPrice := addOn.Price * float64(addOnItem.Quantity)
+			addOnSubTotal += addOnPrice
+		}
+
+		menuSubtotal := menuPrice + addOnSubTotal
+		totalAmount += menuSubtotal
+	}
+	return totalAmount, nil
+}
+
 func CreateOrder() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
@@ -90,6 +122,10 @@ func CreateOrder() gin.HandlerFunc {
 			return
 		}
 
+		if !authorizeBranch(c, order.Branch_ID) {
+			return
+		}
+
 		branchExists, err := helpers.CheckDataExist(ctx, database.BranchCollection, bson.M{"_id": order.Branch_ID})
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to validate branch", "details": err.Error()})
@@ -100,54 +136,93 @@ func CreateOrder() gin.HandlerFunc {
 			return
 		}
 
-		tableExists, err := helpers.CheckDataExist(ctx, database.TableCollection, bson.M{"_id": order.Table_ID})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to validate table", "details": err.Error()})
+		if !validateCustomerID(ctx, c, order.Customer_ID) {
 			return
 		}
-		if !tableExists {
-			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid table ID"})
+
+		// Orders for a customer profile are called out under its name and number
+		if order.Customer_ID != "" && (order.Customer_Name == "" || order.Customer_Phone == "") {
+			var customer models.Customer
+			if err := CustomerCollection.FindOne(ctx, bson.M{"_id": order.Customer_ID}).Decode(&customer); err == nil {
+				if order.Customer_Name == "" {
+					order.Customer_Name = customer.Name
+				}
+				if order.Customer_Phone == "" {
+					order.Customer_Phone = customer.Phone
+				}
+			}
+		}
+
+		if err := helpers.NormalizeOrderFulfilment(&order, order.Customer_ID != ""); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		if order.Order_Type == helpers.OrderTypeDineIn {
+			tableExists, err := helpers.CheckDataExist(ctx, database.TableCollection, bson.M{"_id": order.Table_ID, "branch_id": order.Branch_ID})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to validate table", "details": err.Error()})
+				return
+			}
+			if !tableExists {
+				c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid table ID"})
+				return
+			}
+		}
+
+		if order.Delivery != nil && order.Delivery.Driver_ID != "" && !validateDriver(ctx, c, order.Branch_ID, order.Delivery.Driver_ID) {
+			return
+		}
+
+		if err := validateGuestOrderItems(ctx, order.Branch_ID, order.MenuItems); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid order items", "details": err.Error()})
+			return
+		}
+
+		order.Server_ID, err = helpers.GetUserIDFromMdw(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
 			return
 		}
 
 		order.Order_ID = primitive.NewObjectID().Hex()
+		order.Source = "pos"
+		order.Platform_ID, order.Source_Order_ID = "", ""
 		order.IsPaid = false
 		order.Status = "001"
 		order.Created_At = time.Now()
 		order.Updated_At = time.Now()
 
-		// Calculate total amount
-		totalAmount := 0.0
-		for _, menuItem := range order.MenuItems {
-			var menu models.Menu
-			err := MenuCollection.FindOne(ctx, bson.M{"_id": menuItem.Menu_ID}).Decode(&menu)
-			if err != nil {
-				log.Printf("Error retrieving menu: %v", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving menu", "details": err.Error()})
+		totalAmount, err := calculateOrderTotal(ctx, order.MenuItems)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error calculating order total", "details": err.Error()})
+			return
+		}
+		order.TotalAmount = totalAmount
+		if order.Delivery != nil {
+			if !quoteOrderDelivery(ctx, c, &order) {
 				return
 			}
-			menuPrice := (menu.Price - menu.Discount) * float64(menuItem.Quantity)
-
-			addOnSubTotal := 0.0
-			for _, addOnItem := range menuItem.AddOnItems {
-				var addOn models.AddOn
-				err := AddOnCollection.FindOne(ctx, bson.M{"_id": addOnItem.AddOnID}).Decode(&addOn)
-				if err != nil {
-					log.Printf("Error retrieving addon: %v", err)
-					c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving addon", "details": err.Error()})
-					return
-				}
-				addOnPrice := addOn.Price * float64(addOnItem.Quantity)
-				addOnSubTotal += addOnPrice
-			}
+			order.TotalAmount += order.Delivery.Fee
+		}
 
-			menuSubtotal := menuPrice + addOnSubTotal
-			totalAmount += menuSubtotal
+		// Pre-orders are scheduled for later and wait for their release time
+		order.Release_At, order.Remind_At, order.Reminded_At = time.Time{}, time.Time{}, time.Time{}
+		order.Preorder_Slot = ""
+		if !order.Scheduled_For.IsZero() && !schedulePreorder(ctx, c, &order) {
+			return
 		}
-		order.TotalAmount = totalAmount
 
-		result, err := OrderCollection.InsertOne(ctx, order)
+		var result *mongo.InsertOneResult
+		err = helpers.RunInTransaction(ctx, database.Client, func(ctx context.Context) error {
+			var err error
+			if result, err = OrderCollection.InsertOne(ctx, order); err != nil {
+				return err
+			}
+			return publishWebhookEvent(ctx, order.Branch_ID, helpers.WebhookOrderCreated, order)
+		})
 		if err != nil {
+			releasePreorderSlot(ctx, order.Preorder_Slot)
 			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
 			return
 		}
@@ -163,6 +238,7 @@ func GetAllOrders() gin.HandlerFunc {
 
 		branchID := c.Query("branch_id")
 		tableID := c.Query("table_id")
+		status := c.Query("status")
 
 		filter := bson.M{}
 		if branchID != "" {
@@ -171,6 +247,37 @@ func GetAllOrders() gin.HandlerFunc {
 		if tableID != "" {
 			filter["table_id"] = tableID
 		}
+		if status != "" {
+			filter["status"] = status
+		}
+		if orderType := c.Query("order_type"); orderType != "" {
+			filter["order_type"] = orderType
+		}
+		if driverID := c.Query("driver_id"); driverID != "" {
+			filter["delivery.driver_id"] = driverID
+		}
+		// Pre-orders stay out of the kitchen queue until their release time;
+		// ?scheduled=true lists only pre-orders and ?scheduled=all everything
+		switch c.Query("scheduled") {
+		case "true":
+			filter["scheduled_for"] = bson.M{"$exists": true}
+		case "all":
+		default:
+			filter["$or"] = []bson.M{
+				{"release_at": bson.M{"$exists": false}},
+				{"release_at": bson.M{"$lte": time.Now()}},
+			}
+		}
+
+		var ok bool
+		if c.Query("all_branches") == "true" {
+			filter, ok = aggregateFilter(ctx, c, filter, helpers.PermReportView)
+		} else {
+			filter, ok = scopedFilter(c, filter)
+		}
+		if !ok {
+			return
+		}
 
 		pipeline := orderPipeline(filter)
 
@@ -197,7 +304,10 @@ func GetOneOrder() gin.HandlerFunc {
 		defer cancel()
 
 		orderID := c.Param("order_id")
-		filter := bson.M{"_id": orderID}
+		filter, ok := scopedFilter(c, bson.M{"_id": orderID})
+		if !ok {
+			return
+		}
 
 		pipeline := orderPipeline(filter)
 
@@ -236,21 +346,41 @@ func UpdateOrder() gin.HandlerFunc {
 			return
 		}
 
-		filter := bson.M{"_id": orderID}
-		update := bson.M{
-			"$set": bson.M{
-				"status":     order.Status,
-				"note":       order.Note,
-				"is_paid":    order.IsPaid,
-				"updated_at": time.Now(),
-			},
+		filter, ok := scopedFilter(c, bson.M{"_id": orderID})
+		if !ok {
+			return
+		}
+		// Orders are only marked paid by CreateSale, so a sold order cannot be sold again
+		updateFields := bson.M{
+			"status":     order.Status,
+			"note":       order.Note,
+			"updated_at": time.Now(),
+		}
+		// Promised times are only changed when given, e.g. when the kitchen runs late
+		if !order.Promised_Ready_At.IsZero() {
+			updateFields["promised_ready_at"] = order.Promised_Ready_At
+		}
+		if !order.Promised_Delivery_At.IsZero() {
+			updateFields["promised_delivery_at"] = order.Promised_Delivery_At
 		}
+		update := bson.M{"$set": updateFields}
 
-		result, err := OrderCollection.UpdateOne(ctx, filter, update)
-		if err != nil || result.MatchedCount == 0 {
+		var previous models.Order
+		err := OrderCollection.FindOneAndUpdate(ctx, filter, update).Decode(&previous)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Order not found"})
+			return
+		}
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error updating order", "details": err.Error()})
 			return
 		}
+		if previous.Status != order.Status {
+			notifyPlatformStatus(previous, order.Status)
+		}
+		if previous.Status != "004" && order.Status == "004" {
+			releasePreorderSlot(ctx, previous.Preorder_Slot)
+		}
 
 		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Order updated successfully"})
 	}
@@ -263,12 +393,315 @@ func DeleteOrder() gin.HandlerFunc {
 
 		orderID := c.Param("order_id")
 
-		_, err := OrderCollection.DeleteOne(ctx, bson.M{"_id": orderID})
+		filter, ok := scopedFilter(c, bson.M{"_id": orderID})
+		if !ok {
+			return
+		}
+
+		var order models.Order
+		err := OrderCollection.FindOneAndDelete(ctx, filter).Decode(&order)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Order not found"})
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error deleting order", "details": err.Error()})
 			return
 		}
+		if order.Status != "004" {
+			releasePreorderSlot(ctx, order.Preorder_Slot)
+		}
 
 		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Order deleted successfully"})
 	}
 }
+
+// ConfirmOrder moves a guest order from awaiting confirmation into the kitchen queue,
+// or cancels it when the staff member rejects it.
+func ConfirmOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		orderID := c.Param("order_id")
+
+		var reqBody struct {
+			Accept bool   `json:"accept"`
+			Note   string `json:"note"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		var order models.Order
+		err := OrderCollection.FindOne(ctx, bson.M{"_id": orderID}).Decode(&order)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Order not found", "details": err.Error()})
+			return
+		}
+
+		if !authorizeBranch(c, order.Branch_ID) {
+			return
+		}
+
+		if order.Status != "000" {
+			c.JSON(http.StatusConflict, gin.H{"success": false, "error": "Order is not awaiting confirmation"})
+			return
+		}
+
+		status := "004"
+		if reqBody.Accept {
+			status = "001"
+		}
+		updateFields := bson.M{"status": status, "updated_at": time.Now()}
+		if reqBody.Note != "" {
+			updateFields["note"] = reqBody.Note
+		}
+		// Guest orders are credited to the staff member who accepts them
+		if reqBody.Accept && order.Server_ID == "" {
+			updateFields["server_id"], err = helpers.GetUserIDFromMdw(c)
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+				return
+			}
+		}
+
+		result, err := OrderCollection.UpdateOne(ctx, bson.M{"_id": orderID, "status": "000"}, bson.M{"$set": updateFields})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error confirming order", "details": err.Error()})
+			return
+		}
+		if result.ModifiedCount > 0 {
+			notifyPlatformStatus(order, status)
+			if status == "004" {
+				releasePreorderSlot(ctx, order.Preorder_Slot)
+			}
+		}
+
+		message := "Order rejected successfully"
+		if reqBody.Accept {
+			message = "Order confirmed successfully"
+		}
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": message})
+	}
+}
+
+// quoteOrderDelivery prices a delivery order by its branch's delivery zones and
+// promises a delivery time. Branches without zones deliver for free, within
+// their radius when they have one. It responds with 400 and returns false for
+// addresses that cannot be delivered to.
+func quoteOrderDelivery(ctx context.Context, c *gin.Context, order *models.Order) bool {
+	var branch models.Branch
+	if err := BranchCollection.FindOne(ctx, bson.M{"_id": order.Branch_ID}).Decode(&branch); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid branch ID", "details": err.Error()})
+		return false
+	}
+
+	address := models.GeoPoint{Latitude: order.Delivery.Latitude, Longitude: order.Delivery.Longitude}
+	if len(branch.Delivery_Zones) == 0 {
+		order.Delivery.Zone, order.Delivery.Fee, order.Delivery.Distance_Km = "", 0, 0
+		if branch.Delivery_Radius == 0 {
+			return true
+		}
+		distance, err := helpers.DeliveryDistance(branch, address)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Address cannot be delivered to", "details": err.Error()})
+			return false
+		}
+		order.Delivery.Distance_Km = math.Round(distance*100) / 100
+		return true
+	}
+
+	quote, err := helpers.QuoteDelivery(branch, address, order.TotalAmount, time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Address cannot be delivered to", "details": err.Error()})
+		return false
+	}
+
+	order.Delivery.Zone = quote.Zone
+	order.Delivery.Fee = quote.Fee
+	order.Delivery.Distance_Km = quote.Distance_Km
+	if order.Promised_Delivery_At.IsZero() {
+		order.Promised_Delivery_At = quote.Estimated_At
+		if !order.Scheduled_For.IsZero() {
+			order.Promised_Delivery_At = order.Scheduled_For
+		}
+	}
+	return true
+}
+
+// validateDriver responds with 400 and returns false when the user to deliver an
+// order is not a member of the order's branch.
+func validateDriver(ctx context.Context, c *gin.Context, branchID string, driverID string) bool {
+	var driver models.User
+	if err := UserCollection.FindOne(ctx, bson.M{"_id": driverID}).Decode(&driver); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid driver ID"})
+		return false
+	}
+	if _, ok := helpers.MembershipFor(driver, branchID); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Driver is not a member of this branch"})
+		return false
+	}
+	return true
+}
+
+// AssignOrderDriver assigns, or reassigns, the staff member who delivers a
+// delivery order.
+func AssignOrderDriver() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		orderID := c.Param("order_id")
+
+		var reqBody struct {
+			Driver_ID string `json:"driver_id"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid request body", "details": err.Error()})
+			return
+		}
+
+		filter, ok := scopedFilter(c, bson.M{"_id": orderID})
+		if !ok {
+			return
+		}
+
+		var order models.Order
+		if err := OrderCollection.FindOne(ctx, filter).Decode(&order); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Order not found", "details": err.Error()})
+			return
+		}
+		if order.Order_Type != helpers.OrderTypeDelivery || order.Delivery == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Order is not a delivery order"})
+			return
+		}
+		if order.Delivery.Status == helpers.DeliveryDelivered {
+			c.JSON(http.StatusConflict, gin.H{"success": false, "error": "Order has already been delivered"})
+			return
+		}
+
+		if !validateDriver(ctx, c, order.Branch_ID, reqBody.Driver_ID) {
+			return
+		}
+
+		update := bson.M{"$set": bson.M{
+			"delivery.driver_id": reqBody.Driver_ID,
+			"delivery.status":    helpers.DeliveryAssigned,
+			"updated_at":         time.Now(),
+		}}
+		_, err := OrderCollection.UpdateOne(ctx, bson.M{"_id": orderID, "delivery.status": bson.M{"$ne": helpers.DeliveryDelivered}}, update)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error assigning driver", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Driver assigned successfully"})
+	}
+}
+
+// UpdateDeliveryStatus marks a delivery order as out for delivery or delivered.
+// Only the assigned driver, or staff who may edit orders, may do so.
+func UpdateDeliveryStatus() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		orderID := c.Param("order_id")
+
+		var reqBody struct {
+			Status string `json:"status"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid request body", "details": err.Error()})
+			return
+		}
+		if reqBody.Status != helpers.DeliveryOutForDelivery && reqBody.Status != helpers.DeliveryDelivered {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid status: must be '003' or '004'"})
+			return
+		}
+
+		filter, ok := scopedFilter(c, bson.M{"_id": orderID})
+		if !ok {
+			return
+		}
+
+		var order models.Order
+		if err := OrderCollection.FindOne(ctx, filter).Decode(&order); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Order not found", "details": err.Error()})
+			return
+		}
+		if order.Order_Type != helpers.OrderTypeDelivery || order.Delivery == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Order is not a delivery order"})
+			return
+		}
+		if order.Delivery.Driver_ID == "" {
+			c.JSON(http.StatusConflict, gin.H{"success": false, "error": "No driver is assigned to this order"})
+			return
+		}
+
+		if order.Delivery.Driver_ID != c.GetString("userId") {
+			userInfo, err := helpers.GetCurrentUser(c, UserCollection)
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+				return
+			}
+			granted, err := helpers.GetUserPermissions(ctx, RoleCollection, userInfo, order.Branch_ID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error loading permissions", "details": err.Error()})
+				return
+			}
+			if !helpers.HasPermission(granted, helpers.PermOrderEdit) {
+				c.JSON(http.StatusForbidden, gin.H{"success": false, "error": "Only the assigned driver can update this delivery"})
+				return
+			}
+		}
+
+		updateFields := bson.M{"delivery.status": reqBody.Status, "updated_at": time.Now()}
+		if reqBody.Status == helpers.DeliveryOutForDelivery {
+			updateFields["delivery.dispatched_at"] = time.Now()
+		} else {
+			updateFields["delivery.delivered_at"] = time.Now()
+		}
+
+		_, err := OrderCollection.UpdateOne(ctx, bson.M{"_id": orderID, "delivery.status": bson.M{"$ne": helpers.DeliveryDelivered}}, bson.M{"$set": updateFields})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error updating delivery", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Delivery updated successfully"})
+	}
+}
+
+// GetMyDeliveries lists the delivery orders assigned to the caller that have not
+// been delivered yet, the soonest promised first.
+func GetMyDeliveries() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{
+			"delivery.driver_id": c.GetString("userId"),
+			"delivery.status":    bson.M{"$ne": helpers.DeliveryDelivered},
+			"status":             bson.M{"$ne": "004"},
+		}
+		pipeline := append(orderPipeline(filter), bson.D{{Key: "$sort", Value: bson.M{"promised_delivery_at": 1}}})
+
+		cursor, err := OrderCollection.Aggregate(ctx, pipeline)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving deliveries", "details": err.Error()})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		orders := []bson.M{}
+		if err := cursor.All(ctx, &orders); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error decoding deliveries", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Deliveries retrieved successfully", "data": orders})
+	}
+}
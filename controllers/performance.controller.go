@@ -0,0 +1,404 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	helpers "nano_food_api/helpers"
+	models "nano_food_api/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// findBranchSales returns the sales of a branch created within [from, to) that
+// were not refunded.
+func findBranchSales(ctx context.Context, branchID string, from time.Time, to time.Time) ([]models.Sale, error) {
+	cursor, err := SaleCollection.Find(ctx, bson.M{
+		"branch_id":   branchID,
+		"is_refunded": bson.M{"$ne": true},
+		"created_at":  bson.M{"$gte": from, "$lt": to},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	sales := []models.Sale{}
+	if err := cursor.All(ctx, &sales); err != nil {
+		return nil, err
+	}
+	return sales, nil
+}
+
+// findUsersByID returns the users with the given IDs keyed by ID.
+func findUsersByID(ctx context.Context, userIDs []string) (map[string]models.User, error) {
+	cursor, err := UserCollection.Find(ctx, bson.M{"_id": bson.M{"$in": userIDs}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	byID := map[string]models.User{}
+	for _, user := range users {
+		byID[user.User_ID] = user
+	}
+	return byID, nil
+}
+
+// branchTipDistribution shares out the tips of the given sales by the branch's
+// tip policy. Pooled tips are shared by the hours clocked at the branch in [from, to).
+func branchTipDistribution(ctx context.Context, branch models.Branch, sales []models.Sale, from time.Time, to time.Time) (helpers.TipDistribution, error) {
+	hours := map[string]float64{}
+	roles := map[string]int{}
+
+	if branch.Tip_Policy.Mode == helpers.TipModePooled {
+		entries, err := findTimeEntries(ctx, bson.M{"branch_id": branch.Branch_ID}, from, to)
+		if err != nil {
+			return helpers.TipDistribution{}, err
+		}
+
+		now := time.Now()
+		userIDs := []string{}
+		for _, entry := range entries {
+			if _, ok := hours[entry.User_ID]; !ok {
+				userIDs = append(userIDs, entry.User_ID)
+			}
+			hours[entry.User_ID] += helpers.WorkedDuration(entry, now).Hours()
+		}
+
+		users, err := findUsersByID(ctx, userIDs)
+		if err != nil {
+			return helpers.TipDistribution{}, err
+		}
+		for userID := range hours {
+			membership, ok := helpers.MembershipFor(users[userID], branch.Branch_ID)
+			if !ok {
+				// Former members keep their hours but belong to no eligible role
+				roles[userID] = -1
+				continue
+			}
+			roles[userID] = membership.Role
+		}
+	}
+
+	return helpers.DistributeTips(branch.Tip_Policy, sales, hours, roles), nil
+}
+
+// loadBranchForReport resolves the branch and the ?from=&to= range of a report.
+func loadBranchForReport(ctx context.Context, c *gin.Context) (models.Branch, time.Time, time.Time, bool) {
+	branchID := c.Param("branch_id")
+
+	if !authorizeBranch(c, branchID) {
+		return models.Branch{}, time.Time{}, time.Time{}, false
+	}
+
+	from, to, err := parseDateRange(c.Query("from"), c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid date format, use YYYY-MM-DD", "details": err.Error()})
+		return models.Branch{}, time.Time{}, time.Time{}, false
+	}
+
+	var branch models.Branch
+	err = BranchCollection.FindOne(ctx, bson.M{"_id": branchID}).Decode(&branch)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Branch not found", "details": err.Error()})
+		return models.Branch{}, time.Time{}, time.Time{}, false
+	}
+	return branch, from, to, true
+}
+
+// GetTipDistribution shows how the tips of a branch over ?from=&to= (YYYY-MM-DD)
+// are shared out among staff.
+func GetTipDistribution() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		branch, from, to, ok := loadBranchForReport(ctx, c)
+		if !ok {
+			return
+		}
+
+		sales, err := findBranchSales(ctx, branch.Branch_ID, from, to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving sales", "details": err.Error()})
+			return
+		}
+
+		distribution, err := branchTipDistribution(ctx, branch, sales, from, to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error calculating tips", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Tip distribution retrieved successfully",
+			"policy":  branch.Tip_Policy,
+			"data":    distribution,
+		})
+	}
+}
+
+// staffPerformance is one row of the staff performance report. Revenue of a sale
+// served by several staff is split equally between them, while the average check
+// is the full total of the sales they served.
+type staffPerformance struct {
+	User_ID          string  `json:"user_id"`
+	Name             string  `json:"name"`
+	Orders_Taken     int     `json:"orders_taken"`
+	Order_Revenue    float64 `json:"order_revenue"`
+	Sales_Served     int     `json:"sales_served"`
+	Revenue          float64 `json:"revenue"`
+	Average_Check    float64 `json:"average_check"`
+	Sales_Cashed     int     `json:"sales_cashed"`
+	Amount_Collected float64 `json:"amount_collected"`
+	Tips             float64 `json:"tips"`
+	checkTotal       float64
+}
+
+// GetStaffPerformance reports per staff member of a branch over ?from=&to=
+// (YYYY-MM-DD) the orders they took, the revenue and average check of the sales
+// they served, what they cashed out and their share of tips.
+func GetStaffPerformance() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		branch, from, to, ok := loadBranchForReport(ctx, c)
+		if !ok {
+			return
+		}
+
+		rows := map[string]*staffPerformance{}
+		row := func(userID string) *staffPerformance {
+			if rows[userID] == nil {
+				rows[userID] = &staffPerformance{User_ID: userID}
+			}
+			return rows[userID]
+		}
+
+		pipeline := mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{
+				"branch_id":  branch.Branch_ID,
+				"server_id":  bson.M{"$exists": true, "$ne": ""},
+				"status":     bson.M{"$ne": "004"},
+				"created_at": bson.M{"$gte": from, "$lt": to},
+			}}},
+			// Orders settled by a sale that was later refunded do not count
+			{{Key: "$lookup", Value: bson.M{
+				"from": "sales",
+				"let":  bson.M{"order_id": "$_id"},
+				"pipeline": mongo.Pipeline{
+					{{Key: "$match", Value: bson.M{
+						"is_refunded": true,
+						"$expr":       bson.M{"$in": bson.A{"$$order_id", "$order_ids"}},
+					}}},
+					{{Key: "$limit", Value: 1}},
+				},
+				"as": "refunded_sales",
+			}}},
+			{{Key: "$match", Value: bson.M{"refunded_sales": bson.M{"$size": 0}}}},
+			{{Key: "$group", Value: bson.M{
+				"_id":     "$server_id",
+				"orders":  bson.M{"$sum": 1},
+				"revenue": bson.M{"$sum": "$total_amount"},
+			}}},
+		}
+		cursor, err := OrderCollection.Aggregate(ctx, pipeline)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving orders", "details": err.Error()})
+			return
+		}
+		var orderStats []struct {
+			User_ID string  `bson:"_id"`
+			Orders  int     `bson:"orders"`
+			Revenue float64 `bson:"revenue"`
+		}
+		if err := cursor.All(ctx, &orderStats); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error decoding orders", "details": err.Error()})
+			return
+		}
+		for _, stat := range orderStats {
+			r := row(stat.User_ID)
+			r.Orders_Taken = stat.Orders
+			r.Order_Revenue = stat.Revenue
+		}
+
+		sales, err := findBranchSales(ctx, branch.Branch_ID, from, to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving sales", "details": err.Error()})
+			return
+		}
+		// findBranchSales leaves refunded sales out
+		for _, sale := range sales {
+			for _, userID := range sale.Server_IDs {
+				r := row(userID)
+				r.Sales_Served++
+				r.Revenue += sale.GrandTotal / float64(len(sale.Server_IDs))
+				r.checkTotal += sale.GrandTotal
+			}
+			if sale.Cashier_ID != "" {
+				r := row(sale.Cashier_ID)
+				r.Sales_Cashed++
+				r.Amount_Collected += sale.GrandTotal + sale.Tip
+			}
+		}
+
+		distribution, err := branchTipDistribution(ctx, branch, sales, from, to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error calculating tips", "details": err.Error()})
+			return
+		}
+		for userID, share := range distribution.Shares {
+			row(userID).Tips = share
+		}
+
+		userIDs := []string{}
+		for userID := range rows {
+			userIDs = append(userIDs, userID)
+		}
+		users, err := findUsersByID(ctx, userIDs)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving users", "details": err.Error()})
+			return
+		}
+
+		report := []staffPerformance{}
+		for userID, r := range rows {
+			r.Name = users[userID].Name
+			if r.Sales_Served > 0 {
+				r.Average_Check = helpers.RoundAmount(r.checkTotal / float64(r.Sales_Served))
+			}
+			report = append(report, *r)
+		}
+		sort.Slice(report, func(i, j int) bool { return report[i].Revenue > report[j].Revenue })
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Staff performance retrieved successfully", "data": report})
+	}
+}
+
+// salesReportRequest is the payload of a sales report job.
+type salesReportRequest struct {
+	Branch_ID string    `json:"branch_id"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+}
+
+// salesReport totals a branch's sales over a date range. Refunded sales are
+// left out of the totals and counted separately.
+type salesReport struct {
+	Branch_ID         string             `json:"branch_id" bson:"branch_id"`
+	From              time.Time          `json:"from" bson:"from"`
+	To                time.Time          `json:"to" bson:"to"`
+	Sale_Count        int                `json:"sale_count" bson:"sale_count"`
+	Total_Amount      float64            `json:"total_amount" bson:"total_amount"`
+	Discount          float64            `json:"discount" bson:"discount"`
+	Loyalty_Discount  float64            `json:"loyalty_discount" bson:"loyalty_discount"`
+	Tax               float64            `json:"tax" bson:"tax"`
+	Grand_Total       float64            `json:"grand_total" bson:"grand_total"`
+	Tips              float64            `json:"tips" bson:"tips"`
+	Average_Check     float64            `json:"average_check" bson:"average_check"`
+	By_Payment_Method map[string]float64 `json:"by_payment_method" bson:"by_payment_method"`
+	Refund_Count      int64              `json:"refund_count" bson:"refund_count"`
+}
+
+func runSalesReportJob(ctx context.Context, job models.Job) (interface{}, error) {
+	var request salesReportRequest
+	if err := json.Unmarshal([]byte(job.Payload), &request); err != nil {
+		return nil, fmt.Errorf("%v: %w", err, errJobNoRetry)
+	}
+
+	sales, err := findBranchSales(ctx, request.Branch_ID, request.From, request.To)
+	if err != nil {
+		return nil, err
+	}
+
+	report := salesReport{Branch_ID: request.Branch_ID, From: request.From, To: request.To, By_Payment_Method: map[string]float64{}}
+	for _, sale := range sales {
+		report.Sale_Count++
+		report.Total_Amount += sale.TotalAmount
+		report.Discount += sale.Discount
+		report.Loyalty_Discount += sale.Loyalty_Discount
+		report.Tax += sale.Tax
+		report.Grand_Total += sale.GrandTotal
+		report.Tips += sale.Tip
+		report.By_Payment_Method[sale.PaymentMethod] += sale.GrandTotal
+	}
+	if report.Sale_Count > 0 {
+		report.Average_Check = helpers.RoundAmount(report.Grand_Total / float64(report.Sale_Count))
+	}
+
+	report.Refund_Count, err = SaleCollection.CountDocuments(ctx, bson.M{
+		"branch_id":   request.Branch_ID,
+		"is_refunded": true,
+		"created_at":  bson.M{"$gte": request.From, "$lt": request.To},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// RequestSalesReport queues a sales report of a branch over ?from=&to=
+// (YYYY-MM-DD). Its result is fetched with GetSalesReport once the job is done.
+func RequestSalesReport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		branch, from, to, ok := loadBranchForReport(ctx, c)
+		if !ok {
+			return
+		}
+
+		job, err := helpers.NewJob(helpers.JobGenerateReport, salesReportRequest{Branch_ID: branch.Branch_ID, From: from, To: to})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error creating report job", "details": err.Error()})
+			return
+		}
+		job.Branch_ID = branch.Branch_ID
+		job.Created_By, _ = helpers.GetUserIDFromMdw(c)
+
+		job, err = enqueueJob(ctx, job)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error queueing report", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{"success": true, "message": "Sales report queued successfully", "data": redactJob(job)})
+	}
+}
+
+// GetSalesReport shows the status of a sales report job and, once it has
+// completed, the report.
+func GetSalesReport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var job models.Job
+		err := JobCollection.FindOne(ctx, bson.M{"_id": c.Param("job_id"), "type": helpers.JobGenerateReport}).Decode(&job)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Report not found", "details": err.Error()})
+			return
+		}
+
+		if !authorizeBranch(c, job.Branch_ID) {
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Sales report retrieved successfully", "data": redactJob(job)})
+	}
+}
@@ -0,0 +1,477 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	database "nano_food_api/database"
+	helpers "nano_food_api/helpers"
+	models "nano_food_api/models"
+	"nano_food_api/platforms"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var DeliveryPlatformCollection *mongo.Collection = database.DeliveryPlatformCollection
+
+// validatePlatformMappings makes sure every mapping points at a menu of the
+// platform's branch, and every add-on mapping at an add-on of that menu.
+func validatePlatformMappings(ctx context.Context, branchID string, mappings []models.PlatformItemMapping) error {
+	if err := helpers.ValidatePlatformItemMappings(mappings); err != nil {
+		return err
+	}
+
+	for _, mapping := range mappings {
+		menuExists, err := helpers.CheckDataExist(ctx, MenuCollection, bson.M{"_id": mapping.Menu_ID, "branch_id": branchID})
+		if err != nil {
+			return err
+		}
+		if !menuExists {
+			return fmt.Errorf("invalid menu ID %s for external item %s", mapping.Menu_ID, mapping.External_ID)
+		}
+
+		if mapping.AddOn_ID == "" {
+			continue
+		}
+		addOnExists, err := helpers.CheckDataExist(ctx, AddOnCollection, bson.M{"_id": mapping.AddOn_ID, "menu_id": mapping.Menu_ID})
+		if err != nil {
+			return err
+		}
+		if !addOnExists {
+			return fmt.Errorf("invalid add-on ID %s for external item %s", mapping.AddOn_ID, mapping.External_ID)
+		}
+	}
+	return nil
+}
+
+// mapPlatformItems turns a platform's items into order items through the
+// platform's item mappings. Items must map to menus and their options to
+// add-ons of those menus.
+func mapPlatformItems(platform models.DeliveryPlatform, items []platforms.ExternalItem) ([]models.OrderItem, error) {
+	mappings := map[string]models.PlatformItemMapping{}
+	for _, mapping := range platform.Item_Mappings {
+		mappings[mapping.External_ID] = mapping
+	}
+
+	orderItems := []models.OrderItem{}
+	for _, item := range items {
+		mapping, ok := mappings[item.External_ID]
+		if !ok || mapping.AddOn_ID != "" {
+			return nil, fmt.Errorf("external item %s is not mapped to a menu", item.External_ID)
+		}
+
+		orderItem := models.OrderItem{Menu_ID: mapping.Menu_ID, Quantity: item.Quantity}
+		for _, option := range item.Options {
+			optionMapping, ok := mappings[option.External_ID]
+			if !ok || optionMapping.AddOn_ID == "" || optionMapping.Menu_ID != mapping.Menu_ID {
+				return nil, fmt.Errorf("external option %s is not mapped to an add-on of item %s", option.External_ID, item.External_ID)
+			}
+
+			quantity := option.Quantity
+			if quantity == 0 {
+				quantity = 1
+			}
+			orderItem.AddOnItems = append(orderItem.AddOnItems, models.AddOnItem{AddOnID: optionMapping.AddOn_ID, Quantity: quantity, Note: option.Note})
+		}
+		orderItems = append(orderItems, orderItem)
+	}
+	return orderItems, nil
+}
+
+// notifyPlatformStatus reports an order's new status to the platform it came
+// from, in the background. Orders of our own are ignored.
+func notifyPlatformStatus(order models.Order, status string) {
+	if order.Platform_ID == "" {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		var platform models.DeliveryPlatform
+		if err := DeliveryPlatformCollection.FindOne(ctx, bson.M{"_id": order.Platform_ID}).Decode(&platform); err != nil {
+			log.Printf("Error finding platform %s of order %s: %v", order.Platform_ID, order.Order_ID, err)
+			return
+		}
+		if platform.Callback_URL == "" {
+			return
+		}
+		adapter, ok := platforms.Get(platform.Platform)
+		if !ok {
+			log.Printf("No adapter for platform %s of order %s", platform.Platform, order.Order_ID)
+			return
+		}
+
+		if err := platforms.SendStatus(adapter, platform.Callback_URL, platform.Webhook_Secret, order.Source_Order_ID, status); err != nil {
+			log.Printf("Error reporting status %s of order %s to %s: %v", status, order.Order_ID, platform.Name, err)
+		}
+	}()
+}
+
+// CreateDeliveryPlatform connects a branch to a delivery platform. The webhook
+// secret is generated here and shown only in this response.
+func CreateDeliveryPlatform() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var platform models.DeliveryPlatform
+		if err := c.BindJSON(&platform); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		if !authorizeBranch(c, platform.Branch_ID) {
+			return
+		}
+
+		platform.Platform = strings.ToLower(strings.TrimSpace(platform.Platform))
+		if _, ok := platforms.Get(platform.Platform); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": fmt.Sprintf("Unsupported platform, use one of: %s", strings.Join(platforms.Names(), ", "))})
+			return
+		}
+		if strings.TrimSpace(platform.Name) == "" {
+			platform.Name = platform.Platform
+		}
+		if platform.Item_Mappings == nil {
+			platform.Item_Mappings = []models.PlatformItemMapping{}
+		}
+		if err := validatePlatformMappings(ctx, platform.Branch_ID, platform.Item_Mappings); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid item mappings", "details": err.Error()})
+			return
+		}
+
+		secret, err := helpers.GenerateRandomToken(32)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error generating webhook secret", "details": err.Error()})
+			return
+		}
+
+		platform.Platform_ID = primitive.NewObjectID().Hex()
+		platform.Webhook_Secret = secret
+		platform.IsActive = true
+		platform.Created_By, _ = helpers.GetUserIDFromMdw(c)
+		platform.Created_At = time.Now()
+		platform.Updated_At = platform.Created_At
+
+		if _, err := DeliveryPlatformCollection.InsertOne(ctx, platform); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error creating delivery platform", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{
+			"success":        true,
+			"message":        "Delivery platform created successfully. Store the webhook secret now, it cannot be shown again.",
+			"data":           platform,
+			"webhook_secret": secret,
+			"webhook_path":   "/webhooks/platform/" + platform.Platform_ID,
+		})
+	}
+}
+
+// GetDeliveryPlatforms lists the delivery platforms of ?branch_id=.
+func GetDeliveryPlatforms() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		if branchID := c.Query("branch_id"); branchID != "" {
+			filter["branch_id"] = branchID
+		}
+
+		filter, ok := scopedFilter(c, filter)
+		if !ok {
+			return
+		}
+
+		cursor, err := DeliveryPlatformCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": 1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving delivery platforms", "details": err.Error()})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		deliveryPlatforms := []models.DeliveryPlatform{}
+		if err := cursor.All(ctx, &deliveryPlatforms); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error decoding delivery platforms", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Delivery platforms retrieved successfully", "data": deliveryPlatforms})
+	}
+}
+
+// UpdateDeliveryPlatform changes a platform's name, callback URL, auto-accept,
+// item mappings and whether it is active. With "rotate_secret" a new webhook
+// secret is generated and shown once.
+func UpdateDeliveryPlatform() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		platformID := c.Param("platform_id")
+
+		var reqBody struct {
+			Name          string                       `json:"name"`
+			Callback_URL  string                       `json:"callback_url"`
+			Auto_Accept   bool                         `json:"auto_accept"`
+			Item_Mappings []models.PlatformItemMapping `json:"item_mappings"`
+			IsActive      bool                         `json:"is_active"`
+			Rotate_Secret bool                         `json:"rotate_secret"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		filter, ok := scopedFilter(c, bson.M{"_id": platformID})
+		if !ok {
+			return
+		}
+
+		var platform models.DeliveryPlatform
+		if err := DeliveryPlatformCollection.FindOne(ctx, filter).Decode(&platform); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Delivery platform not found", "details": err.Error()})
+			return
+		}
+
+		if reqBody.Item_Mappings == nil {
+			reqBody.Item_Mappings = []models.PlatformItemMapping{}
+		}
+		if err := validatePlatformMappings(ctx, platform.Branch_ID, reqBody.Item_Mappings); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid item mappings", "details": err.Error()})
+			return
+		}
+		if strings.TrimSpace(reqBody.Name) == "" {
+			reqBody.Name = platform.Name
+		}
+
+		updateFields := bson.M{
+			"name":          reqBody.Name,
+			"callback_url":  reqBody.Callback_URL,
+			"auto_accept":   reqBody.Auto_Accept,
+			"item_mappings": reqBody.Item_Mappings,
+			"is_active":     reqBody.IsActive,
+			"updated_at":    time.Now(),
+		}
+		secret := ""
+		if reqBody.Rotate_Secret {
+			var err error
+			secret, err = helpers.GenerateRandomToken(32)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error generating webhook secret", "details": err.Error()})
+				return
+			}
+			updateFields["webhook_secret"] = secret
+		}
+
+		if _, err := DeliveryPlatformCollection.UpdateOne(ctx, bson.M{"_id": platformID}, bson.M{"$set": updateFields}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error updating delivery platform", "details": err.Error()})
+			return
+		}
+
+		response := gin.H{"success": true, "message": "Delivery platform updated successfully"}
+		if secret != "" {
+			response["webhook_secret"] = secret
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+func DeleteDeliveryPlatform() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter, ok := scopedFilter(c, bson.M{"_id": c.Param("platform_id")})
+		if !ok {
+			return
+		}
+
+		result, err := DeliveryPlatformCollection.DeleteOne(ctx, filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error deleting delivery platform", "details": err.Error()})
+			return
+		}
+		if result.DeletedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Delivery platform not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Delivery platform deleted successfully"})
+	}
+}
+
+// cancelPlatformOrder cancels an order at its platform's request. Completed
+// orders are left alone.
+func cancelPlatformOrder(ctx context.Context, c *gin.Context, platform models.DeliveryPlatform, externalID string) {
+	var order models.Order
+	err := OrderCollection.FindOneAndUpdate(ctx,
+		bson.M{"platform_id": platform.Platform_ID, "source_order_id": externalID, "status": bson.M{"$nin": []string{"003", "004"}}},
+		bson.M{"$set": bson.M{"status": "004", "updated_at": time.Now()}},
+	).Decode(&order)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusConflict, gin.H{"success": false, "error": "Order not found, or already completed or cancelled"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error cancelling order", "details": err.Error()})
+		return
+	}
+	releasePreorderSlot(ctx, order.Preorder_Slot)
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Order cancelled successfully"})
+}
+
+// maxPlatformWebhookBytes bounds the body of a platform webhook, which is read
+// before its signature can be checked.
+const maxPlatformWebhookBytes = 1 << 20
+
+// ReceivePlatformWebhook takes orders and cancellations from a delivery
+// platform. The body must be signed, together with a recent timestamp, with the
+// platform's webhook secret. Orders wait for staff confirmation unless the
+// platform is set to auto-accept, and an order sent twice is only created once.
+func ReceivePlatformWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var platform models.DeliveryPlatform
+		err := DeliveryPlatformCollection.FindOne(ctx, bson.M{"_id": c.Param("platform_id"), "is_active": true}).Decode(&platform)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Delivery platform not found"})
+			return
+		}
+		adapter, ok := platforms.Get(platform.Platform)
+		if !ok {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "No adapter for platform " + platform.Platform})
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxPlatformWebhookBytes))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Error reading request body", "details": err.Error()})
+			return
+		}
+		timestamp := c.GetHeader(adapter.TimestampHeader())
+		if !platforms.VerifySignature(platform.Webhook_Secret, timestamp, body, c.GetHeader(adapter.SignatureHeader()), time.Now()) {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": "Invalid signature"})
+			return
+		}
+
+		external, err := adapter.ParseOrder(body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid order payload", "details": err.Error()})
+			return
+		}
+
+		if external.Cancelled {
+			cancelPlatformOrder(ctx, c, platform, external.External_ID)
+			return
+		}
+
+		received, err := helpers.CheckDataExist(ctx, OrderCollection, bson.M{"platform_id": platform.Platform_ID, "source_order_id": external.External_ID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error checking for the order", "details": err.Error()})
+			return
+		}
+		if received {
+			c.JSON(http.StatusOK, gin.H{"success": true, "message": "Order already received"})
+			return
+		}
+
+		menuItems, err := mapPlatformItems(platform, external.Items)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"success": false, "error": "Unknown order items", "details": err.Error()})
+			return
+		}
+		if err := validateGuestOrderItems(ctx, platform.Branch_ID, menuItems); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"success": false, "error": "Invalid order items", "details": err.Error()})
+			return
+		}
+
+		order := models.Order{
+			Order_ID:        primitive.NewObjectID().Hex(),
+			Order_Type:      helpers.OrderTypeDelivery,
+			Branch_ID:       platform.Branch_ID,
+			MenuItems:       menuItems,
+			Status:          "000",
+			Note:            external.Note,
+			Customer_Name:   external.Customer_Name,
+			Customer_Phone:  external.Customer_Phone,
+			Scheduled_For:   external.Scheduled_For,
+			Source:          platform.Platform,
+			Platform_ID:     platform.Platform_ID,
+			Source_Order_ID: external.External_ID,
+			Created_At:      time.Now(),
+			Updated_At:      time.Now(),
+		}
+		if external.Order_Type == platforms.OrderTypePickup {
+			order.Order_Type = helpers.OrderTypePickup
+		} else {
+			order.Delivery = &models.OrderDelivery{
+				Address:   external.Address,
+				Latitude:  external.Latitude,
+				Longitude: external.Longitude,
+				Fee:       external.Delivery_Fee,
+			}
+		}
+		if platform.Auto_Accept {
+			order.Status = "001"
+		}
+
+		// The platform keeps in touch with its customers, so their name and
+		// phone are optional
+		if err := helpers.NormalizeOrderFulfilment(&order, true); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		totalAmount, err := calculateOrderTotal(ctx, order.MenuItems)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error calculating order total", "details": err.Error()})
+			return
+		}
+		order.TotalAmount = totalAmount
+		if order.Delivery != nil {
+			order.TotalAmount += order.Delivery.Fee
+		}
+
+		if !order.Scheduled_For.IsZero() && !schedulePreorder(ctx, c, &order) {
+			return
+		}
+		err = helpers.RunInTransaction(ctx, database.Client, func(ctx context.Context) error {
+			if _, err := OrderCollection.InsertOne(ctx, order); err != nil {
+				return err
+			}
+			return publishWebhookEvent(ctx, order.Branch_ID, helpers.WebhookOrderCreated, order)
+		})
+		if err != nil {
+			releasePreorderSlot(ctx, order.Preorder_Slot)
+			// The same order arrived on another request in the meantime
+			if mongo.IsDuplicateKeyError(err) {
+				c.JSON(http.StatusOK, gin.H{"success": true, "message": "Order already received"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error creating order", "details": err.Error()})
+			return
+		}
+
+		if platform.Auto_Accept {
+			notifyPlatformStatus(order, order.Status)
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"success": true, "message": "Order received successfully", "data": gin.H{"_id": order.Order_ID}})
+	}
+}
@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"nano_food_api/platforms"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+const testWebhookSecret = "platform-secret"
+
+var testPlatform = bson.D{
+	{Key: "_id", Value: "platform-1"},
+	{Key: "branch_id", Value: "branch-a"},
+	{Key: "platform", Value: "generic"},
+	{Key: "webhook_secret", Value: testWebhookSecret},
+	{Key: "is_active", Value: true},
+}
+
+// platformWebhookRequest posts a generic platform payload signed at the given
+// time with the given secret.
+func platformWebhookRequest(t *testing.T, order platforms.ExternalOrder, secret string, signedAt time.Time) *httptest.ResponseRecorder {
+	adapter, _ := platforms.Get("generic")
+	body, err := adapter.EncodeOrder(order)
+	if err != nil {
+		t.Fatalf("encoding order: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/webhooks/platform/:platform_id", ReceivePlatformWebhook())
+
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/platform/platform-1", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(adapter.TimestampHeader(), strconv.FormatInt(signedAt.Unix(), 10))
+	req.Header.Set(adapter.SignatureHeader(), platforms.Sign(secret, signedAt.Unix(), body))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+// withMockCollections points the collections the webhook uses at a mocked
+// deployment for the length of a test.
+func withMockCollections(mt *mtest.T) {
+	withMockCollection(mt, &DeliveryPlatformCollection, "delivery_platforms")
+	withMockCollection(mt, &OrderCollection, "orders")
+}
+
+func TestReceivePlatformWebhook(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	order := platforms.ExternalOrder{
+		External_ID: "EXT-1",
+		Order_Type:  platforms.OrderTypePickup,
+		Items:       []platforms.ExternalItem{{External_ID: "burger", Quantity: 1}},
+	}
+
+	mt.Run("valid signature", func(mt *mtest.T) {
+		withMockCollections(mt)
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "nano_food.delivery_platforms", mtest.FirstBatch, testPlatform),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{{Key: "_id", Value: "order-1"}, {Key: "status", Value: "001"}}}),
+		)
+
+		cancellation := platforms.ExternalOrder{External_ID: "EXT-1", Cancelled: true}
+		rec := platformWebhookRequest(mt.T, cancellation, testWebhookSecret, time.Now())
+		if rec.Code != http.StatusOK {
+			mt.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+	})
+
+	mt.Run("bad signature", func(mt *mtest.T) {
+		withMockCollections(mt)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "nano_food.delivery_platforms", mtest.FirstBatch, testPlatform))
+
+		rec := platformWebhookRequest(mt.T, order, "wrong-secret", time.Now())
+		if rec.Code != http.StatusUnauthorized {
+			mt.Fatalf("expected 401, got %d: %s", rec.Code, rec.Body.String())
+		}
+	})
+
+	mt.Run("stale timestamp", func(mt *mtest.T) {
+		withMockCollections(mt)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "nano_food.delivery_platforms", mtest.FirstBatch, testPlatform))
+
+		rec := platformWebhookRequest(mt.T, order, testWebhookSecret, time.Now().Add(-platforms.SignatureTolerance-time.Minute))
+		if rec.Code != http.StatusUnauthorized {
+			mt.Fatalf("expected 401, got %d: %s", rec.Code, rec.Body.String())
+		}
+	})
+
+	mt.Run("duplicate order", func(mt *mtest.T) {
+		withMockCollections(mt)
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "nano_food.delivery_platforms", mtest.FirstBatch, testPlatform),
+			mtest.CreateCursorResponse(0, "nano_food.orders", mtest.FirstBatch, bson.D{{Key: "n", Value: int32(1)}}),
+		)
+
+		rec := platformWebhookRequest(mt.T, order, testWebhookSecret, time.Now())
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "already received") {
+			mt.Fatalf("expected the order to be received once, got %d: %s", rec.Code, rec.Body.String())
+		}
+	})
+}
+
+func TestReceivePlatformWebhookBodyLimit(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("oversized body", func(mt *mtest.T) {
+		withMockCollections(mt)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "nano_food.delivery_platforms", mtest.FirstBatch, testPlatform))
+
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		router.POST("/webhooks/platform/:platform_id", ReceivePlatformWebhook())
+
+		body := bytes.Repeat([]byte("a"), maxPlatformWebhookBytes+1)
+		req := httptest.NewRequest(http.MethodPost, "/webhooks/platform/platform-1", bytes.NewReader(body))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			mt.Fatalf("expected 400, got %d: %s", rec.Code, rec.Body.String())
+		}
+	})
+}
@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+
+	helpers "nano_food_api/helpers"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// authorizeBranch responds with 403 and returns false when the caller may not
+// act on data of the given branch.
+func authorizeBranch(c *gin.Context, branchID string) bool {
+	scope, err := helpers.GetBranchScope(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+		return false
+	}
+
+	if !scope.CanAccess(branchID) {
+		c.JSON(http.StatusForbidden, gin.H{"success": false, "error": "Unauthorized Access"})
+		return false
+	}
+	return true
+}
+
+// scopedFilter restricts a query on branch-owned documents to the caller's
+// branch, so documents of other branches behave as if they did not exist. It
+// responds with 403 and returns false when the filter names another branch.
+func scopedFilter(c *gin.Context, filter bson.M) (bson.M, bool) {
+	scope, err := helpers.GetBranchScope(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+		return nil, false
+	}
+
+	filter, err = scope.ScopeFilter(filter)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"success": false, "error": "Unauthorized Access", "details": err.Error()})
+		return nil, false
+	}
+	return filter, true
+}
+
+// aggregateFilter restricts a query that spans branches to every branch where the
+// caller holds the permission, so owners can see all of their outlets at once.
+// Root admins are not restricted. A branch_id already in the filter must be one
+// of those branches.
+func aggregateFilter(ctx context.Context, c *gin.Context, filter bson.M, permission string) (bson.M, bool) {
+	userInfo, err := helpers.GetCurrentUser(c, UserCollection)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+		return nil, false
+	}
+	if userInfo.Role == 100 {
+		return filter, true
+	}
+
+	branchIDs := []string{}
+	for _, membership := range helpers.UserBranches(userInfo) {
+		granted, err := helpers.GetUserPermissions(ctx, RoleCollection, userInfo, membership.Branch_ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error loading permissions", "details": err.Error()})
+			return nil, false
+		}
+		if helpers.HasPermission(granted, permission) {
+			branchIDs = append(branchIDs, membership.Branch_ID)
+		}
+	}
+
+	if requested, ok := filter["branch_id"].(string); ok && requested != "" {
+		for _, branchID := range branchIDs {
+			if branchID == requested {
+				return filter, true
+			}
+		}
+		c.JSON(http.StatusForbidden, gin.H{"success": false, "error": "Unauthorized Access"})
+		return nil, false
+	}
+
+	filter["branch_id"] = bson.M{"$in": branchIDs}
+	return filter, true
+}
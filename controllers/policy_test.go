@@ -0,0 +1,218 @@
+package controllers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// withScope stands in for Authentication, storing the caller's claims the way
+// the middleware does.
+func withScope(userID string, role int, branchID string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("userId", userID)
+		c.Set("role", role)
+		c.Set("branchId", branchID)
+		c.Next()
+	}
+}
+
+func scopedRouter(scope gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	auth := router.Group("/")
+	if scope != nil {
+		auth.Use(scope)
+	}
+
+	auth.POST("/create-category", CreateCategory())
+	auth.POST("/create-table", CreateTable())
+	auth.GET("/get-all-orders", GetAllOrders())
+	auth.GET("/get-all-sales", GetAllSales())
+	auth.GET("/get-branch-time-entries/:branch_id", GetBranchTimeEntries())
+	return router
+}
+
+func TestScopedRoutesDenyOtherBranch(t *testing.T) {
+	router := scopedRouter(withScope("manager-a", 2, "branch-a"))
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{"create category", http.MethodPost, "/create-category", `{"name":"Drinks","branch_id":"branch-b"}`},
+		{"create table", http.MethodPost, "/create-table", `{"name":"T1","branch_id":"branch-b"}`},
+		{"list orders", http.MethodGet, "/get-all-orders?branch_id=branch-b", ""},
+		{"list sales", http.MethodGet, "/get-all-sales?branch_id=branch-b", ""},
+		{"branch time entries", http.MethodGet, "/get-branch-time-entries/branch-b", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusForbidden {
+				t.Fatalf("expected %d, got %d: %s", http.StatusForbidden, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestScopedRoutesRequireScope(t *testing.T) {
+	router := scopedRouter(nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/get-branch-time-entries/branch-a", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected %d, got %d: %s", http.StatusUnauthorized, rec.Code, rec.Body.String())
+	}
+}
+
+func TestAuthorizeBranch(t *testing.T) {
+	tests := []struct {
+		name     string
+		role     int
+		branchID string
+		want     bool
+		wantCode int
+	}{
+		{"own branch", 2, "branch-a", true, http.StatusOK},
+		{"other branch", 2, "branch-b", false, http.StatusForbidden},
+		{"empty branch", 2, "", false, http.StatusForbidden},
+		{"root admin", 100, "branch-b", true, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			c.Set("userId", "user-a")
+			c.Set("role", tt.role)
+			c.Set("branchId", "branch-a")
+
+			if got := authorizeBranch(c, tt.branchID); got != tt.want {
+				t.Fatalf("authorizeBranch(%q) = %v, want %v", tt.branchID, got, tt.want)
+			}
+			if rec.Code != tt.wantCode {
+				t.Fatalf("expected %d, got %d", tt.wantCode, rec.Code)
+			}
+		})
+	}
+}
+
+func TestScopedFilter(t *testing.T) {
+	tests := []struct {
+		name       string
+		role       int
+		filter     bson.M
+		wantOK     bool
+		wantBranch interface{}
+	}{
+		{"adds own branch", 2, bson.M{"_id": "order-1"}, true, "branch-a"},
+		{"keeps own branch", 2, bson.M{"branch_id": "branch-a"}, true, "branch-a"},
+		{"denies other branch", 2, bson.M{"branch_id": "branch-b"}, false, nil},
+		{"root admin sees other branch", 100, bson.M{"branch_id": "branch-b"}, true, "branch-b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			c.Set("userId", "user-a")
+			c.Set("role", tt.role)
+			c.Set("branchId", "branch-a")
+
+			filter, ok := scopedFilter(c, tt.filter)
+			if ok != tt.wantOK {
+				t.Fatalf("scopedFilter ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				if rec.Code != http.StatusForbidden {
+					t.Fatalf("expected %d, got %d", http.StatusForbidden, rec.Code)
+				}
+				return
+			}
+			if filter["branch_id"] != tt.wantBranch {
+				t.Fatalf("branch_id = %v, want %v", filter["branch_id"], tt.wantBranch)
+			}
+		})
+	}
+}
+
+// withMockCollection points a collection at a mocked deployment for the length
+// of a test.
+func withMockCollection(mt *mtest.T, collection **mongo.Collection, name string) {
+	previous := *collection
+	*collection = mt.DB.Collection(name)
+	mt.Cleanup(func() { *collection = previous })
+}
+
+func TestScopedHandlersIgnoreOtherBranch(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	noDocument := mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil})
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		collection **mongo.Collection
+		namespace  string
+		response   bson.D
+		filterKey  string // where the command carries its filter
+	}{
+		{"update menu", http.MethodPut, "/update-menu/menu-b", "", &MenuCollection, "menus", mtest.CreateCursorResponse(0, "nano_food.menus", mtest.FirstBatch), "filter"},
+		{"update order", http.MethodPut, "/update-order/order-b", `{"status":"002"}`, &OrderCollection, "orders", noDocument, "query"},
+		{"delete order", http.MethodDelete, "/delete-order/order-b", "", &OrderCollection, "orders", noDocument, "query"},
+		{"delete category", http.MethodDelete, "/delete-category/category-b", "", &CategoryCollection, "categories", mtest.CreateCursorResponse(0, "nano_food.categories", mtest.FirstBatch), "filter"},
+	}
+
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			withMockCollection(mt, tt.collection, tt.namespace)
+			mt.AddMockResponses(tt.response)
+
+			gin.SetMode(gin.TestMode)
+			router := gin.New()
+			router.Use(withScope("manager-a", 2, "branch-a"))
+			router.PUT("/update-menu/:menu_id", UpdateMenu())
+			router.PUT("/update-order/:order_id", UpdateOrder())
+			router.DELETE("/delete-order/:order_id", DeleteOrder())
+			router.DELETE("/delete-category/:category_id", DeleteCategory())
+
+			req := httptest.NewRequest(tt.method, tt.path, bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusNotFound && rec.Code != http.StatusForbidden {
+				mt.Fatalf("expected 404 or 403, got %d: %s", rec.Code, rec.Body.String())
+			}
+
+			started := mt.GetStartedEvent()
+			if started == nil {
+				mt.Fatalf("no command was sent")
+			}
+			branchID, err := started.Command.LookupErr(tt.filterKey, "branch_id")
+			if err != nil || branchID.StringValue() != "branch-a" {
+				mt.Fatalf("%s filter is not scoped to the caller's branch: %s", started.CommandName, started.Command)
+			}
+			if next := mt.GetStartedEvent(); next != nil {
+				mt.Fatalf("unexpected %s after the document was not found", next.CommandName)
+			}
+		})
+	}
+}
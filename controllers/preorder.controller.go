@@ -0,0 +1,380 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"time"
+
+	database "nano_food_api/database"
+	helpers "nano_food_api/helpers"
+	models "nano_food_api/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var PreorderSlotCollection *mongo.Collection = database.PreorderSlotCollection
+
+// countSlotPreorders counts the live pre-orders of a branch due within [from, to),
+// leaving out the given order.
+func countSlotPreorders(ctx context.Context, branchID string, from time.Time, to time.Time, exceptOrderID string) (int64, error) {
+	filter := bson.M{
+		"branch_id":     branchID,
+		"scheduled_for": bson.M{"$gte": from, "$lt": to},
+		"status":        bson.M{"$ne": "004"},
+	}
+	if exceptOrderID != "" {
+		filter["_id"] = bson.M{"$ne": exceptOrderID}
+	}
+	return OrderCollection.CountDocuments(ctx, filter)
+}
+
+// preorderSlotID names the counter of the slot of a branch starting at the given time.
+func preorderSlotID(branchID string, slotStart time.Time) string {
+	return fmt.Sprintf("%s@%s", branchID, slotStart.UTC().Format(time.RFC3339))
+}
+
+// reservePreorderSlot takes a place in the slot [from, to) of a branch and
+// returns false when the slot is full. A slot's counter is seeded with the
+// pre-orders already booked in it the first time it is used, and only ever
+// raised below capacity, so that concurrent requests cannot overbook it.
+func reservePreorderSlot(ctx context.Context, branchID string, from time.Time, to time.Time, capacity int, exceptOrderID string) (bool, error) {
+	slotID := preorderSlotID(branchID, from)
+
+	var counter bson.M
+	err := PreorderSlotCollection.FindOne(ctx, bson.M{"_id": slotID}).Decode(&counter)
+	if err == mongo.ErrNoDocuments {
+		booked, err := countSlotPreorders(ctx, branchID, from, to, exceptOrderID)
+		if err != nil {
+			return false, err
+		}
+		_, err = PreorderSlotCollection.UpdateOne(ctx,
+			bson.M{"_id": slotID},
+			bson.M{"$setOnInsert": bson.M{"branch_id": branchID, "slot_start": from, "booked": booked}},
+			options.Update().SetUpsert(true),
+		)
+		// Another request seeded the counter in the meantime
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return false, err
+		}
+	} else if err != nil {
+		return false, err
+	}
+
+	result, err := PreorderSlotCollection.UpdateOne(ctx,
+		bson.M{"_id": slotID, "booked": bson.M{"$lt": capacity}},
+		bson.M{"$inc": bson.M{"booked": 1}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// releasePreorderSlot gives back the place a pre-order holds in its slot, e.g.
+// when it is cancelled, deleted or moved to another slot.
+func releasePreorderSlot(ctx context.Context, slotID string) {
+	if slotID == "" {
+		return
+	}
+	_, err := PreorderSlotCollection.UpdateOne(ctx,
+		bson.M{"_id": slotID, "booked": bson.M{"$gt": 0}},
+		bson.M{"$inc": bson.M{"booked": -1}},
+	)
+	if err != nil {
+		log.Printf("Error releasing pre-order slot %s: %v", slotID, err)
+	}
+}
+
+// schedulePreorder validates the time a pre-order is scheduled for against its
+// branch's policy, reserves a place in its slot when slots are limited and sets
+// its release and reminder times. It responds and returns false when the order
+// cannot be scheduled. The caller releases the new slot when the order is not
+// saved after all, and the previous one when the order moved.
+func schedulePreorder(ctx context.Context, c *gin.Context, order *models.Order) bool {
+	if !order.Scheduled_For.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Pre-orders must be scheduled for a future time"})
+		return false
+	}
+
+	var branch models.Branch
+	if err := BranchCollection.FindOne(ctx, bson.M{"_id": order.Branch_ID}).Decode(&branch); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid branch ID", "details": err.Error()})
+		return false
+	}
+
+	slotID := ""
+	if branch.Preorder_Policy.Slot_Capacity > 0 {
+		slotStart, slotEnd := helpers.PreorderSlot(branch.Preorder_Policy, order.Scheduled_For)
+		slotID = preorderSlotID(order.Branch_ID, slotStart)
+		// An order moved within the slot it already holds keeps its place
+		if slotID != order.Preorder_Slot {
+			ok, err := reservePreorderSlot(ctx, order.Branch_ID, slotStart, slotEnd, branch.Preorder_Policy.Slot_Capacity, order.Order_ID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error checking slot capacity", "details": err.Error()})
+				return false
+			}
+			if !ok {
+				c.JSON(http.StatusConflict, gin.H{"success": false, "error": fmt.Sprintf("The %s slot is fully booked", slotStart.Format("15:04"))})
+				return false
+			}
+		}
+	}
+
+	order.Preorder_Slot = slotID
+	helpers.SchedulePreorder(branch.Preorder_Policy, order)
+	return true
+}
+
+// RescheduleOrder moves a pre-order to another time.
+func RescheduleOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		orderID := c.Param("order_id")
+
+		var reqBody struct {
+			Scheduled_For time.Time `json:"scheduled_for"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid request body", "details": err.Error()})
+			return
+		}
+
+		filter, ok := scopedFilter(c, bson.M{"_id": orderID})
+		if !ok {
+			return
+		}
+
+		var order models.Order
+		if err := OrderCollection.FindOne(ctx, filter).Decode(&order); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Order not found", "details": err.Error()})
+			return
+		}
+		if order.Scheduled_For.IsZero() {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Order is not a pre-order"})
+			return
+		}
+		if order.Status != "000" && order.Status != "001" {
+			c.JSON(http.StatusConflict, gin.H{"success": false, "error": "Only pre-orders that have not been started can be rescheduled"})
+			return
+		}
+
+		// A new time also gets a new promise
+		previousSlot := order.Preorder_Slot
+		order.Scheduled_For = reqBody.Scheduled_For
+		order.Promised_Ready_At = time.Time{}
+		if !schedulePreorder(ctx, c, &order) {
+			return
+		}
+
+		setFields := bson.M{
+			"scheduled_for":     order.Scheduled_For,
+			"release_at":        order.Release_At,
+			"remind_at":         order.Remind_At,
+			"promised_ready_at": order.Promised_Ready_At,
+			"updated_at":        time.Now(),
+		}
+		unsetFields := bson.M{"reminded_at": ""}
+		if order.Preorder_Slot != "" {
+			setFields["preorder_slot"] = order.Preorder_Slot
+		} else {
+			unsetFields["preorder_slot"] = ""
+		}
+		update := bson.M{"$set": setFields, "$unset": unsetFields}
+		if _, err := OrderCollection.UpdateOne(ctx, bson.M{"_id": orderID}, update); err != nil {
+			if order.Preorder_Slot != previousSlot {
+				releasePreorderSlot(ctx, order.Preorder_Slot)
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error rescheduling order", "details": err.Error()})
+			return
+		}
+		if order.Preorder_Slot != previousSlot {
+			releasePreorderSlot(ctx, previousSlot)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Order rescheduled successfully"})
+	}
+}
+
+// preorderSlot is one slot of a day's pre-order availability.
+type preorderSlot struct {
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Booked    int       `json:"booked"`
+	Capacity  int       `json:"capacity"` // 0 means no limit
+	Available bool      `json:"available"`
+}
+
+// GetPreorderSlots lists the pre-order slots of a branch on ?date= (YYYY-MM-DD,
+// today by default) with how many orders each has booked.
+func GetPreorderSlots() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		branchID := c.Param("branch_id")
+
+		if !authorizeBranch(c, branchID) {
+			return
+		}
+
+		from, to, err := parseDateRange(c.Query("date"), c.Query("date"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid date format, use YYYY-MM-DD", "details": err.Error()})
+			return
+		}
+
+		var branch models.Branch
+		if err := BranchCollection.FindOne(ctx, bson.M{"_id": branchID}).Decode(&branch); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Branch not found", "details": err.Error()})
+			return
+		}
+
+		cursor, err := OrderCollection.Find(ctx, bson.M{
+			"branch_id":     branchID,
+			"scheduled_for": bson.M{"$gte": from, "$lt": to},
+			"status":        bson.M{"$ne": "004"},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving pre-orders", "details": err.Error()})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		var orders []models.Order
+		if err := cursor.All(ctx, &orders); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error decoding pre-orders", "details": err.Error()})
+			return
+		}
+
+		booked := map[time.Time]int{}
+		for _, order := range orders {
+			slotStart, _ := helpers.PreorderSlot(branch.Preorder_Policy, order.Scheduled_For)
+			booked[slotStart]++
+		}
+
+		now := time.Now()
+		capacity := branch.Preorder_Policy.Slot_Capacity
+		slots := []preorderSlot{}
+		for _, start := range helpers.PreorderSlotsOfDay(branch.Preorder_Policy, from) {
+			_, end := helpers.PreorderSlot(branch.Preorder_Policy, start)
+			slots = append(slots, preorderSlot{
+				Start:     start,
+				End:       end,
+				Booked:    booked[start],
+				Capacity:  capacity,
+				Available: end.After(now) && (capacity == 0 || booked[start] < capacity),
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Pre-order slots retrieved successfully",
+			"policy":  helpers.EffectivePreorderPolicy(branch.Preorder_Policy),
+			"data":    slots,
+		})
+	}
+}
+
+// preorderReminderRecipients returns the emails of the staff clocked in at a
+// branch, or of its managers when nobody is.
+func preorderReminderRecipients(ctx context.Context, branchID string) ([]string, error) {
+	cursor, err := TimeEntryCollection.Find(ctx, bson.M{"branch_id": branchID, "status": "001"})
+	if err != nil {
+		return nil, err
+	}
+	var entries []models.TimeEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+
+	userIDs := []string{}
+	for _, entry := range entries {
+		userIDs = append(userIDs, entry.User_ID)
+	}
+	filter := bson.M{"_id": bson.M{"$in": userIDs}}
+	if len(userIDs) == 0 {
+		filter = bson.M{"$or": []bson.M{
+			{"branch_id": branchID, "role": bson.M{"$gte": 2, "$lt": 100}},
+			{"memberships": bson.M{"$elemMatch": bson.M{"branch_id": branchID, "role": bson.M{"$gte": 2, "$lt": 100}}}},
+		}}
+	}
+
+	cursor, err = UserCollection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	emails := []string{}
+	for _, user := range users {
+		if user.Email != "" {
+			emails = append(emails, user.Email)
+		}
+	}
+	return emails, nil
+}
+
+// sendPreorderReminders queues emails to staff about every pre-order whose
+// reminder time has come. Each order is marked reminded in the same
+// transaction that queues its emails, so that several instances do not remind
+// twice.
+func sendPreorderReminders(ctx context.Context) error {
+	now := time.Now()
+	cursor, err := OrderCollection.Find(ctx, bson.M{
+		"remind_at":   bson.M{"$lte": now},
+		"reminded_at": bson.M{"$exists": false},
+		"status":      bson.M{"$in": []string{"000", "001"}},
+	})
+	if err != nil {
+		return err
+	}
+	var orders []models.Order
+	if err := cursor.All(ctx, &orders); err != nil {
+		return err
+	}
+
+	for _, order := range orders {
+		recipients, err := preorderReminderRecipients(ctx, order.Branch_ID)
+		if err != nil {
+			log.Printf("Error finding staff to remind of pre-order %s: %v", order.Order_ID, err)
+			continue
+		}
+
+		subject := fmt.Sprintf("Pre-order due at %s", order.Scheduled_For.In(time.Local).Format("15:04"))
+		body := fmt.Sprintf(
+			"A pre-order for <b>%s</b> (%s) with %d items is due at <b>%s</b>.<br>Order ID: %s",
+			html.EscapeString(order.Customer_Name), html.EscapeString(order.Customer_Phone), len(order.MenuItems),
+			order.Scheduled_For.In(time.Local).Format("2006-01-02 15:04"), order.Order_ID,
+		)
+		err = helpers.RunInTransaction(ctx, database.Client, func(ctx context.Context) error {
+			result, err := OrderCollection.UpdateOne(ctx,
+				bson.M{"_id": order.Order_ID, "reminded_at": bson.M{"$exists": false}},
+				bson.M{"$set": bson.M{"reminded_at": now}},
+			)
+			if err != nil || result.ModifiedCount == 0 {
+				return err
+			}
+			for _, email := range recipients {
+				if err := enqueueEmail(ctx, email, subject, body); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("Error queueing pre-order reminders for order %s: %v", order.Order_ID, err)
+		}
+	}
+	return nil
+}
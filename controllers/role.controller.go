@@ -0,0 +1,354 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	database "nano_food_api/database"
+	helpers "nano_food_api/helpers"
+	models "nano_food_api/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var RoleCollection *mongo.Collection = database.RoleCollection
+
+// validateRolePermissions rejects unknown permissions and permissions the caller
+// does not hold themselves, so custom roles cannot be used to escalate.
+func validateRolePermissions(permissions []string, granted []string) error {
+	for _, permission := range permissions {
+		if !helpers.IsValidPermission(permission) {
+			return fmt.Errorf("unknown permission %s", permission)
+		}
+		if !helpers.HasPermission(granted, permission) {
+			return fmt.Errorf("you cannot grant the %s permission", permission)
+		}
+	}
+	return nil
+}
+
+func GetPermissions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		userInfo, err := helpers.GetCurrentUser(c, UserCollection)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		granted, err := helpers.GetUserPermissions(ctx, RoleCollection, userInfo, c.GetString("branchId"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error loading permissions", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Permissions retrieved successfully",
+			"data": gin.H{
+				"permissions": helpers.AllPermissions,
+				"granted":     granted,
+			},
+		})
+	}
+}
+
+func GetBranchRoles() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		branchID := c.Param("branch_id")
+
+		if !authorizeBranch(c, branchID) {
+			return
+		}
+
+		filter := bson.M{"$or": bson.A{bson.M{"is_default": true}, bson.M{"branch_id": branchID}}}
+		cursor, err := RoleCollection.Find(ctx, filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving roles", "details": err.Error()})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		var roles []models.Role
+		if err := cursor.All(ctx, &roles); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error decoding roles", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Roles retrieved successfully", "data": roles})
+	}
+}
+
+func CreateRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var reqBody struct {
+			Branch_ID   string   `json:"branch_id" binding:"required"`
+			Name        string   `json:"name" binding:"required"`
+			Level       int      `json:"level"`
+			Permissions []string `json:"permissions"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		userInfo, err := helpers.GetCurrentUser(c, UserCollection)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		if !authorizeBranch(c, reqBody.Branch_ID) {
+			return
+		}
+
+		// Levels are judged by the caller's role in the active branch, not at home
+		scope, err := helpers.GetBranchScope(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		if !helpers.Contains(assignableRoleLevels(scope.Role), reqBody.Level) {
+			c.JSON(http.StatusForbidden, gin.H{"success": false, "error": "You are not authorized to create a role of this level"})
+			return
+		}
+
+		branchExists, err := helpers.CheckDataExist(ctx, BranchCollection, bson.M{"_id": reqBody.Branch_ID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to validate branch", "details": err.Error()})
+			return
+		}
+		if !branchExists {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid branch ID"})
+			return
+		}
+
+		granted, err := helpers.GetUserPermissions(ctx, RoleCollection, userInfo, c.GetString("branchId"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error loading permissions", "details": err.Error()})
+			return
+		}
+		if err := validateRolePermissions(reqBody.Permissions, granted); err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"success": false, "error": "Invalid permissions", "details": err.Error()})
+			return
+		}
+
+		role := models.Role{
+			Role_ID:     primitive.NewObjectID().Hex(),
+			Branch_ID:   reqBody.Branch_ID,
+			Name:        strings.TrimSpace(reqBody.Name),
+			Level:       reqBody.Level,
+			Permissions: reqBody.Permissions,
+			IsDefault:   false,
+			Created_At:  time.Now(),
+			Updated_At:  time.Now(),
+		}
+		if role.Permissions == nil {
+			role.Permissions = []string{}
+		}
+
+		_, err = RoleCollection.InsertOne(ctx, role)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error creating role", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"success": true, "message": "Role created successfully", "data": role})
+	}
+}
+
+// UpdateRole changes the name or permissions of a custom role. Default roles can
+// only be changed by root admins and keep their level.
+func UpdateRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		roleID := c.Param("role_id")
+
+		var reqBody struct {
+			Name        *string  `json:"name"`
+			Permissions []string `json:"permissions"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		var role models.Role
+		err := RoleCollection.FindOne(ctx, bson.M{"_id": roleID}).Decode(&role)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Role not found", "details": err.Error()})
+			return
+		}
+
+		userInfo, err := helpers.GetCurrentUser(c, UserCollection)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		// Default roles have no branch, so only root admins pass this check for them
+		if !authorizeBranch(c, role.Branch_ID) {
+			return
+		}
+
+		updateFields := bson.M{"updated_at": time.Now()}
+		if reqBody.Name != nil {
+			updateFields["name"] = strings.TrimSpace(*reqBody.Name)
+		}
+		if reqBody.Permissions != nil {
+			granted, err := helpers.GetUserPermissions(ctx, RoleCollection, userInfo, c.GetString("branchId"))
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error loading permissions", "details": err.Error()})
+				return
+			}
+			if err := validateRolePermissions(reqBody.Permissions, granted); err != nil {
+				c.JSON(http.StatusForbidden, gin.H{"success": false, "error": "Invalid permissions", "details": err.Error()})
+				return
+			}
+			updateFields["permissions"] = reqBody.Permissions
+		}
+
+		_, err = RoleCollection.UpdateOne(ctx, bson.M{"_id": roleID}, bson.M{"$set": updateFields})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error updating role", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Role updated successfully"})
+	}
+}
+
+// DeleteRole removes a custom role. Users assigned to it fall back to the default
+// role of their level.
+func DeleteRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		roleID := c.Param("role_id")
+
+		var role models.Role
+		err := RoleCollection.FindOne(ctx, bson.M{"_id": roleID}).Decode(&role)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Role not found", "details": err.Error()})
+			return
+		}
+
+		if role.IsDefault {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Default roles cannot be deleted"})
+			return
+		}
+
+		if !authorizeBranch(c, role.Branch_ID) {
+			return
+		}
+
+		_, err = RoleCollection.DeleteOne(ctx, bson.M{"_id": roleID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error deleting role", "details": err.Error()})
+			return
+		}
+
+		_, err = UserCollection.UpdateMany(
+			ctx,
+			bson.M{"role_id": roleID},
+			bson.M{"$unset": bson.M{"role_id": ""}, "$set": bson.M{"updated_at": time.Now()}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error unassigning role", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Role deleted successfully"})
+	}
+}
+
+// AssignUserRole gives a user a custom role of their branch, or puts them back on
+// the default role of their level when role_id is empty.
+func AssignUserRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var reqBody struct {
+			User_ID string `json:"user_id" binding:"required"`
+			Role_ID string `json:"role_id"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		var user models.User
+		err := UserCollection.FindOne(ctx, bson.M{"_id": reqBody.User_ID}).Decode(&user)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "User not found", "details": err.Error()})
+			return
+		}
+
+		if !authorizeBranch(c, user.Branch_ID) {
+			return
+		}
+
+		scope, err := helpers.GetBranchScope(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		if !helpers.Contains(assignableRoleLevels(scope.Role), user.Role) {
+			c.JSON(http.StatusForbidden, gin.H{"success": false, "error": "You are not authorized to change this user's role"})
+			return
+		}
+
+		newLevel := user.Role
+		update := bson.M{"$unset": bson.M{"role_id": ""}, "$set": bson.M{"updated_at": time.Now()}}
+		if reqBody.Role_ID != "" {
+			var role models.Role
+			err := RoleCollection.FindOne(ctx, bson.M{"_id": reqBody.Role_ID, "branch_id": user.Branch_ID, "is_default": false}).Decode(&role)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Role not found in the user's branch"})
+				return
+			}
+
+			if !helpers.Contains(assignableRoleLevels(scope.Role), role.Level) {
+				c.JSON(http.StatusForbidden, gin.H{"success": false, "error": "You are not authorized to assign this role"})
+				return
+			}
+
+			newLevel = role.Level
+			update = bson.M{"$set": bson.M{"role_id": role.Role_ID, "role": role.Level, "updated_at": time.Now()}}
+		}
+
+		_, err = UserCollection.UpdateOne(ctx, bson.M{"_id": reqBody.User_ID}, update)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error assigning role", "details": err.Error()})
+			return
+		}
+
+		// The role level is part of the access token
+		if newLevel != user.Role {
+			if err := helpers.RevokeUserSessions(ctx, SessionCollection, user.User_ID, ""); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error revoking sessions", "details": err.Error()})
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Role assigned successfully"})
+	}
+}
@@ -2,16 +2,20 @@ package controllers
 
 import (
 	"context"
+	"fmt"
+	"log"
 	"nano_food_api/database"
 	"nano_food_api/helpers"
 	"nano_food_api/models"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var SaleCollection *mongo.Collection = database.SaleCollection
@@ -27,6 +31,10 @@ func CreateSale() gin.HandlerFunc {
 			return
 		}
 
+		if !authorizeBranch(c, sale.Branch_ID) {
+			return
+		}
+
 		// Validate Branch ID
 		branchExists, err := helpers.CheckDataExist(ctx, database.BranchCollection, bson.M{"_id": sale.Branch_ID})
 		if err != nil {
@@ -38,21 +46,100 @@ func CreateSale() gin.HandlerFunc {
 			return
 		}
 
-		// Validate Table ID
-		tableExists, err := helpers.CheckDataExist(ctx, database.TableCollection, bson.M{"_id": sale.Table_ID})
+		if len(sale.OrderIDs) == 0 && len(sale.GiftCard_Items) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "A sale needs orders or gift cards"})
+			return
+		}
+
+		// Validate Table ID, takeaway and delivery orders and gift cards are
+		// paid for without one
+		if sale.Table_ID != "" {
+			tableExists, err := helpers.CheckDataExist(ctx, database.TableCollection, bson.M{"_id": sale.Table_ID, "branch_id": sale.Branch_ID})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to validate table", "details": err.Error()})
+				return
+			}
+			if !tableExists {
+				c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid table ID"})
+				return
+			}
+		}
+
+		for _, item := range sale.GiftCard_Items {
+			if item.Amount <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Gift card amounts must be positive"})
+				return
+			}
+			if !validateCustomerID(ctx, c, item.Customer_ID) {
+				return
+			}
+		}
+		walletPayment := false
+		for _, payment := range sale.GiftCard_Payments {
+			if payment.Amount <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Gift card payments must be positive"})
+				return
+			}
+			if payment.Code == "" {
+				if payment.GiftCard_ID == "" || sale.Customer_ID == "" {
+					c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Gift card payments need a code, or a card in the customer's wallet"})
+					return
+				}
+				walletPayment = true
+			}
+		}
+
+		if sale.Tip < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Tip cannot be negative"})
+			return
+		}
+
+		if !validateCustomerID(ctx, c, sale.Customer_ID) {
+			return
+		}
+
+		// Cards in a wallet are spent with the code the customer was just sent,
+		// so a cashier cannot pick any customer and spend their cards
+		if walletPayment && !verifyCustomerCode(ctx, c, sale.Customer_ID, sale.Customer_Code) {
+			return
+		}
+
+		if sale.Points_Redeemed < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Redeemed points cannot be negative"})
+			return
+		}
+		loyaltySettings, err := loadLoyaltySettings(ctx)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to validate table", "details": err.Error()})
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving loyalty settings", "details": err.Error()})
 			return
 		}
-		if !tableExists {
-			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid table ID"})
+		if sale.Points_Redeemed > 0 && !loyaltySettings.Enabled {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "The loyalty programme is not enabled"})
+			return
+		}
+
+		sale.Cashier_ID, err = helpers.GetUserIDFromMdw(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
 			return
 		}
 
-		// Update order statuses and calculate total amounts
+		// Steps that mark orders paid or move points or gift card balances
+		// register how to undo them, in case the sale cannot be created after all
+		undo := []func(){}
+		rollback := func() {
+			for i := len(undo) - 1; i >= 0; i-- {
+				undo[i]()
+			}
+		}
+
+		// Update order statuses and calculate total amounts. Only unpaid orders
+		// are matched, so an order cannot be sold twice.
 		totalAmount := 0.0
+		sale.Server_IDs = []string{}
+		orderCustomers := []string{}
 		for _, orderID := range sale.OrderIDs {
-			filter := bson.M{"_id": orderID}
+			filter := bson.M{"_id": orderID, "branch_id": sale.Branch_ID, "is_paid": false}
 			update := bson.M{
 				"$set": bson.M{
 					"status":  "003", // Completed
@@ -61,29 +148,351 @@ func CreateSale() gin.HandlerFunc {
 			}
 			order := models.Order{}
 			err := OrderCollection.FindOneAndUpdate(ctx, filter, update).Decode(&order)
+			if err == mongo.ErrNoDocuments {
+				rollback()
+				c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Order not found or already paid", "details": orderID})
+				return
+			}
 			if err != nil {
+				rollback()
 				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to update order status", "details": err.Error()})
 				return
 			}
+
+			previousStatus := order.Status
+			undo = append(undo, func() {
+				if _, err := OrderCollection.UpdateOne(
+					ctx,
+					bson.M{"_id": orderID, "is_paid": true},
+					bson.M{"$set": bson.M{"status": previousStatus, "is_paid": false}},
+				); err != nil {
+					log.Printf("Error reopening order %s of failed sale: %v", orderID, err)
+				}
+			})
+
 			totalAmount += order.TotalAmount
+			if order.Server_ID != "" && !helpers.ContainsString(sale.Server_IDs, order.Server_ID) {
+				sale.Server_IDs = append(sale.Server_IDs, order.Server_ID)
+			}
+			if order.Customer_ID != "" && !helpers.ContainsString(orderCustomers, order.Customer_ID) {
+				orderCustomers = append(orderCustomers, order.Customer_ID)
+			}
+		}
+
+		// A sale of orders that all belong to one customer belongs to them too
+		if sale.Customer_ID == "" && len(orderCustomers) == 1 {
+			sale.Customer_ID = orderCustomers[0]
 		}
 
 		// Assign IDs and calculate GrandTotal
 		sale.Sale_ID = primitive.NewObjectID().Hex()
 		sale.TotalAmount = totalAmount
 		sale.GrandTotal = totalAmount - sale.Discount + sale.Tax
+		sale.Loyalty_Discount = 0
+		sale.Points_Earned = 0
+		sale.IsRefunded = false
+		sale.Refund_Reason = ""
+		sale.Refunded_By = ""
+		sale.Refunded_At = time.Time{}
 		sale.Created_At = time.Now()
 
-		result, err := SaleCollection.InsertOne(ctx, sale)
+		// Redeemed points are taken off the bill like a discount
+		if sale.Points_Redeemed > 0 {
+			if sale.Customer_ID == "" {
+				rollback()
+				c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "A customer is required to redeem points"})
+				return
+			}
+			sale.Loyalty_Discount, err = helpers.RedemptionValue(loyaltySettings, sale.Points_Redeemed, sale.GrandTotal)
+			if err != nil {
+				rollback()
+				c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+				return
+			}
+			err = debitPoints(ctx, models.LoyaltyTransaction{
+				Customer_ID: sale.Customer_ID,
+				Branch_ID:   sale.Branch_ID,
+				Sale_ID:     sale.Sale_ID,
+				Type:        helpers.LoyaltyRedeemed,
+				Points:      sale.Points_Redeemed,
+				Created_By:  sale.Cashier_ID,
+			})
+			if err == errInsufficientPoints {
+				rollback()
+				c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "The customer does not have enough points"})
+				return
+			}
+			if err != nil {
+				rollback()
+				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error redeeming loyalty points", "details": err.Error()})
+				return
+			}
+			sale.GrandTotal -= sale.Loyalty_Discount
+
+			undo = append(undo, func() {
+				if err := creditPoints(ctx, loyaltySettings, models.LoyaltyTransaction{
+					Customer_ID: sale.Customer_ID,
+					Branch_ID:   sale.Branch_ID,
+					Sale_ID:     sale.Sale_ID,
+					Type:        helpers.LoyaltyReversed,
+					Points:      sale.Points_Redeemed,
+					Note:        "Sale could not be created",
+					Created_By:  sale.Cashier_ID,
+				}); err != nil {
+					log.Printf("Error giving back points redeemed on failed sale %s: %v", sale.Sale_ID, err)
+				}
+			})
+		}
+
+		// Points are earned on what the customer paid for their orders, tip and
+		// gift cards bought excluded
+		var customer models.Customer
+		if sale.Customer_ID != "" {
+			if err := CustomerCollection.FindOne(ctx, bson.M{"_id": sale.Customer_ID}).Decode(&customer); err == nil {
+				sale.Points_Earned = helpers.EarnedPoints(loyaltySettings, customer.Lifetime_Points, sale.GrandTotal)
+			}
+		}
+
+		for _, item := range sale.GiftCard_Items {
+			sale.GrandTotal += helpers.RoundAmount(item.Amount)
+		}
+
+		// Gift card payments are taken off their cards straight away, partly
+		// paying the bill; the rest is paid by PaymentMethod
+		giftCardPaid := 0.0
+		for i := range sale.GiftCard_Payments {
+			payment := &sale.GiftCard_Payments[i]
+			payment.Amount = helpers.RoundAmount(payment.Amount)
+
+			var card models.GiftCard
+			if payment.Code != "" {
+				card, err = findGiftCardByCode(ctx, payment.Code)
+			} else {
+				err = GiftCardCollection.FindOne(ctx, bson.M{"_id": payment.GiftCard_ID, "customer_id": sale.Customer_ID}).Decode(&card)
+			}
+			if err != nil {
+				rollback()
+				c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Gift card not found"})
+				return
+			}
+
+			giftCardPaid += payment.Amount
+			if giftCardPaid > sale.GrandTotal+0.005 {
+				rollback()
+				c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Gift card payments exceed the bill"})
+				return
+			}
+
+			card, err = changeGiftCardBalance(ctx, card.GiftCard_ID, -payment.Amount, models.GiftCardTransaction{
+				Branch_ID:  sale.Branch_ID,
+				Sale_ID:    sale.Sale_ID,
+				Type:       helpers.GiftCardRedeemed,
+				Created_By: sale.Cashier_ID,
+			})
+			if err == errInsufficientGiftCardBalance {
+				rollback()
+				c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": fmt.Sprintf("Gift card ending in %s is disabled or its balance is too low", card.Code_Last4)})
+				return
+			}
+			if err != nil {
+				rollback()
+				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error redeeming gift card", "details": err.Error()})
+				return
+			}
+			payment.GiftCard_ID = card.GiftCard_ID
+			payment.Code_Last4 = card.Code_Last4
+
+			amount := payment.Amount
+			undo = append(undo, func() {
+				if _, err := changeGiftCardBalance(ctx, card.GiftCard_ID, amount, models.GiftCardTransaction{
+					Branch_ID:  sale.Branch_ID,
+					Sale_ID:    sale.Sale_ID,
+					Type:       helpers.GiftCardReturned,
+					Note:       "Sale could not be created",
+					Created_By: sale.Cashier_ID,
+				}); err != nil {
+					log.Printf("Error giving back gift card payment of failed sale %s: %v", sale.Sale_ID, err)
+				}
+			})
+		}
+
+		// Issue the gift cards sold, or load the existing cards given by code
+		issuedGiftCards := []gin.H{}
+		for i := range sale.GiftCard_Items {
+			item := &sale.GiftCard_Items[i]
+			item.Amount = helpers.RoundAmount(item.Amount)
+
+			if item.Code != "" {
+				card, err := findGiftCardByCode(ctx, item.Code)
+				if err != nil {
+					rollback()
+					c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Gift card to load not found"})
+					return
+				}
+				card, err = changeGiftCardBalance(ctx, card.GiftCard_ID, item.Amount, models.GiftCardTransaction{
+					Branch_ID:  sale.Branch_ID,
+					Sale_ID:    sale.Sale_ID,
+					Type:       helpers.GiftCardLoaded,
+					Created_By: sale.Cashier_ID,
+				})
+				if err == errInsufficientGiftCardBalance {
+					rollback()
+					c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "A disabled gift card cannot be loaded"})
+					return
+				}
+				if err != nil {
+					rollback()
+					c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error loading gift card", "details": err.Error()})
+					return
+				}
+				item.GiftCard_ID = card.GiftCard_ID
+				item.Code_Last4 = card.Code_Last4
+
+				amount := item.Amount
+				undo = append(undo, func() {
+					if _, err := changeGiftCardBalance(ctx, card.GiftCard_ID, -amount, models.GiftCardTransaction{
+						Branch_ID:  sale.Branch_ID,
+						Sale_ID:    sale.Sale_ID,
+						Type:       helpers.GiftCardVoided,
+						Note:       "Sale could not be created",
+						Created_By: sale.Cashier_ID,
+					}); err != nil {
+						log.Printf("Error taking back gift card load of failed sale %s: %v", sale.Sale_ID, err)
+					}
+				})
+				continue
+			}
+
+			card, code, err := issueGiftCard(ctx, models.GiftCard{
+				Branch_ID:   sale.Branch_ID,
+				Customer_ID: item.Customer_ID,
+				Sale_ID:     sale.Sale_ID,
+				Created_By:  sale.Cashier_ID,
+			}, item.Amount)
+			if err != nil {
+				rollback()
+				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error issuing gift card", "details": err.Error()})
+				return
+			}
+			item.GiftCard_ID = card.GiftCard_ID
+			item.Code_Last4 = card.Code_Last4
+			issuedGiftCards = append(issuedGiftCards, gin.H{"gift_card_id": card.GiftCard_ID, "code": code, "balance": card.Balance})
+
+			undo = append(undo, func() {
+				if _, err := GiftCardCollection.UpdateOne(ctx, bson.M{"_id": card.GiftCard_ID}, bson.M{"$set": bson.M{"status": helpers.GiftCardDisabled, "balance": 0.0}}); err != nil {
+					log.Printf("Error voiding gift card of failed sale %s: %v", sale.Sale_ID, err)
+				}
+			})
+		}
+
+		var result *mongo.InsertOneResult
+		err = helpers.RunInTransaction(ctx, database.Client, func(ctx context.Context) error {
+			var err error
+			if result, err = SaleCollection.InsertOne(ctx, sale); err != nil {
+				return err
+			}
+			return publishWebhookEvent(ctx, sale.Branch_ID, helpers.WebhookSaleCreated, webhookSale(sale))
+		})
 		if err != nil {
+			rollback()
 			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error creating sale", "details": err.Error()})
 			return
 		}
 
+		if sale.Points_Earned > 0 {
+			if err := creditPoints(ctx, loyaltySettings, models.LoyaltyTransaction{
+				Customer_ID: sale.Customer_ID,
+				Branch_ID:   sale.Branch_ID,
+				Sale_ID:     sale.Sale_ID,
+				Type:        helpers.LoyaltyEarned,
+				Points:      sale.Points_Earned,
+				Created_By:  sale.Cashier_ID,
+			}); err != nil {
+				log.Printf("Error awarding loyalty points for sale %s: %v", sale.Sale_ID, err)
+			}
+		}
+
+		if len(issuedGiftCards) > 0 {
+			// The codes of new cards cannot be looked up again, print them now
+			c.JSON(http.StatusCreated, gin.H{"success": true, "message": "Sale created successfully", "data": result, "gift_cards": issuedGiftCards})
+			return
+		}
 		c.JSON(http.StatusCreated, gin.H{"success": true, "message": "Sale created successfully", "data": result})
 	}
 }
 
+// RefundSale marks a sale as refunded, reverses the loyalty points earned and
+// redeemed on it, returns its gift card payments and voids the gift cards sold.
+// A sale can only be refunded once.
+func RefundSale() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		saleID := c.Param("sale_id")
+
+		var reqBody struct {
+			Reason string `json:"reason"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid request body", "details": err.Error()})
+			return
+		}
+		if strings.TrimSpace(reqBody.Reason) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Refund reason is required"})
+			return
+		}
+
+		userID, err := helpers.GetUserIDFromMdw(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		filter, ok := scopedFilter(c, bson.M{"_id": saleID})
+		if !ok {
+			return
+		}
+		filter["is_refunded"] = bson.M{"$ne": true}
+
+		update := bson.M{"$set": bson.M{
+			"is_refunded":   true,
+			"refund_reason": strings.TrimSpace(reqBody.Reason),
+			"refunded_by":   userID,
+			"refunded_at":   time.Now(),
+		}}
+		var sale models.Sale
+		err = helpers.RunInTransaction(ctx, database.Client, func(ctx context.Context) error {
+			err := SaleCollection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&sale)
+			if err != nil {
+				return err
+			}
+			return publishWebhookEvent(ctx, sale.Branch_ID, helpers.WebhookSaleRefunded, sale)
+		})
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Sale not found or already refunded"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error refunding sale", "details": err.Error()})
+			return
+		}
+
+		settings, err := loadLoyaltySettings(ctx)
+		if err == nil {
+			err = reverseSalePoints(ctx, settings, sale, userID)
+		}
+		if err != nil {
+			log.Printf("Error reversing loyalty points of sale %s: %v", sale.Sale_ID, err)
+		}
+		if err := reverseSaleGiftCards(ctx, sale, userID); err != nil {
+			log.Printf("Error reversing gift cards of sale %s: %v", sale.Sale_ID, err)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Sale refunded successfully", "data": sale})
+	}
+}
+
 func DeleteSale() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
@@ -91,11 +500,20 @@ func DeleteSale() gin.HandlerFunc {
 
 		saleID := c.Param("sale_id")
 
-		_, err := SaleCollection.DeleteOne(ctx, bson.M{"_id": saleID})
+		filter, ok := scopedFilter(c, bson.M{"_id": saleID})
+		if !ok {
+			return
+		}
+
+		result, err := SaleCollection.DeleteOne(ctx, filter)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error deleting sale", "details": err.Error()})
 			return
 		}
+		if result.DeletedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Sale not found"})
+			return
+		}
 
 		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Sale deleted successfully"})
 	}
@@ -117,6 +535,16 @@ func GetAllSales() gin.HandlerFunc {
 			filter["table_id"] = tableID
 		}
 
+		var ok bool
+		if c.Query("all_branches") == "true" {
+			filter, ok = aggregateFilter(ctx, c, filter, helpers.PermSaleView)
+		} else {
+			filter, ok = scopedFilter(c, filter)
+		}
+		if !ok {
+			return
+		}
+
 		// MongoDB aggregation pipeline
 		pipeline := mongo.Pipeline{
 			{{Key: "$match", Value: filter}},
@@ -153,9 +581,14 @@ func GetOneSale() gin.HandlerFunc {
 
 		saleID := c.Param("sale_id")
 
+		filter, ok := scopedFilter(c, bson.M{"_id": saleID})
+		if !ok {
+			return
+		}
+
 		// MongoDB aggregation pipeline
 		pipeline := mongo.Pipeline{
-			{{Key: "$match", Value: bson.M{"_id": saleID}}},
+			{{Key: "$match", Value: filter}},
 			{{Key: "$lookup", Value: bson.M{
 				"from":         "tables",
 				"localField":   "table_id",
@@ -0,0 +1,307 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	helpers "nano_food_api/helpers"
+	models "nano_food_api/models"
+	token "nano_food_api/tokens"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RefreshToken exchanges a refresh token for a new access token and rotates the
+// refresh token. Presenting an already rotated refresh token revokes the session.
+func RefreshToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var reqBody struct {
+			RefreshToken string `json:"refresh_token" binding:"required"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		tokenHash := helpers.HashToken(reqBody.RefreshToken)
+
+		var session models.Session
+		err := SessionCollection.FindOne(ctx, bson.M{"refresh_token": tokenHash}).Decode(&session)
+		if err == mongo.ErrNoDocuments {
+			// A rotated token showing up again means it was stolen: kill the session
+			reuseResult, reuseErr := SessionCollection.UpdateOne(
+				ctx,
+				bson.M{"previous_token": tokenHash},
+				bson.M{"$set": bson.M{"is_revoked": true, "updated_at": time.Now()}},
+			)
+			if reuseErr == nil && reuseResult.MatchedCount > 0 {
+				log.Printf("Refresh token reuse detected, session revoked")
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": "Invalid refresh token"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving session", "details": err.Error()})
+			return
+		}
+
+		if session.IsRevoked || session.Expires_At.Before(time.Now()) {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": "Session has expired, please login again"})
+			return
+		}
+
+		var user models.User
+		err = UserCollection.FindOne(ctx, bson.M{"_id": session.User_ID}).Decode(&user)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": "User not found", "details": err.Error()})
+			return
+		}
+
+		newRefreshToken, err := helpers.GenerateRandomToken(32)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error generating token", "details": err.Error()})
+			return
+		}
+
+		// Match on the old hash so two concurrent refreshes cannot both succeed
+		result, err := SessionCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": session.Session_ID, "refresh_token": tokenHash, "is_revoked": false},
+			bson.M{"$set": bson.M{
+				"refresh_token":  helpers.HashToken(newRefreshToken),
+				"previous_token": tokenHash,
+				"expires_at":     token.RefreshTokenExpiry(),
+				"ip":             c.ClientIP(),
+				"last_seen_at":   time.Now(),
+				"updated_at":     time.Now(),
+			}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error rotating refresh token", "details": err.Error()})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": "Invalid refresh token"})
+			return
+		}
+
+		// Stay on the branch the session switched to while the user still belongs to it
+		membership, ok := helpers.MembershipFor(user, session.Branch_ID)
+		if !ok {
+			membership, _ = helpers.MembershipFor(user, "")
+		}
+
+		accessToken, err := token.TokenGenerator(user.Email, user.User_ID, membership.Role, membership.Branch_ID, session.Session_ID)
+		if err != nil {
+			log.Printf("Error generating token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error generating token", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success":      true,
+			"message":      "Token refreshed successfully",
+			"accessToken":  accessToken,
+			"refreshToken": newRefreshToken,
+		})
+	}
+}
+
+func Logout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		sessionID, err := helpers.GetSessionIDFromMdw(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		_, err = SessionCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": sessionID},
+			bson.M{"$set": bson.M{"is_revoked": true, "updated_at": time.Now()}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error logging out", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Logged out successfully"})
+	}
+}
+
+func LogoutAllDevices() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		user_id, err := helpers.GetUserIDFromMdw(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		if err := helpers.RevokeUserSessions(ctx, SessionCollection, user_id, ""); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error logging out", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Logged out from all devices successfully"})
+	}
+}
+
+// GetMySessions lists the devices the current user is logged in on.
+func GetMySessions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		user_id, err := helpers.GetUserIDFromMdw(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		sessionID, _ := helpers.GetSessionIDFromMdw(c)
+
+		filter := bson.M{"user_id": user_id, "is_revoked": false, "expires_at": bson.M{"$gt": time.Now()}}
+		opts := options.Find().SetSort(bson.M{"last_seen_at": -1})
+
+		cursor, err := SessionCollection.Find(ctx, filter, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving sessions", "details": err.Error()})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		var sessions []models.Session
+		if err := cursor.All(ctx, &sessions); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error decoding sessions", "details": err.Error()})
+			return
+		}
+
+		data := make([]gin.H, 0, len(sessions))
+		for _, session := range sessions {
+			data = append(data, gin.H{
+				"_id":          session.Session_ID,
+				"device_type":  session.DeviceType,
+				"os":           session.OS,
+				"browser":      session.Browser,
+				"ip":           session.IP,
+				"created_at":   session.Created_At,
+				"last_seen_at": session.Last_Seen_At,
+				"is_current":   session.Session_ID == sessionID,
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Sessions retrieved successfully", "data": data})
+	}
+}
+
+func RevokeMySession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		user_id, err := helpers.GetUserIDFromMdw(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		sessionID := c.Param("session_id")
+
+		result, err := SessionCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": sessionID, "user_id": user_id},
+			bson.M{"$set": bson.M{"is_revoked": true, "updated_at": time.Now()}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error revoking session", "details": err.Error()})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Session not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Session revoked successfully"})
+	}
+}
+
+// GetBranchSessions lets managers see which staff of their branch are logged in and where.
+func GetBranchSessions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		branchID := c.Param("branch_id")
+
+		var branch models.Branch
+		err := BranchCollection.FindOne(ctx, bson.M{"_id": branchID}).Decode(&branch)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Branch not found", "details": err.Error()})
+			return
+		}
+
+		if !authorizeBranch(c, branch.Branch_ID) {
+			return
+		}
+
+		pipeline := mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{"is_revoked": false, "expires_at": bson.M{"$gt": time.Now()}}}},
+			{{Key: "$lookup", Value: bson.M{
+				"from":         "users",
+				"localField":   "user_id",
+				"foreignField": "_id",
+				"as":           "user",
+			}}},
+			{{Key: "$unwind", Value: "$user"}},
+			{{Key: "$match", Value: bson.M{"$or": []bson.M{
+				{"user.branch_id": branchID},
+				{"user.memberships.branch_id": branchID},
+			}}}},
+			{{Key: "$project", Value: bson.M{
+				"user_id":      1,
+				"terminal_id":  1,
+				"branch_id":    1,
+				"device_type":  1,
+				"os":           1,
+				"browser":      1,
+				"ip":           1,
+				"expires_at":   1,
+				"last_seen_at": 1,
+				"created_at":   1,
+				"user._id":     1,
+				"user.name":    1,
+				"user.email":   1,
+				"user.role":    1,
+			}}},
+			{{Key: "$sort", Value: bson.M{"last_seen_at": -1}}},
+		}
+
+		cursor, err := SessionCollection.Aggregate(ctx, pipeline)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving sessions", "details": err.Error()})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		var sessions []bson.M
+		if err := cursor.All(ctx, &sessions); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error decoding sessions", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Sessions retrieved successfully", "data": sessions})
+	}
+}
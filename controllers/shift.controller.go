@@ -0,0 +1,439 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	database "nano_food_api/database"
+	helpers "nano_food_api/helpers"
+	models "nano_food_api/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ShiftCollection *mongo.Collection = database.ShiftCollection
+
+// findShifts returns the shifts matching the filter that start within [from, to).
+func findShifts(ctx context.Context, filter bson.M, from time.Time, to time.Time) ([]models.Shift, error) {
+	filter["start_at"] = bson.M{"$gte": from, "$lt": to}
+
+	cursor, err := ShiftCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"start_at": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	shifts := []models.Shift{}
+	if err := cursor.All(ctx, &shifts); err != nil {
+		return nil, err
+	}
+	return shifts, nil
+}
+
+// parseWeek returns the Monday-to-Monday week containing a YYYY-MM-DD date,
+// this week by default.
+func parseWeek(date string) (time.Time, time.Time, error) {
+	day, _, err := parseDateRange(date, date)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	offset := (int(day.Weekday()) + 6) % 7
+	start := day.AddDate(0, 0, -offset)
+	return start, start.AddDate(0, 0, 7), nil
+}
+
+// validateShift checks that a shift is well formed, that the user belongs to its
+// branch and that it does not overlap another of their shifts.
+func validateShift(ctx context.Context, c *gin.Context, shift models.Shift) bool {
+	if !shift.End_At.After(shift.Start_At) {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Shift must end after it starts"})
+		return false
+	}
+	if shift.End_At.Sub(shift.Start_At) > 24*time.Hour {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Shift cannot be longer than 24 hours"})
+		return false
+	}
+
+	var user models.User
+	err := UserCollection.FindOne(ctx, bson.M{"_id": shift.User_ID}).Decode(&user)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "User not found", "details": err.Error()})
+		return false
+	}
+	if _, ok := helpers.MembershipFor(user, shift.Branch_ID); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "User is not a member of this branch"})
+		return false
+	}
+
+	overlaps, err := helpers.CheckDataExist(ctx, ShiftCollection, bson.M{
+		"_id":      bson.M{"$ne": shift.Shift_ID},
+		"user_id":  shift.User_ID,
+		"start_at": bson.M{"$lt": shift.End_At},
+		"end_at":   bson.M{"$gt": shift.Start_At},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving shifts", "details": err.Error()})
+		return false
+	}
+	if overlaps {
+		c.JSON(http.StatusConflict, gin.H{"success": false, "error": "User already has a shift at this time"})
+		return false
+	}
+	return true
+}
+
+func CreateShift() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var reqBody struct {
+			Branch_ID string    `json:"branch_id" binding:"required"`
+			User_ID   string    `json:"user_id" binding:"required"`
+			Start_At  time.Time `json:"start_at" binding:"required"`
+			End_At    time.Time `json:"end_at" binding:"required"`
+			Note      string    `json:"note"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		if !authorizeBranch(c, reqBody.Branch_ID) {
+			return
+		}
+
+		createdBy, err := helpers.GetUserIDFromMdw(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		shift := models.Shift{
+			Shift_ID:   primitive.NewObjectID().Hex(),
+			Branch_ID:  reqBody.Branch_ID,
+			User_ID:    reqBody.User_ID,
+			Start_At:   reqBody.Start_At,
+			End_At:     reqBody.End_At,
+			Note:       reqBody.Note,
+			Created_By: createdBy,
+			Created_At: time.Now(),
+			Updated_At: time.Now(),
+		}
+
+		if !validateShift(ctx, c, shift) {
+			return
+		}
+
+		_, err = ShiftCollection.InsertOne(ctx, shift)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error creating shift", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"success": true, "message": "Shift created successfully", "data": shift})
+	}
+}
+
+func UpdateShift() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		shiftID := c.Param("shift_id")
+
+		var reqBody struct {
+			User_ID  string     `json:"user_id"`
+			Start_At *time.Time `json:"start_at"`
+			End_At   *time.Time `json:"end_at"`
+			Note     *string    `json:"note"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		var shift models.Shift
+		err := ShiftCollection.FindOne(ctx, bson.M{"_id": shiftID}).Decode(&shift)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Shift not found", "details": err.Error()})
+			return
+		}
+
+		if !authorizeBranch(c, shift.Branch_ID) {
+			return
+		}
+
+		if reqBody.User_ID != "" {
+			shift.User_ID = reqBody.User_ID
+		}
+		if reqBody.Start_At != nil {
+			shift.Start_At = *reqBody.Start_At
+		}
+		if reqBody.End_At != nil {
+			shift.End_At = *reqBody.End_At
+		}
+		if reqBody.Note != nil {
+			shift.Note = *reqBody.Note
+		}
+		shift.Updated_At = time.Now()
+
+		if !validateShift(ctx, c, shift) {
+			return
+		}
+
+		_, err = ShiftCollection.ReplaceOne(ctx, bson.M{"_id": shift.Shift_ID}, shift)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error updating shift", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Shift updated successfully", "data": shift})
+	}
+}
+
+func DeleteShift() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		shiftID := c.Param("shift_id")
+
+		var shift models.Shift
+		err := ShiftCollection.FindOne(ctx, bson.M{"_id": shiftID}).Decode(&shift)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Shift not found", "details": err.Error()})
+			return
+		}
+
+		if !authorizeBranch(c, shift.Branch_ID) {
+			return
+		}
+
+		_, err = ShiftCollection.DeleteOne(ctx, bson.M{"_id": shiftID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error deleting shift", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Shift deleted successfully"})
+	}
+}
+
+// GetBranchRoster returns the shifts of the week containing ?week=YYYY-MM-DD,
+// grouped by day starting Monday.
+func GetBranchRoster() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		branchID := c.Param("branch_id")
+
+		if !authorizeBranch(c, branchID) {
+			return
+		}
+
+		weekStart, weekEnd, err := parseWeek(c.Query("week"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid date format, use YYYY-MM-DD", "details": err.Error()})
+			return
+		}
+
+		shifts, err := findShifts(ctx, bson.M{"branch_id": branchID}, weekStart, weekEnd)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving shifts", "details": err.Error()})
+			return
+		}
+
+		days := []gin.H{}
+		for day := weekStart; day.Before(weekEnd); day = day.AddDate(0, 0, 1) {
+			dayShifts := []models.Shift{}
+			for _, shift := range shifts {
+				if !shift.Start_At.Before(day) && shift.Start_At.Before(day.AddDate(0, 0, 1)) {
+					dayShifts = append(dayShifts, shift)
+				}
+			}
+			days = append(days, gin.H{"date": day.Format("2006-01-02"), "shifts": dayShifts})
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success":    true,
+			"message":    "Roster retrieved successfully",
+			"week_start": weekStart.Format("2006-01-02"),
+			"data":       days,
+		})
+	}
+}
+
+// CopyBranchRoster copies the shifts of one week into another, shifted by the
+// number of days between them. Shifts that would overlap existing ones are skipped.
+func CopyBranchRoster() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var reqBody struct {
+			Branch_ID string `json:"branch_id" binding:"required"`
+			From_Week string `json:"from_week" binding:"required"`
+			To_Week   string `json:"to_week" binding:"required"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		if !authorizeBranch(c, reqBody.Branch_ID) {
+			return
+		}
+
+		fromStart, fromEnd, err := parseWeek(reqBody.From_Week)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid date format, use YYYY-MM-DD", "details": err.Error()})
+			return
+		}
+		toStart, _, err := parseWeek(reqBody.To_Week)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid date format, use YYYY-MM-DD", "details": err.Error()})
+			return
+		}
+		if fromStart.Equal(toStart) {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Source and target weeks must differ"})
+			return
+		}
+
+		createdBy, err := helpers.GetUserIDFromMdw(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		shifts, err := findShifts(ctx, bson.M{"branch_id": reqBody.Branch_ID}, fromStart, fromEnd)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving shifts", "details": err.Error()})
+			return
+		}
+
+		days := int(toStart.Sub(fromStart).Hours()/24 + 0.5)
+		copied := []models.Shift{}
+		skipped := 0
+		for _, shift := range shifts {
+			newShift := models.Shift{
+				Shift_ID:   primitive.NewObjectID().Hex(),
+				Branch_ID:  shift.Branch_ID,
+				User_ID:    shift.User_ID,
+				Start_At:   shift.Start_At.AddDate(0, 0, days),
+				End_At:     shift.End_At.AddDate(0, 0, days),
+				Note:       shift.Note,
+				Created_By: createdBy,
+				Created_At: time.Now(),
+				Updated_At: time.Now(),
+			}
+
+			overlaps, err := helpers.CheckDataExist(ctx, ShiftCollection, bson.M{
+				"user_id":  newShift.User_ID,
+				"start_at": bson.M{"$lt": newShift.End_At},
+				"end_at":   bson.M{"$gt": newShift.Start_At},
+			})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving shifts", "details": err.Error()})
+				return
+			}
+			if overlaps {
+				skipped++
+				continue
+			}
+
+			if _, err := ShiftCollection.InsertOne(ctx, newShift); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error creating shift", "details": err.Error()})
+				return
+			}
+			copied = append(copied, newShift)
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"success": true, "message": "Roster copied successfully", "data": copied, "skipped": skipped})
+	}
+}
+
+// GetMyShifts returns the caller's shifts in every branch over ?from=&to=
+// (YYYY-MM-DD), the coming week by default.
+func GetMyShifts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		userID, err := helpers.GetUserIDFromMdw(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		toDate := c.Query("to")
+		if toDate == "" {
+			toDate = time.Now().AddDate(0, 0, 6).Format("2006-01-02")
+		}
+		from, to, err := parseDateRange(c.Query("from"), toDate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid date format, use YYYY-MM-DD", "details": err.Error()})
+			return
+		}
+
+		shifts, err := findShifts(ctx, bson.M{"user_id": userID}, from, to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving shifts", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Shifts retrieved successfully", "data": shifts})
+	}
+}
+
+// GetBranchAttendance compares the roster with the punches over ?from=&to=
+// (YYYY-MM-DD) and flags late and absent shifts. ?status= keeps one status only.
+func GetBranchAttendance() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		branchID := c.Param("branch_id")
+
+		if !authorizeBranch(c, branchID) {
+			return
+		}
+
+		from, to, err := parseDateRange(c.Query("from"), c.Query("to"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid date format, use YYYY-MM-DD", "details": err.Error()})
+			return
+		}
+
+		shifts, err := findShifts(ctx, bson.M{"branch_id": branchID}, from, to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving shifts", "details": err.Error()})
+			return
+		}
+
+		entries, err := findTimeEntries(ctx, bson.M{"branch_id": branchID}, from.Add(-helpers.EarlyClockInWindow), to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving time entries", "details": err.Error()})
+			return
+		}
+
+		grace := lateGrace()
+		now := time.Now()
+		attendance := []helpers.ShiftAttendance{}
+		for _, shift := range shifts {
+			result := helpers.EvaluateShift(shift, entries, grace, now)
+			if status := c.Query("status"); status != "" && result.Status != status {
+				continue
+			}
+			attendance = append(attendance, result)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Attendance retrieved successfully", "data": attendance})
+	}
+}
@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	storage "nano_food_api/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FileStorage keeps uploaded images. It is set up once at startup.
+var FileStorage storage.Storage
+
+var unsafeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// storeUpload saves an uploaded file in a folder of FileStorage and returns its
+// public URL.
+func storeUpload(folder string, file multipart.File, header *multipart.FileHeader) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Second)
+	defer cancel()
+
+	filename := unsafeFilenameChars.ReplaceAllString(header.Filename, "_")
+	contentType := header.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(filename))
+	}
+
+	key := fmt.Sprintf("%s/%d_%s", folder, time.Now().Unix(), filename)
+	return FileStorage.Put(ctx, key, file, header.Size, contentType)
+}
+
+// ServeStoredFile serves the files of the local storage driver. Private files
+// need a signed URL, and signed URLs are refused once they expire.
+func ServeStoredFile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		local, ok := FileStorage.(*storage.Local)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "File not found"})
+			return
+		}
+
+		key := c.Param("key")
+		signature := c.Query("signature")
+		if (signature != "" || storage.IsPrivate(key)) && !local.VerifySignature(key, c.Query("expires"), signature) {
+			c.JSON(http.StatusForbidden, gin.H{"success": false, "error": "Invalid or expired link"})
+			return
+		}
+
+		filePath, err := local.Path(key)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "File not found"})
+			return
+		}
+		if info, err := os.Stat(filePath); err != nil || info.IsDir() {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "File not found"})
+			return
+		}
+
+		c.File(filePath)
+	}
+}
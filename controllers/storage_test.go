@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	storage "nano_food_api/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestServeStoredFile(t *testing.T) {
+	local, err := storage.NewLocal(t.TempDir(), "http://localhost:8000"+storage.LocalRoute, "signing-key")
+	if err != nil {
+		t.Fatalf("NewLocal: %v", err)
+	}
+	previous := FileStorage
+	FileStorage = local
+	t.Cleanup(func() { FileStorage = previous })
+
+	ctx := context.Background()
+	for _, key := range []string{"menu_images/pizza.png", "private/report.csv"} {
+		if _, err := local.Put(ctx, key, strings.NewReader("content"), 7, ""); err != nil {
+			t.Fatalf("Put(%q): %v", key, err)
+		}
+	}
+	signedPath := func(key string, expires time.Duration) string {
+		signedURL, err := local.SignedURL(ctx, key, expires)
+		if err != nil {
+			t.Fatalf("SignedURL(%q): %v", key, err)
+		}
+		parsed, err := url.Parse(signedURL)
+		if err != nil {
+			t.Fatalf("parsing %q: %v", signedURL, err)
+		}
+		return parsed.RequestURI()
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET(storage.LocalRoute+"/*key", ServeStoredFile())
+
+	tests := []struct {
+		name string
+		path string
+		want int
+	}{
+		{"public file", storage.LocalRoute + "/menu_images/pizza.png", http.StatusOK},
+		{"signed public file", signedPath("menu_images/pizza.png", time.Minute), http.StatusOK},
+		{"expired public file", signedPath("menu_images/pizza.png", -time.Minute), http.StatusForbidden},
+		{"unsigned private file", storage.LocalRoute + "/private/report.csv", http.StatusForbidden},
+		{"signed private file", signedPath("private/report.csv", time.Minute), http.StatusOK},
+		{"expired private file", signedPath("private/report.csv", -time.Minute), http.StatusForbidden},
+		{"missing file", storage.LocalRoute + "/menu_images/missing.png", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.want {
+				t.Fatalf("GET %s: expected %d, got %d: %s", tt.path, tt.want, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
@@ -6,7 +6,11 @@ import (
 	"nano_food_api/database"
 	"nano_food_api/helpers"
 	"nano_food_api/models"
+	token "nano_food_api/tokens"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -28,6 +32,10 @@ func CreateTable() gin.HandlerFunc {
 			return
 		}
 
+		if !authorizeBranch(c, table.Branch_ID) {
+			return
+		}
+
 		var branch models.Branch
 		err := BranchCollection.FindOne(ctx, bson.M{"_id": table.Branch_ID}).Decode(&branch)
 		if err != nil {
@@ -37,6 +45,7 @@ func CreateTable() gin.HandlerFunc {
 		}
 
 		table.Table_ID = primitive.NewObjectID().Hex()
+		table.QR_Nonce = primitive.NewObjectID().Hex()
 		table.Created_At = time.Now()
 		table.Updated_At = time.Now()
 
@@ -146,24 +155,16 @@ func UpdateTable() gin.HandlerFunc {
 
 		tableID := c.Param("table_id")
 
-		userInfo, err := helpers.GetCurrentUser(c, database.UserCollection)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
-			return
-		}
-
 		var table models.Table
 		if err := c.BindJSON(&table); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
 			return
 		}
 
-		if userInfo.Role != 100 && userInfo.Branch_ID != table.Branch_ID {
-			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Unauthorized Access"})
+		filter, ok := scopedFilter(c, bson.M{"_id": tableID})
+		if !ok {
 			return
 		}
-
-		filter := bson.M{"_id": tableID}
 		update := bson.M{
 			"$set": bson.M{
 				"name":        table.Name,
@@ -175,10 +176,14 @@ func UpdateTable() gin.HandlerFunc {
 		}
 
 		result, err := TableCollection.UpdateOne(ctx, filter, update)
-		if err != nil || result.MatchedCount == 0 {
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error updating table", "details": err.Error()})
 			return
 		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Table not found"})
+			return
+		}
 
 		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Table updated successfully"})
 	}
@@ -191,22 +196,15 @@ func DeleteTable() gin.HandlerFunc {
 
 		tableID := c.Param("table_id")
 
-		userInfo, err := helpers.GetCurrentUser(c, database.UserCollection)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
-			return
-		}
-
 		var table models.Table
-		err = TableCollection.FindOne(ctx, bson.M{"_id": tableID}).Decode(&table)
+		err := TableCollection.FindOne(ctx, bson.M{"_id": tableID}).Decode(&table)
 		if err != nil {
 			log.Printf("Error retrieving table: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving table", "details": err.Error()})
 			return
 		}
 
-		if userInfo.Role != 100 && userInfo.Branch_ID != table.Branch_ID {
-			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Unauthorized Access"})
+		if !authorizeBranch(c, table.Branch_ID) {
 			return
 		}
 
@@ -219,3 +217,138 @@ func DeleteTable() gin.HandlerFunc {
 		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Table deleted successfully"})
 	}
 }
+
+// tableQRToken returns the signed guest token for a table, assigning a QR nonce
+// first for tables created before QR ordering existed.
+func tableQRToken(ctx context.Context, table *models.Table) (string, error) {
+	if table.QR_Nonce == "" {
+		table.QR_Nonce = primitive.NewObjectID().Hex()
+		_, err := TableCollection.UpdateOne(ctx, bson.M{"_id": table.Table_ID}, bson.M{"$set": bson.M{"qr_nonce": table.QR_Nonce}})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return token.TableTokenGenerator(table.Branch_ID, table.Table_ID, table.QR_Nonce)
+}
+
+// tableQRContent is what gets encoded in the printed QR code: the guest ordering
+// page URL when GUEST_ORDER_URL is configured, otherwise the bare token.
+func tableQRContent(qrToken string) string {
+	guestOrderURL := os.Getenv("GUEST_ORDER_URL")
+	if guestOrderURL == "" {
+		return qrToken
+	}
+	return guestOrderURL + "?token=" + url.QueryEscape(qrToken)
+}
+
+func GetTableQRCode() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		tableID := c.Param("table_id")
+
+		var table models.Table
+		err := TableCollection.FindOne(ctx, bson.M{"_id": tableID}).Decode(&table)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Table not found", "details": err.Error()})
+			return
+		}
+
+		if !authorizeBranch(c, table.Branch_ID) {
+			return
+		}
+
+		qrToken, err := tableQRToken(ctx, &table)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error generating table token", "details": err.Error()})
+			return
+		}
+		content := tableQRContent(qrToken)
+
+		size, err := strconv.Atoi(c.DefaultQuery("size", "256"))
+		if err != nil || size < 64 || size > 2048 {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid size! Must be between 64 and 2048."})
+			return
+		}
+
+		switch c.DefaultQuery("format", "json") {
+		case "png":
+			png, err := helpers.GenerateQRCodePNG(content, size)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error generating QR code", "details": err.Error()})
+				return
+			}
+			c.Data(http.StatusOK, "image/png", png)
+		case "svg":
+			svg, err := helpers.GenerateQRCodeSVG(content, size)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error generating QR code", "details": err.Error()})
+				return
+			}
+			c.Data(http.StatusOK, "image/svg+xml", svg)
+		case "json":
+			c.JSON(http.StatusOK, gin.H{
+				"success": true,
+				"message": "Table QR code retrieved successfully",
+				"data": gin.H{
+					"table_id":  table.Table_ID,
+					"branch_id": table.Branch_ID,
+					"token":     qrToken,
+					"content":   content,
+				},
+			})
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid format! Allowed formats are json, png or svg."})
+		}
+	}
+}
+
+// RotateTableQRCode invalidates every QR code printed for the table so far.
+func RotateTableQRCode() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		tableID := c.Param("table_id")
+
+		var table models.Table
+		err := TableCollection.FindOne(ctx, bson.M{"_id": tableID}).Decode(&table)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Table not found", "details": err.Error()})
+			return
+		}
+
+		if !authorizeBranch(c, table.Branch_ID) {
+			return
+		}
+
+		table.QR_Nonce = primitive.NewObjectID().Hex()
+		_, err = TableCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": tableID},
+			bson.M{"$set": bson.M{"qr_nonce": table.QR_Nonce, "updated_at": time.Now()}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error rotating table QR code", "details": err.Error()})
+			return
+		}
+
+		qrToken, err := tableQRToken(ctx, &table)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error generating table token", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Table QR code rotated successfully",
+			"data": gin.H{
+				"table_id": table.Table_ID,
+				"token":    qrToken,
+				"content":  tableQRContent(qrToken),
+			},
+		})
+	}
+}
@@ -0,0 +1,363 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+
+	database "nano_food_api/database"
+	helpers "nano_food_api/helpers"
+	models "nano_food_api/models"
+	token "nano_food_api/tokens"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var TerminalCollection *mongo.Collection = database.TerminalCollection
+
+var pinPattern = regexp.MustCompile(`^[0-9]{4,6}$`)
+
+// terminalFromRequest resolves the registered terminal from the X-Terminal-Token header.
+func terminalFromRequest(ctx context.Context, c *gin.Context) (models.Terminal, error) {
+	deviceToken := c.GetHeader("X-Terminal-Token")
+	if deviceToken == "" {
+		return models.Terminal{}, fmt.Errorf("terminal token not provided")
+	}
+
+	var terminal models.Terminal
+	err := TerminalCollection.FindOne(ctx, bson.M{"device_token": helpers.HashToken(deviceToken)}).Decode(&terminal)
+	if err != nil {
+		return models.Terminal{}, fmt.Errorf("terminal is not registered")
+	}
+	return terminal, nil
+}
+
+func RegisterTerminal() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var reqBody struct {
+			Branch_ID string `json:"branch_id" binding:"required"`
+			Name      string `json:"name" binding:"required"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		userInfo, err := helpers.GetCurrentUser(c, UserCollection)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		if !authorizeBranch(c, reqBody.Branch_ID) {
+			return
+		}
+
+		branchExists, err := helpers.CheckDataExist(ctx, BranchCollection, bson.M{"_id": reqBody.Branch_ID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to validate branch", "details": err.Error()})
+			return
+		}
+		if !branchExists {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid branch ID"})
+			return
+		}
+
+		deviceToken, err := helpers.GenerateRandomToken(32)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error generating device token", "details": err.Error()})
+			return
+		}
+
+		terminal := models.Terminal{
+			Terminal_ID: primitive.NewObjectID().Hex(),
+			Branch_ID:   reqBody.Branch_ID,
+			Name:        reqBody.Name,
+			DeviceToken: helpers.HashToken(deviceToken),
+			Created_By:  userInfo.User_ID,
+			Created_At:  time.Now(),
+			Updated_At:  time.Now(),
+		}
+
+		_, err = TerminalCollection.InsertOne(ctx, terminal)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error registering terminal", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{
+			"success":      true,
+			"message":      "Terminal registered successfully. Store the device token on the terminal, it is only shown once.",
+			"data":         terminal,
+			"device_token": deviceToken,
+		})
+	}
+}
+
+func GetBranchTerminals() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		branchID := c.Param("branch_id")
+
+		if !authorizeBranch(c, branchID) {
+			return
+		}
+
+		cursor, err := TerminalCollection.Find(ctx, bson.M{"branch_id": branchID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving terminals", "details": err.Error()})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		var terminals []models.Terminal
+		if err := cursor.All(ctx, &terminals); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error decoding terminals", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Terminals retrieved successfully", "data": terminals})
+	}
+}
+
+// DeleteTerminal unregisters a terminal and ends every PIN session on it.
+func DeleteTerminal() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		terminalID := c.Param("terminal_id")
+
+		var terminal models.Terminal
+		err := TerminalCollection.FindOne(ctx, bson.M{"_id": terminalID}).Decode(&terminal)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Terminal not found", "details": err.Error()})
+			return
+		}
+
+		if !authorizeBranch(c, terminal.Branch_ID) {
+			return
+		}
+
+		_, err = TerminalCollection.DeleteOne(ctx, bson.M{"_id": terminalID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error deleting terminal", "details": err.Error()})
+			return
+		}
+
+		_, err = SessionCollection.UpdateMany(
+			ctx,
+			bson.M{"terminal_id": terminalID, "is_revoked": false},
+			bson.M{"$set": bson.M{"is_revoked": true, "updated_at": time.Now()}},
+		)
+		if err != nil {
+			log.Printf("Error revoking terminal sessions: %v", err)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Terminal deleted successfully"})
+	}
+}
+
+// SetStaffPin sets the PIN waiters, chefs and assistants use on branch terminals.
+func SetStaffPin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var reqBody struct {
+			User_ID string `json:"user_id" binding:"required"`
+			Pin     string `json:"pin" binding:"required"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		if !pinPattern.MatchString(reqBody.Pin) {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "PIN must be 4 to 6 digits"})
+			return
+		}
+
+		var user models.User
+		err := UserCollection.FindOne(ctx, bson.M{"_id": reqBody.User_ID}).Decode(&user)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "User not found", "details": err.Error()})
+			return
+		}
+
+		if !authorizeBranch(c, user.Branch_ID) {
+			return
+		}
+
+		if !helpers.Contains([]int{0, 1}, user.Role) {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "PIN login is only available for staff and assistants"})
+			return
+		}
+
+		hashedPin, err := helpers.HashPassword(reqBody.Pin)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error hashing PIN", "details": err.Error()})
+			return
+		}
+
+		_, err = UserCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": user.User_ID},
+			bson.M{"$set": bson.M{"pin": hashedPin, "updated_at": time.Now()}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error updating PIN", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Staff PIN updated successfully"})
+	}
+}
+
+// GetTerminalStaff lists who can log in on the terminal, for the user picker.
+func GetTerminalStaff() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		terminal, err := terminalFromRequest(ctx, c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		cursor, err := UserCollection.Find(ctx, bson.M{
+			"branch_id": terminal.Branch_ID,
+			"role":      bson.M{"$in": []int{0, 1}},
+			"pin":       bson.M{"$exists": true},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving users", "details": err.Error()})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		var users []models.User
+		if err := cursor.All(ctx, &users); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error decoding users", "details": err.Error()})
+			return
+		}
+
+		staff := make([]gin.H, 0, len(users))
+		for _, user := range users {
+			staff = append(staff, gin.H{
+				"_id":    user.User_ID,
+				"name":   user.Name,
+				"avatar": user.Avatar,
+				"role":   user.Role,
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Staff retrieved successfully", "data": staff})
+	}
+}
+
+// PinLogin logs a staff member in on a registered terminal. Logging in replaces
+// whoever was using the terminal before, so staff can switch without the
+// terminal itself being logged out.
+func PinLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		terminal, err := terminalFromRequest(ctx, c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		var reqBody struct {
+			User_ID string `json:"user_id" binding:"required"`
+			Pin     string `json:"pin" binding:"required"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		accountKey, ipKey := attemptKeys("pin", reqBody.User_ID, c.ClientIP())
+		if !checkAttemptsAllowed(ctx, c, accountKey, ipKey) {
+			return
+		}
+
+		var user models.User
+		err = UserCollection.FindOne(ctx, bson.M{"_id": reqBody.User_ID, "branch_id": terminal.Branch_ID}).Decode(&user)
+		if err != nil || user.Pin == "" || !helpers.Contains([]int{0, 1}, user.Role) {
+			recordFailedAttempts(ctx, accountKey, ipKey, nil)
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": "Invalid user or PIN"})
+			return
+		}
+
+		if !helpers.CheckPassword(user.Pin, reqBody.Pin) {
+			recordFailedAttempts(ctx, accountKey, ipKey, &user)
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": "Invalid user or PIN"})
+			return
+		}
+
+		if err := helpers.ResetAttempts(ctx, LoginAttemptCollection, accountKey); err != nil {
+			log.Printf("Error resetting failed attempts: %v", err)
+		}
+
+		_, err = SessionCollection.UpdateMany(
+			ctx,
+			bson.M{"terminal_id": terminal.Terminal_ID, "is_revoked": false},
+			bson.M{"$set": bson.M{"is_revoked": true, "updated_at": time.Now()}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error switching user", "details": err.Error()})
+			return
+		}
+
+		session := helpers.NewSession(c, user.User_ID)
+		session.Terminal_ID = terminal.Terminal_ID
+
+		accessToken, expiresAt, err := token.TerminalTokenGenerator(user.Email, user.User_ID, user.Role, session.Session_ID, terminal.Branch_ID)
+		if err != nil {
+			log.Printf("Error generating token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error generating token", "details": err.Error()})
+			return
+		}
+		session.Expires_At = expiresAt
+
+		_, err = SessionCollection.InsertOne(ctx, session)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error creating session", "details": err.Error()})
+			return
+		}
+
+		_, err = TerminalCollection.UpdateOne(ctx, bson.M{"_id": terminal.Terminal_ID}, bson.M{"$set": bson.M{"last_used_at": time.Now()}})
+		if err != nil {
+			log.Printf("Error updating terminal: %v", err)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success":     true,
+			"message":     "Login successful",
+			"accessToken": accessToken,
+			"expires_at":  expiresAt,
+			"user": gin.H{
+				"_id":       user.User_ID,
+				"name":      user.Name,
+				"avatar":    user.Avatar,
+				"role":      user.Role,
+				"branch_id": user.Branch_ID,
+			},
+		})
+	}
+}
@@ -0,0 +1,536 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	database "nano_food_api/database"
+	helpers "nano_food_api/helpers"
+	models "nano_food_api/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var TimeEntryCollection *mongo.Collection = database.TimeEntryCollection
+
+// lateGrace is how many minutes after a shift starts a clock-in still counts as
+// on time, SHIFT_LATE_GRACE or 5 by default.
+func lateGrace() time.Duration {
+	grace, err := strconv.Atoi(os.Getenv("SHIFT_LATE_GRACE"))
+	if err != nil {
+		grace = 5
+	}
+	return time.Duration(grace) * time.Minute
+}
+
+// findOpenTimeEntry returns the caller's entry that has not been clocked out yet.
+func findOpenTimeEntry(ctx context.Context, userID string) (models.TimeEntry, error) {
+	var entry models.TimeEntry
+	err := TimeEntryCollection.FindOne(ctx, bson.M{"user_id": userID, "status": "001"}).Decode(&entry)
+	return entry, err
+}
+
+// findTimeEntries returns the entries matching the filter clocked in within [from, to).
+func findTimeEntries(ctx context.Context, filter bson.M, from time.Time, to time.Time) ([]models.TimeEntry, error) {
+	filter["clock_in_at"] = bson.M{"$gte": from, "$lt": to}
+
+	cursor, err := TimeEntryCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"clock_in_at": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	entries := []models.TimeEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// ClockIn starts a time entry at the branch of the caller's token. Punches made
+// from a PIN session record the terminal.
+func ClockIn() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var reqBody struct {
+			Note string `json:"note"`
+		}
+		_ = c.ShouldBindJSON(&reqBody)
+
+		scope, err := helpers.GetBranchScope(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		if scope.Branch_ID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Switch to a branch before clocking in"})
+			return
+		}
+
+		openEntry, err := findOpenTimeEntry(ctx, scope.User_ID)
+		if err == nil {
+			c.JSON(http.StatusConflict, gin.H{"success": false, "error": "You are already clocked in", "data": openEntry})
+			return
+		}
+		if err != mongo.ErrNoDocuments {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving time entries", "details": err.Error()})
+			return
+		}
+
+		entry := models.TimeEntry{
+			TimeEntry_ID: primitive.NewObjectID().Hex(),
+			User_ID:      scope.User_ID,
+			Branch_ID:    scope.Branch_ID,
+			Clock_In_At:  time.Now(),
+			Breaks:       []models.TimeBreak{},
+			Status:       "001",
+			Note:         reqBody.Note,
+			Created_At:   time.Now(),
+			Updated_At:   time.Now(),
+		}
+
+		if sessionID, err := helpers.GetSessionIDFromMdw(c); err == nil {
+			var session models.Session
+			if err := SessionCollection.FindOne(ctx, bson.M{"_id": sessionID}).Decode(&session); err == nil {
+				entry.Terminal_ID = session.Terminal_ID
+			}
+		}
+
+		_, err = TimeEntryCollection.InsertOne(ctx, entry)
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"success": false, "error": "You are already clocked in"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error clocking in", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"success": true, "message": "Clocked in successfully", "data": entry})
+	}
+}
+
+// ClockOut closes the caller's open time entry, ending a break still running.
+func ClockOut() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		userID, err := helpers.GetUserIDFromMdw(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		entry, err := findOpenTimeEntry(ctx, userID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "You are not clocked in"})
+			return
+		}
+
+		now := time.Now()
+		if i := helpers.OpenBreak(entry); i >= 0 {
+			entry.Breaks[i].End_At = now
+		}
+		entry.Clock_Out_At = now
+		entry.Status = "002"
+		entry.Updated_At = now
+
+		_, err = TimeEntryCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": entry.TimeEntry_ID, "status": "001"},
+			bson.M{"$set": bson.M{"clock_out_at": entry.Clock_Out_At, "breaks": entry.Breaks, "status": entry.Status, "updated_at": entry.Updated_At}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error clocking out", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success":       true,
+			"message":       "Clocked out successfully",
+			"data":          entry,
+			"worked_hours":  helpers.WorkedDuration(entry, now).Hours(),
+			"break_minutes": helpers.BreakDuration(entry, now).Minutes(),
+		})
+	}
+}
+
+func StartBreak() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		userID, err := helpers.GetUserIDFromMdw(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		entry, err := findOpenTimeEntry(ctx, userID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "You are not clocked in"})
+			return
+		}
+
+		if helpers.OpenBreak(entry) >= 0 {
+			c.JSON(http.StatusConflict, gin.H{"success": false, "error": "You are already on a break"})
+			return
+		}
+
+		entry.Breaks = append(entry.Breaks, models.TimeBreak{Start_At: time.Now()})
+		_, err = TimeEntryCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": entry.TimeEntry_ID},
+			bson.M{"$set": bson.M{"breaks": entry.Breaks, "updated_at": time.Now()}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error starting break", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Break started", "data": entry})
+	}
+}
+
+func EndBreak() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		userID, err := helpers.GetUserIDFromMdw(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		entry, err := findOpenTimeEntry(ctx, userID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "You are not clocked in"})
+			return
+		}
+
+		i := helpers.OpenBreak(entry)
+		if i < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "You are not on a break"})
+			return
+		}
+
+		entry.Breaks[i].End_At = time.Now()
+		_, err = TimeEntryCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": entry.TimeEntry_ID},
+			bson.M{"$set": bson.M{"breaks": entry.Breaks, "updated_at": time.Now()}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error ending break", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Break ended", "data": entry})
+	}
+}
+
+// GetMyTimeEntries returns the caller's punches over ?from=&to= (YYYY-MM-DD) and
+// whether they are clocked in right now.
+func GetMyTimeEntries() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		userID, err := helpers.GetUserIDFromMdw(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		from, to, err := parseDateRange(c.Query("from"), c.Query("to"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid date format, use YYYY-MM-DD", "details": err.Error()})
+			return
+		}
+
+		entries, err := findTimeEntries(ctx, bson.M{"user_id": userID}, from, to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving time entries", "details": err.Error()})
+			return
+		}
+
+		var current interface{}
+		if openEntry, err := findOpenTimeEntry(ctx, userID); err == nil {
+			current = openEntry
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Time entries retrieved successfully", "data": entries, "current": current})
+	}
+}
+
+func GetBranchTimeEntries() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		branchID := c.Param("branch_id")
+
+		if !authorizeBranch(c, branchID) {
+			return
+		}
+
+		from, to, err := parseDateRange(c.Query("from"), c.Query("to"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid date format, use YYYY-MM-DD", "details": err.Error()})
+			return
+		}
+
+		filter := bson.M{"branch_id": branchID}
+		if userID := c.Query("user_id"); userID != "" {
+			filter["user_id"] = userID
+		}
+
+		entries, err := findTimeEntries(ctx, filter, from, to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving time entries", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Time entries retrieved successfully", "data": entries})
+	}
+}
+
+// UpdateTimeEntry lets a manager correct forgotten or wrong punches. The editor
+// is recorded on the entry.
+func UpdateTimeEntry() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		timeEntryID := c.Param("time_entry_id")
+
+		var reqBody struct {
+			Clock_In_At  *time.Time         `json:"clock_in_at"`
+			Clock_Out_At *time.Time         `json:"clock_out_at"`
+			Breaks       []models.TimeBreak `json:"breaks"`
+			Note         *string            `json:"note"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		var entry models.TimeEntry
+		err := TimeEntryCollection.FindOne(ctx, bson.M{"_id": timeEntryID}).Decode(&entry)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Time entry not found", "details": err.Error()})
+			return
+		}
+
+		if !authorizeBranch(c, entry.Branch_ID) {
+			return
+		}
+
+		if reqBody.Clock_In_At != nil {
+			entry.Clock_In_At = *reqBody.Clock_In_At
+		}
+		if reqBody.Clock_Out_At != nil {
+			entry.Clock_Out_At = *reqBody.Clock_Out_At
+			entry.Status = "002"
+		}
+		if reqBody.Breaks != nil {
+			entry.Breaks = reqBody.Breaks
+		}
+		if reqBody.Note != nil {
+			entry.Note = *reqBody.Note
+		}
+
+		if !entry.Clock_Out_At.IsZero() && !entry.Clock_Out_At.After(entry.Clock_In_At) {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Clock-out must be after clock-in"})
+			return
+		}
+		for _, b := range entry.Breaks {
+			if b.Start_At.Before(entry.Clock_In_At) || (!b.End_At.IsZero() && !b.End_At.After(b.Start_At)) {
+				c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Breaks must start after clock-in and end after they start"})
+				return
+			}
+			if !entry.Clock_Out_At.IsZero() && (b.End_At.IsZero() || b.End_At.After(entry.Clock_Out_At)) {
+				c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Breaks must end before clock-out"})
+				return
+			}
+		}
+
+		entry.Edited_By, err = helpers.GetUserIDFromMdw(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		entry.Updated_At = time.Now()
+
+		_, err = TimeEntryCollection.ReplaceOne(ctx, bson.M{"_id": entry.TimeEntry_ID}, entry)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error updating time entry", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Time entry updated successfully", "data": entry})
+	}
+}
+
+// staffHours is one row of the hours report.
+type staffHours struct {
+	User_ID         string  `json:"user_id"`
+	Name            string  `json:"name"`
+	Email           string  `json:"email"`
+	Entries         int     `json:"entries"`
+	Worked_Hours    float64 `json:"worked_hours"`
+	Break_Hours     float64 `json:"break_hours"`
+	Scheduled_Hours float64 `json:"scheduled_hours"`
+	Late_Count      int     `json:"late_count"`
+	Absent_Count    int     `json:"absent_count"`
+	Open_Entries    int     `json:"open_entries"`
+}
+
+func roundHours(d time.Duration) float64 {
+	return float64(int64(d.Hours()*100+0.5)) / 100
+}
+
+// GetHoursReport totals worked, break and rostered hours per user of a branch
+// over ?from=&to= (YYYY-MM-DD). With ?format=csv it is returned as a CSV file
+// for payroll.
+func GetHoursReport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		branchID := c.Param("branch_id")
+
+		if !authorizeBranch(c, branchID) {
+			return
+		}
+
+		from, to, err := parseDateRange(c.Query("from"), c.Query("to"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid date format, use YYYY-MM-DD", "details": err.Error()})
+			return
+		}
+
+		entries, err := findTimeEntries(ctx, bson.M{"branch_id": branchID}, from.Add(-helpers.EarlyClockInWindow), to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving time entries", "details": err.Error()})
+			return
+		}
+
+		shifts, err := findShifts(ctx, bson.M{"branch_id": branchID}, from, to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving shifts", "details": err.Error()})
+			return
+		}
+
+		now := time.Now()
+		worked := map[string]time.Duration{}
+		breaks := map[string]time.Duration{}
+		scheduled := map[string]time.Duration{}
+		rows := map[string]*staffHours{}
+		row := func(userID string) *staffHours {
+			if rows[userID] == nil {
+				rows[userID] = &staffHours{User_ID: userID}
+			}
+			return rows[userID]
+		}
+
+		for _, entry := range entries {
+			if entry.Clock_In_At.Before(from) {
+				continue
+			}
+			r := row(entry.User_ID)
+			r.Entries++
+			if entry.Status == "001" {
+				r.Open_Entries++
+			}
+			worked[entry.User_ID] += helpers.WorkedDuration(entry, now)
+			breaks[entry.User_ID] += helpers.BreakDuration(entry, now)
+		}
+
+		grace := lateGrace()
+		for _, shift := range shifts {
+			r := row(shift.User_ID)
+			scheduled[shift.User_ID] += shift.End_At.Sub(shift.Start_At)
+			switch helpers.EvaluateShift(shift, entries, grace, now).Status {
+			case helpers.AttendanceLate:
+				r.Late_Count++
+			case helpers.AttendanceAbsent:
+				r.Absent_Count++
+			}
+		}
+
+		userIDs := []string{}
+		for userID, r := range rows {
+			r.Worked_Hours = roundHours(worked[userID])
+			r.Break_Hours = roundHours(breaks[userID])
+			r.Scheduled_Hours = roundHours(scheduled[userID])
+			userIDs = append(userIDs, userID)
+		}
+
+		cursor, err := UserCollection.Find(ctx, bson.M{"_id": bson.M{"$in": userIDs}}, options.Find().SetProjection(bson.M{"name": 1, "email": 1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving users", "details": err.Error()})
+			return
+		}
+		var users []models.User
+		if err := cursor.All(ctx, &users); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error decoding users", "details": err.Error()})
+			return
+		}
+		for _, user := range users {
+			rows[user.User_ID].Name = user.Name
+			rows[user.User_ID].Email = user.Email
+		}
+
+		report := []staffHours{}
+		for _, r := range rows {
+			report = append(report, *r)
+		}
+		sort.Slice(report, func(i, j int) bool { return report[i].Name < report[j].Name })
+
+		if c.Query("format") != "csv" {
+			c.JSON(http.StatusOK, gin.H{"success": true, "message": "Hours report retrieved successfully", "data": report})
+			return
+		}
+
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		w.Write([]string{"user_id", "name", "email", "entries", "worked_hours", "break_hours", "scheduled_hours", "late_count", "absent_count", "open_entries"})
+		for _, r := range report {
+			w.Write([]string{
+				r.User_ID, r.Name, r.Email,
+				strconv.Itoa(r.Entries),
+				strconv.FormatFloat(r.Worked_Hours, 'f', 2, 64),
+				strconv.FormatFloat(r.Break_Hours, 'f', 2, 64),
+				strconv.FormatFloat(r.Scheduled_Hours, 'f', 2, 64),
+				strconv.Itoa(r.Late_Count),
+				strconv.Itoa(r.Absent_Count),
+				strconv.Itoa(r.Open_Entries),
+			})
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error writing CSV", "details": err.Error()})
+			return
+		}
+
+		filename := fmt.Sprintf("hours-%s-%s-%s.csv", branchID, from.Format("20060102"), to.AddDate(0, 0, -1).Format("20060102"))
+		c.Header("Content-Disposition", "attachment; filename="+filename)
+		c.Data(http.StatusOK, "text/csv", buf.Bytes())
+	}
+}
@@ -0,0 +1,454 @@
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	helpers "nano_food_api/helpers"
+	models "nano_food_api/models"
+	token "nano_food_api/tokens"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// twoFactorRequired reports whether TWO_FACTOR_REQUIRED_ROLES (e.g. "2,3,100")
+// forces accounts of this role to use two-factor authentication.
+func twoFactorRequired(role int) bool {
+	for _, value := range strings.Split(os.Getenv("TWO_FACTOR_REQUIRED_ROLES"), ",") {
+		requiredRole, err := strconv.Atoi(strings.TrimSpace(value))
+		if err == nil && requiredRole == role {
+			return true
+		}
+	}
+	return false
+}
+
+func userFromPreAuthToken(ctx context.Context, preAuthToken string) (models.User, error) {
+	claims, msg := token.ValidatePreAuthToken(preAuthToken)
+	if msg != "" {
+		return models.User{}, fmt.Errorf("%s", msg)
+	}
+
+	var user models.User
+	err := UserCollection.FindOne(ctx, bson.M{"_id": claims.User_ID}).Decode(&user)
+	if err != nil {
+		return models.User{}, fmt.Errorf("user not found")
+	}
+	return user, nil
+}
+
+// verifySecondFactor accepts either a TOTP code or one of the user's unused
+// recovery codes, consuming the code so it cannot be used twice.
+func verifySecondFactor(ctx context.Context, user models.User, code string, recoveryCode string) error {
+	if code != "" {
+		step, ok := helpers.ValidateTOTP(user.TwoFactorSecret, code, user.TwoFactorStep)
+		if !ok {
+			return fmt.Errorf("invalid authentication code")
+		}
+
+		result, err := UserCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": user.User_ID, "two_factor_step": bson.M{"$not": bson.M{"$gte": step}}},
+			bson.M{"$set": bson.M{"two_factor_step": step}},
+		)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return fmt.Errorf("authentication code has already been used")
+		}
+		return nil
+	}
+
+	if recoveryCode != "" {
+		recoveryCode = strings.ToLower(strings.TrimSpace(recoveryCode))
+		for _, hashedCode := range user.RecoveryCodes {
+			if !helpers.CheckPassword(hashedCode, recoveryCode) {
+				continue
+			}
+
+			result, err := UserCollection.UpdateOne(
+				ctx,
+				bson.M{"_id": user.User_ID, "recovery_codes": hashedCode},
+				bson.M{"$pull": bson.M{"recovery_codes": hashedCode}},
+			)
+			if err != nil {
+				return err
+			}
+			if result.MatchedCount == 0 {
+				return fmt.Errorf("recovery code has already been used")
+			}
+			return nil
+		}
+		return fmt.Errorf("invalid recovery code")
+	}
+
+	return fmt.Errorf("authentication code is required")
+}
+
+// startTwoFactorSetup stores a pending secret and responds with everything an
+// authenticator app needs to enrol it.
+func startTwoFactorSetup(ctx context.Context, c *gin.Context, user models.User) {
+	if user.TwoFactorEnabled {
+		c.JSON(http.StatusConflict, gin.H{"success": false, "error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	key, err := helpers.GenerateTOTPKey(user.Email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error generating secret", "details": err.Error()})
+		return
+	}
+
+	png, err := helpers.GenerateQRCodePNG(key.URL(), 256)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error generating QR code", "details": err.Error()})
+		return
+	}
+
+	_, err = UserCollection.UpdateOne(ctx, bson.M{"_id": user.User_ID}, bson.M{"$set": bson.M{"two_factor_pending": key.Secret()}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error saving secret", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Scan the QR code with your authenticator app and confirm with a code",
+		"data": gin.H{
+			"secret":      key.Secret(),
+			"otpauth_url": key.URL(),
+			"qr_code":     "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+		},
+	})
+}
+
+// finishTwoFactorSetup enables two-factor once the user proves their app works,
+// and returns fresh recovery codes to show once.
+func finishTwoFactorSetup(ctx context.Context, user models.User, code string) ([]string, error) {
+	if user.TwoFactorEnabled {
+		return nil, fmt.Errorf("two-factor authentication is already enabled")
+	}
+	if user.TwoFactorPending == "" {
+		return nil, fmt.Errorf("two-factor setup has not been started")
+	}
+
+	step, ok := helpers.ValidateTOTP(user.TwoFactorPending, code, 0)
+	if !ok {
+		return nil, fmt.Errorf("invalid authentication code")
+	}
+
+	recoveryCodes, hashedCodes, err := helpers.GenerateRecoveryCodes(10)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = UserCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": user.User_ID},
+		bson.M{
+			"$set": bson.M{
+				"two_factor_enabled": true,
+				"two_factor_secret":  user.TwoFactorPending,
+				"two_factor_step":    step,
+				"recovery_codes":     hashedCodes,
+				"updated_at":         time.Now(),
+			},
+			"$unset": bson.M{"two_factor_pending": ""},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return recoveryCodes, nil
+}
+
+func SetupTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		user, err := helpers.GetCurrentUser(c, UserCollection)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		startTwoFactorSetup(ctx, c, user)
+	}
+}
+
+func ConfirmTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var reqBody struct {
+			Code string `json:"code" binding:"required"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		user, err := helpers.GetCurrentUser(c, UserCollection)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		accountKey, ipKey := attemptKeys("2fa", user.User_ID, c.ClientIP())
+		if !checkAttemptsAllowed(ctx, c, accountKey, ipKey) {
+			return
+		}
+		recoveryCodes, err := finishTwoFactorSetup(ctx, user, reqBody.Code)
+		if err != nil {
+			recordFailedAttempts(ctx, accountKey, ipKey, &user)
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Error enabling two-factor authentication", "details": err.Error()})
+			return
+		}
+		if err := helpers.ResetAttempts(ctx, LoginAttemptCollection, accountKey); err != nil {
+			log.Printf("Error resetting failed attempts: %v", err)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success":        true,
+			"message":        "Two-factor authentication enabled. Store the recovery codes somewhere safe.",
+			"recovery_codes": recoveryCodes,
+		})
+	}
+}
+
+func DisableTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var reqBody struct {
+			Password     string `json:"password" binding:"required"`
+			Code         string `json:"code"`
+			RecoveryCode string `json:"recovery_code"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		user, err := helpers.GetCurrentUser(c, UserCollection)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		if !user.TwoFactorEnabled {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Two-factor authentication is not enabled"})
+			return
+		}
+		if twoFactorRequired(user.Role) {
+			c.JSON(http.StatusForbidden, gin.H{"success": false, "error": "Two-factor authentication is required for your role"})
+			return
+		}
+
+		accountKey, ipKey := attemptKeys("2fa", user.User_ID, c.ClientIP())
+		if !checkAttemptsAllowed(ctx, c, accountKey, ipKey) {
+			return
+		}
+		if !helpers.CheckPassword(user.Password, reqBody.Password) {
+			recordFailedAttempts(ctx, accountKey, ipKey, &user)
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": "Invalid password"})
+			return
+		}
+		if err := verifySecondFactor(ctx, user, reqBody.Code, reqBody.RecoveryCode); err != nil {
+			recordFailedAttempts(ctx, accountKey, ipKey, &user)
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		if err := helpers.ResetAttempts(ctx, LoginAttemptCollection, accountKey); err != nil {
+			log.Printf("Error resetting failed attempts: %v", err)
+		}
+
+		_, err = UserCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": user.User_ID},
+			bson.M{
+				"$set":   bson.M{"two_factor_enabled": false, "updated_at": time.Now()},
+				"$unset": bson.M{"two_factor_secret": "", "two_factor_pending": "", "two_factor_step": "", "recovery_codes": ""},
+			},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error disabling two-factor authentication", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Two-factor authentication disabled"})
+	}
+}
+
+func RegenerateRecoveryCodes() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var reqBody struct {
+			Code string `json:"code" binding:"required"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		user, err := helpers.GetCurrentUser(c, UserCollection)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		if !user.TwoFactorEnabled {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Two-factor authentication is not enabled"})
+			return
+		}
+
+		accountKey, ipKey := attemptKeys("2fa", user.User_ID, c.ClientIP())
+		if !checkAttemptsAllowed(ctx, c, accountKey, ipKey) {
+			return
+		}
+		if err := verifySecondFactor(ctx, user, reqBody.Code, ""); err != nil {
+			recordFailedAttempts(ctx, accountKey, ipKey, &user)
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		if err := helpers.ResetAttempts(ctx, LoginAttemptCollection, accountKey); err != nil {
+			log.Printf("Error resetting failed attempts: %v", err)
+		}
+
+		recoveryCodes, hashedCodes, err := helpers.GenerateRecoveryCodes(10)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error generating recovery codes", "details": err.Error()})
+			return
+		}
+
+		_, err = UserCollection.UpdateOne(ctx, bson.M{"_id": user.User_ID}, bson.M{"$set": bson.M{"recovery_codes": hashedCodes}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error saving recovery codes", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Recovery codes regenerated", "recovery_codes": recoveryCodes})
+	}
+}
+
+// LoginTwoFactor is the second step of LoginUser for accounts with two-factor enabled.
+func LoginTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var reqBody struct {
+			PreAuthToken string `json:"pre_auth_token" binding:"required"`
+			Code         string `json:"code"`
+			RecoveryCode string `json:"recovery_code"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		user, err := userFromPreAuthToken(ctx, reqBody.PreAuthToken)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": "Invalid pre-auth token", "details": err.Error()})
+			return
+		}
+
+		if !user.TwoFactorEnabled {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Two-factor authentication is not enabled"})
+			return
+		}
+
+		accountKey, ipKey := attemptKeys("2fa", user.User_ID, c.ClientIP())
+		if !checkAttemptsAllowed(ctx, c, accountKey, ipKey) {
+			return
+		}
+		if err := verifySecondFactor(ctx, user, reqBody.Code, reqBody.RecoveryCode); err != nil {
+			recordFailedAttempts(ctx, accountKey, ipKey, &user)
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		if err := helpers.ResetAttempts(ctx, LoginAttemptCollection, accountKey); err != nil {
+			log.Printf("Error resetting failed attempts: %v", err)
+		}
+
+		completeLogin(ctx, c, user, nil)
+	}
+}
+
+// SetupTwoFactorLogin lets accounts whose role requires two-factor enrol during login.
+func SetupTwoFactorLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var reqBody struct {
+			PreAuthToken string `json:"pre_auth_token" binding:"required"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		user, err := userFromPreAuthToken(ctx, reqBody.PreAuthToken)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": "Invalid pre-auth token", "details": err.Error()})
+			return
+		}
+
+		startTwoFactorSetup(ctx, c, user)
+	}
+}
+
+func ConfirmTwoFactorLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var reqBody struct {
+			PreAuthToken string `json:"pre_auth_token" binding:"required"`
+			Code         string `json:"code" binding:"required"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		user, err := userFromPreAuthToken(ctx, reqBody.PreAuthToken)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": "Invalid pre-auth token", "details": err.Error()})
+			return
+		}
+
+		accountKey, ipKey := attemptKeys("2fa", user.User_ID, c.ClientIP())
+		if !checkAttemptsAllowed(ctx, c, accountKey, ipKey) {
+			return
+		}
+		recoveryCodes, err := finishTwoFactorSetup(ctx, user, reqBody.Code)
+		if err != nil {
+			recordFailedAttempts(ctx, accountKey, ipKey, &user)
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Error enabling two-factor authentication", "details": err.Error()})
+			return
+		}
+		if err := helpers.ResetAttempts(ctx, LoginAttemptCollection, accountKey); err != nil {
+			log.Printf("Error resetting failed attempts: %v", err)
+		}
+
+		user.TwoFactorEnabled = true
+		completeLogin(ctx, c, user, gin.H{"recovery_codes": recoveryCodes})
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	database "nano_food_api/database"
@@ -22,6 +24,97 @@ import (
 
 var UserCollection *mongo.Collection = database.UserCollection
 var BranchCollection *mongo.Collection = database.BranchCollection
+var SessionCollection *mongo.Collection = database.SessionCollection
+var LoginAttemptCollection *mongo.Collection = database.LoginAttemptCollection
+
+const maxVerifyAttempts = 5
+
+// newVerificationCode generates a 6-digit email verification code and stores it
+// hashed, with a fresh expiry and attempt counter, on the user.
+func newVerificationCode(user *models.User) (string, error) {
+	code, err := helpers.GenerateNumericCode(6)
+	if err != nil {
+		return "", err
+	}
+
+	hashedCode, err := helpers.HashPassword(code)
+	if err != nil {
+		return "", err
+	}
+
+	codeExpire, err := strconv.Atoi(os.Getenv("VERIFICATION_CODE_EXPIRE"))
+	if err != nil {
+		codeExpire = 15
+	}
+
+	user.VerificationCode = hashedCode
+	user.VerifyExpiresAt = time.Now().Add(time.Duration(codeExpire) * time.Minute)
+	user.VerifyAttempts = 0
+	user.VerifySentAt = time.Now()
+
+	return code, nil
+}
+
+func sendVerificationEmail(ctx context.Context, email string, code string) error {
+	subject := "Your Verification Code From NanoFood"
+	body := fmt.Sprintf("Your verification code is: <b>%s</b>", code)
+	return enqueueEmail(ctx, email, subject, body)
+}
+
+// attemptKeys are the failed-attempt counters for an action: one for the account
+// and one for the caller's IP address.
+func attemptKeys(action string, account string, ip string) (string, string) {
+	return action + ":account:" + strings.ToLower(account), action + ":ip:" + ip
+}
+
+// checkAttemptsAllowed responds with 429 and returns false while the account or
+// the IP address is backing off or locked.
+func checkAttemptsAllowed(ctx context.Context, c *gin.Context, accountKey string, ipKey string) bool {
+	accountWait, err := helpers.CheckAttempts(ctx, LoginAttemptCollection, accountKey, helpers.AccountAttemptPolicy)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error checking failed attempts", "details": err.Error()})
+		return false
+	}
+	ipWait, err := helpers.CheckAttempts(ctx, LoginAttemptCollection, ipKey, helpers.IPAttemptPolicy)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error checking failed attempts", "details": err.Error()})
+		return false
+	}
+
+	wait := accountWait
+	if ipWait > wait {
+		wait = ipWait
+	}
+	if wait > 0 {
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"success":     false,
+			"error":       "Too many failed attempts, please try again later",
+			"retry_after": int(wait.Seconds()) + 1,
+		})
+		return false
+	}
+	return true
+}
+
+// recordFailedAttempts counts a failure for the account and the IP address, and
+// emails the account owner when this failure locked the account.
+func recordFailedAttempts(ctx context.Context, accountKey string, ipKey string, user *models.User) {
+	locked, err := helpers.RecordFailedAttempt(ctx, LoginAttemptCollection, accountKey, helpers.AccountAttemptPolicy)
+	if err != nil {
+		log.Printf("Error recording failed attempt: %v", err)
+	}
+	if locked && user != nil && user.Email != "" {
+		subject := "Your NanoFood Account Has Been Locked"
+		body := fmt.Sprintf("We temporarily locked your account after too many failed sign-in attempts. It will unlock automatically in %d minutes, or your manager can unlock it sooner. If this was not you, please change your password.", int(helpers.AccountAttemptPolicy.Lockout.Minutes()))
+		if emailErr := enqueueEmail(ctx, user.Email, subject, body); emailErr != nil {
+			log.Printf("Error queueing lockout email: %v", emailErr)
+		}
+	}
+
+	if _, err := helpers.RecordFailedAttempt(ctx, LoginAttemptCollection, ipKey, helpers.IPAttemptPolicy); err != nil {
+		log.Printf("Error recording failed attempt: %v", err)
+	}
+}
 
 func RegisterUser() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -34,6 +127,7 @@ func RegisterUser() gin.HandlerFunc {
 			return
 		}
 
+		user.Email = helpers.NormalizeEmail(user.Email)
 		if !govalidator.IsEmail(user.Email) {
 			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid email format!"})
 			return
@@ -62,22 +156,30 @@ func RegisterUser() gin.HandlerFunc {
 		}
 		user.Password = hashedPassword
 
-		verificationCode := fmt.Sprintf("%06d", time.Now().UnixNano()%1000000)
-		user.VerificationCode = verificationCode
+		verificationCode, codeErr := newVerificationCode(&user)
+		if codeErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error generating verification code", "details": codeErr.Error()})
+			return
+		}
 		user.IsVerified = false
+		user.TwoFactorEnabled = false
 		user.User_ID = primitive.NewObjectID().Hex()
 		user.Role = 0
 		user.Created_At = time.Now()
 		user.Updated_At = time.Now()
 
-		subject := "Your Verification Code From NanoFood"
-		body := fmt.Sprintf("Your verification code is: <b>%s</b>", verificationCode)
-		if emailErr := helpers.SendEmail(user.Email, subject, body); emailErr != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to send verification email", "details": emailErr.Error()})
+		// The account and its verification email are stored together; the email
+		// itself is sent by a job worker
+		err = helpers.RunInTransaction(ctx, database.Client, func(ctx context.Context) error {
+			if _, err := UserCollection.InsertOne(ctx, user); err != nil {
+				return err
+			}
+			return sendVerificationEmail(ctx, user.Email, verificationCode)
+		})
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"success": false, "error": "User already exists!"})
 			return
 		}
-
-		_, err = UserCollection.InsertOne(ctx, user)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error creating user", "details": err.Error()})
 			return
@@ -109,10 +211,17 @@ func VerifyUser() gin.HandlerFunc {
 			return
 		}
 
+		request.Email = helpers.NormalizeEmail(request.Email)
+		accountKey, ipKey := attemptKeys("verify", request.Email, c.ClientIP())
+		if !checkAttemptsAllowed(ctx, c, accountKey, ipKey) {
+			return
+		}
+
 		var user models.User
 		err := UserCollection.FindOne(ctx, bson.M{"email": request.Email}).Decode(&user)
 		if err != nil {
 			if err == mongo.ErrNoDocuments {
+				recordFailedAttempts(ctx, accountKey, ipKey, nil)
 				c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "User not found", "details": err.Error()})
 				return
 			}
@@ -121,90 +230,158 @@ func VerifyUser() gin.HandlerFunc {
 			return
 		}
 
-		if user.VerificationCode != request.VerificationCode {
-			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid verification code"})
+		if user.IsVerified {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "User already verified"})
 			return
 		}
 
-		update := bson.M{"$set": bson.M{"is_verified": true, "verification_code": ""}}
+		if user.VerificationCode == "" || user.VerifyAttempts >= maxVerifyAttempts || user.VerifyExpiresAt.Before(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Verification code has expired, please request a new one"})
+			return
+		}
+
+		if !helpers.CheckPassword(user.VerificationCode, request.VerificationCode) {
+			_, incErr := UserCollection.UpdateOne(ctx, bson.M{"_id": user.User_ID}, bson.M{"$inc": bson.M{"verify_attempts": 1}})
+			if incErr != nil {
+				log.Printf("Error counting verification attempt: %v", incErr)
+			}
+			recordFailedAttempts(ctx, accountKey, ipKey, &user)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success":            false,
+				"error":              "Invalid verification code",
+				"remaining_attempts": maxVerifyAttempts - user.VerifyAttempts - 1,
+			})
+			return
+		}
+
+		update := bson.M{
+			"$set":   bson.M{"is_verified": true, "updated_at": time.Now()},
+			"$unset": bson.M{"verification_code": "", "verify_expires_at": "", "verify_attempts": "", "verify_sent_at": ""},
+		}
 		_, updateErr := UserCollection.UpdateOne(ctx, bson.M{"_id": user.User_ID}, update)
 		if updateErr != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to verify user", "details": updateErr.Error()})
 			return
 		}
 
+		if err := helpers.ResetAttempts(ctx, LoginAttemptCollection, accountKey); err != nil {
+			log.Printf("Error resetting failed attempts: %v", err)
+		}
+
 		c.JSON(http.StatusOK, gin.H{"success": true, "message": "User verified successfully"})
 	}
 }
 
-func CreateUser() gin.HandlerFunc {
+// ResendVerificationCode replaces the pending code with a new one, at most once per cooldown.
+func ResendVerificationCode() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		var user models.User
-		if err := c.BindJSON(&user); err != nil {
+		var reqBody struct {
+			Email string `json:"email" binding:"required"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
 			return
 		}
 
-		if !helpers.Contains([]int{0, 1, 2}, user.Role) {
-			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid role! Allowed roles are 0, 1, or 2."})
-			return
+		response := gin.H{
+			"success": true,
+			"message": "If the account is awaiting verification, a new code has been sent.",
 		}
 
-		var branch models.Branch
-		err := BranchCollection.FindOne(ctx, bson.M{"_id": user.Branch_ID}).Decode(&branch)
+		var user models.User
+		err := UserCollection.FindOne(ctx, bson.M{"email": helpers.NormalizeEmail(reqBody.Email)}).Decode(&user)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving branch", "details": err.Error()})
+			if err != mongo.ErrNoDocuments {
+				log.Printf("Error retrieving user: %v", err)
+			}
+			c.JSON(http.StatusOK, response)
 			return
 		}
 
-		// Validate email and password
-		if !govalidator.IsEmail(user.Email) {
-			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid email format!"})
-			return
-		}
-		if len(user.Password) < 6 {
-			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Password must be at least 6 characters!"})
+		if user.IsVerified {
+			c.JSON(http.StatusOK, response)
 			return
 		}
 
-		// Check if user already exists
-		var existUser models.User
-		err = UserCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&existUser)
-		if err == nil {
-			c.JSON(http.StatusConflict, gin.H{"success": false, "error": "User already exists!"})
+		cooldown, err := strconv.Atoi(os.Getenv("VERIFICATION_RESEND_COOLDOWN"))
+		if err != nil {
+			cooldown = 60
+		}
+		nextAllowed := user.VerifySentAt.Add(time.Duration(cooldown) * time.Second)
+		if time.Now().Before(nextAllowed) {
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"success":     false,
+				"error":       "Please wait before requesting another code",
+				"retry_after": int(time.Until(nextAllowed).Seconds()) + 1,
+			})
 			return
 		}
-		if err != mongo.ErrNoDocuments {
-			log.Printf("Error retrieving user: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving user"})
+
+		verificationCode, err := newVerificationCode(&user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error generating verification code", "details": err.Error()})
 			return
 		}
 
-		// Hash the password
-		hashedPassword, hashErr := helpers.HashPassword(user.Password)
-		if hashErr != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error hashing password", "details": hashErr.Error()})
+		err = helpers.RunInTransaction(ctx, database.Client, func(ctx context.Context) error {
+			_, err := UserCollection.UpdateOne(
+				ctx,
+				bson.M{"_id": user.User_ID},
+				bson.M{"$set": bson.M{
+					"verification_code": user.VerificationCode,
+					"verify_expires_at": user.VerifyExpiresAt,
+					"verify_attempts":   user.VerifyAttempts,
+					"verify_sent_at":    user.VerifySentAt,
+				}},
+			)
+			if err != nil {
+				return err
+			}
+			return sendVerificationEmail(ctx, user.Email, verificationCode)
+		})
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"success": false, "error": "User already exists!"})
 			return
 		}
-		user.Password = hashedPassword
-
-		// Create user
-		user.User_ID = primitive.NewObjectID().Hex()
-		user.Created_At = time.Now()
-		user.Updated_At = time.Now()
-		user.IsVerified = true
-
-		_, err = UserCollection.InsertOne(ctx, user)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error creating user", "details": err.Error()})
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error saving verification code", "details": err.Error()})
 			return
 		}
 
-		c.JSON(http.StatusCreated, gin.H{"success": true, "message": "Created user and added to branch successfully"})
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// completeLogin starts a session for an authenticated user and responds with its tokens.
+func completeLogin(ctx context.Context, c *gin.Context, user models.User, extra gin.H) {
+	session, refreshToken, err := helpers.CreateSession(ctx, c, SessionCollection, user.User_ID)
+	if err != nil {
+		log.Printf("Error creating session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error creating session", "details": err.Error()})
+		return
+	}
+
+	accessToken, err := token.TokenGenerator(user.Email, user.User_ID, user.Role, user.Branch_ID, session.Session_ID)
+	if err != nil {
+		log.Printf("Error generating token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error generating token", "details": err.Error()})
+		return
+	}
+
+	response := gin.H{
+		"success":      true,
+		"message":      "Login successful",
+		"accessToken":  accessToken,
+		"refreshToken": refreshToken,
+		"user":         user,
+	}
+	for key, value := range extra {
+		response[key] = value
 	}
+	c.JSON(http.StatusOK, response)
 }
 
 func LoginUser() gin.HandlerFunc {
@@ -222,10 +399,17 @@ func LoginUser() gin.HandlerFunc {
 			return
 		}
 
+		loginData.Email = helpers.NormalizeEmail(loginData.Email)
+		accountKey, ipKey := attemptKeys("login", loginData.Email, c.ClientIP())
+		if !checkAttemptsAllowed(ctx, c, accountKey, ipKey) {
+			return
+		}
+
 		var user models.User
 		err := UserCollection.FindOne(ctx, bson.M{"email": loginData.Email}).Decode(&user)
 		if err != nil {
 			if err == mongo.ErrNoDocuments {
+				recordFailedAttempts(ctx, accountKey, ipKey, nil)
 				c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": "Invalid email", "details": err.Error()})
 				return
 			}
@@ -236,23 +420,44 @@ func LoginUser() gin.HandlerFunc {
 
 		isValidPassword := helpers.CheckPassword(user.Password, loginData.Password)
 		if !isValidPassword {
+			recordFailedAttempts(ctx, accountKey, ipKey, &user)
 			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": "Invalid password"})
 			return
 		}
 
-		accessToken, err := token.TokenGenerator(user.Email, user.User_ID, user.Role)
-		if err != nil {
-			log.Printf("Error generating token: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error generating token", "details": err.Error()})
+		if err := helpers.ResetAttempts(ctx, LoginAttemptCollection, accountKey); err != nil {
+			log.Printf("Error resetting failed attempts: %v", err)
+		}
+
+		if !user.IsVerified {
+			c.JSON(http.StatusForbidden, gin.H{
+				"success":     false,
+				"error":       "Email not verified",
+				"details":     "Please verify your email with the code we sent before logging in",
+				"is_verified": false,
+			})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{
-			"success":     true,
-			"message":     "Login successful",
-			"accessToken": accessToken,
-			"user":        user,
-		})
+		// Managers and owners with two-factor enabled (or required) finish logging in via /login/2fa
+		if user.TwoFactorEnabled || twoFactorRequired(user.Role) {
+			preAuthToken, err := token.PreAuthTokenGenerator(user.User_ID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error generating token", "details": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"success":                   true,
+				"message":                   "Two-factor authentication required",
+				"two_factor_required":       user.TwoFactorEnabled,
+				"two_factor_setup_required": !user.TwoFactorEnabled,
+				"preAuthToken":              preAuthToken,
+			})
+			return
+		}
+
+		completeLogin(ctx, c, user, nil)
 	}
 }
 
@@ -345,33 +550,12 @@ func UpdateUserInfo() gin.HandlerFunc {
 
 func UploadAvatar() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		app, err := helpers.InitializeFirebaseApp()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to initialize Firebase", "details": err.Error()})
-			return
-		}
-
-		client, err := app.Storage(context.Background())
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to connect to Firebase Storage", "details": err.Error()})
-			fmt.Printf("Firebase error >>> %v \n", err)
-			return
-		}
-
 		userInfo, _ := helpers.GetCurrentUser(c, UserCollection)
 
-		if userInfo.Avatar != "" {
-			deleteErr := helpers.DeleteFileFromFirebase(client, userInfo.Avatar)
-			if deleteErr != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to delete old avatar", "details": deleteErr.Error()})
-				return
-			}
-		}
-
 		avatarFile, avatarHeader, err := c.Request.FormFile("avatar")
 		if err == nil {
 			defer avatarFile.Close()
-			avatarURL, uploadErr := helpers.UploadFileToFirebase(client, avatarFile, fmt.Sprintf("avatars/%d_%s", time.Now().Unix(), avatarHeader.Filename))
+			avatarURL, uploadErr := storeUpload("avatars", avatarFile, avatarHeader)
 			if uploadErr != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to upload avatar", "details": uploadErr.Error()})
 				return
@@ -386,9 +570,18 @@ func UploadAvatar() gin.HandlerFunc {
 			var ctxUpdate, cancelUpdate = context.WithTimeout(context.Background(), 100*time.Second)
 			defer cancelUpdate()
 
+			// The old avatar is deleted once the new one is saved
 			filter := bson.M{"_id": user_id}
 			update := bson.M{"$set": bson.M{"avatar": avatarURL, "updated_at": time.Now()}}
-			_, err = UserCollection.UpdateOne(ctxUpdate, filter, update)
+			err = helpers.RunInTransaction(ctxUpdate, database.Client, func(ctx context.Context) error {
+				if _, err := UserCollection.UpdateOne(ctx, filter, update); err != nil {
+					return err
+				}
+				if userInfo.Avatar == "" {
+					return nil
+				}
+				return enqueueImageDeletion(ctx, []string{userInfo.Avatar})
+			})
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to update user avatar", "details": err.Error()})
 				return
@@ -454,10 +647,28 @@ func UpdateUserPassword() gin.HandlerFunc {
 			return
 		}
 
+		// Sign out every other device that still knows the old password
+		sessionID, _ := helpers.GetSessionIDFromMdw(c)
+		if err := helpers.RevokeUserSessions(ctx, SessionCollection, user_id, sessionID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error revoking sessions", "details": err.Error()})
+			return
+		}
+
 		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Password updated successfully"})
 	}
 }
 
+// assignableRoleLevels are the role levels a user of the given level may hand out.
+func assignableRoleLevels(role int) []int {
+	var availableRoles = []int{0, 1}
+	if role == 3 {
+		availableRoles = append(availableRoles, 2)
+	} else if role == 100 {
+		availableRoles = append(availableRoles, 2, 3)
+	}
+	return availableRoles
+}
+
 func UpdateUserRole() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
@@ -493,39 +704,43 @@ func UpdateUserRole() gin.HandlerFunc {
 			return
 		}
 
-		currentUser, err := helpers.GetCurrentUser(c, UserCollection)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+		if !authorizeBranch(c, branch.Branch_ID) {
 			return
 		}
 
-		if currentUser.Role != 100 {
-			if currentUser.Branch_ID != branch.Branch_ID {
-				c.JSON(http.StatusForbidden, gin.H{"success": false, "error": "You are not authorized to update user role"})
-				return
-			}
+		// Levels are judged by the caller's role in the active branch, not at home
+		scope, err := helpers.GetBranchScope(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
 		}
-
-		var availableRoles = []int{0, 1}
-		if currentUser.Role == 3 {
-			availableRoles = append(availableRoles, 2)
-		} else if currentUser.Role == 100 {
-			availableRoles = append(availableRoles, 2, 3)
+		if !helpers.Contains(assignableRoleLevels(scope.Role), user.Role) {
+			c.JSON(http.StatusForbidden, gin.H{"success": false, "error": "You are not authorized to change this user's role"})
+			return
 		}
-		if !helpers.Contains(availableRoles, roleData.Role) {
+		if !helpers.Contains(assignableRoleLevels(scope.Role), roleData.Role) {
 			c.JSON(http.StatusForbidden, gin.H{"success": false, "error": "You are not authorized to update user to this role"})
+			return
 		}
 
 		_, err = UserCollection.UpdateOne(
 			ctx,
 			bson.M{"_id": roleData.User_ID},
-			bson.M{"$set": bson.M{"role": roleData.Role, "updated_at": time.Now()}},
+			bson.M{
+				"$set":   bson.M{"role": roleData.Role, "updated_at": time.Now()},
+				"$unset": bson.M{"role_id": ""},
+			},
 		)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error updating user role", "details": err.Error()})
 			return
 		}
 
+		if err := helpers.RevokeUserSessions(ctx, SessionCollection, roleData.User_ID, ""); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error revoking sessions", "details": err.Error()})
+			return
+		}
+
 		c.JSON(http.StatusOK, gin.H{"success": true, "message": "User role updated successfully"})
 	}
 }
@@ -559,29 +774,29 @@ func UpdateUserBranch() gin.HandlerFunc {
 			return
 		}
 
-		currentUser, err := helpers.GetCurrentUser(c, UserCollection)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+		// Both the branch the user leaves and the one they join must be the caller's
+		if !authorizeBranch(c, user.Branch_ID) || !authorizeBranch(c, branch.Branch_ID) {
 			return
 		}
 
-		if currentUser.Role != 100 {
-			if currentUser.Branch_ID != branch.Branch_ID {
-				c.JSON(http.StatusForbidden, gin.H{"success": false, "error": "You are not authorized to update user branch"})
-				return
-			}
-		}
-
 		_, err = UserCollection.UpdateOne(
 			ctx,
 			bson.M{"_id": reqBody.User_ID},
-			bson.M{"$set": bson.M{"branch_id": branch.Branch_ID, "updated_at": time.Now()}},
+			bson.M{
+				"$set":   bson.M{"branch_id": branch.Branch_ID, "updated_at": time.Now()},
+				"$unset": bson.M{"role_id": ""},
+			},
 		)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error updating user branch", "details": err.Error()})
 			return
 		}
 
+		if err := helpers.RevokeUserSessions(ctx, SessionCollection, reqBody.User_ID, ""); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error revoking sessions", "details": err.Error()})
+			return
+		}
+
 		c.JSON(http.StatusOK, gin.H{"success": true, "message": "User branch updated successfully"})
 	}
 }
@@ -614,19 +829,10 @@ func DeleteUser() gin.HandlerFunc {
 			return
 		}
 
-		currentUser, err := helpers.GetCurrentUser(c, UserCollection)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+		if !authorizeBranch(c, branch.Branch_ID) {
 			return
 		}
 
-		if currentUser.Role != 100 {
-			if currentUser.Branch_ID != branch.Branch_ID {
-				c.JSON(http.StatusForbidden, gin.H{"success": false, "error": "You are not authorized to delete user"})
-				return
-			}
-		}
-
 		result, err := UserCollection.DeleteOne(ctx, bson.M{"_id": User_ID})
 		if err != nil {
 			log.Printf("Error deleting user: %v", err)
@@ -639,6 +845,10 @@ func DeleteUser() gin.HandlerFunc {
 			return
 		}
 
+		if err := helpers.RevokeUserSessions(ctx, SessionCollection, User_ID, ""); err != nil {
+			log.Printf("Error revoking sessions of deleted user: %v", err)
+		}
+
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
 			"message": "User deleted successfully",
@@ -661,21 +871,15 @@ func GetAllBranchUsers() gin.HandlerFunc {
 			return
 		}
 
-		currentUser, err := helpers.GetCurrentUser(c, UserCollection)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+		if !authorizeBranch(c, branch.Branch_ID) {
 			return
 		}
 
-		if currentUser.Role != 100 {
-			if currentUser.Branch_ID != branch.Branch_ID {
-				c.JSON(http.StatusForbidden, gin.H{"success": false, "error": "You are not authorized to view branch users"})
-				return
-			}
-		}
-
 		pipeline := mongo.Pipeline{
-			{{Key: "$match", Value: bson.M{"branch_id": branchID}}},
+			{{Key: "$match", Value: bson.M{"$or": bson.A{
+				bson.M{"branch_id": branchID},
+				bson.M{"memberships.branch_id": branchID},
+			}}}},
 			{{Key: "$lookup", Value: bson.M{
 				"from":         "branches",
 				"localField":   "branch_id",
@@ -750,3 +954,183 @@ func GetAllUsers() gin.HandlerFunc {
 		})
 	}
 }
+
+// ForgotPassword emails a single-use reset link. The response is the same whether
+// or not the email belongs to an account.
+func ForgotPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var reqBody struct {
+			Email string `json:"email" binding:"required"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		response := gin.H{
+			"success": true,
+			"message": "If an account exists for this email, a password reset link has been sent.",
+		}
+
+		var user models.User
+		err := UserCollection.FindOne(ctx, bson.M{"email": helpers.NormalizeEmail(reqBody.Email)}).Decode(&user)
+		if err != nil {
+			if err != mongo.ErrNoDocuments {
+				log.Printf("Error retrieving user: %v", err)
+			}
+			c.JSON(http.StatusOK, response)
+			return
+		}
+
+		resetToken, err := helpers.GenerateRandomToken(32)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error generating reset token", "details": err.Error()})
+			return
+		}
+
+		resetExpire, err := strconv.Atoi(os.Getenv("RESET_TOKEN_EXPIRE"))
+		if err != nil {
+			resetExpire = 30
+		}
+
+		resetLink := resetToken
+		if resetURL := os.Getenv("RESET_PASSWORD_URL"); resetURL != "" {
+			resetLink = resetURL + "?token=" + resetToken
+		}
+		subject := "Reset Your NanoFood Password"
+		body := fmt.Sprintf("Use the following link to reset your password: <b>%s</b><br/>It expires in %d minutes. If you did not request this, you can ignore this email.", resetLink, resetExpire)
+
+		// The email is queued, so response time does not reveal whether the
+		// account exists, in the same transaction that saves its token
+		err = helpers.RunInTransaction(ctx, database.Client, func(ctx context.Context) error {
+			_, err := UserCollection.UpdateOne(
+				ctx,
+				bson.M{"_id": user.User_ID},
+				bson.M{"$set": bson.M{
+					"reset_token":      helpers.HashToken(resetToken),
+					"reset_expires_at": time.Now().Add(time.Duration(resetExpire) * time.Minute),
+				}},
+			)
+			if err != nil {
+				return err
+			}
+			return enqueueEmail(ctx, user.Email, subject, body)
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error saving reset token", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+func ResetPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var reqBody struct {
+			Token       string `json:"token" binding:"required"`
+			NewPassword string `json:"new_password" binding:"required"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		if len(reqBody.NewPassword) < 6 {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Password must be at least 6 characters!"})
+			return
+		}
+
+		hashedPassword, err := helpers.HashPassword(reqBody.NewPassword)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error hashing new password", "details": err.Error()})
+			return
+		}
+
+		// Matching on the token while unsetting it makes the token single-use
+		var user models.User
+		err = UserCollection.FindOneAndUpdate(
+			ctx,
+			bson.M{
+				"reset_token":      helpers.HashToken(reqBody.Token),
+				"reset_expires_at": bson.M{"$gt": time.Now()},
+			},
+			bson.M{
+				"$set":   bson.M{"password": hashedPassword, "updated_at": time.Now()},
+				"$unset": bson.M{"reset_token": "", "reset_expires_at": ""},
+			},
+		).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid or expired reset token"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error resetting password", "details": err.Error()})
+			return
+		}
+
+		if err := helpers.RevokeUserSessions(ctx, SessionCollection, user.User_ID, ""); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error revoking sessions", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Password reset successfully. Please login with your new password."})
+	}
+}
+
+// UnlockUser clears the failed login, verification and PIN attempts of a staff
+// member so they can sign in again before the lockout runs out.
+func UnlockUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var reqBody struct {
+			User_ID string `json:"user_id" binding:"required"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		var user models.User
+		err := UserCollection.FindOne(ctx, bson.M{"_id": reqBody.User_ID}).Decode(&user)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "User not found", "details": err.Error()})
+			return
+		}
+
+		if !authorizeBranch(c, user.Branch_ID) {
+			return
+		}
+
+		loginKey, _ := attemptKeys("login", user.Email, "")
+		verifyKey, _ := attemptKeys("verify", user.Email, "")
+		pinKey, _ := attemptKeys("pin", user.User_ID, "")
+		twoFactorKey, _ := attemptKeys("2fa", user.User_ID, "")
+		if err := helpers.ResetAttempts(ctx, LoginAttemptCollection, loginKey, verifyKey, pinKey, twoFactorKey); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error unlocking user", "details": err.Error()})
+			return
+		}
+
+		// Recorded on the user so the unlock shows up in the audit log
+		unlockedBy, _ := helpers.GetUserIDFromMdw(c)
+		_, err = UserCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": user.User_ID},
+			bson.M{"$set": bson.M{"unlocked_at": time.Now(), "unlocked_by": unlockedBy, "updated_at": time.Now()}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error unlocking user", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "User unlocked successfully"})
+	}
+}
@@ -0,0 +1,489 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	database "nano_food_api/database"
+	helpers "nano_food_api/helpers"
+	models "nano_food_api/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var WebhookEndpointCollection *mongo.Collection = database.WebhookEndpointCollection
+var WebhookDeliveryCollection *mongo.Collection = database.WebhookDeliveryCollection
+
+var errWebhookEndpointGone = fmt.Errorf("endpoint was deleted or disabled")
+
+// publishWebhookEvent queues an event for every active endpoint of the branch
+// subscribed to it. Every endpoint gets the same payload, whose "id" identifies
+// the event. Callers pass the context of the transaction that makes the change
+// the event reports, so that the event is queued if and only if the change is
+// saved.
+func publishWebhookEvent(ctx context.Context, branchID string, event string, data interface{}) error {
+	cursor, err := WebhookEndpointCollection.Find(ctx, bson.M{"branch_id": branchID, "events": event, "is_active": true})
+	if err != nil {
+		return err
+	}
+	var endpoints []models.WebhookEndpoint
+	if err := cursor.All(ctx, &endpoints); err != nil {
+		return err
+	}
+	if len(endpoints) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(gin.H{
+		"id":         primitive.NewObjectID().Hex(),
+		"event":      event,
+		"branch_id":  branchID,
+		"created_at": time.Now(),
+		"data":       data,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, endpoint := range endpoints {
+		delivery := models.WebhookDelivery{
+			Delivery_ID: primitive.NewObjectID().Hex(),
+			Endpoint_ID: endpoint.Endpoint_ID,
+			Branch_ID:   branchID,
+			Event:       event,
+			Payload:     string(payload),
+		}
+		if _, err := insertWebhookDelivery(ctx, delivery); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertWebhookDelivery stores a pending delivery and the job that delivers it,
+// in the transaction of ctx.
+func insertWebhookDelivery(ctx context.Context, delivery models.WebhookDelivery) (models.WebhookDelivery, error) {
+	now := time.Now()
+	delivery.Status = helpers.WebhookPending
+	delivery.Attempts = []models.WebhookAttempt{}
+	delivery.Next_Attempt_At = now
+	delivery.Created_At = now
+	delivery.Updated_At = now
+
+	job, err := helpers.NewJob(helpers.JobDeliverWebhook, webhookJob{Delivery_ID: delivery.Delivery_ID})
+	if err != nil {
+		return delivery, err
+	}
+	job.Branch_ID = delivery.Branch_ID
+
+	if _, err := WebhookDeliveryCollection.InsertOne(ctx, delivery); err != nil {
+		return delivery, err
+	}
+	_, err = enqueueJob(ctx, job)
+	return delivery, err
+}
+
+// queueWebhookDelivery stores a pending delivery together with the job that
+// delivers it.
+func queueWebhookDelivery(ctx context.Context, delivery models.WebhookDelivery) (models.WebhookDelivery, error) {
+	err := helpers.RunInTransaction(ctx, database.Client, func(ctx context.Context) error {
+		var err error
+		delivery, err = insertWebhookDelivery(ctx, delivery)
+		return err
+	})
+	return delivery, err
+}
+
+type webhookJob struct {
+	Delivery_ID string `json:"delivery_id"`
+}
+
+func runDeliverWebhookJob(ctx context.Context, job models.Job) (interface{}, error) {
+	var payload webhookJob
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return nil, fmt.Errorf("%v: %w", err, errJobNoRetry)
+	}
+
+	var delivery models.WebhookDelivery
+	err := WebhookDeliveryCollection.FindOne(ctx, bson.M{"_id": payload.Delivery_ID, "status": helpers.WebhookPending}).Decode(&delivery)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, attemptWebhookDelivery(ctx, delivery)
+}
+
+// webhookSale copies a sale for a webhook payload without the gift card codes
+// it was made with, which are secrets.
+func webhookSale(sale models.Sale) models.Sale {
+	sale.GiftCard_Items = append([]models.SaleGiftCardItem(nil), sale.GiftCard_Items...)
+	for i := range sale.GiftCard_Items {
+		sale.GiftCard_Items[i].Code = ""
+	}
+	sale.GiftCard_Payments = append([]models.SaleGiftCardPayment(nil), sale.GiftCard_Payments...)
+	for i := range sale.GiftCard_Payments {
+		sale.GiftCard_Payments[i].Code = ""
+	}
+	return sale
+}
+
+// attemptWebhookDelivery sends a delivery once and logs the attempt. Failed
+// deliveries are retried by their job with exponential backoff and
+// dead-lettered after helpers.MaxWebhookAttempts attempts; the error returned
+// tells the job whether to retry.
+func attemptWebhookDelivery(ctx context.Context, delivery models.WebhookDelivery) error {
+	started := time.Now()
+	attempt := models.WebhookAttempt{Attempted_At: started}
+
+	var endpoint models.WebhookEndpoint
+	err := WebhookEndpointCollection.FindOne(ctx, bson.M{"_id": delivery.Endpoint_ID, "is_active": true}).Decode(&endpoint)
+	if err == nil {
+		attempt.Status_Code, err = helpers.SendWebhook(endpoint.URL, endpoint.Secret, delivery.Delivery_ID, delivery.Event, []byte(delivery.Payload))
+	} else if err == mongo.ErrNoDocuments {
+		err = errWebhookEndpointGone
+	}
+	attempt.Duration_Ms = time.Since(started).Milliseconds()
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+
+	set := bson.M{"updated_at": time.Now()}
+	update := bson.M{"$push": bson.M{"attempts": attempt}, "$set": set}
+	result := err
+	switch {
+	case err == nil:
+		set["status"] = helpers.WebhookDelivered
+		set["delivered_at"] = time.Now()
+		update["$unset"] = bson.M{"next_attempt_at": ""}
+	case err == errWebhookEndpointGone || len(delivery.Attempts)+1 >= helpers.MaxWebhookAttempts:
+		set["status"] = helpers.WebhookDead
+		update["$unset"] = bson.M{"next_attempt_at": ""}
+		result = fmt.Errorf("%v: %w", err, errJobNoRetry)
+	default:
+		set["next_attempt_at"] = time.Now().Add(helpers.WebhookRetryDelay(len(delivery.Attempts) + 1))
+	}
+
+	if _, err := WebhookDeliveryCollection.UpdateOne(ctx, bson.M{"_id": delivery.Delivery_ID}, update); err != nil {
+		log.Printf("Error logging attempt of webhook delivery %s: %v", delivery.Delivery_ID, err)
+	}
+	return result
+}
+
+// CreateWebhookEndpoint registers an endpoint for a branch's events. The
+// signing secret is generated here and shown only in this response.
+func CreateWebhookEndpoint() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var endpoint models.WebhookEndpoint
+		if err := c.BindJSON(&endpoint); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		if !authorizeBranch(c, endpoint.Branch_ID) {
+			return
+		}
+
+		endpoint.URL = strings.TrimSpace(endpoint.URL)
+		if err := helpers.ValidateWebhookEndpoint(endpoint.URL, endpoint.Events); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		secret, err := helpers.GenerateRandomToken(32)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error generating signing secret", "details": err.Error()})
+			return
+		}
+
+		endpoint.Endpoint_ID = primitive.NewObjectID().Hex()
+		endpoint.Secret = secret
+		endpoint.IsActive = true
+		endpoint.Created_By, _ = helpers.GetUserIDFromMdw(c)
+		endpoint.Created_At = time.Now()
+		endpoint.Updated_At = endpoint.Created_At
+
+		if _, err := WebhookEndpointCollection.InsertOne(ctx, endpoint); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error creating webhook endpoint", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{
+			"success": true,
+			"message": "Webhook endpoint created successfully. Store the secret now, it cannot be shown again.",
+			"data":    endpoint,
+			"secret":  secret,
+		})
+	}
+}
+
+// GetWebhookEndpoints lists the webhook endpoints of ?branch_id=.
+func GetWebhookEndpoints() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		if branchID := c.Query("branch_id"); branchID != "" {
+			filter["branch_id"] = branchID
+		}
+
+		filter, ok := scopedFilter(c, filter)
+		if !ok {
+			return
+		}
+
+		cursor, err := WebhookEndpointCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": 1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving webhook endpoints", "details": err.Error()})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		endpoints := []models.WebhookEndpoint{}
+		if err := cursor.All(ctx, &endpoints); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error decoding webhook endpoints", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Webhook endpoints retrieved successfully", "data": endpoints})
+	}
+}
+
+// UpdateWebhookEndpoint changes an endpoint's name, URL, events and whether it
+// is active. With "rotate_secret" a new signing secret is generated and shown
+// once.
+func UpdateWebhookEndpoint() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		endpointID := c.Param("endpoint_id")
+
+		var reqBody struct {
+			Name          string   `json:"name"`
+			URL           string   `json:"url"`
+			Events        []string `json:"events"`
+			IsActive      bool     `json:"is_active"`
+			Rotate_Secret bool     `json:"rotate_secret"`
+		}
+		if err := c.BindJSON(&reqBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		reqBody.URL = strings.TrimSpace(reqBody.URL)
+		if err := helpers.ValidateWebhookEndpoint(reqBody.URL, reqBody.Events); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+
+		filter, ok := scopedFilter(c, bson.M{"_id": endpointID})
+		if !ok {
+			return
+		}
+
+		updateFields := bson.M{
+			"name":       reqBody.Name,
+			"url":        reqBody.URL,
+			"events":     reqBody.Events,
+			"is_active":  reqBody.IsActive,
+			"updated_at": time.Now(),
+		}
+		secret := ""
+		if reqBody.Rotate_Secret {
+			var err error
+			secret, err = helpers.GenerateRandomToken(32)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error generating signing secret", "details": err.Error()})
+				return
+			}
+			updateFields["secret"] = secret
+		}
+
+		result, err := WebhookEndpointCollection.UpdateOne(ctx, filter, bson.M{"$set": updateFields})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error updating webhook endpoint", "details": err.Error()})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Webhook endpoint not found"})
+			return
+		}
+
+		response := gin.H{"success": true, "message": "Webhook endpoint updated successfully"}
+		if secret != "" {
+			response["secret"] = secret
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// DeleteWebhookEndpoint removes an endpoint. Its delivery log is kept, and its
+// pending deliveries are dead-lettered on their next attempt.
+func DeleteWebhookEndpoint() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter, ok := scopedFilter(c, bson.M{"_id": c.Param("endpoint_id")})
+		if !ok {
+			return
+		}
+
+		result, err := WebhookEndpointCollection.DeleteOne(ctx, filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error deleting webhook endpoint", "details": err.Error()})
+			return
+		}
+		if result.DeletedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Webhook endpoint not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Webhook endpoint deleted successfully"})
+	}
+}
+
+// respondWebhookDeliveries lists deliveries newest first, filtered by
+// ?branch_id=, ?endpoint_id= and ?event=, in pages of ?limit= (100 by default,
+// at most 500).
+func respondWebhookDeliveries(c *gin.Context, filter bson.M, message string) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	for _, key := range []string{"branch_id", "endpoint_id", "event"} {
+		if value := c.Query(key); value != "" {
+			filter[key] = value
+		}
+	}
+
+	filter, ok := scopedFilter(c, filter)
+	if !ok {
+		return
+	}
+
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit <= 0 {
+		limit = 100
+	}
+	if limit > 500 {
+		limit = 500
+	}
+	page, err := strconv.Atoi(c.Query("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	total, err := WebhookDeliveryCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error counting webhook deliveries", "details": err.Error()})
+		return
+	}
+
+	findOptions := options.Find().
+		SetSort(bson.M{"created_at": -1}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+	cursor, err := WebhookDeliveryCollection.Find(ctx, filter, findOptions)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error retrieving webhook deliveries", "details": err.Error()})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	deliveries := []models.WebhookDelivery{}
+	if err := cursor.All(ctx, &deliveries); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error decoding webhook deliveries", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": message,
+		"data":    deliveries,
+		"page":    page,
+		"limit":   limit,
+		"total":   total,
+	})
+}
+
+// GetWebhookDeliveries is the delivery log, with every attempt of every
+// delivery. It can also be filtered by ?status=.
+func GetWebhookDeliveries() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filter := bson.M{}
+		if status := c.Query("status"); status != "" {
+			filter["status"] = status
+		}
+		respondWebhookDeliveries(c, filter, "Webhook deliveries retrieved successfully")
+	}
+}
+
+// GetWebhookDeadLetters lists the deliveries that were given up on, to be
+// replayed once the endpoint is fixed.
+func GetWebhookDeadLetters() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		respondWebhookDeliveries(c, bson.M{"status": helpers.WebhookDead}, "Dead-lettered webhook deliveries retrieved successfully")
+	}
+}
+
+// ReplayWebhookDelivery sends a delivery's payload to its endpoint again as a
+// new delivery, with a fresh set of retries. The payload keeps its event ID, so
+// receivers can tell a replay from a new event.
+func ReplayWebhookDelivery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter, ok := scopedFilter(c, bson.M{"_id": c.Param("delivery_id")})
+		if !ok {
+			return
+		}
+
+		var original models.WebhookDelivery
+		if err := WebhookDeliveryCollection.FindOne(ctx, filter).Decode(&original); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Webhook delivery not found", "details": err.Error()})
+			return
+		}
+
+		endpointExists, err := helpers.CheckDataExist(ctx, WebhookEndpointCollection, bson.M{"_id": original.Endpoint_ID, "is_active": true})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to validate webhook endpoint", "details": err.Error()})
+			return
+		}
+		if !endpointExists {
+			c.JSON(http.StatusConflict, gin.H{"success": false, "error": "The webhook endpoint was deleted or is disabled"})
+			return
+		}
+
+		delivery, err := queueWebhookDelivery(ctx, models.WebhookDelivery{
+			Delivery_ID: primitive.NewObjectID().Hex(),
+			Endpoint_ID: original.Endpoint_ID,
+			Branch_ID:   original.Branch_ID,
+			Event:       original.Event,
+			Payload:     original.Payload,
+			Replay_Of:   original.Delivery_ID,
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error replaying webhook delivery", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"success": true, "message": "Webhook delivery replayed successfully", "data": delivery})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"testing"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -13,6 +14,16 @@ import (
 )
 
 func DBSet() *mongo.Client {
+	// Test binaries have no .env or server. They get a client that is never
+	// connected, so a handler under test fails fast on its first query.
+	if testing.Testing() {
+		client, err := mongo.NewClient(options.Client().ApplyURI("mongodb://localhost:27017"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		return client
+	}
+
 	err := godotenv.Load()
 	if err != nil {
 		log.Fatalf("Error loading .env file")
@@ -55,4 +66,23 @@ var MenuCollection *mongo.Collection = NanoFoodData(Client, "menus")
 var AddOnCollection *mongo.Collection = NanoFoodData(Client, "add_ons")
 var TableCollection *mongo.Collection = NanoFoodData(Client, "tables")
 var OrderCollection *mongo.Collection = NanoFoodData(Client, "orders")
+var PreorderSlotCollection *mongo.Collection = NanoFoodData(Client, "preorder_slots")
 var SaleCollection *mongo.Collection = NanoFoodData(Client, "sales")
+var SessionCollection *mongo.Collection = NanoFoodData(Client, "sessions")
+var TerminalCollection *mongo.Collection = NanoFoodData(Client, "terminals")
+var LoginAttemptCollection *mongo.Collection = NanoFoodData(Client, "login_attempts")
+var RoleCollection *mongo.Collection = NanoFoodData(Client, "roles")
+var InvitationCollection *mongo.Collection = NanoFoodData(Client, "invitations")
+var ShiftCollection *mongo.Collection = NanoFoodData(Client, "shifts")
+var TimeEntryCollection *mongo.Collection = NanoFoodData(Client, "time_entries")
+var AuditLogCollection *mongo.Collection = NanoFoodData(Client, "audit_logs")
+var CustomerCollection *mongo.Collection = NanoFoodData(Client, "customers")
+var CustomerOTPCollection *mongo.Collection = NanoFoodData(Client, "customer_otps")
+var LoyaltySettingsCollection *mongo.Collection = NanoFoodData(Client, "loyalty_settings")
+var LoyaltyTransactionCollection *mongo.Collection = NanoFoodData(Client, "loyalty_transactions")
+var GiftCardCollection *mongo.Collection = NanoFoodData(Client, "gift_cards")
+var GiftCardTransactionCollection *mongo.Collection = NanoFoodData(Client, "gift_card_transactions")
+var DeliveryPlatformCollection *mongo.Collection = NanoFoodData(Client, "delivery_platforms")
+var WebhookEndpointCollection *mongo.Collection = NanoFoodData(Client, "webhook_endpoints")
+var WebhookDeliveryCollection *mongo.Collection = NanoFoodData(Client, "webhook_deliveries")
+var JobCollection *mongo.Collection = NanoFoodData(Client, "jobs")
@@ -0,0 +1,106 @@
+package helpers
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"nano_food_api/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type AttemptPolicy struct {
+	FreeAttempts int           // failures allowed before backoff starts
+	MaxAttempts  int           // failures that lock the key
+	Lockout      time.Duration // how long a locked key stays locked
+	Window       time.Duration // failures older than this are forgotten
+}
+
+// AccountAttemptPolicy applies to a single account, IPAttemptPolicy to everything
+// coming from one address, which may be a whole restaurant behind one NAT.
+var AccountAttemptPolicy = AttemptPolicy{FreeAttempts: 3, MaxAttempts: 10, Lockout: 15 * time.Minute, Window: 15 * time.Minute}
+var IPAttemptPolicy = AttemptPolicy{FreeAttempts: 20, MaxAttempts: 100, Lockout: 15 * time.Minute, Window: 15 * time.Minute}
+
+const maxAttemptBackoff = 5 * time.Minute
+
+// CheckAttempts returns how long the caller has to wait before trying the key
+// again, zero when an attempt is allowed now.
+func CheckAttempts(ctx context.Context, attemptCollection *mongo.Collection, key string, policy AttemptPolicy) (time.Duration, error) {
+	var attempt models.LoginAttempt
+	err := attemptCollection.FindOne(ctx, bson.M{"_id": key}).Decode(&attempt)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	if attempt.Locked_Until.After(now) {
+		return attempt.Locked_Until.Sub(now), nil
+	}
+	if attempt.Last_Failure_At.Before(now.Add(-policy.Window)) || attempt.Failures < policy.FreeAttempts {
+		return 0, nil
+	}
+
+	// Exponential backoff: 1s, 2s, 4s ... after the free attempts are used up
+	backoff := time.Duration(math.Pow(2, float64(attempt.Failures-policy.FreeAttempts))) * time.Second
+	if backoff > maxAttemptBackoff {
+		backoff = maxAttemptBackoff
+	}
+	nextAttempt := attempt.Last_Failure_At.Add(backoff)
+	if nextAttempt.After(now) {
+		return nextAttempt.Sub(now), nil
+	}
+	return 0, nil
+}
+
+// RecordFailedAttempt counts a failure for the key and locks it once the policy's
+// maximum is reached. It reports true only for the failure that caused the lock.
+func RecordFailedAttempt(ctx context.Context, attemptCollection *mongo.Collection, key string, policy AttemptPolicy) (bool, error) {
+	now := time.Now()
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"failures": bson.M{"$cond": bson.A{
+				bson.M{"$lt": bson.A{"$last_failure_at", now.Add(-policy.Window)}},
+				1,
+				bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$failures", 0}}, 1}},
+			}},
+			"last_failure_at": now,
+		}}},
+	}
+
+	var attempt models.LoginAttempt
+	err := attemptCollection.FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": key},
+		update,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&attempt)
+	if err != nil {
+		return false, err
+	}
+
+	if attempt.Failures < policy.MaxAttempts {
+		return false, nil
+	}
+
+	// Start over after the lockout instead of staying in maximum backoff
+	_, err = attemptCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": key},
+		bson.M{"$set": bson.M{"failures": 0, "locked_until": now.Add(policy.Lockout)}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func ResetAttempts(ctx context.Context, attemptCollection *mongo.Collection, keys ...string) error {
+	_, err := attemptCollection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": keys}})
+	return err
+}
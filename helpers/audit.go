@@ -0,0 +1,235 @@
+package helpers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+
+	"nano_food_api/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const redactedValue = "[redacted]"
+
+// redactedFields hold secrets or their hashes and are never copied into the audit log.
+var redactedFields = map[string]bool{
+	"password":           true,
+	"pin":                true,
+	"token":              true,
+	"refresh_token":      true,
+	"previous_token":     true,
+	"reset_token":        true,
+	"device_token":       true,
+	"verification_code":  true,
+	"two_factor_secret":  true,
+	"two_factor_pending": true,
+	"recovery_codes":     true,
+	"qr_nonce":           true,
+	"webhook_secret":     true,
+	"secret":             true,
+	"payload":            true,
+}
+
+// DiffDocuments lists the top-level fields that differ between two versions of
+// a document, sorted by field. A nil document stands for one that does not
+// exist, so creates and deletes list every field. updated_at is left out.
+func DiffDocuments(before bson.M, after bson.M) []models.AuditChange {
+	fields := map[string]bool{}
+	for field := range before {
+		fields[field] = true
+	}
+	for field := range after {
+		fields[field] = true
+	}
+
+	names := []string{}
+	for field := range fields {
+		if field != "updated_at" {
+			names = append(names, field)
+		}
+	}
+	sort.Strings(names)
+
+	changes := []models.AuditChange{}
+	for _, field := range names {
+		from, to := before[field], after[field]
+		if reflect.DeepEqual(from, to) {
+			continue
+		}
+		if redactedFields[field] {
+			if from != nil {
+				from = redactedValue
+			}
+			if to != nil {
+				to = redactedValue
+			}
+		}
+		changes = append(changes, models.AuditChange{Field: field, From: from, To: to})
+	}
+	return changes
+}
+
+// EnsureAuditLogIndexes creates the unique sequence index that keeps the chain
+// linear when several instances append at once, and the indexes used by queries.
+func EnsureAuditLogIndexes(ctx context.Context, auditLogCollection *mongo.Collection) error {
+	_, err := auditLogCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "seq", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "branch_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "entity", Value: 1}, {Key: "entity_id", Value: 1}}},
+		{Keys: bson.D{{Key: "actor_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	return err
+}
+
+// hashAuditDocument keys the chain with AUDIT_LOG_KEY, which is kept out of the
+// database, so someone who can write to the database cannot recompute the hashes
+// of entries they edited.
+func hashAuditDocument(raw []byte) (string, error) {
+	key := os.Getenv("AUDIT_LOG_KEY")
+	if key == "" {
+		return "", fmt.Errorf("AUDIT_LOG_KEY environment variable not set")
+	}
+
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(raw)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// withoutHash copies a stored entry without its hash element, keeping the bytes
+// of every other element as they are.
+func withoutHash(raw bson.Raw) (bson.D, error) {
+	elements, err := raw.Elements()
+	if err != nil {
+		return nil, err
+	}
+
+	doc := bson.D{}
+	for _, element := range elements {
+		if element.Key() != "hash" {
+			doc = append(doc, bson.E{Key: element.Key(), Value: element.Value()})
+		}
+	}
+	return doc, nil
+}
+
+// AppendAuditLog adds an entry at the end of the chain. Its hash covers the
+// exact BSON that is stored, including the hash of the previous entry. The new
+// head is also written to the application log, outside the database, so that
+// entries deleted from the end of the chain can be noticed.
+func AppendAuditLog(ctx context.Context, auditLogCollection *mongo.Collection, entry models.AuditLog) (models.AuditLog, error) {
+	for attempt := 0; attempt < 5; attempt++ {
+		var last models.AuditLog
+		err := auditLogCollection.FindOne(ctx, bson.M{}, options.FindOne().SetSort(bson.M{"seq": -1})).Decode(&last)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return entry, err
+		}
+
+		entry.Seq = last.Seq + 1
+		entry.Prev_Hash = last.Hash
+		entry.Hash = ""
+
+		raw, err := bson.Marshal(entry)
+		if err != nil {
+			return entry, err
+		}
+		doc, err := withoutHash(raw)
+		if err != nil {
+			return entry, err
+		}
+		entry.Hash, err = hashAuditDocument(raw)
+		if err != nil {
+			return entry, err
+		}
+		doc = append(doc, bson.E{Key: "hash", Value: entry.Hash})
+
+		_, err = auditLogCollection.InsertOne(ctx, doc)
+		if mongo.IsDuplicateKeyError(err) {
+			// Another instance took this sequence number, link to its entry instead
+			continue
+		}
+		if err == nil {
+			log.Printf("Audit log head: seq=%d hash=%s", entry.Seq, entry.Hash)
+		}
+		return entry, err
+	}
+	return entry, fmt.Errorf("could not append audit log entry after several attempts")
+}
+
+// AuditChainStatus is the result of checking the audit log for tampering. The
+// head is the last entry checked; if it is older than the last head in the
+// application log, entries were deleted from the end.
+type AuditChainStatus struct {
+	Checked    int64  `json:"checked"`
+	Valid      bool   `json:"valid"`
+	Broken_Seq int64  `json:"broken_seq,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	Head_Seq   int64  `json:"head_seq"`
+	Head_Hash  string `json:"head_hash,omitempty"`
+}
+
+// VerifyAuditChain walks the audit log in order and reports the first entry that
+// was edited, or that follows a deleted entry.
+func VerifyAuditChain(ctx context.Context, auditLogCollection *mongo.Collection) (AuditChainStatus, error) {
+	status := AuditChainStatus{Valid: true}
+
+	cursor, err := auditLogCollection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"seq": 1}))
+	if err != nil {
+		return status, err
+	}
+	defer cursor.Close(ctx)
+
+	var prevSeq int64
+	prevHash := ""
+	for cursor.Next(ctx) {
+		raw := cursor.Current
+		seq, _ := raw.Lookup("seq").AsInt64OK()
+		storedHash, _ := raw.Lookup("hash").StringValueOK()
+		storedPrev, _ := raw.Lookup("prev_hash").StringValueOK()
+
+		broken := func(reason string) (AuditChainStatus, error) {
+			status.Valid = false
+			status.Broken_Seq = seq
+			status.Reason = reason
+			return status, nil
+		}
+
+		if seq != prevSeq+1 {
+			return broken(fmt.Sprintf("entries %d to %d are missing", prevSeq+1, seq-1))
+		}
+		if storedPrev != prevHash {
+			return broken("entry does not link to the previous entry")
+		}
+
+		doc, err := withoutHash(raw)
+		if err != nil {
+			return status, err
+		}
+		content, err := bson.Marshal(doc)
+		if err != nil {
+			return status, err
+		}
+		hash, err := hashAuditDocument(content)
+		if err != nil {
+			return status, err
+		}
+		if !hmac.Equal([]byte(hash), []byte(storedHash)) {
+			return broken("entry was modified after it was written")
+		}
+
+		status.Checked++
+		status.Head_Seq = seq
+		status.Head_Hash = storedHash
+		prevSeq = seq
+		prevHash = storedHash
+	}
+	return status, cursor.Err()
+}
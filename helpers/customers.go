@@ -0,0 +1,19 @@
+package helpers
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsureCustomerOTPIndexes lets MongoDB remove sign-in codes that expired
+// without being used.
+func EnsureCustomerOTPIndexes(ctx context.Context, customerOTPCollection *mongo.Collection) error {
+	_, err := customerOTPCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
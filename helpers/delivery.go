@@ -0,0 +1,162 @@
+package helpers
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"nano_food_api/models"
+)
+
+const earthRadiusKm = 6371.0
+
+// DeliveryQuote is what delivering to an address costs and how long it takes.
+type DeliveryQuote struct {
+	Zone_ID           string    `json:"zone_id"`
+	Zone              string    `json:"zone"`
+	Distance_Km       float64   `json:"distance_km"`
+	Fee               float64   `json:"fee"`
+	Min_Order         float64   `json:"min_order"`
+	Estimated_Minutes int       `json:"estimated_minutes"`
+	Estimated_At      time.Time `json:"estimated_at"`
+}
+
+// DistanceKm is the great-circle distance between two coordinates.
+func DistanceKm(from models.GeoPoint, to models.GeoPoint) float64 {
+	toRadians := func(degrees float64) float64 { return degrees * math.Pi / 180 }
+
+	dLat := toRadians(to.Latitude - from.Latitude)
+	dLng := toRadians(to.Longitude - from.Longitude)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRadians(from.Latitude))*math.Cos(toRadians(to.Latitude))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return earthRadiusKm * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// InPolygon tells whether a point lies inside a polygon, by ray casting. The
+// polygon is closed implicitly.
+func InPolygon(point models.GeoPoint, polygon []models.GeoPoint) bool {
+	inside := false
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		a, b := polygon[i], polygon[j]
+		if (a.Latitude > point.Latitude) != (b.Latitude > point.Latitude) &&
+			point.Longitude < (b.Longitude-a.Longitude)*(point.Latitude-a.Latitude)/(b.Latitude-a.Latitude)+a.Longitude {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+func validCoordinate(point models.GeoPoint) bool {
+	return point.Latitude >= -90 && point.Latitude <= 90 && point.Longitude >= -180 && point.Longitude <= 180
+}
+
+// ValidateDeliveryZones checks a branch's delivery area. Fee bands are sorted
+// by distance as a side effect.
+func ValidateDeliveryZones(branchPoint models.GeoPoint, radiusKm float64, zones []models.DeliveryZone) error {
+	if radiusKm < 0 {
+		return fmt.Errorf("delivery radius cannot be negative")
+	}
+	if len(zones) > 0 && !validCoordinate(branchPoint) {
+		return fmt.Errorf("invalid branch coordinates")
+	}
+
+	for i := range zones {
+		zone := &zones[i]
+		if strings.TrimSpace(zone.Name) == "" {
+			return fmt.Errorf("zone name is required")
+		}
+		if len(zone.Polygon) == 0 && zone.Radius_Km <= 0 {
+			return fmt.Errorf("zone %s needs a radius or a polygon", zone.Name)
+		}
+		if len(zone.Polygon) > 0 && len(zone.Polygon) < 3 {
+			return fmt.Errorf("the polygon of zone %s needs at least 3 points", zone.Name)
+		}
+		for _, point := range zone.Polygon {
+			if !validCoordinate(point) {
+				return fmt.Errorf("zone %s has invalid coordinates", zone.Name)
+			}
+		}
+		if zone.Min_Order < 0 || zone.Fee < 0 || zone.Base_Minutes < 0 || zone.Minutes_Per_Km < 0 {
+			return fmt.Errorf("zone %s has a negative amount", zone.Name)
+		}
+
+		sort.Slice(zone.Fee_Bands, func(a, b int) bool { return zone.Fee_Bands[a].Up_To_Km < zone.Fee_Bands[b].Up_To_Km })
+		for _, band := range zone.Fee_Bands {
+			if band.Up_To_Km <= 0 || band.Fee < 0 {
+				return fmt.Errorf("zone %s has an invalid fee band", zone.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// zoneFee is the fee of a zone for a delivery over the given distance, and
+// whether the zone's fee bands reach that far.
+func zoneFee(zone models.DeliveryZone, distanceKm float64) (float64, bool) {
+	if len(zone.Fee_Bands) == 0 {
+		return zone.Fee, true
+	}
+	for _, band := range zone.Fee_Bands {
+		if distanceKm <= band.Up_To_Km {
+			return band.Fee, true
+		}
+	}
+	return 0, false
+}
+
+// DeliveryDistance is how far an address is from a branch. Addresses without
+// valid coordinates or beyond the branch's radius are rejected.
+func DeliveryDistance(branch models.Branch, address models.GeoPoint) (float64, error) {
+	if !validCoordinate(address) || (address.Latitude == 0 && address.Longitude == 0) {
+		return 0, fmt.Errorf("the delivery address needs valid coordinates")
+	}
+
+	distance := DistanceKm(models.GeoPoint{Latitude: branch.Latitude, Longitude: branch.Longitude}, address)
+	if branch.Delivery_Radius > 0 && distance > branch.Delivery_Radius {
+		return 0, fmt.Errorf("the address is %.1f km away, beyond the %.1f km delivery radius", distance, branch.Delivery_Radius)
+	}
+	return distance, nil
+}
+
+// QuoteDelivery finds the first of a branch's zones that covers the address and
+// prices a delivery of an order worth subtotal there. Addresses beyond the
+// branch's radius or outside every zone, and orders below the zone's minimum,
+// are rejected.
+func QuoteDelivery(branch models.Branch, address models.GeoPoint, subtotal float64, now time.Time) (DeliveryQuote, error) {
+	distance, err := DeliveryDistance(branch, address)
+	if err != nil {
+		return DeliveryQuote{}, err
+	}
+
+	for _, zone := range branch.Delivery_Zones {
+		covered := distance <= zone.Radius_Km
+		if len(zone.Polygon) > 0 {
+			covered = InPolygon(address, zone.Polygon)
+		}
+		if !covered {
+			continue
+		}
+
+		fee, ok := zoneFee(zone, distance)
+		if !ok {
+			continue
+		}
+		if subtotal < zone.Min_Order {
+			return DeliveryQuote{}, fmt.Errorf("the minimum order for delivery to %s is %.2f", zone.Name, zone.Min_Order)
+		}
+
+		minutes := zone.Base_Minutes + int(math.Ceil(distance*zone.Minutes_Per_Km))
+		return DeliveryQuote{
+			Zone_ID:           zone.Zone_ID,
+			Zone:              zone.Name,
+			Distance_Km:       math.Round(distance*100) / 100,
+			Fee:               roundCents(fee),
+			Min_Order:         zone.Min_Order,
+			Estimated_Minutes: minutes,
+			Estimated_At:      now.Add(time.Duration(minutes) * time.Minute),
+		}, nil
+	}
+	return DeliveryQuote{}, fmt.Errorf("the address is outside the delivery area")
+}
@@ -0,0 +1,77 @@
+package helpers
+
+import (
+	"context"
+	"crypto/rand"
+	"math/big"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Gift card statuses and transaction types, see models.GiftCard and
+// models.GiftCardTransaction.
+const (
+	GiftCardActive   = "001"
+	GiftCardDisabled = "002"
+
+	GiftCardLoaded   = "001"
+	GiftCardRedeemed = "002"
+	GiftCardReturned = "003"
+	GiftCardVoided   = "004"
+)
+
+// giftCardAlphabet leaves out characters that are easily misread, like 0/O and 1/I.
+const giftCardAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// GenerateGiftCardCode returns a random 16 character code in groups of four,
+// e.g. "K7PX-2MQD-9RTA-WC4N".
+func GenerateGiftCardCode() (string, error) {
+	var code strings.Builder
+	for i := 0; i < 16; i++ {
+		if i > 0 && i%4 == 0 {
+			code.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(giftCardAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		code.WriteByte(giftCardAlphabet[n.Int64()])
+	}
+	return code.String(), nil
+}
+
+// NormalizeGiftCardCode uppercases a typed code and strips its spaces and dashes.
+func NormalizeGiftCardCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(code)))
+}
+
+// HashGiftCardCode is what gift cards are stored and looked up by.
+func HashGiftCardCode(code string) string {
+	return HashToken(NormalizeGiftCardCode(code))
+}
+
+// GiftCardLast4 is the part of a code that is safe to print on receipts.
+func GiftCardLast4(code string) string {
+	code = NormalizeGiftCardCode(code)
+	if len(code) < 4 {
+		return code
+	}
+	return code[len(code)-4:]
+}
+
+// RoundAmount rounds an amount of money to cents.
+func RoundAmount(amount float64) float64 {
+	return roundCents(amount)
+}
+
+// EnsureGiftCardIndexes makes gift card codes unique.
+func EnsureGiftCardIndexes(ctx context.Context, giftCardCollection *mongo.Collection) error {
+	_, err := giftCardCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "code_hash", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
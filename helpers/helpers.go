@@ -1,27 +1,35 @@
 package helpers
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
-	"io"
 	"log"
+	"math/big"
+	"net/http"
 	"os"
 	"strconv"
 	"strings"
 	"time"
 
 	"nano_food_api/models"
+	token "nano_food_api/tokens"
 
-	cloudStorage "cloud.google.com/go/storage"
-	firebase "firebase.google.com/go"
-	"firebase.google.com/go/storage"
+	"github.com/avct/uasurfer"
 	"github.com/gin-gonic/gin"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	qrcode "github.com/skip2/go-qrcode"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
-	"google.golang.org/api/option"
 	"gopkg.in/gomail.v2"
 )
 
@@ -38,77 +46,117 @@ func CheckPassword(hashedPassword, plainPassword string) bool {
 	return err == nil
 }
 
-func InitializeFirebaseApp() (*firebase.App, error) {
-	serviceAccountKeyPath := "keys/se-reactjs-firebase-adminsdk.json"
-	opt := option.WithCredentialsFile(serviceAccountKeyPath)
-
-	config := &firebase.Config{
-		StorageBucket: os.Getenv("STORAGE_BUCKET"),
+func GenerateRandomToken(byteLength int) (string, error) {
+	b := make([]byte, byteLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
 	}
+	return hex.EncodeToString(b), nil
+}
 
-	app, err := firebase.NewApp(context.Background(), config, opt)
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize Firebase app: %v", err)
+// GenerateNumericCode returns a uniformly random code of the given number of digits.
+func GenerateNumericCode(digits int) (string, error) {
+	code := make([]byte, digits)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		code[i] = byte('0' + n.Int64())
 	}
+	return string(code), nil
+}
 
-	return app, nil
+// HashToken is used for secrets that are looked up by value (refresh tokens,
+// reset tokens) and therefore cannot be salted like passwords.
+func HashToken(plainToken string) string {
+	hash := sha256.Sum256([]byte(plainToken))
+	return hex.EncodeToString(hash[:])
 }
 
-func UploadFileToFirebase(client *storage.Client, file io.Reader, filename string) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*50)
-	defer cancel()
+// ParseUserAgent returns the device type, OS and browser of a User-Agent header,
+// e.g. "Phone", "iOS 17.4", "Safari 17.4".
+func ParseUserAgent(userAgent string) (deviceType string, osName string, browser string) {
+	ua := uasurfer.Parse(userAgent)
 
-	bucket, err := client.DefaultBucket()
-	if err != nil {
-		return "", err
+	deviceType = ua.DeviceType.StringTrimPrefix()
+	osName = ua.OS.Name.StringTrimPrefix()
+	if ua.OS.Version.Major > 0 {
+		osName = fmt.Sprintf("%s %d.%d", osName, ua.OS.Version.Major, ua.OS.Version.Minor)
+	}
+	browser = ua.Browser.Name.StringTrimPrefix()
+	if ua.Browser.Version.Major > 0 {
+		browser = fmt.Sprintf("%s %d.%d", browser, ua.Browser.Version.Major, ua.Browser.Version.Minor)
 	}
 
-	writer := bucket.Object(filename).NewWriter(ctx)
-	writer.ContentType = "image/" + strings.Split(filename, ".")[1]
+	return deviceType, osName, browser
+}
 
-	if _, err := io.Copy(writer, file); err != nil {
-		return "", err
+// NewSession describes a login of the user from the requesting device. The
+// caller sets the expiry and credentials before storing it.
+func NewSession(c *gin.Context, userId string) models.Session {
+	userAgent := c.Request.UserAgent()
+	deviceType, osName, browser := ParseUserAgent(userAgent)
+
+	return models.Session{
+		Session_ID:   primitive.NewObjectID().Hex(),
+		User_ID:      userId,
+		DeviceType:   deviceType,
+		OS:           osName,
+		Browser:      browser,
+		UserAgent:    userAgent,
+		IP:           c.ClientIP(),
+		IsRevoked:    false,
+		Last_Seen_At: time.Now(),
+		Created_At:   time.Now(),
+		Updated_At:   time.Now(),
 	}
+}
 
-	if err := writer.Close(); err != nil {
-		return "", err
+// CreateSession starts a login session for the user on the requesting device and
+// returns it together with the plain refresh token, which is never stored.
+func CreateSession(ctx context.Context, c *gin.Context, sessionCollection *mongo.Collection, userId string) (models.Session, string, error) {
+	refreshToken, err := GenerateRandomToken(32)
+	if err != nil {
+		return models.Session{}, "", err
 	}
 
-	object := bucket.Object(filename)
-	acl := object.ACL()
-	if err := acl.Set(ctx, cloudStorage.AllUsers, cloudStorage.RoleReader); err != nil {
-		return "", fmt.Errorf("failed to set public read access: %v", err)
-	}
+	session := NewSession(c, userId)
+	session.RefreshToken = HashToken(refreshToken)
+	session.Expires_At = token.RefreshTokenExpiry()
 
-	bucketAttrs, err := bucket.Attrs(ctx)
+	_, err = sessionCollection.InsertOne(ctx, session)
 	if err != nil {
-		return "", err
+		return models.Session{}, "", err
 	}
 
-	return fmt.Sprintf("https://storage.googleapis.com/%s/%s", bucketAttrs.Name, filename), nil
+	return session, refreshToken, nil
 }
 
-func DeleteFileFromFirebase(client *storage.Client, filePath string) error {
-	ctx := context.Background()
-
-	bucket, err := client.DefaultBucket()
-	if err != nil {
-		return fmt.Errorf("failed to get default bucket: %v", err)
+// RevokeUserSessions logs the user out everywhere, except for exceptSessionId
+// when it is not empty.
+func RevokeUserSessions(ctx context.Context, sessionCollection *mongo.Collection, userId string, exceptSessionId string) error {
+	filter := bson.M{"user_id": userId, "is_revoked": false}
+	if exceptSessionId != "" {
+		filter["_id"] = bson.M{"$ne": exceptSessionId}
 	}
 
-	storageBucket := os.Getenv("STORAGE_BUCKET") + "/"
-
-	newFilePatch := strings.Split(filePath, storageBucket)[1]
-	fmt.Println("Deleting file from path: ", newFilePatch)
+	_, err := sessionCollection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"is_revoked": true, "updated_at": time.Now()}})
+	return err
+}
 
-	object := bucket.Object(newFilePatch)
+func GetSessionIDFromMdw(c *gin.Context) (string, error) {
+	sessionIDFromMdw, exists := c.Get("sessionId")
+	if !exists {
+		return "", fmt.Errorf("session ID not found in request context")
+	}
 
-	err = object.Delete(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to delete file from Firebase Storage: %v", err)
+	sessionIDStr, ok := sessionIDFromMdw.(string)
+	if !ok {
+		return "", fmt.Errorf("invalid session ID format")
 	}
 
-	return nil
+	return sessionIDStr, nil
 }
 
 func SendEmail(recept_mail string, subject string, body string) error {
@@ -137,6 +185,41 @@ func SendEmail(recept_mail string, subject string, body string) error {
 	return nil
 }
 
+// SendSMS posts a text message to the SMS gateway at SMS_GATEWAY_URL as JSON
+// {"to", "message"}, authenticated with SMS_GATEWAY_TOKEN.
+func SendSMS(phone string, message string) error {
+	gatewayURL := os.Getenv("SMS_GATEWAY_URL")
+	if gatewayURL == "" {
+		return fmt.Errorf("SMS gateway is not configured")
+	}
+
+	payload, err := json.Marshal(map[string]string{"to": phone, "message": message})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, gatewayURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if gatewayToken := os.Getenv("SMS_GATEWAY_TOKEN"); gatewayToken != "" {
+		req.Header.Set("Authorization", "Bearer "+gatewayToken)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("SMS gateway responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
 func GetUserIDFromMdw(c *gin.Context) (user_obj_id string, err error) {
 	userIDFromMdw, exists := c.Get("userId")
 	if !exists {
@@ -195,6 +278,15 @@ func Contains(slice []int, item int) bool {
 	return false
 }
 
+func ContainsString(slice []string, item string) bool {
+	for _, v := range slice {
+		if v == item {
+			return true
+		}
+	}
+	return false
+}
+
 func ParseFloat(value string) float64 {
 	result, err := strconv.ParseFloat(value, 64)
 	if err != nil {
@@ -202,3 +294,89 @@ func ParseFloat(value string) float64 {
 	}
 	return result
 }
+
+func GenerateQRCodePNG(content string, size int) ([]byte, error) {
+	return qrcode.Encode(content, qrcode.Medium, size)
+}
+
+func GenerateQRCodeSVG(content string, size int) ([]byte, error) {
+	qr, err := qrcode.New(content, qrcode.Medium)
+	if err != nil {
+		return nil, err
+	}
+
+	bitmap := qr.Bitmap()
+	modules := len(bitmap)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size, size, modules, modules)
+	fmt.Fprintf(&sb, `<rect width="%d" height="%d" fill="#ffffff"/>`, modules, modules)
+	sb.WriteString(`<path fill="#000000" d="`)
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&sb, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	sb.WriteString(`"/></svg>`)
+
+	return []byte(sb.String()), nil
+}
+
+// GenerateTOTPKey creates a new authenticator secret for the account.
+func GenerateTOTPKey(accountName string) (*otp.Key, error) {
+	issuer := os.Getenv("TOTP_ISSUER")
+	if issuer == "" {
+		issuer = "NanoFood"
+	}
+	return totp.Generate(totp.GenerateOpts{Issuer: issuer, AccountName: accountName})
+}
+
+// ValidateTOTP checks a code against the current, previous and next 30 second
+// step. Steps up to lastStep are rejected so a code cannot be replayed; the
+// matched step is returned to be stored as the new lastStep.
+func ValidateTOTP(secret string, code string, lastStep int64) (int64, bool) {
+	now := time.Now().UTC()
+	for skew := int64(-1); skew <= 1; skew++ {
+		step := now.Unix()/30 + skew
+		if step <= lastStep {
+			continue
+		}
+
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(step*30, 0).UTC(), totp.ValidateOpts{
+			Period:    30,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(strings.TrimSpace(code))) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// GenerateRecoveryCodes returns plain codes to show the user once, and their
+// bcrypt hashes to store.
+func GenerateRecoveryCodes(count int) ([]string, []string, error) {
+	codes := make([]string, 0, count)
+	hashedCodes := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		raw, err := GenerateRandomToken(5)
+		if err != nil {
+			return nil, nil, err
+		}
+		code := raw[:5] + "-" + raw[5:]
+
+		hashedCode, err := HashPassword(code)
+		if err != nil {
+			return nil, nil, err
+		}
+		codes = append(codes, code)
+		hashedCodes = append(hashedCodes, hashedCode)
+	}
+	return codes, hashedCodes, nil
+}
@@ -0,0 +1,132 @@
+package helpers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"nano_food_api/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Job types and statuses, see models.Job.
+const (
+	JobSendEmail         = "email.send"
+	JobSendSMS           = "sms.send"
+	JobDeliverWebhook    = "webhook.deliver"
+	JobDeleteImages      = "image.delete"
+	JobGenerateReport    = "report.generate"
+	JobPreorderReminders = "preorder.reminders"
+
+	JobQueued    = "001"
+	JobRunning   = "002"
+	JobCompleted = "003"
+	JobFailed    = "004"
+	JobCancelled = "005"
+)
+
+const defaultJobMaxAttempts = 5
+
+// NewJob builds a job of the given type that runs as soon as a worker is free.
+// The payload is stored as JSON.
+func NewJob(jobType string, payload interface{}) (models.Job, error) {
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return models.Job{}, err
+	}
+	return models.Job{Type: jobType, Payload: string(encoded)}, nil
+}
+
+// EnqueueJob stores a job for the workers. Called with the context of a
+// transaction, see RunInTransaction, the job is only queued if the
+// transaction commits. Jobs with a Unique_Key that is already queued are
+// skipped.
+func EnqueueJob(ctx context.Context, jobCollection *mongo.Collection, job models.Job) (models.Job, error) {
+	now := time.Now()
+	job.Job_ID = primitive.NewObjectID().Hex()
+	job.Status = JobQueued
+	job.Attempts = 0
+	if job.Max_Attempts <= 0 {
+		job.Max_Attempts = defaultJobMaxAttempts
+	}
+	if job.Run_At.IsZero() {
+		job.Run_At = now
+	}
+	job.Created_At = now
+	job.Updated_At = now
+
+	_, err := jobCollection.InsertOne(ctx, job)
+	if job.Unique_Key != "" && mongo.IsDuplicateKeyError(err) {
+		return job, nil
+	}
+	return job, err
+}
+
+// ExponentialDelay doubles base for every attempt after the first, up to max.
+func ExponentialDelay(base time.Duration, max time.Duration, attempts int) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// JobRetryDelay is how long a job waits after the given number of failed
+// attempts: 10s, 20s, 40s and so on, up to an hour.
+func JobRetryDelay(attempts int) time.Duration {
+	return ExponentialDelay(10*time.Second, time.Hour, attempts)
+}
+
+// RunInTransaction runs fn in a transaction, so that a business change and the
+// jobs it queues are written together or not at all. fn must use the context
+// it is given. See CheckTransactionSupport.
+func RunInTransaction(ctx context.Context, client *mongo.Client, fn func(ctx context.Context) error) error {
+	session, err := client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessionCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessionCtx)
+	})
+	return err
+}
+
+// CheckTransactionSupport fails unless the deployment is a replica set or a
+// sharded cluster. A standalone server has no transactions, so the job outbox
+// could not be written together with the changes that queue jobs.
+func CheckTransactionSupport(ctx context.Context, client *mongo.Client) error {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		return err
+	}
+	if hello.SetName == "" && hello.Msg != "isdbgrid" {
+		return errors.New("MongoDB is a standalone server without transactions, run it as a replica set")
+	}
+	return nil
+}
+
+// EnsureJobIndexes indexes the jobs workers look for and makes unique keys
+// unique.
+func EnsureJobIndexes(ctx context.Context, jobCollection *mongo.Collection) error {
+	_, err := jobCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "type", Value: 1}, {Key: "run_at", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "unique_key", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"unique_key": bson.M{"$exists": true}}),
+		},
+	})
+	return err
+}
@@ -0,0 +1,134 @@
+package helpers
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"nano_food_api/models"
+)
+
+// LoyaltySettingsID is the ID of the single loyalty programme document.
+const LoyaltySettingsID = "default"
+
+// Loyalty transaction types, see models.LoyaltyTransaction.
+const (
+	LoyaltyEarned     = "001"
+	LoyaltyRedeemed   = "002"
+	LoyaltyExpired    = "003"
+	LoyaltyReversed   = "004"
+	LoyaltyAdjustment = "005"
+)
+
+// DefaultLoyaltySettings is the programme used until one is configured. It is
+// switched off; the rates earn one point per 1000 spent and value a point at 10.
+func DefaultLoyaltySettings() models.LoyaltySettings {
+	return models.LoyaltySettings{
+		Settings_ID:       LoyaltySettingsID,
+		Enabled:           false,
+		Earn_Rate:         0.001,
+		Redeem_Value:      10,
+		Min_Redeem_Points: 100,
+		Expiry_Months:     12,
+		Tiers: []models.LoyaltyTier{
+			{Name: "silver", Min_Lifetime_Points: 0, Multiplier: 1},
+			{Name: "gold", Min_Lifetime_Points: 5000, Multiplier: 1.5, Perks: []string{"Priority seating"}},
+		},
+	}
+}
+
+func ValidateLoyaltySettings(settings models.LoyaltySettings) error {
+	if settings.Earn_Rate < 0 || settings.Redeem_Value < 0 {
+		return fmt.Errorf("earn rate and redeem value cannot be negative")
+	}
+	if settings.Min_Redeem_Points < 0 || settings.Expiry_Months < 0 {
+		return fmt.Errorf("minimum redeem points and expiry months cannot be negative")
+	}
+
+	names := map[string]bool{}
+	for _, tier := range settings.Tiers {
+		name := strings.ToLower(strings.TrimSpace(tier.Name))
+		if name == "" {
+			return fmt.Errorf("tier name is required")
+		}
+		if names[name] {
+			return fmt.Errorf("duplicate tier: %s", tier.Name)
+		}
+		names[name] = true
+		if tier.Min_Lifetime_Points < 0 || tier.Multiplier <= 0 {
+			return fmt.Errorf("tier %s needs a non-negative threshold and a positive multiplier", tier.Name)
+		}
+	}
+	return nil
+}
+
+// SortLoyaltyTiers orders tiers from the lowest threshold to the highest.
+func SortLoyaltyTiers(tiers []models.LoyaltyTier) {
+	sort.SliceStable(tiers, func(i, j int) bool { return tiers[i].Min_Lifetime_Points < tiers[j].Min_Lifetime_Points })
+}
+
+// LoyaltyTierFor returns the highest tier reached with the given lifetime points,
+// and whether any tier is reached at all. Tiers must be sorted.
+func LoyaltyTierFor(settings models.LoyaltySettings, lifetimePoints int) (models.LoyaltyTier, bool) {
+	var reached models.LoyaltyTier
+	found := false
+	for _, tier := range settings.Tiers {
+		if lifetimePoints >= tier.Min_Lifetime_Points {
+			reached = tier
+			found = true
+		}
+	}
+	return reached, found
+}
+
+// NextLoyaltyTier returns the first tier above the given lifetime points.
+func NextLoyaltyTier(settings models.LoyaltySettings, lifetimePoints int) (models.LoyaltyTier, bool) {
+	for _, tier := range settings.Tiers {
+		if tier.Min_Lifetime_Points > lifetimePoints {
+			return tier, true
+		}
+	}
+	return models.LoyaltyTier{}, false
+}
+
+// EarnedPoints is what a customer with the given lifetime points earns on an
+// amount paid, rounded down.
+func EarnedPoints(settings models.LoyaltySettings, lifetimePoints int, amount float64) int {
+	if !settings.Enabled || amount <= 0 {
+		return 0
+	}
+
+	multiplier := 1.0
+	if tier, ok := LoyaltyTierFor(settings, lifetimePoints); ok {
+		multiplier = tier.Multiplier
+	}
+	return int(math.Floor(amount * settings.Earn_Rate * multiplier))
+}
+
+// RedemptionValue validates a redemption against a bill and returns the amount
+// the points take off it.
+func RedemptionValue(settings models.LoyaltySettings, points int, bill float64) (float64, error) {
+	if !settings.Enabled {
+		return 0, fmt.Errorf("the loyalty programme is not enabled")
+	}
+	if points < settings.Min_Redeem_Points {
+		return 0, fmt.Errorf("at least %d points must be redeemed", settings.Min_Redeem_Points)
+	}
+
+	value := roundCents(float64(points) * settings.Redeem_Value)
+	if value > bill {
+		return 0, fmt.Errorf("%d points are worth %.2f, more than the bill of %.2f", points, value, bill)
+	}
+	return value, nil
+}
+
+// PointsExpiry is when points earned at the given time expire; the zero time
+// when points never expire.
+func PointsExpiry(settings models.LoyaltySettings, earnedAt time.Time) time.Time {
+	if settings.Expiry_Months <= 0 {
+		return time.Time{}
+	}
+	return earnedAt.AddDate(0, settings.Expiry_Months, 0)
+}
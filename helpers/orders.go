@@ -0,0 +1,76 @@
+package helpers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"nano_food_api/models"
+)
+
+// Order types and delivery statuses, see models.Order and models.OrderDelivery.
+const (
+	OrderTypeDineIn   = "001"
+	OrderTypeTakeaway = "002"
+	OrderTypeDelivery = "003"
+	OrderTypePickup   = "004"
+
+	DeliveryAwaitingDriver = "001"
+	DeliveryAssigned       = "002"
+	DeliveryOutForDelivery = "003"
+	DeliveryDelivered      = "004"
+)
+
+// NormalizeOrderFulfilment defaults the order type to dine-in and validates
+// what each type needs: a table for dine-in, a customer to call for takeaway
+// and pickup, and an address for delivery. Fields that do not apply to the
+// type are cleared. hasCustomer tells whether a customer profile is attached,
+// which stands in for the name and phone.
+func NormalizeOrderFulfilment(order *models.Order, hasCustomer bool) error {
+	if order.Order_Type == "" {
+		order.Order_Type = OrderTypeDineIn
+	}
+	order.Customer_Name = strings.TrimSpace(order.Customer_Name)
+	order.Customer_Phone = strings.TrimSpace(order.Customer_Phone)
+
+	switch order.Order_Type {
+	case OrderTypeDineIn:
+		if order.Table_ID == "" {
+			return fmt.Errorf("a table is required for dine-in orders")
+		}
+		order.Delivery = nil
+		order.Promised_Delivery_At = time.Time{}
+		return nil
+	case OrderTypeTakeaway, OrderTypePickup, OrderTypeDelivery:
+	default:
+		return fmt.Errorf("invalid order type: must be '001', '002', '003' or '004'")
+	}
+
+	order.Table_ID = ""
+	if !hasCustomer && (order.Customer_Name == "" || order.Customer_Phone == "") {
+		return fmt.Errorf("a customer name and phone number are required for orders without a table")
+	}
+
+	if order.Order_Type != OrderTypeDelivery {
+		order.Delivery = nil
+		order.Promised_Delivery_At = time.Time{}
+		return nil
+	}
+
+	if order.Delivery == nil || strings.TrimSpace(order.Delivery.Address) == "" {
+		return fmt.Errorf("a delivery address is required for delivery orders")
+	}
+	if order.Delivery.Fee < 0 {
+		return fmt.Errorf("delivery fee cannot be negative")
+	}
+	order.Delivery.Address = strings.TrimSpace(order.Delivery.Address)
+	order.Delivery.Fee = roundCents(order.Delivery.Fee)
+	order.Delivery.Dispatched_At = time.Time{}
+	order.Delivery.Delivered_At = time.Time{}
+	if order.Delivery.Driver_ID == "" {
+		order.Delivery.Status = DeliveryAwaitingDriver
+	} else {
+		order.Delivery.Status = DeliveryAssigned
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"nano_food_api/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Permissions that routes and roles are built from.
+const (
+	PermUserView     = "user.view"
+	PermUserEdit     = "user.edit"
+	PermUserCreate   = "user.create"
+	PermUserDelete   = "user.delete"
+	PermUserTransfer = "user.transfer"
+	PermUserViewAll  = "user.view_all"
+
+	PermRoleManage     = "role.manage"
+	PermTerminalManage = "terminal.manage"
+
+	PermBranchViewAll = "branch.view_all"
+	PermBranchCreate  = "branch.create"
+	PermBranchEdit    = "branch.edit"
+	PermBranchDelete  = "branch.delete"
+
+	PermCategoryEdit   = "category.edit"
+	PermCategoryDelete = "category.delete"
+	PermTableEdit      = "table.edit"
+	PermTableDelete    = "table.delete"
+	PermMenuEdit       = "menu.edit"
+	PermMenuDelete     = "menu.delete"
+
+	PermOrderCreate  = "order.create"
+	PermOrderConfirm = "order.confirm"
+	PermOrderEdit    = "order.edit"
+	PermOrderVoid    = "order.void"
+
+	PermSaleCreate = "sale.create"
+	PermSaleView   = "sale.view"
+	PermSaleDelete = "sale.delete"
+	PermSaleRefund = "sale.refund"
+
+	PermReportView = "report.view"
+	PermAuditView  = "audit.view"
+
+	PermLoyaltyAdjust  = "loyalty.adjust"
+	PermGiftCardManage = "giftcard.manage"
+	PermWebhookManage  = "webhook.manage"
+	PermJobManage      = "job.manage"
+)
+
+var AllPermissions = []string{
+	PermUserView, PermUserEdit, PermUserCreate, PermUserDelete, PermUserTransfer, PermUserViewAll,
+	PermRoleManage, PermTerminalManage,
+	PermBranchViewAll, PermBranchCreate, PermBranchEdit, PermBranchDelete,
+	PermCategoryEdit, PermCategoryDelete, PermTableEdit, PermTableDelete, PermMenuEdit, PermMenuDelete,
+	PermOrderCreate, PermOrderConfirm, PermOrderEdit, PermOrderVoid,
+	PermSaleCreate, PermSaleView, PermSaleDelete, PermSaleRefund,
+	PermReportView, PermAuditView,
+	PermLoyaltyAdjust, PermGiftCardManage, PermWebhookManage,
+	PermJobManage,
+}
+
+var staffPermissions = []string{PermOrderCreate, PermOrderConfirm}
+
+var assistantPermissions = append(append([]string{}, staffPermissions...), PermSaleCreate)
+
+var managerPermissions = append(append([]string{}, assistantPermissions...),
+	PermUserView, PermUserEdit, PermRoleManage, PermTerminalManage,
+	PermCategoryEdit, PermTableEdit, PermMenuEdit,
+	PermOrderEdit, PermSaleView, PermReportView,
+	PermLoyaltyAdjust, PermGiftCardManage,
+)
+
+var ownerPermissions = append(append([]string{}, managerPermissions...),
+	PermUserCreate, PermUserDelete, PermUserTransfer,
+	PermBranchViewAll, PermBranchCreate, PermBranchEdit,
+	PermCategoryDelete, PermTableDelete, PermMenuDelete,
+	PermOrderVoid, PermSaleDelete, PermSaleRefund,
+	PermAuditView, PermWebhookManage,
+)
+
+// DefaultRolePermissions are the permissions seeded for each role level.
+var DefaultRolePermissions = map[int][]string{
+	0:   staffPermissions,
+	1:   assistantPermissions,
+	2:   managerPermissions,
+	3:   ownerPermissions,
+	100: AllPermissions,
+}
+
+var defaultRoleNames = map[int]string{
+	0:   "Waiter / Chef",
+	1:   "Assistant",
+	2:   "Manager",
+	3:   "Owner",
+	100: "Root Admin",
+}
+
+func DefaultRoleID(level int) string {
+	return fmt.Sprintf("default-%d", level)
+}
+
+// rolePermissionMigrations are permissions added to the default roles after
+// databases were first seeded. Each migration is applied once to the existing
+// default roles whose level includes the permissions, so a permission an owner
+// removes afterwards stays removed.
+var rolePermissionMigrations = []struct {
+	ID          string
+	Permissions []string
+}{
+	{ID: "loyalty-giftcards-webhooks", Permissions: []string{PermLoyaltyAdjust, PermGiftCardManage, PermWebhookManage}},
+}
+
+// SeedDefaultRoles inserts the default roles that do not exist yet, so edits made
+// to them later are kept across restarts, and then applies permission migrations.
+func SeedDefaultRoles(ctx context.Context, roleCollection *mongo.Collection) error {
+	for level, permissions := range DefaultRolePermissions {
+		_, err := roleCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": DefaultRoleID(level)},
+			bson.M{"$setOnInsert": models.Role{
+				Role_ID:     DefaultRoleID(level),
+				Name:        defaultRoleNames[level],
+				Level:       level,
+				Permissions: permissions,
+				IsDefault:   true,
+				Created_At:  time.Now(),
+				Updated_At:  time.Now(),
+			}},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return migrateDefaultRolePermissions(ctx, roleCollection)
+}
+
+func migrateDefaultRolePermissions(ctx context.Context, roleCollection *mongo.Collection) error {
+	for _, migration := range rolePermissionMigrations {
+		for level, defaults := range DefaultRolePermissions {
+			grant := []string{}
+			for _, permission := range migration.Permissions {
+				if HasPermission(defaults, permission) {
+					grant = append(grant, permission)
+				}
+			}
+			if len(grant) == 0 {
+				continue
+			}
+
+			_, err := roleCollection.UpdateOne(
+				ctx,
+				bson.M{"_id": DefaultRoleID(level), "migrations": bson.M{"$ne": migration.ID}},
+				bson.M{
+					"$addToSet": bson.M{"permissions": bson.M{"$each": grant}, "migrations": migration.ID},
+					"$set":      bson.M{"updated_at": time.Now()},
+				},
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// GetUserPermissions returns the permissions the user holds in a branch: those of
+// their custom role there, or of the default role for their level. Root admins
+// always have every permission; users outside the branch have none.
+func GetUserPermissions(ctx context.Context, roleCollection *mongo.Collection, user models.User, branchId string) ([]string, error) {
+	if user.Role == 100 {
+		return AllPermissions, nil
+	}
+
+	membership, ok := MembershipFor(user, branchId)
+	if !ok {
+		return []string{}, nil
+	}
+
+	roleID := membership.Role_ID
+	if roleID == "" {
+		roleID = DefaultRoleID(membership.Role)
+	}
+
+	var role models.Role
+	err := roleCollection.FindOne(ctx, bson.M{"_id": roleID}).Decode(&role)
+	if err == mongo.ErrNoDocuments && membership.Role_ID == "" {
+		return DefaultRolePermissions[membership.Role], nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return role.Permissions, nil
+}
+
+func HasPermission(granted []string, permission string) bool {
+	for _, p := range granted {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func IsValidPermission(permission string) bool {
+	return HasPermission(AllPermissions, permission)
+}
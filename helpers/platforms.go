@@ -0,0 +1,43 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"nano_food_api/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ValidatePlatformItemMappings checks that every mapping names a menu and that
+// no platform item ID is mapped twice.
+func ValidatePlatformItemMappings(mappings []models.PlatformItemMapping) error {
+	seen := map[string]bool{}
+	for i := range mappings {
+		mapping := &mappings[i]
+		mapping.External_ID = strings.TrimSpace(mapping.External_ID)
+		if mapping.External_ID == "" || mapping.Menu_ID == "" {
+			return fmt.Errorf("item mappings need an external ID and a menu ID")
+		}
+		if seen[mapping.External_ID] {
+			return fmt.Errorf("external item %s is mapped more than once", mapping.External_ID)
+		}
+		seen[mapping.External_ID] = true
+	}
+	return nil
+}
+
+// EnsureOrderSourceIndexes makes a platform's order IDs unique, so that a
+// webhook delivered twice does not create two orders.
+func EnsureOrderSourceIndexes(ctx context.Context, orderCollection *mongo.Collection) error {
+	_, err := orderCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "platform_id", Value: 1}, {Key: "source_order_id", Value: 1}},
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{
+			"source_order_id": bson.M{"$exists": true},
+		}),
+	})
+	return err
+}
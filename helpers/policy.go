@@ -0,0 +1,92 @@
+package helpers
+
+import (
+	"fmt"
+
+	"nano_food_api/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// BranchScope is the set of branches the authenticated caller may read and
+// write, taken from their access token.
+type BranchScope struct {
+	User_ID     string
+	Role        int
+	Branch_ID   string
+	AllBranches bool
+}
+
+func NewBranchScope(userId string, role int, branchId string) BranchScope {
+	return BranchScope{
+		User_ID:     userId,
+		Role:        role,
+		Branch_ID:   branchId,
+		AllBranches: role == 100,
+	}
+}
+
+// GetBranchScope builds the caller's scope from what Authentication stored in
+// the request context.
+func GetBranchScope(c *gin.Context) (BranchScope, error) {
+	userId := c.GetString("userId")
+	if userId == "" {
+		return BranchScope{}, fmt.Errorf("user ID not found in request context")
+	}
+
+	role, ok := c.Get("role")
+	if !ok {
+		return BranchScope{}, fmt.Errorf("role not found in request context")
+	}
+	roleInt, ok := role.(int)
+	if !ok {
+		return BranchScope{}, fmt.Errorf("invalid role format")
+	}
+
+	return NewBranchScope(userId, roleInt, c.GetString("branchId")), nil
+}
+
+// CanAccess reports whether the caller may act on data of the given branch.
+func (s BranchScope) CanAccess(branchId string) bool {
+	if s.AllBranches {
+		return true
+	}
+	return branchId != "" && branchId == s.Branch_ID
+}
+
+// ScopeFilter restricts a query filter to the caller's branch. A branch_id
+// already in the filter is kept only if the caller may access it.
+func (s BranchScope) ScopeFilter(filter bson.M) (bson.M, error) {
+	if s.AllBranches {
+		return filter, nil
+	}
+
+	if requested, ok := filter["branch_id"].(string); ok && requested != "" && !s.CanAccess(requested) {
+		return nil, fmt.Errorf("you do not have access to branch %s", requested)
+	}
+	filter["branch_id"] = s.Branch_ID
+	return filter, nil
+}
+
+// UserBranches returns every branch the user belongs to, home branch first.
+func UserBranches(user models.User) []models.BranchMembership {
+	branches := []models.BranchMembership{{Branch_ID: user.Branch_ID, Role: user.Role, Role_ID: user.Role_ID}}
+	for _, membership := range user.Memberships {
+		if membership.Branch_ID != user.Branch_ID {
+			branches = append(branches, membership)
+		}
+	}
+	return branches
+}
+
+// MembershipFor returns the user's role in a branch. An empty branch ID means the
+// home branch.
+func MembershipFor(user models.User, branchId string) (models.BranchMembership, bool) {
+	for _, membership := range UserBranches(user) {
+		if branchId == "" || membership.Branch_ID == branchId {
+			return membership, true
+		}
+	}
+	return models.BranchMembership{}, false
+}
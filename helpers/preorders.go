@@ -0,0 +1,93 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"nano_food_api/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultPreorderLeadMinutes = 30
+	defaultPreorderSlotMinutes = 15
+)
+
+// EffectivePreorderPolicy fills in the defaults for the unset minutes of a
+// branch's pre-order policy. Reminders default to the moment the order reaches
+// the kitchen.
+func EffectivePreorderPolicy(policy models.PreorderPolicy) models.PreorderPolicy {
+	if policy.Lead_Minutes <= 0 {
+		policy.Lead_Minutes = defaultPreorderLeadMinutes
+	}
+	if policy.Slot_Minutes <= 0 {
+		policy.Slot_Minutes = defaultPreorderSlotMinutes
+	}
+	if policy.Reminder_Minutes <= 0 {
+		policy.Reminder_Minutes = policy.Lead_Minutes
+	}
+	return policy
+}
+
+func ValidatePreorderPolicy(policy models.PreorderPolicy) error {
+	if policy.Lead_Minutes < 0 || policy.Slot_Minutes < 0 || policy.Reminder_Minutes < 0 || policy.Slot_Capacity < 0 {
+		return fmt.Errorf("pre-order minutes and capacity cannot be negative")
+	}
+	if policy.Slot_Minutes > 0 && (24*60)%policy.Slot_Minutes != 0 {
+		return fmt.Errorf("slot minutes must divide a day evenly, e.g. 15, 30 or 60")
+	}
+	return nil
+}
+
+// PreorderSlot returns the slot a pre-order due at the given time falls in.
+// Slots are counted from local midnight.
+func PreorderSlot(policy models.PreorderPolicy, scheduledFor time.Time) (time.Time, time.Time) {
+	policy = EffectivePreorderPolicy(policy)
+	slotLength := time.Duration(policy.Slot_Minutes) * time.Minute
+
+	local := scheduledFor.In(time.Local)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.Local)
+	start := midnight.Add(local.Sub(midnight) / slotLength * slotLength)
+	return start, start.Add(slotLength)
+}
+
+// PreorderSlotsOfDay returns the start of every slot of the day beginning at the
+// given local midnight.
+func PreorderSlotsOfDay(policy models.PreorderPolicy, midnight time.Time) []time.Time {
+	policy = EffectivePreorderPolicy(policy)
+	slotLength := time.Duration(policy.Slot_Minutes) * time.Minute
+	end := midnight.AddDate(0, 0, 1)
+
+	slots := []time.Time{}
+	for start := midnight; start.Before(end); start = start.Add(slotLength) {
+		slots = append(slots, start)
+	}
+	return slots
+}
+
+// SchedulePreorder sets when a pre-order reaches the kitchen and when staff are
+// reminded of it, and promises it ready for its scheduled time unless another
+// time was promised.
+func SchedulePreorder(policy models.PreorderPolicy, order *models.Order) {
+	policy = EffectivePreorderPolicy(policy)
+	order.Release_At = order.Scheduled_For.Add(-time.Duration(policy.Lead_Minutes) * time.Minute)
+	order.Remind_At = order.Scheduled_For.Add(-time.Duration(policy.Reminder_Minutes) * time.Minute)
+	order.Reminded_At = time.Time{}
+	if order.Promised_Ready_At.IsZero() {
+		order.Promised_Ready_At = order.Scheduled_For
+	}
+}
+
+// EnsurePreorderSlotIndexes lets MongoDB remove the counters of slots that
+// passed a day ago, as no pre-order can be booked into them any more.
+func EnsurePreorderSlotIndexes(ctx context.Context, preorderSlotCollection *mongo.Collection) error {
+	_, err := preorderSlotCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "slot_start", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(24 * 60 * 60),
+	})
+	return err
+}
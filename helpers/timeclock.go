@@ -0,0 +1,130 @@
+package helpers
+
+import (
+	"context"
+	"time"
+
+	"nano_food_api/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Attendance statuses of a rostered shift.
+const (
+	AttendanceUpcoming = "upcoming"
+	AttendanceOnTime   = "on_time"
+	AttendanceLate     = "late"
+	AttendanceAbsent   = "absent"
+)
+
+// EarlyClockInWindow is how long before a shift starts a clock-in still counts
+// towards that shift.
+const EarlyClockInWindow = 2 * time.Hour
+
+// EnsureTimeEntryIndexes lets each user have only one open time entry, so two
+// clock-ins racing each other cannot both succeed. Partial indexes cannot
+// filter on a missing clock_out_at, so the open status stands in for it.
+func EnsureTimeEntryIndexes(ctx context.Context, timeEntryCollection *mongo.Collection) error {
+	_, err := timeEntryCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}},
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"status": "001"}),
+	})
+	return err
+}
+
+// ShiftAttendance compares one rostered shift with the user's punches.
+type ShiftAttendance struct {
+	Shift        models.Shift `json:"shift"`
+	Status       string       `json:"status"`
+	TimeEntry_ID string       `json:"time_entry_id,omitempty"`
+	Clock_In_At  time.Time    `json:"clock_in_at,omitempty"`
+	Late_Minutes int          `json:"late_minutes"`
+}
+
+// OpenBreak returns the index of the break that has not ended yet, or -1.
+func OpenBreak(entry models.TimeEntry) int {
+	for i, b := range entry.Breaks {
+		if b.End_At.IsZero() {
+			return i
+		}
+	}
+	return -1
+}
+
+// BreakDuration sums the breaks of an entry. A break still running counts until now.
+func BreakDuration(entry models.TimeEntry, now time.Time) time.Duration {
+	var total time.Duration
+	for _, b := range entry.Breaks {
+		end := b.End_At
+		if end.IsZero() {
+			end = now
+		}
+		if end.After(b.Start_At) {
+			total += end.Sub(b.Start_At)
+		}
+	}
+	return total
+}
+
+// WorkedDuration is the time between clock-in and clock-out, less breaks. An
+// entry still open counts until now.
+func WorkedDuration(entry models.TimeEntry, now time.Time) time.Duration {
+	end := entry.Clock_Out_At
+	if end.IsZero() {
+		end = now
+	}
+	worked := end.Sub(entry.Clock_In_At) - BreakDuration(entry, now)
+	if worked < 0 {
+		return 0
+	}
+	return worked
+}
+
+// MatchShiftEntry returns the earliest entry of the shift's user at the shift's
+// branch that was clocked in between EarlyClockInWindow before the shift start
+// and the shift end.
+func MatchShiftEntry(shift models.Shift, entries []models.TimeEntry) (models.TimeEntry, bool) {
+	var match models.TimeEntry
+	found := false
+	for _, entry := range entries {
+		if entry.User_ID != shift.User_ID || entry.Branch_ID != shift.Branch_ID {
+			continue
+		}
+		if entry.Clock_In_At.Before(shift.Start_At.Add(-EarlyClockInWindow)) || !entry.Clock_In_At.Before(shift.End_At) {
+			continue
+		}
+		if !found || entry.Clock_In_At.Before(match.Clock_In_At) {
+			match = entry
+			found = true
+		}
+	}
+	return match, found
+}
+
+// EvaluateShift flags a shift as late when the user clocked in more than grace
+// after it started, and as absent when it has started past the grace period
+// without a clock-in.
+func EvaluateShift(shift models.Shift, entries []models.TimeEntry, grace time.Duration, now time.Time) ShiftAttendance {
+	attendance := ShiftAttendance{Shift: shift}
+
+	entry, found := MatchShiftEntry(shift, entries)
+	if !found {
+		if now.Before(shift.Start_At.Add(grace)) {
+			attendance.Status = AttendanceUpcoming
+		} else {
+			attendance.Status = AttendanceAbsent
+		}
+		return attendance
+	}
+
+	attendance.TimeEntry_ID = entry.TimeEntry_ID
+	attendance.Clock_In_At = entry.Clock_In_At
+	attendance.Status = AttendanceOnTime
+	if late := entry.Clock_In_At.Sub(shift.Start_At); late > grace {
+		attendance.Status = AttendanceLate
+		attendance.Late_Minutes = int(late.Minutes())
+	}
+	return attendance
+}
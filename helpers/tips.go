@@ -0,0 +1,105 @@
+package helpers
+
+import (
+	"fmt"
+	"math"
+
+	"nano_food_api/models"
+)
+
+// Tip policy modes, see models.TipPolicy.
+const (
+	TipModeIndividual = "001"
+	TipModePooled     = "002"
+)
+
+// TipDistribution is how the tips of a period are shared out. Tips that could
+// not be given to anyone, e.g. of sales without a server or of a pool nobody
+// clocked in for, are Unassigned.
+type TipDistribution struct {
+	Total      float64            `json:"total"`
+	House      float64            `json:"house"`
+	Unassigned float64            `json:"unassigned"`
+	Shares     map[string]float64 `json:"shares"`
+}
+
+func ValidateTipPolicy(policy models.TipPolicy) error {
+	if policy.Mode != "" && policy.Mode != TipModeIndividual && policy.Mode != TipModePooled {
+		return fmt.Errorf("invalid tip policy mode: must be '001' or '002'")
+	}
+	if policy.House_Percent < 0 || policy.House_Percent > 100 {
+		return fmt.Errorf("house percent must be between 0 and 100")
+	}
+	return nil
+}
+
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// DistributeTips shares the tips of the given sales according to the policy.
+// In pooled mode, hours are the hours each user clocked at the branch and roles
+// their role there; users of roles outside Eligible_Roles get nothing. Sales
+// without servers give their tip to the cashier in individual mode.
+func DistributeTips(policy models.TipPolicy, sales []models.Sale, hours map[string]float64, roles map[string]int) TipDistribution {
+	distribution := TipDistribution{Shares: map[string]float64{}}
+
+	pool := 0.0
+	for _, sale := range sales {
+		if sale.Tip <= 0 {
+			continue
+		}
+		distribution.Total += sale.Tip
+		house := sale.Tip * policy.House_Percent / 100
+		distribution.House += house
+		remaining := sale.Tip - house
+
+		if policy.Mode == TipModePooled {
+			pool += remaining
+			continue
+		}
+
+		recipients := sale.Server_IDs
+		if len(recipients) == 0 && sale.Cashier_ID != "" {
+			recipients = []string{sale.Cashier_ID}
+		}
+		if len(recipients) == 0 {
+			distribution.Unassigned += remaining
+			continue
+		}
+		for _, userID := range recipients {
+			distribution.Shares[userID] += remaining / float64(len(recipients))
+		}
+	}
+
+	if policy.Mode == TipModePooled && pool > 0 {
+		eligibleHours := map[string]float64{}
+		totalHours := 0.0
+		for userID, h := range hours {
+			if h <= 0 {
+				continue
+			}
+			if len(policy.Eligible_Roles) > 0 && !Contains(policy.Eligible_Roles, roles[userID]) {
+				continue
+			}
+			eligibleHours[userID] = h
+			totalHours += h
+		}
+
+		if totalHours == 0 {
+			distribution.Unassigned += pool
+		} else {
+			for userID, h := range eligibleHours {
+				distribution.Shares[userID] += pool * h / totalHours
+			}
+		}
+	}
+
+	distribution.Total = roundCents(distribution.Total)
+	distribution.House = roundCents(distribution.House)
+	distribution.Unassigned = roundCents(distribution.Unassigned)
+	for userID, share := range distribution.Shares {
+		distribution.Shares[userID] = roundCents(share)
+	}
+	return distribution
+}
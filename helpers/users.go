@@ -0,0 +1,36 @@
+package helpers
+
+import (
+	"context"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NormalizeEmail is the form in which user emails are stored and looked up, so
+// "Jane@Example.com " and "jane@example.com" are the same account.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// EnsureUserIndexes normalizes emails stored before they were lowercased and
+// makes them unique. It fails if two accounts differ only by case; those must
+// be merged by hand first.
+func EnsureUserIndexes(ctx context.Context, userCollection *mongo.Collection) error {
+	_, err := userCollection.UpdateMany(
+		ctx,
+		bson.M{"email": bson.M{"$regex": `[A-Z]|^\s|\s$`}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{"email": bson.M{"$toLower": bson.M{"$trim": bson.M{"input": "$email"}}}}}}},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = userCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "email", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
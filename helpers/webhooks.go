@@ -0,0 +1,164 @@
+package helpers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Webhook events and delivery statuses, see models.WebhookEndpoint and
+// models.WebhookDelivery.
+const (
+	WebhookOrderCreated = "order.created"
+	WebhookSaleCreated  = "sale.created"
+	WebhookSaleRefunded = "sale.refunded"
+	WebhookMenuUpdated  = "menu.updated"
+
+	WebhookPending   = "001"
+	WebhookDelivered = "002"
+	WebhookDead      = "003"
+)
+
+var WebhookEvents = []string{WebhookOrderCreated, WebhookSaleCreated, WebhookSaleRefunded, WebhookMenuUpdated}
+
+// MaxWebhookAttempts is how often a delivery is tried before it is dead-lettered.
+const MaxWebhookAttempts = 8
+
+const (
+	webhookBaseDelay = 30 * time.Second
+	webhookMaxDelay  = 6 * time.Hour
+)
+
+// sharedAddressSpace is the carrier-grade NAT range, which is not routable on
+// the internet either.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// publicAddress tells whether an IP address is reachable on the internet, as
+// opposed to a loopback, private, link-local (e.g. cloud metadata) or otherwise
+// internal address that webhooks must not be pointed at.
+func publicAddress(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified() && !sharedAddressSpace.Contains(ip)
+}
+
+// checkWebhookHost resolves a host and rejects it unless all its addresses are
+// public.
+func checkWebhookHost(host string) error {
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return fmt.Errorf("the URL's host cannot be resolved")
+	}
+	for _, ip := range ips {
+		if !publicAddress(ip) {
+			return fmt.Errorf("the URL must not point at a private or local address")
+		}
+	}
+	return nil
+}
+
+// webhookClient only connects to public addresses. The check is made on the
+// address actually dialed, so it also covers redirects and hosts that resolve
+// differently than when the endpoint was registered.
+var webhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: func(network string, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || !publicAddress(ip) {
+					return fmt.Errorf("refusing to connect to non-public address %s", host)
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+}
+
+// ValidateWebhookEndpoint checks an endpoint's URL and events. URLs must
+// resolve to public addresses only.
+func ValidateWebhookEndpoint(endpointURL string, events []string) error {
+	parsed, err := url.Parse(endpointURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("the URL must be an absolute http or https URL")
+	}
+	if err := checkWebhookHost(parsed.Hostname()); err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		return fmt.Errorf("subscribe to at least one event")
+	}
+	for _, event := range events {
+		if !ContainsString(WebhookEvents, event) {
+			return fmt.Errorf("unknown event %s", event)
+		}
+	}
+	return nil
+}
+
+// WebhookRetryDelay is how long to wait after the given number of failed
+// attempts: 30s, 1m, 2m, 4m and so on, up to 6 hours.
+func WebhookRetryDelay(attempts int) time.Duration {
+	return ExponentialDelay(webhookBaseDelay, webhookMaxDelay, attempts)
+}
+
+// SignWebhook signs a payload sent at the given Unix time. Receivers recompute
+// the HMAC-SHA256 of "<timestamp>.<payload>" with the endpoint's secret and
+// compare it to the X-Webhook-Signature header.
+func SignWebhook(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SendWebhook posts a signed payload to an endpoint and returns the status code
+// it responded with. Anything but a 2xx response is an error, as is an endpoint
+// that resolves to a non-public address by now.
+func SendWebhook(endpointURL string, secret string, deliveryID string, event string, payload []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, endpointURL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", event)
+	req.Header.Set("X-Webhook-Delivery", deliveryID)
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Webhook-Signature", SignWebhook(secret, timestamp, payload))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// EnsureWebhookIndexes indexes the delivery log and dead letters of a branch.
+func EnsureWebhookIndexes(ctx context.Context, deliveryCollection *mongo.Collection) error {
+	_, err := deliveryCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "branch_id", Value: 1}, {Key: "status", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+	return err
+}
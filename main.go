@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
+	"nano_food_api/controllers"
+	"nano_food_api/database"
+	"nano_food_api/helpers"
 	"nano_food_api/middlewares"
 	"nano_food_api/routes"
+	"nano_food_api/storage"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -24,14 +30,25 @@ func main() {
 		log.Fatalf("Error loading .env file")
 	}
 
+	// The audit log chain is keyed with a secret that must not live in the database
+	if os.Getenv("AUDIT_LOG_KEY") == "" {
+		log.Fatal("AUDIT_LOG_KEY environment variable not set")
+	}
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8000"
 	}
 
+	// Failed logins are throttled per account and IP in the controllers; this limit
+	// only guards against floods, so it must leave room for a restaurant behind one NAT.
+	rateLimit, err := strconv.ParseInt(os.Getenv("RATE_LIMIT_PER_MINUTE"), 10, 64)
+	if err != nil {
+		rateLimit = 300
+	}
 	rate := limiter.Rate{
 		Period: 1 * time.Minute,
-		Limit:  20,
+		Limit:  rateLimit,
 	}
 	store := memory.NewStore()
 
@@ -50,18 +67,58 @@ func main() {
 			"https://nano-food.vercel.app",
 		},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-Terminal-Token"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
 	}))
 
+	// Jobs are queued in the same transaction as the changes that cause them
+	if err := helpers.CheckTransactionSupport(context.Background(), database.Client); err != nil {
+		log.Fatalf("Error checking MongoDB transactions: %v", err)
+	}
+	if err := helpers.SeedDefaultRoles(context.Background(), database.RoleCollection); err != nil {
+		log.Fatalf("Error seeding default roles: %v", err)
+	}
+	if err := helpers.EnsureUserIndexes(context.Background(), database.UserCollection); err != nil {
+		log.Fatalf("Error creating user indexes: %v", err)
+	}
+	if err := helpers.EnsureTimeEntryIndexes(context.Background(), database.TimeEntryCollection); err != nil {
+		log.Fatalf("Error creating time entry indexes: %v", err)
+	}
+	if err := helpers.EnsureAuditLogIndexes(context.Background(), database.AuditLogCollection); err != nil {
+		log.Fatalf("Error creating audit log indexes: %v", err)
+	}
+	if err := helpers.EnsureCustomerOTPIndexes(context.Background(), database.CustomerOTPCollection); err != nil {
+		log.Fatalf("Error creating customer OTP indexes: %v", err)
+	}
+	if err := helpers.EnsurePreorderSlotIndexes(context.Background(), database.PreorderSlotCollection); err != nil {
+		log.Fatalf("Error creating pre-order slot indexes: %v", err)
+	}
+	if err := helpers.EnsureGiftCardIndexes(context.Background(), database.GiftCardCollection); err != nil {
+		log.Fatalf("Error creating gift card indexes: %v", err)
+	}
+	if err := helpers.EnsureOrderSourceIndexes(context.Background(), database.OrderCollection); err != nil {
+		log.Fatalf("Error creating order source indexes: %v", err)
+	}
+	if err := helpers.EnsureWebhookIndexes(context.Background(), database.WebhookDeliveryCollection); err != nil {
+		log.Fatalf("Error creating webhook indexes: %v", err)
+	}
+	if err := helpers.EnsureJobIndexes(context.Background(), database.JobCollection); err != nil {
+		log.Fatalf("Error creating job indexes: %v", err)
+	}
+
+	fileStorage, err := storage.NewFromEnv(context.Background())
+	if err != nil {
+		log.Fatalf("Error initializing file storage: %v", err)
+	}
+	controllers.FileStorage = fileStorage
+
+	controllers.StartJobWorkers()
+
 	routeGroups := &routes.RouteGroups{
-		Public:    router.Group("/"),
-		Auth:      router.Group("/").Use(middlewares.Authentication([]int{})),
-		Assistant: router.Group("/").Use(middlewares.Authentication([]int{1, 2, 3, 100})),
-		Manager:   router.Group("/").Use(middlewares.Authentication([]int{2, 3, 100})),
-		Admin:     router.Group("/").Use(middlewares.Authentication([]int{3, 100})),
-		Root:      router.Group("/").Use(middlewares.Authentication([]int{100})),
+		Public:   router.Group("/"),
+		Auth:     router.Group("/").Use(middlewares.Authentication([]int{})),
+		Customer: router.Group("/").Use(middlewares.CustomerAuthentication()),
 	}
 
 	routeGroups.Public.GET("/", func(c *gin.Context) {
@@ -76,12 +133,26 @@ func main() {
 
 	// Routes
 	routes.UserRoutes(routeGroups)
+	routes.RoleRoutes(routeGroups)
+	routes.InvitationRoutes(routeGroups)
+	routes.AuditLogRoutes(routeGroups)
+	routes.CustomerRoutes(routeGroups)
+	routes.LoyaltyRoutes(routeGroups)
+	routes.GiftCardRoutes(routeGroups)
+	routes.TerminalRoutes(routeGroups)
+	routes.TimeClockRoutes(routeGroups)
+	routes.ShiftRoutes(routeGroups)
 	routes.BranchRoutes(routeGroups)
 	routes.CategoryRoutes(routeGroups)
 	routes.TableRoutes(routeGroups)
 	routes.MenuRoutes(routeGroups)
 	routes.AddOnRoutes(routeGroups)
 	routes.OrderRoutes(routeGroups)
+	routes.GuestRoutes(routeGroups)
+	routes.PlatformRoutes(routeGroups)
+	routes.WebhookRoutes(routeGroups)
+	routes.JobRoutes(routeGroups)
+	routes.StorageRoutes(routeGroups)
 	routes.SaleRoutes(routeGroups)
 
 	log.Fatal(router.Run(":" + port))
@@ -0,0 +1,209 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"time"
+
+	database "nano_food_api/database"
+	helpers "nano_food_api/helpers"
+	models "nano_food_api/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// auditResponseWriter keeps a copy of the response so the IDs of created
+// entities can be read from it.
+type auditResponseWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *auditResponseWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// Audit records every successful create, update or delete of an entity in the
+// audit log. The entity ID is read from the idKey route parameter or JSON body
+// field; with an empty idKey the handler creates entities and their IDs are read
+// from the "_id" of the objects it responds with.
+func Audit(entity string, collection *mongo.Collection, idKey string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		entityID := ""
+		if idKey != "" {
+			entityID = c.Param(idKey)
+			if entityID == "" {
+				entityID = jsonBodyField(c, idKey)
+			}
+		}
+		auditEntity(c, entity, collection, entityID)
+	}
+}
+
+// AuditSelf records changes the caller makes to their own account.
+func AuditSelf(entity string, collection *mongo.Collection) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		auditEntity(c, entity, collection, c.GetString("userId"))
+	}
+}
+
+// AuditDocument records changes to a singleton document with a fixed ID, such
+// as settings shared by every branch.
+func AuditDocument(entity string, collection *mongo.Collection, id string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		auditEntity(c, entity, collection, id)
+	}
+}
+
+func auditEntity(c *gin.Context, entity string, collection *mongo.Collection, entityID string) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	before := loadAuditDocument(ctx, collection, entityID)
+
+	writer := &auditResponseWriter{ResponseWriter: c.Writer}
+	c.Writer = writer
+	c.Next()
+
+	if writer.Status() < 200 || writer.Status() >= 300 {
+		return
+	}
+
+	entityIDs := []string{entityID}
+	if entityID == "" {
+		entityIDs = responseIDs(writer.body.Bytes())
+	}
+
+	for _, id := range entityIDs {
+		after := loadAuditDocument(ctx, collection, id)
+		recordAudit(ctx, c, entity, id, before, after)
+	}
+}
+
+func recordAudit(ctx context.Context, c *gin.Context, entity string, entityID string, before bson.M, after bson.M) {
+	action := "update"
+	switch {
+	case before == nil && after == nil:
+		return
+	case before == nil:
+		action = "create"
+	case after == nil:
+		action = "delete"
+	}
+
+	changes := helpers.DiffDocuments(before, after)
+	if action == "update" && len(changes) == 0 {
+		return
+	}
+
+	branchID := c.GetString("branchId")
+	if entity == "branch" {
+		branchID = entityID
+	} else if id, ok := after["branch_id"].(string); ok && id != "" {
+		branchID = id
+	} else if id, ok := before["branch_id"].(string); ok && id != "" {
+		branchID = id
+	}
+
+	entry := models.AuditLog{
+		AuditLog_ID: primitive.NewObjectID().Hex(),
+		Actor_ID:    c.GetString("userId"),
+		Actor_Role:  c.GetInt("role"),
+		Session_ID:  c.GetString("sessionId"),
+		Branch_ID:   branchID,
+		Action:      action,
+		Entity:      entity,
+		Entity_ID:   entityID,
+		Changes:     changes,
+		Method:      c.Request.Method,
+		Path:        c.FullPath(),
+		IP:          c.ClientIP(),
+		UserAgent:   c.Request.UserAgent(),
+		Created_At:  time.Now(),
+	}
+
+	if _, err := helpers.AppendAuditLog(ctx, database.AuditLogCollection, entry); err != nil {
+		log.Printf("Error writing audit log for %s %s: %v", entity, entityID, err)
+	}
+}
+
+func loadAuditDocument(ctx context.Context, collection *mongo.Collection, id string) bson.M {
+	if id == "" {
+		return nil
+	}
+
+	var doc bson.M
+	if err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&doc); err != nil {
+		return nil
+	}
+	return doc
+}
+
+// jsonBodyField reads a string field of a JSON request body and puts the body
+// back for the handler.
+func jsonBodyField(c *gin.Context, field string) string {
+	if c.Request.Body == nil || c.ContentType() != gin.MIMEJSON {
+		return ""
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return ""
+	}
+	value, _ := fields[field].(string)
+	return value
+}
+
+// responseIDs collects the "_id" (or InsertedID of an insert result) of the
+// objects and arrays of objects in a JSON response.
+func responseIDs(body []byte) []string {
+	var response map[string]json.RawMessage
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil
+	}
+
+	objectID := func(raw json.RawMessage) string {
+		var object struct {
+			ID         string `json:"_id"`
+			InsertedID string `json:"InsertedID"`
+		}
+		if err := json.Unmarshal(raw, &object); err != nil {
+			return ""
+		}
+		if object.ID != "" {
+			return object.ID
+		}
+		return object.InsertedID
+	}
+
+	ids := []string{}
+	for _, raw := range response {
+		if id := objectID(raw); id != "" {
+			ids = append(ids, id)
+			continue
+		}
+
+		var items []json.RawMessage
+		if err := json.Unmarshal(raw, &items); err == nil {
+			for _, item := range items {
+				if id := objectID(item); id != "" {
+					ids = append(ids, id)
+				}
+			}
+		}
+	}
+	return ids
+}
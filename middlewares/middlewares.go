@@ -1,12 +1,19 @@
 package middlewares
 
 import (
+	"context"
+	"log"
 	"net/http"
 	"strings"
+	"time"
 
+	database "nano_food_api/database"
+	helpers "nano_food_api/helpers"
+	models "nano_food_api/models"
 	token "nano_food_api/tokens"
 
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
 )
 
 func Authentication(roles []int) gin.HandlerFunc {
@@ -34,6 +41,37 @@ func Authentication(roles []int) gin.HandlerFunc {
 			return
 		}
 
+		// Reject tokens whose session was logged out or revoked
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		sessionCount, dbErr := database.SessionCollection.CountDocuments(ctx, bson.M{
+			"_id":        claims.Session_ID,
+			"user_id":    claims.User_ID,
+			"is_revoked": false,
+			"expires_at": bson.M{"$gt": time.Now()},
+		})
+		if dbErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error validating session"})
+			c.Abort()
+			return
+		}
+		if sessionCount == 0 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has been revoked"})
+			c.Abort()
+			return
+		}
+
+		// Touch the session at most once a minute to keep last seen reasonably fresh
+		_, dbErr = database.SessionCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": claims.Session_ID, "last_seen_at": bson.M{"$lt": time.Now().Add(-1 * time.Minute)}},
+			bson.M{"$set": bson.M{"last_seen_at": time.Now(), "ip": c.ClientIP()}},
+		)
+		if dbErr != nil {
+			log.Printf("Error updating session last seen: %v", dbErr)
+		}
+
 		isAuthorized := false
 		if len(roles) > 0 {
 			for _, role := range roles {
@@ -56,6 +94,97 @@ func Authentication(roles []int) gin.HandlerFunc {
 		c.Set("email", claims.Email)
 		c.Set("userId", claims.User_ID)
 		c.Set("role", claims.Role)
+		c.Set("sessionId", claims.Session_ID)
+		c.Set("branchId", claims.Branch_ID)
+		c.Next()
+	}
+}
+
+// RequirePermission must run after Authentication. It allows the request only
+// when the user's role grants every listed permission. Permissions are read from
+// the database on each request, so role changes apply immediately.
+func RequirePermission(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId := c.GetString("userId")
+		if userId == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization required"})
+			c.Abort()
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var user models.User
+		err := database.UserCollection.FindOne(ctx, bson.M{"_id": userId}).Decode(&user)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": "User not found"})
+			c.Abort()
+			return
+		}
+
+		granted, err := helpers.GetUserPermissions(ctx, database.RoleCollection, user, c.GetString("branchId"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Error loading permissions"})
+			c.Abort()
+			return
+		}
+
+		for _, permission := range permissions {
+			if !helpers.HasPermission(granted, permission) {
+				c.JSON(http.StatusForbidden, gin.H{"success": false, "error": "Access denied", "missing_permission": permission})
+				c.Abort()
+				return
+			}
+		}
+
+		c.Set("permissions", granted)
+		c.Next()
+	}
+}
+
+// CustomerAuthentication accepts only customer tokens. Tokens issued before the
+// customer signed out everywhere are rejected.
+func CustomerAuthentication() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authHeader := c.Request.Header.Get("Authorization")
+		if authHeader == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header not provided"})
+			c.Abort()
+			return
+		}
+
+		fields := strings.Fields(authHeader)
+		if len(fields) != 2 || strings.ToLower(fields[0]) != "bearer" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization header format"})
+			c.Abort()
+			return
+		}
+
+		claims, msg := token.ValidateCustomerToken(fields[1])
+		if msg != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+			c.Abort()
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var customer models.Customer
+		err := database.CustomerCollection.FindOne(ctx, bson.M{"_id": claims.Customer_ID}).Decode(&customer)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Customer not found"})
+			c.Abort()
+			return
+		}
+		if claims.IssuedAt < customer.Tokens_Valid_After.Unix() {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has been revoked"})
+			c.Abort()
+			return
+		}
+
+		c.Set("customerId", claims.Customer_ID)
 		c.Next()
 	}
 }
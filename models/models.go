@@ -20,24 +20,310 @@ func (g TGender) IsValid() error {
 }
 
 type User struct {
-	User_ID          string    `json:"_id" bson:"_id"`
-	Branch_ID        string    `json:"branch_id" bson:"branch_id"`
-	Name             string    `json:"name" bson:"name"`
-	Email            string    `json:"email" bson:"email"`
-	Password         string    `json:"password" bson:"password"`
-	Avatar           string    `json:"avatar" bson:"avatar"`
-	Role             int       `json:"role" bson:"role"`
-	Address          string    `json:"address" bson:"address"`
-	Nrc              string    `json:"nrc" bson:"nrc"`
-	Gender           string    `json:"gender" bson:"gender"`
-	VerificationCode string    `json:"verification_code,omitempty" bson:"verification_code,omitempty"`
-	IsVerified       bool      `json:"is_verified" bson:"is_verified"`
-	T1               string    `json:"t1" bson:"t1"`
-	T2               string    `json:"t2" bson:"t2"`
-	Created_At       time.Time `json:"created_at" bson:"created_at"`
-	Updated_At       time.Time `json:"updated_at" bson:"updated_at"`
+	User_ID          string             `json:"_id" bson:"_id"`
+	Branch_ID        string             `json:"branch_id" bson:"branch_id"`
+	Name             string             `json:"name" bson:"name"`
+	Email            string             `json:"email" bson:"email"`
+	Password         string             `json:"password" bson:"password"`
+	Avatar           string             `json:"avatar" bson:"avatar"`
+	Role             int                `json:"role" bson:"role"`
+	Role_ID          string             `json:"role_id,omitempty" bson:"role_id,omitempty"`
+	Memberships      []BranchMembership `json:"memberships,omitempty" bson:"memberships,omitempty"`
+	Address          string             `json:"address" bson:"address"`
+	Nrc              string             `json:"nrc" bson:"nrc"`
+	Gender           string             `json:"gender" bson:"gender"`
+	VerificationCode string             `json:"-" bson:"verification_code,omitempty"`
+	VerifyExpiresAt  time.Time          `json:"-" bson:"verify_expires_at,omitempty"`
+	VerifyAttempts   int                `json:"-" bson:"verify_attempts,omitempty"`
+	VerifySentAt     time.Time          `json:"-" bson:"verify_sent_at,omitempty"`
+	IsVerified       bool               `json:"is_verified" bson:"is_verified"`
+	TwoFactorEnabled bool               `json:"two_factor_enabled" bson:"two_factor_enabled"`
+	TwoFactorSecret  string             `json:"-" bson:"two_factor_secret,omitempty"`
+	TwoFactorPending string             `json:"-" bson:"two_factor_pending,omitempty"`
+	TwoFactorStep    int64              `json:"-" bson:"two_factor_step,omitempty"`
+	RecoveryCodes    []string           `json:"-" bson:"recovery_codes,omitempty"`
+	Pin              string             `json:"-" bson:"pin,omitempty"`
+	ResetToken       string             `json:"-" bson:"reset_token,omitempty"`
+	ResetExpiresAt   time.Time          `json:"-" bson:"reset_expires_at,omitempty"`
+	Unlocked_At      time.Time          `json:"unlocked_at,omitempty" bson:"unlocked_at,omitempty"`
+	Unlocked_By      string             `json:"unlocked_by,omitempty" bson:"unlocked_by,omitempty"`
+	T1               string             `json:"t1" bson:"t1"`
+	T2               string             `json:"t2" bson:"t2"`
+	Created_At       time.Time          `json:"created_at" bson:"created_at"`
+	Updated_At       time.Time          `json:"updated_at" bson:"updated_at"`
 }
 
+// Invitation is a pending staff account. The invitee sets their own password
+// through the emailed link; only the hash of the link token is stored.
+type Invitation struct {
+	Invitation_ID string    `json:"_id" bson:"_id"`
+	Email         string    `json:"email" bson:"email"`
+	Name          string    `json:"name" bson:"name"`
+	Branch_ID     string    `json:"branch_id" bson:"branch_id"`
+	Role          int       `json:"role" bson:"role"`
+	Token         string    `json:"-" bson:"token"`
+	Status        string    `json:"status" bson:"status"`
+	Invited_By    string    `json:"invited_by" bson:"invited_by"`
+	User_ID       string    `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	Expires_At    time.Time `json:"expires_at" bson:"expires_at"`
+	Accepted_At   time.Time `json:"accepted_at,omitempty" bson:"accepted_at,omitempty"`
+	Created_At    time.Time `json:"created_at" bson:"created_at"`
+	Updated_At    time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+/**
+Invitation Status
+001 - pending
+002 - accepted
+003 - revoked
+**/
+
+// BranchMembership gives a user a role in a branch other than their home branch
+// (Branch_ID and Role on the user).
+type BranchMembership struct {
+	Branch_ID string `json:"branch_id" bson:"branch_id"`
+	Role      int    `json:"role" bson:"role"`
+	Role_ID   string `json:"role_id,omitempty" bson:"role_id,omitempty"`
+}
+
+// Session is one login of a user. The refresh token is only stored hashed and is
+// rotated on every refresh; the previous hash is kept to detect token reuse.
+type Session struct {
+	Session_ID    string    `json:"_id" bson:"_id"`
+	User_ID       string    `json:"user_id" bson:"user_id"`
+	RefreshToken  string    `json:"-" bson:"refresh_token"`
+	PreviousToken string    `json:"-" bson:"previous_token,omitempty"`
+	Terminal_ID   string    `json:"terminal_id,omitempty" bson:"terminal_id,omitempty"`
+	Branch_ID     string    `json:"branch_id,omitempty" bson:"branch_id,omitempty"`
+	DeviceType    string    `json:"device_type" bson:"device_type"`
+	OS            string    `json:"os" bson:"os"`
+	Browser       string    `json:"browser" bson:"browser"`
+	UserAgent     string    `json:"user_agent" bson:"user_agent"`
+	IP            string    `json:"ip" bson:"ip"`
+	IsRevoked     bool      `json:"is_revoked" bson:"is_revoked"`
+	Expires_At    time.Time `json:"expires_at" bson:"expires_at"`
+	Last_Seen_At  time.Time `json:"last_seen_at" bson:"last_seen_at"`
+	Created_At    time.Time `json:"created_at" bson:"created_at"`
+	Updated_At    time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// Terminal is a shared POS device registered to a branch, on which staff log in
+// with their PIN. Only the hash of its device token is stored.
+type Terminal struct {
+	Terminal_ID  string    `json:"_id" bson:"_id"`
+	Branch_ID    string    `json:"branch_id" bson:"branch_id"`
+	Name         string    `json:"name" bson:"name"`
+	DeviceToken  string    `json:"-" bson:"device_token"`
+	Created_By   string    `json:"created_by" bson:"created_by"`
+	Last_Used_At time.Time `json:"last_used_at,omitempty" bson:"last_used_at,omitempty"`
+	Created_At   time.Time `json:"created_at" bson:"created_at"`
+	Updated_At   time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// LoginAttempt counts recent failed attempts for one key, e.g. an account or an
+// IP address on the login, verify or PIN endpoints.
+type LoginAttempt struct {
+	Key             string    `json:"_id" bson:"_id"`
+	Failures        int       `json:"failures" bson:"failures"`
+	Last_Failure_At time.Time `json:"last_failure_at" bson:"last_failure_at"`
+	Locked_Until    time.Time `json:"locked_until,omitempty" bson:"locked_until,omitempty"`
+}
+
+// AuditLog records one create, update or delete. Entries are only ever inserted;
+// each carries the hash of the one before it, so an edited or deleted entry
+// breaks the chain.
+type AuditLog struct {
+	AuditLog_ID string        `json:"_id" bson:"_id"`
+	Seq         int64         `json:"seq" bson:"seq"`
+	Actor_ID    string        `json:"actor_id,omitempty" bson:"actor_id,omitempty"`
+	Actor_Role  int           `json:"actor_role" bson:"actor_role"`
+	Session_ID  string        `json:"session_id,omitempty" bson:"session_id,omitempty"`
+	Branch_ID   string        `json:"branch_id,omitempty" bson:"branch_id,omitempty"`
+	Action      string        `json:"action" bson:"action"`
+	Entity      string        `json:"entity" bson:"entity"`
+	Entity_ID   string        `json:"entity_id" bson:"entity_id"`
+	Changes     []AuditChange `json:"changes" bson:"changes"`
+	Method      string        `json:"method" bson:"method"`
+	Path        string        `json:"path" bson:"path"`
+	IP          string        `json:"ip" bson:"ip"`
+	UserAgent   string        `json:"user_agent" bson:"user_agent"`
+	Created_At  time.Time     `json:"created_at" bson:"created_at"`
+	Prev_Hash   string        `json:"prev_hash" bson:"prev_hash"`
+	Hash        string        `json:"hash" bson:"hash,omitempty"`
+}
+
+// AuditChange is one field of an entity before and after a change. Secrets such
+// as password hashes are recorded as "[redacted]".
+type AuditChange struct {
+	Field string      `json:"field" bson:"field"`
+	From  interface{} `json:"from" bson:"from"`
+	To    interface{} `json:"to" bson:"to"`
+}
+
+/**
+Audit Log Action
+create
+update
+delete
+**/
+
+// Customer is a guest of the restaurants, kept apart from staff users. Customers
+// sign in with a one-time code sent to their phone or email; staff can also add
+// them at the POS.
+type Customer struct {
+	Customer_ID        string            `json:"_id" bson:"_id"`
+	Name               string            `json:"name" bson:"name"`
+	Phone              string            `json:"phone,omitempty" bson:"phone,omitempty"`
+	Email              string            `json:"email,omitempty" bson:"email,omitempty"`
+	Favourites         []string          `json:"favourites" bson:"favourites"`
+	Addresses          []CustomerAddress `json:"addresses" bson:"addresses"`
+	IsVerified         bool              `json:"is_verified" bson:"is_verified"`
+	Loyalty_Points     int               `json:"loyalty_points" bson:"loyalty_points"`
+	Lifetime_Points    int               `json:"lifetime_points" bson:"lifetime_points"`
+	Tier               string            `json:"tier,omitempty" bson:"tier,omitempty"`
+	Tokens_Valid_After time.Time         `json:"-" bson:"tokens_valid_after,omitempty"`
+	Created_By         string            `json:"created_by,omitempty" bson:"created_by,omitempty"`
+	Created_At         time.Time         `json:"created_at" bson:"created_at"`
+	Updated_At         time.Time         `json:"updated_at" bson:"updated_at"`
+}
+
+// CustomerOTP is a sign-in code sent to a phone number or email. It is kept
+// apart from customers so that no customer exists until the code is verified.
+// Only the hash of the code is stored.
+type CustomerOTP struct {
+	Identity   string    `json:"_id" bson:"_id"` // the normalized phone or email
+	Code       string    `json:"-" bson:"code"`
+	Attempts   int       `json:"attempts" bson:"attempts"`
+	Sent_At    time.Time `json:"sent_at" bson:"sent_at"`
+	Expires_At time.Time `json:"expires_at" bson:"expires_at"`
+}
+
+type CustomerAddress struct {
+	Address_ID string  `json:"_id" bson:"_id"`
+	Label      string  `json:"label" bson:"label"`
+	Address    string  `json:"address" bson:"address"`
+	Note       string  `json:"note,omitempty" bson:"note,omitempty"`
+	Latitude   float64 `json:"latitude,omitempty" bson:"latitude,omitempty"`
+	Longitude  float64 `json:"longitude,omitempty" bson:"longitude,omitempty"`
+}
+
+// LoyaltySettings is the loyalty programme, shared by every branch. Customers
+// earn Earn_Rate points per currency unit paid, times their tier's multiplier,
+// and redeem points at Redeem_Value each.
+type LoyaltySettings struct {
+	Settings_ID       string        `json:"_id" bson:"_id"`
+	Enabled           bool          `json:"enabled" bson:"enabled"`
+	Earn_Rate         float64       `json:"earn_rate" bson:"earn_rate"`
+	Redeem_Value      float64       `json:"redeem_value" bson:"redeem_value"`
+	Min_Redeem_Points int           `json:"min_redeem_points" bson:"min_redeem_points"`
+	Expiry_Months     int           `json:"expiry_months" bson:"expiry_months"` // 0 means points never expire
+	Tiers             []LoyaltyTier `json:"tiers" bson:"tiers"`
+	Updated_By        string        `json:"updated_by,omitempty" bson:"updated_by,omitempty"`
+	Updated_At        time.Time     `json:"updated_at" bson:"updated_at"`
+}
+
+// LoyaltyTier is reached once a customer has earned Min_Lifetime_Points.
+type LoyaltyTier struct {
+	Name                string   `json:"name" bson:"name"`
+	Min_Lifetime_Points int      `json:"min_lifetime_points" bson:"min_lifetime_points"`
+	Multiplier          float64  `json:"multiplier" bson:"multiplier"`
+	Perks               []string `json:"perks,omitempty" bson:"perks,omitempty"`
+}
+
+// LoyaltyTransaction is one entry of a customer's points ledger. Points is
+// negative for redemptions, expiries and reversals of earned points. Earned
+// points keep in Remaining what has not been redeemed or expired yet.
+type LoyaltyTransaction struct {
+	Transaction_ID string    `json:"_id" bson:"_id"`
+	Customer_ID    string    `json:"customer_id" bson:"customer_id"`
+	Branch_ID      string    `json:"branch_id,omitempty" bson:"branch_id,omitempty"`
+	Sale_ID        string    `json:"sale_id,omitempty" bson:"sale_id,omitempty"`
+	Type           string    `json:"type" bson:"type"`
+	Points         int       `json:"points" bson:"points"`
+	Remaining      int       `json:"remaining,omitempty" bson:"remaining,omitempty"`
+	Expires_At     time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	Note           string    `json:"note,omitempty" bson:"note,omitempty"`
+	Created_By     string    `json:"created_by,omitempty" bson:"created_by,omitempty"`
+	Created_At     time.Time `json:"created_at" bson:"created_at"`
+}
+
+// SaleGiftCardItem sells a new gift card, or loads an existing one when Code is
+// given. Code is only ever read from the request.
+type SaleGiftCardItem struct {
+	GiftCard_ID string  `json:"gift_card_id,omitempty" bson:"gift_card_id"`
+	Code        string  `json:"code,omitempty" bson:"-"`
+	Code_Last4  string  `json:"code_last4,omitempty" bson:"code_last4"`
+	Customer_ID string  `json:"customer_id,omitempty" bson:"customer_id,omitempty"`
+	Amount      float64 `json:"amount" bson:"amount"`
+}
+
+// SaleGiftCardPayment pays part of a sale from a gift card, found by its Code or,
+// for cards in the sale customer's wallet, by GiftCard_ID together with the
+// sale's Customer_Code.
+type SaleGiftCardPayment struct {
+	GiftCard_ID string  `json:"gift_card_id,omitempty" bson:"gift_card_id"`
+	Code        string  `json:"code,omitempty" bson:"-"`
+	Code_Last4  string  `json:"code_last4,omitempty" bson:"code_last4"`
+	Amount      float64 `json:"amount" bson:"amount"`
+}
+
+// GiftCard is a stored-value card. Only a hash of its code is kept; the code
+// itself is shown once when the card is issued. Cards with a Customer_ID are in
+// that customer's wallet.
+type GiftCard struct {
+	GiftCard_ID     string    `json:"_id" bson:"_id"`
+	Code_Hash       string    `json:"-" bson:"code_hash"`
+	Code_Last4      string    `json:"code_last4" bson:"code_last4"`
+	Branch_ID       string    `json:"branch_id" bson:"branch_id"` // issuing branch, cards are accepted at every branch
+	Customer_ID     string    `json:"customer_id,omitempty" bson:"customer_id,omitempty"`
+	Initial_Balance float64   `json:"initial_balance" bson:"initial_balance"`
+	Balance         float64   `json:"balance" bson:"balance"`
+	Status          string    `json:"status" bson:"status"`
+	Sale_ID         string    `json:"sale_id,omitempty" bson:"sale_id,omitempty"`
+	Note            string    `json:"note,omitempty" bson:"note,omitempty"`
+	Created_By      string    `json:"created_by,omitempty" bson:"created_by,omitempty"`
+	Created_At      time.Time `json:"created_at" bson:"created_at"`
+	Updated_At      time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+/**
+Gift Card Status
+001 - active
+002 - disabled
+**/
+
+// GiftCardTransaction is one entry of a gift card's ledger. Amount is negative
+// for redemptions and voids.
+type GiftCardTransaction struct {
+	Transaction_ID string    `json:"_id" bson:"_id"`
+	GiftCard_ID    string    `json:"gift_card_id" bson:"gift_card_id"`
+	Branch_ID      string    `json:"branch_id" bson:"branch_id"`
+	Sale_ID        string    `json:"sale_id,omitempty" bson:"sale_id,omitempty"`
+	Type           string    `json:"type" bson:"type"`
+	Amount         float64   `json:"amount" bson:"amount"`
+	Balance_After  float64   `json:"balance_after" bson:"balance_after"`
+	Note           string    `json:"note,omitempty" bson:"note,omitempty"`
+	Created_By     string    `json:"created_by,omitempty" bson:"created_by,omitempty"`
+	Created_At     time.Time `json:"created_at" bson:"created_at"`
+}
+
+/**
+Gift Card Transaction Type
+001 - loaded (issue or top-up)
+002 - redeemed on a sale
+003 - returned on a refunded sale
+004 - voided
+**/
+
+/**
+Loyalty Transaction Type
+001 - earned on a sale
+002 - redeemed on a sale
+003 - expired
+004 - reversed on refund
+005 - adjusted by staff
+**/
+
 /**
 User Roles
 0 - waiter or chef
@@ -47,16 +333,130 @@ User Roles
 100 - root admin
 **/
 
-type Branch struct {
-	Branch_ID   string    `json:"_id" bson:"_id"`
+// Role is a named set of permissions. The default roles mirror the role levels
+// above and belong to no branch; custom roles belong to one branch and are based
+// on a level, which users assigned to them take on.
+type Role struct {
+	Role_ID     string    `json:"_id" bson:"_id"`
+	Branch_ID   string    `json:"branch_id,omitempty" bson:"branch_id,omitempty"`
 	Name        string    `json:"name" bson:"name"`
-	Description string    `json:"description" bson:"description"`
-	Address     string    `json:"address" bson:"address"`
-	Contact     string    `json:"contact" bson:"contact"`
+	Level       int       `json:"level" bson:"level"`
+	Permissions []string  `json:"permissions" bson:"permissions"`
+	IsDefault   bool      `json:"is_default" bson:"is_default"`
+	Migrations  []string  `json:"-" bson:"migrations,omitempty"` // permission migrations already applied to a default role
 	Created_At  time.Time `json:"created_at" bson:"created_at"`
 	Updated_At  time.Time `json:"updated_at" bson:"updated_at"`
 }
 
+// Shift is a scheduled working period of a user at a branch, as set on the
+// weekly roster.
+type Shift struct {
+	Shift_ID   string    `json:"_id" bson:"_id"`
+	Branch_ID  string    `json:"branch_id" bson:"branch_id"`
+	User_ID    string    `json:"user_id" bson:"user_id"`
+	Start_At   time.Time `json:"start_at" bson:"start_at"`
+	End_At     time.Time `json:"end_at" bson:"end_at"`
+	Note       string    `json:"note,omitempty" bson:"note,omitempty"`
+	Created_By string    `json:"created_by" bson:"created_by"`
+	Created_At time.Time `json:"created_at" bson:"created_at"`
+	Updated_At time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// TimeEntry is one clock-in to clock-out of a user at a branch. Terminal_ID is
+// set when the punch was made from a PIN session on a registered terminal.
+type TimeEntry struct {
+	TimeEntry_ID string      `json:"_id" bson:"_id"`
+	User_ID      string      `json:"user_id" bson:"user_id"`
+	Branch_ID    string      `json:"branch_id" bson:"branch_id"`
+	Terminal_ID  string      `json:"terminal_id,omitempty" bson:"terminal_id,omitempty"`
+	Clock_In_At  time.Time   `json:"clock_in_at" bson:"clock_in_at"`
+	Clock_Out_At time.Time   `json:"clock_out_at,omitempty" bson:"clock_out_at,omitempty"`
+	Breaks       []TimeBreak `json:"breaks" bson:"breaks"`
+	Status       string      `json:"status" bson:"status"`
+	Note         string      `json:"note,omitempty" bson:"note,omitempty"`
+	Edited_By    string      `json:"edited_by,omitempty" bson:"edited_by,omitempty"`
+	Created_At   time.Time   `json:"created_at" bson:"created_at"`
+	Updated_At   time.Time   `json:"updated_at" bson:"updated_at"`
+}
+
+type TimeBreak struct {
+	Start_At time.Time `json:"start_at" bson:"start_at"`
+	End_At   time.Time `json:"end_at,omitempty" bson:"end_at,omitempty"`
+}
+
+/**
+Time Entry Status
+001 - clocked in
+002 - clocked out
+**/
+
+type Branch struct {
+	Branch_ID       string         `json:"_id" bson:"_id"`
+	Name            string         `json:"name" bson:"name"`
+	Description     string         `json:"description" bson:"description"`
+	Address         string         `json:"address" bson:"address"`
+	Contact         string         `json:"contact" bson:"contact"`
+	Tip_Policy      TipPolicy      `json:"tip_policy" bson:"tip_policy"`
+	Preorder_Policy PreorderPolicy `json:"preorder_policy" bson:"preorder_policy"`
+	Latitude        float64        `json:"latitude,omitempty" bson:"latitude,omitempty"`
+	Longitude       float64        `json:"longitude,omitempty" bson:"longitude,omitempty"`
+	Delivery_Radius float64        `json:"delivery_radius_km,omitempty" bson:"delivery_radius_km,omitempty"` // furthest delivery distance, 0 means no limit
+	Delivery_Zones  []DeliveryZone `json:"delivery_zones,omitempty" bson:"delivery_zones,omitempty"`
+	Created_At      time.Time      `json:"created_at" bson:"created_at"`
+	Updated_At      time.Time      `json:"updated_at" bson:"updated_at"`
+}
+
+// PreorderPolicy is how a branch takes orders scheduled for a later time. They
+// reach the kitchen Lead_Minutes before they are due and staff are reminded
+// Reminder_Minutes before. At most Slot_Capacity orders may be due in a slot of
+// Slot_Minutes, 0 means no limit. Zero minutes fall back to the defaults.
+type PreorderPolicy struct {
+	Lead_Minutes     int `json:"lead_minutes" bson:"lead_minutes"`
+	Slot_Minutes     int `json:"slot_minutes" bson:"slot_minutes"`
+	Slot_Capacity    int `json:"slot_capacity" bson:"slot_capacity"`
+	Reminder_Minutes int `json:"reminder_minutes" bson:"reminder_minutes"`
+}
+
+// TipPolicy is how a branch shares out tips. House_Percent of every tip is kept
+// by the house before the rest is shared.
+type TipPolicy struct {
+	Mode           string  `json:"mode" bson:"mode"`
+	House_Percent  float64 `json:"house_percent" bson:"house_percent"`
+	Eligible_Roles []int   `json:"eligible_roles,omitempty" bson:"eligible_roles,omitempty"`
+}
+
+// DeliveryZone is an area a branch delivers to, either within Radius_Km of the
+// branch or inside Polygon. The fee is taken from Fee_Bands by distance when
+// there are any, otherwise it is the flat Fee.
+type DeliveryZone struct {
+	Zone_ID        string            `json:"zone_id" bson:"zone_id"`
+	Name           string            `json:"name" bson:"name"`
+	Radius_Km      float64           `json:"radius_km,omitempty" bson:"radius_km,omitempty"`
+	Polygon        []GeoPoint        `json:"polygon,omitempty" bson:"polygon,omitempty"`
+	Min_Order      float64           `json:"min_order" bson:"min_order"`
+	Fee            float64           `json:"fee" bson:"fee"`
+	Fee_Bands      []DeliveryFeeBand `json:"fee_bands,omitempty" bson:"fee_bands,omitempty"`
+	Base_Minutes   int               `json:"base_minutes" bson:"base_minutes"`     // preparation and hand-over
+	Minutes_Per_Km float64           `json:"minutes_per_km" bson:"minutes_per_km"` // travel
+}
+
+// DeliveryFeeBand charges Fee for deliveries up to Up_To_Km from the branch.
+type DeliveryFeeBand struct {
+	Up_To_Km float64 `json:"up_to_km" bson:"up_to_km"`
+	Fee      float64 `json:"fee" bson:"fee"`
+}
+
+type GeoPoint struct {
+	Latitude  float64 `json:"latitude" bson:"latitude"`
+	Longitude float64 `json:"longitude" bson:"longitude"`
+}
+
+/**
+Tip Policy Mode
+001 - individual, each tip goes to the servers of its sale in equal parts
+002 - pooled, all tips are shared among staff of the eligible roles by hours clocked
+**/
+
 type Category struct {
 	Category_ID string    `json:"_id" bson:"_id"`
 	Branch_ID   string    `json:"branch_id" bson:"branch_id"`
@@ -106,12 +506,14 @@ type Table struct {
 	Seats      int       `json:"seats" bson:"seats"`
 	Status     string    `json:"status" bson:"status"`
 	IsReserved bool      `json:"is_reserved" bson:"is_reserved"`
+	QR_Nonce   string    `json:"-" bson:"qr_nonce,omitempty"`
 	Created_At time.Time `json:"created_at" bson:"created_at"`
 	Updated_At time.Time `json:"updated_at" bson:"updated_at"`
 }
 
 /**
 order status
+000 => Awaiting Confirmation (guest orders placed via table QR code)
 001 => Pending
 002 => In Progress
 003 => Completed
@@ -119,16 +521,96 @@ order status
 **/
 
 type Order struct {
-	Order_ID    string      `json:"_id" bson:"_id"`
-	Table_ID    string      `json:"table_id" bson:"table_id"`
-	Branch_ID   string      `json:"branch_id" bson:"branch_id"`
-	MenuItems   []OrderItem `json:"menu_items" bson:"menu_items"`
-	TotalAmount float64     `json:"total_amount" bson:"total_amount"`
-	Status      string      `json:"status" bson:"status"` // e.g., "Pending", "In Progress", "Completed", "Cancelled"
-	Note        string      `json:"note,omitempty" bson:"note,omitempty"`
-	IsPaid      bool        `json:"is_paid" bson:"is_paid"`
-	Created_At  time.Time   `json:"created_at" bson:"created_at"`
-	Updated_At  time.Time   `json:"updated_at" bson:"updated_at"`
+	Order_ID             string         `json:"_id" bson:"_id"`
+	Order_Type           string         `json:"order_type" bson:"order_type"`
+	Table_ID             string         `json:"table_id" bson:"table_id"` // dine-in orders only
+	Branch_ID            string         `json:"branch_id" bson:"branch_id"`
+	MenuItems            []OrderItem    `json:"menu_items" bson:"menu_items"`
+	TotalAmount          float64        `json:"total_amount" bson:"total_amount"` // includes the delivery fee
+	Status               string         `json:"status" bson:"status"`             // e.g., "Pending", "In Progress", "Completed", "Cancelled"
+	Note                 string         `json:"note,omitempty" bson:"note,omitempty"`
+	Server_ID            string         `json:"server_id,omitempty" bson:"server_id,omitempty"` // staff who took or confirmed the order
+	Customer_ID          string         `json:"customer_id,omitempty" bson:"customer_id,omitempty"`
+	Customer_Name        string         `json:"customer_name,omitempty" bson:"customer_name,omitempty"`
+	Customer_Phone       string         `json:"customer_phone,omitempty" bson:"customer_phone,omitempty"`
+	Delivery             *OrderDelivery `json:"delivery,omitempty" bson:"delivery,omitempty"`
+	Promised_Ready_At    time.Time      `json:"promised_ready_at,omitempty" bson:"promised_ready_at,omitempty"`
+	Promised_Delivery_At time.Time      `json:"promised_delivery_at,omitempty" bson:"promised_delivery_at,omitempty"`
+	Scheduled_For        time.Time      `json:"scheduled_for,omitempty" bson:"scheduled_for,omitempty"` // pre-orders only
+	Release_At           time.Time      `json:"release_at,omitempty" bson:"release_at,omitempty"`       // when a pre-order shows in the kitchen queue
+	Remind_At            time.Time      `json:"remind_at,omitempty" bson:"remind_at,omitempty"`
+	Reminded_At          time.Time      `json:"reminded_at,omitempty" bson:"reminded_at,omitempty"`
+	Preorder_Slot        string         `json:"-" bson:"preorder_slot,omitempty"` // the slot counter a pre-order holds a place in
+	Source               string         `json:"source,omitempty" bson:"source,omitempty"`
+	Platform_ID          string         `json:"platform_id,omitempty" bson:"platform_id,omitempty"`         // platform orders only
+	Source_Order_ID      string         `json:"source_order_id,omitempty" bson:"source_order_id,omitempty"` // the platform's own order ID
+	IsPaid               bool           `json:"is_paid" bson:"is_paid"`
+	Created_At           time.Time      `json:"created_at" bson:"created_at"`
+	Updated_At           time.Time      `json:"updated_at" bson:"updated_at"`
+}
+
+/**
+Order Type
+001 - dine-in
+002 - takeaway
+003 - delivery
+004 - pickup
+**/
+
+/**
+Order Source
+pos      - taken by staff
+guest    - placed from a table QR code
+<name>   - a delivery platform, e.g. foodpanda or grabfood
+**/
+
+// OrderDelivery is where and by whom a delivery order is delivered.
+type OrderDelivery struct {
+	Address       string    `json:"address" bson:"address"`
+	Note          string    `json:"note,omitempty" bson:"note,omitempty"`
+	Latitude      float64   `json:"latitude,omitempty" bson:"latitude,omitempty"`
+	Longitude     float64   `json:"longitude,omitempty" bson:"longitude,omitempty"`
+	Zone          string    `json:"zone,omitempty" bson:"zone,omitempty"`
+	Fee           float64   `json:"fee" bson:"fee"`
+	Distance_Km   float64   `json:"distance_km,omitempty" bson:"distance_km,omitempty"`
+	Driver_ID     string    `json:"driver_id,omitempty" bson:"driver_id,omitempty"`
+	Status        string    `json:"status" bson:"status"`
+	Dispatched_At time.Time `json:"dispatched_at,omitempty" bson:"dispatched_at,omitempty"`
+	Delivered_At  time.Time `json:"delivered_at,omitempty" bson:"delivered_at,omitempty"`
+}
+
+/**
+Delivery Status
+001 - awaiting a driver
+002 - driver assigned
+003 - out for delivery
+004 - delivered
+**/
+
+// DeliveryPlatform is a branch's account on a third-party delivery platform.
+// Orders arrive on its webhook signed with Webhook_Secret, and status changes
+// are posted back to Callback_URL signed with the same secret.
+type DeliveryPlatform struct {
+	Platform_ID    string                `json:"_id" bson:"_id"`
+	Branch_ID      string                `json:"branch_id" bson:"branch_id"`
+	Platform       string                `json:"platform" bson:"platform"` // adapter name, e.g. foodpanda
+	Name           string                `json:"name" bson:"name"`
+	Webhook_Secret string                `json:"-" bson:"webhook_secret"`
+	Callback_URL   string                `json:"callback_url,omitempty" bson:"callback_url,omitempty"`
+	Auto_Accept    bool                  `json:"auto_accept" bson:"auto_accept"` // skip staff confirmation
+	Item_Mappings  []PlatformItemMapping `json:"item_mappings" bson:"item_mappings"`
+	IsActive       bool                  `json:"is_active" bson:"is_active"`
+	Created_By     string                `json:"created_by,omitempty" bson:"created_by,omitempty"`
+	Created_At     time.Time             `json:"created_at" bson:"created_at"`
+	Updated_At     time.Time             `json:"updated_at" bson:"updated_at"`
+}
+
+// PlatformItemMapping maps a platform's item ID to one of our menus, or to an
+// add-on when AddOn_ID is set.
+type PlatformItemMapping struct {
+	External_ID string `json:"external_id" bson:"external_id"`
+	Menu_ID     string `json:"menu_id" bson:"menu_id"`
+	AddOn_ID    string `json:"add_on_id,omitempty" bson:"add_on_id,omitempty"`
 }
 
 // OrderItem represents an individual item in an order
@@ -146,15 +628,107 @@ type AddOnItem struct {
 }
 
 type Sale struct {
-	Sale_ID       string    `json:"_id" bson:"_id"`
-	Table_ID      string    `json:"table_id" bson:"table_id"`
-	Branch_ID     string    `json:"branch_id" bson:"branch_id"`
-	OrderIDs      []string  `json:"order_ids" bson:"order_ids"`
-	TotalAmount   float64   `json:"total_amount" bson:"total_amount"`
-	Discount      float64   `json:"discount" bson:"discount"`
-	Tax           float64   `json:"tax" bson:"tax"`
-	GrandTotal    float64   `json:"grand_total" bson:"grand_total"`
-	PaymentMethod string    `json:"payment_method" bson:"payment_method"` // Payment method (e.g., "Cash", "Card")
-	Note          string    `json:"note,omitempty" bson:"note,omitempty"`
-	Created_At    time.Time `json:"created_at" bson:"created_at"`
+	Sale_ID           string                `json:"_id" bson:"_id"`
+	Table_ID          string                `json:"table_id" bson:"table_id"`
+	Branch_ID         string                `json:"branch_id" bson:"branch_id"`
+	OrderIDs          []string              `json:"order_ids" bson:"order_ids"`
+	TotalAmount       float64               `json:"total_amount" bson:"total_amount"`
+	Discount          float64               `json:"discount" bson:"discount"`
+	Tax               float64               `json:"tax" bson:"tax"`
+	GrandTotal        float64               `json:"grand_total" bson:"grand_total"`
+	Tip               float64               `json:"tip" bson:"tip"`                       // paid on top of GrandTotal
+	PaymentMethod     string                `json:"payment_method" bson:"payment_method"` // Payment method (e.g., "Cash", "Card")
+	Cashier_ID        string                `json:"cashier_id,omitempty" bson:"cashier_id,omitempty"`
+	Server_IDs        []string              `json:"server_ids,omitempty" bson:"server_ids,omitempty"`
+	Customer_ID       string                `json:"customer_id,omitempty" bson:"customer_id,omitempty"`
+	Points_Redeemed   int                   `json:"points_redeemed,omitempty" bson:"points_redeemed,omitempty"`
+	Loyalty_Discount  float64               `json:"loyalty_discount,omitempty" bson:"loyalty_discount,omitempty"` // value of the redeemed points, taken off GrandTotal
+	Points_Earned     int                   `json:"points_earned,omitempty" bson:"points_earned,omitempty"`
+	GiftCard_Items    []SaleGiftCardItem    `json:"gift_card_items,omitempty" bson:"gift_card_items,omitempty"`       // gift cards sold, included in GrandTotal
+	GiftCard_Payments []SaleGiftCardPayment `json:"gift_card_payments,omitempty" bson:"gift_card_payments,omitempty"` // paid from gift cards, the rest by PaymentMethod
+	Customer_Code     string                `json:"customer_code,omitempty" bson:"-"`                                 // sign-in code sent to the customer, required to pay from their wallet
+	Note              string                `json:"note,omitempty" bson:"note,omitempty"`
+	IsRefunded        bool                  `json:"is_refunded" bson:"is_refunded"`
+	Refund_Reason     string                `json:"refund_reason,omitempty" bson:"refund_reason,omitempty"`
+	Refunded_By       string                `json:"refunded_by,omitempty" bson:"refunded_by,omitempty"`
+	Refunded_At       time.Time             `json:"refunded_at,omitempty" bson:"refunded_at,omitempty"`
+	Created_At        time.Time             `json:"created_at" bson:"created_at"`
 }
+
+// WebhookEndpoint is a URL of an external tool, e.g. accounting software, that
+// a branch's events are posted to. Payloads are signed with Secret.
+type WebhookEndpoint struct {
+	Endpoint_ID string    `json:"_id" bson:"_id"`
+	Branch_ID   string    `json:"branch_id" bson:"branch_id"`
+	Name        string    `json:"name" bson:"name"`
+	URL         string    `json:"url" bson:"url"`
+	Events      []string  `json:"events" bson:"events"` // e.g. order.created, sale.refunded
+	Secret      string    `json:"-" bson:"secret"`
+	IsActive    bool      `json:"is_active" bson:"is_active"`
+	Created_By  string    `json:"created_by,omitempty" bson:"created_by,omitempty"`
+	Created_At  time.Time `json:"created_at" bson:"created_at"`
+	Updated_At  time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// WebhookDelivery is one event sent to one endpoint, with the log of every
+// attempt to deliver it.
+type WebhookDelivery struct {
+	Delivery_ID     string           `json:"_id" bson:"_id"`
+	Endpoint_ID     string           `json:"endpoint_id" bson:"endpoint_id"`
+	Branch_ID       string           `json:"branch_id" bson:"branch_id"`
+	Event           string           `json:"event" bson:"event"`
+	Payload         string           `json:"payload" bson:"payload"` // the JSON body, exactly as signed
+	Status          string           `json:"status" bson:"status"`
+	Attempts        []WebhookAttempt `json:"attempts" bson:"attempts"`
+	Next_Attempt_At time.Time        `json:"next_attempt_at,omitempty" bson:"next_attempt_at,omitempty"`
+	Delivered_At    time.Time        `json:"delivered_at,omitempty" bson:"delivered_at,omitempty"`
+	Replay_Of       string           `json:"replay_of,omitempty" bson:"replay_of,omitempty"`
+	Created_At      time.Time        `json:"created_at" bson:"created_at"`
+	Updated_At      time.Time        `json:"updated_at" bson:"updated_at"`
+}
+
+/**
+Webhook Delivery Status
+001 - pending
+002 - delivered
+003 - dead, gave up after the last retry
+**/
+
+type WebhookAttempt struct {
+	Attempted_At time.Time `json:"attempted_at" bson:"attempted_at"`
+	Status_Code  int       `json:"status_code,omitempty" bson:"status_code,omitempty"`
+	Error        string    `json:"error,omitempty" bson:"error,omitempty"`
+	Duration_Ms  int64     `json:"duration_ms" bson:"duration_ms"`
+}
+
+// Job is a unit of background work, e.g. an email or a webhook delivery, run by
+// the job workers. Failed jobs are retried with backoff until Max_Attempts.
+type Job struct {
+	Job_ID       string      `json:"_id" bson:"_id"`
+	Type         string      `json:"type" bson:"type"`
+	Payload      string      `json:"payload,omitempty" bson:"payload,omitempty"` // JSON, cleared once jobs carrying secrets finish and never shown for them
+	Branch_ID    string      `json:"branch_id,omitempty" bson:"branch_id,omitempty"`
+	Status       string      `json:"status" bson:"status"`
+	Attempts     int         `json:"attempts" bson:"attempts"`
+	Max_Attempts int         `json:"max_attempts" bson:"max_attempts"`
+	Run_At       time.Time   `json:"run_at" bson:"run_at"` // not before, for retries and scheduled jobs
+	Locked_Until time.Time   `json:"locked_until,omitempty" bson:"locked_until,omitempty"`
+	Worker_ID    string      `json:"worker_id,omitempty" bson:"worker_id,omitempty"`
+	Last_Error   string      `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	Result       interface{} `json:"result,omitempty" bson:"result,omitempty"`
+	Unique_Key   string      `json:"unique_key,omitempty" bson:"unique_key,omitempty"` // at most one job per key, e.g. per run of a recurring job
+	Created_By   string      `json:"created_by,omitempty" bson:"created_by,omitempty"`
+	Created_At   time.Time   `json:"created_at" bson:"created_at"`
+	Started_At   time.Time   `json:"started_at,omitempty" bson:"started_at,omitempty"`
+	Finished_At  time.Time   `json:"finished_at,omitempty" bson:"finished_at,omitempty"`
+	Updated_At   time.Time   `json:"updated_at" bson:"updated_at"`
+}
+
+/**
+Job Status
+001 - queued, also while waiting to be retried
+002 - running
+003 - completed
+004 - failed, out of attempts
+005 - cancelled
+**/
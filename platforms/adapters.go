@@ -0,0 +1,410 @@
+package platforms
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+func init() {
+	register("generic", genericAdapter{})
+	register("foodpanda", foodpandaAdapter{})
+	register("grabfood", grabfoodAdapter{})
+}
+
+func validateExternalOrder(order ExternalOrder) error {
+	if order.External_ID == "" {
+		return fmt.Errorf("order ID is missing")
+	}
+	if order.Cancelled {
+		return nil
+	}
+	if order.Order_Type != OrderTypeDelivery && order.Order_Type != OrderTypePickup {
+		return fmt.Errorf("unsupported order type: %s", order.Order_Type)
+	}
+	if len(order.Items) == 0 {
+		return fmt.Errorf("order has no items")
+	}
+	return nil
+}
+
+// genericAdapter is our own webhook format, for platforms or middleware that
+// can be configured to send it.
+type genericAdapter struct{}
+
+var genericStatuses = statusNames{"001": "accepted", "002": "preparing", "003": "completed", "004": "cancelled"}
+
+type genericItem struct {
+	ID       string        `json:"id"`
+	Quantity int           `json:"quantity"`
+	Note     string        `json:"note,omitempty"`
+	Options  []genericItem `json:"options,omitempty"`
+}
+
+type genericOrder struct {
+	Event    string `json:"event"` // order.created or order.cancelled
+	OrderID  string `json:"order_id"`
+	Type     string `json:"type"`
+	Customer struct {
+		Name  string `json:"name"`
+		Phone string `json:"phone"`
+	} `json:"customer"`
+	Delivery struct {
+		Address   string  `json:"address"`
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+		Fee       float64 `json:"fee"`
+	} `json:"delivery"`
+	Items        []genericItem `json:"items"`
+	Note         string        `json:"note,omitempty"`
+	ScheduledFor time.Time     `json:"scheduled_for,omitempty"`
+}
+
+func (genericAdapter) SignatureHeader() string { return "X-Signature" }
+func (genericAdapter) TimestampHeader() string { return "X-Timestamp" }
+
+func (genericAdapter) ParseOrder(body []byte) (ExternalOrder, error) {
+	var payload genericOrder
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return ExternalOrder{}, err
+	}
+
+	var toItems func(items []genericItem) []ExternalItem
+	toItems = func(items []genericItem) []ExternalItem {
+		result := []ExternalItem{}
+		for _, item := range items {
+			result = append(result, ExternalItem{External_ID: item.ID, Quantity: item.Quantity, Note: item.Note, Options: toItems(item.Options)})
+		}
+		return result
+	}
+
+	order := ExternalOrder{
+		External_ID:    payload.OrderID,
+		Cancelled:      payload.Event == "order.cancelled",
+		Order_Type:     payload.Type,
+		Customer_Name:  payload.Customer.Name,
+		Customer_Phone: payload.Customer.Phone,
+		Address:        payload.Delivery.Address,
+		Latitude:       payload.Delivery.Latitude,
+		Longitude:      payload.Delivery.Longitude,
+		Delivery_Fee:   payload.Delivery.Fee,
+		Note:           payload.Note,
+		Scheduled_For:  payload.ScheduledFor,
+		Items:          toItems(payload.Items),
+	}
+	return order, validateExternalOrder(order)
+}
+
+func (genericAdapter) EncodeOrder(order ExternalOrder) ([]byte, error) {
+	var fromItems func(items []ExternalItem) []genericItem
+	fromItems = func(items []ExternalItem) []genericItem {
+		result := []genericItem{}
+		for _, item := range items {
+			result = append(result, genericItem{ID: item.External_ID, Quantity: item.Quantity, Note: item.Note, Options: fromItems(item.Options)})
+		}
+		return result
+	}
+
+	payload := genericOrder{Event: "order.created", OrderID: order.External_ID, Type: order.Order_Type, Items: fromItems(order.Items), Note: order.Note, ScheduledFor: order.Scheduled_For}
+	if order.Cancelled {
+		payload.Event = "order.cancelled"
+	}
+	payload.Customer.Name = order.Customer_Name
+	payload.Customer.Phone = order.Customer_Phone
+	payload.Delivery.Address = order.Address
+	payload.Delivery.Latitude = order.Latitude
+	payload.Delivery.Longitude = order.Longitude
+	payload.Delivery.Fee = order.Delivery_Fee
+	return json.Marshal(payload)
+}
+
+func (genericAdapter) StatusPayload(externalID string, status string) ([]byte, error) {
+	name, err := genericStatuses.external(status)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]string{"order_id": externalID, "status": name})
+}
+
+func (genericAdapter) ParseStatus(body []byte) (string, string, error) {
+	var payload struct {
+		OrderID string `json:"order_id"`
+		Status  string `json:"status"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return "", "", err
+	}
+	status, err := genericStatuses.internal(payload.Status)
+	return payload.OrderID, status, err
+}
+
+// foodpandaAdapter reads Foodpanda-style payloads: products with remote codes and
+// toppings, and an expedition type.
+type foodpandaAdapter struct{}
+
+var foodpandaStatuses = statusNames{"001": "order_accepted", "002": "order_preparing", "003": "order_picked_up", "004": "order_rejected"}
+
+type foodpandaProduct struct {
+	RemoteCode       string             `json:"remoteCode"`
+	Quantity         int                `json:"quantity"`
+	Comment          string             `json:"comment,omitempty"`
+	SelectedToppings []foodpandaProduct `json:"selectedToppings,omitempty"`
+}
+
+type foodpandaOrder struct {
+	Code           string `json:"code"`
+	Status         string `json:"status"` // RECEIVED or CANCELLED
+	ExpeditionType string `json:"expeditionType"`
+	PreOrderTime   string `json:"preOrderTime,omitempty"`
+	Customer       struct {
+		FirstName   string `json:"firstName"`
+		LastName    string `json:"lastName"`
+		MobilePhone string `json:"mobilePhone"`
+	} `json:"customer"`
+	Delivery struct {
+		Address struct {
+			Street    string  `json:"street"`
+			City      string  `json:"city"`
+			Latitude  float64 `json:"latitude"`
+			Longitude float64 `json:"longitude"`
+		} `json:"address"`
+	} `json:"delivery"`
+	Products []foodpandaProduct `json:"products"`
+	Comments struct {
+		CustomerComment string `json:"customerComment,omitempty"`
+	} `json:"comments"`
+	Price struct {
+		DeliveryFees []struct {
+			Value float64 `json:"value"`
+		} `json:"deliveryFees"`
+	} `json:"price"`
+}
+
+func (foodpandaAdapter) SignatureHeader() string { return "X-Foodpanda-Signature" }
+func (foodpandaAdapter) TimestampHeader() string { return "X-Foodpanda-Timestamp" }
+
+func (foodpandaAdapter) ParseOrder(body []byte) (ExternalOrder, error) {
+	var payload foodpandaOrder
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return ExternalOrder{}, err
+	}
+
+	var toItems func(products []foodpandaProduct) []ExternalItem
+	toItems = func(products []foodpandaProduct) []ExternalItem {
+		result := []ExternalItem{}
+		for _, product := range products {
+			result = append(result, ExternalItem{External_ID: product.RemoteCode, Quantity: product.Quantity, Note: product.Comment, Options: toItems(product.SelectedToppings)})
+		}
+		return result
+	}
+
+	fee := 0.0
+	for _, deliveryFee := range payload.Price.DeliveryFees {
+		fee += deliveryFee.Value
+	}
+	address := payload.Delivery.Address.Street
+	if payload.Delivery.Address.City != "" {
+		address = strings.TrimSpace(address + ", " + payload.Delivery.Address.City)
+	}
+
+	order := ExternalOrder{
+		External_ID:    payload.Code,
+		Cancelled:      payload.Status == "CANCELLED",
+		Order_Type:     strings.ToLower(payload.ExpeditionType),
+		Customer_Name:  strings.TrimSpace(payload.Customer.FirstName + " " + payload.Customer.LastName),
+		Customer_Phone: payload.Customer.MobilePhone,
+		Address:        address,
+		Latitude:       payload.Delivery.Address.Latitude,
+		Longitude:      payload.Delivery.Address.Longitude,
+		Delivery_Fee:   fee,
+		Note:           payload.Comments.CustomerComment,
+		Items:          toItems(payload.Products),
+	}
+	if payload.PreOrderTime != "" {
+		scheduledFor, err := time.Parse(time.RFC3339, payload.PreOrderTime)
+		if err != nil {
+			return order, fmt.Errorf("invalid pre-order time: %v", err)
+		}
+		order.Scheduled_For = scheduledFor
+	}
+	return order, validateExternalOrder(order)
+}
+
+func (foodpandaAdapter) EncodeOrder(order ExternalOrder) ([]byte, error) {
+	var fromItems func(items []ExternalItem) []foodpandaProduct
+	fromItems = func(items []ExternalItem) []foodpandaProduct {
+		result := []foodpandaProduct{}
+		for _, item := range items {
+			result = append(result, foodpandaProduct{RemoteCode: item.External_ID, Quantity: item.Quantity, Comment: item.Note, SelectedToppings: fromItems(item.Options)})
+		}
+		return result
+	}
+
+	payload := foodpandaOrder{Code: order.External_ID, Status: "RECEIVED", ExpeditionType: order.Order_Type, Products: fromItems(order.Items)}
+	if order.Cancelled {
+		payload.Status = "CANCELLED"
+	}
+	if !order.Scheduled_For.IsZero() {
+		payload.PreOrderTime = order.Scheduled_For.Format(time.RFC3339)
+	}
+	payload.Customer.FirstName = order.Customer_Name
+	payload.Customer.MobilePhone = order.Customer_Phone
+	payload.Delivery.Address.Street = order.Address
+	payload.Delivery.Address.Latitude = order.Latitude
+	payload.Delivery.Address.Longitude = order.Longitude
+	payload.Comments.CustomerComment = order.Note
+	payload.Price.DeliveryFees = append(payload.Price.DeliveryFees, struct {
+		Value float64 `json:"value"`
+	}{Value: order.Delivery_Fee})
+	return json.Marshal(payload)
+}
+
+func (foodpandaAdapter) StatusPayload(externalID string, status string) ([]byte, error) {
+	name, err := foodpandaStatuses.external(status)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]string{"orderId": externalID, "status": name})
+}
+
+func (foodpandaAdapter) ParseStatus(body []byte) (string, string, error) {
+	var payload struct {
+		OrderID string `json:"orderId"`
+		Status  string `json:"status"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return "", "", err
+	}
+	status, err := foodpandaStatuses.internal(payload.Status)
+	return payload.OrderID, status, err
+}
+
+// grabfoodAdapter reads GrabFood-style payloads: items with modifiers, a
+// receiver and upper-case states.
+type grabfoodAdapter struct{}
+
+var grabfoodStatuses = statusNames{"001": "ACCEPTED", "002": "PREPARING", "003": "COLLECTED", "004": "REJECTED"}
+
+type grabfoodItem struct {
+	ID             string         `json:"id"`
+	Quantity       int            `json:"quantity"`
+	Specifications string         `json:"specifications,omitempty"`
+	Modifiers      []grabfoodItem `json:"modifiers,omitempty"`
+}
+
+type grabfoodOrder struct {
+	OrderID       string `json:"orderID"`
+	State         string `json:"state"`     // PENDING or CANCELLED
+	OrderType     string `json:"orderType"` // DELIVERY or TAKEAWAY
+	ScheduledTime string `json:"scheduledTime,omitempty"`
+	Receiver      struct {
+		Name    string `json:"name"`
+		Phones  string `json:"phones"`
+		Address struct {
+			Address     string `json:"address"`
+			Coordinates struct {
+				Latitude  float64 `json:"latitude"`
+				Longitude float64 `json:"longitude"`
+			} `json:"coordinates"`
+		} `json:"address"`
+	} `json:"receiver"`
+	Items []grabfoodItem `json:"items"`
+	Price struct {
+		DeliveryFee float64 `json:"deliveryFee"`
+	} `json:"price"`
+}
+
+func (grabfoodAdapter) SignatureHeader() string { return "X-Grab-Signature" }
+func (grabfoodAdapter) TimestampHeader() string { return "X-Grab-Timestamp" }
+
+func (grabfoodAdapter) ParseOrder(body []byte) (ExternalOrder, error) {
+	var payload grabfoodOrder
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return ExternalOrder{}, err
+	}
+
+	var toItems func(items []grabfoodItem) []ExternalItem
+	toItems = func(items []grabfoodItem) []ExternalItem {
+		result := []ExternalItem{}
+		for _, item := range items {
+			result = append(result, ExternalItem{External_ID: item.ID, Quantity: item.Quantity, Note: item.Specifications, Options: toItems(item.Modifiers)})
+		}
+		return result
+	}
+
+	orderType := OrderTypeDelivery
+	if payload.OrderType == "TAKEAWAY" {
+		orderType = OrderTypePickup
+	}
+
+	order := ExternalOrder{
+		External_ID:    payload.OrderID,
+		Cancelled:      payload.State == "CANCELLED",
+		Order_Type:     orderType,
+		Customer_Name:  payload.Receiver.Name,
+		Customer_Phone: payload.Receiver.Phones,
+		Address:        payload.Receiver.Address.Address,
+		Latitude:       payload.Receiver.Address.Coordinates.Latitude,
+		Longitude:      payload.Receiver.Address.Coordinates.Longitude,
+		Delivery_Fee:   payload.Price.DeliveryFee,
+		Items:          toItems(payload.Items),
+	}
+	if payload.ScheduledTime != "" {
+		scheduledFor, err := time.Parse(time.RFC3339, payload.ScheduledTime)
+		if err != nil {
+			return order, fmt.Errorf("invalid scheduled time: %v", err)
+		}
+		order.Scheduled_For = scheduledFor
+	}
+	return order, validateExternalOrder(order)
+}
+
+func (grabfoodAdapter) EncodeOrder(order ExternalOrder) ([]byte, error) {
+	var fromItems func(items []ExternalItem) []grabfoodItem
+	fromItems = func(items []ExternalItem) []grabfoodItem {
+		result := []grabfoodItem{}
+		for _, item := range items {
+			result = append(result, grabfoodItem{ID: item.External_ID, Quantity: item.Quantity, Specifications: item.Note, Modifiers: fromItems(item.Options)})
+		}
+		return result
+	}
+
+	payload := grabfoodOrder{OrderID: order.External_ID, State: "PENDING", OrderType: "DELIVERY", Items: fromItems(order.Items)}
+	if order.Cancelled {
+		payload.State = "CANCELLED"
+	}
+	if order.Order_Type == OrderTypePickup {
+		payload.OrderType = "TAKEAWAY"
+	}
+	if !order.Scheduled_For.IsZero() {
+		payload.ScheduledTime = order.Scheduled_For.Format(time.RFC3339)
+	}
+	payload.Receiver.Name = order.Customer_Name
+	payload.Receiver.Phones = order.Customer_Phone
+	payload.Receiver.Address.Address = order.Address
+	payload.Receiver.Address.Coordinates.Latitude = order.Latitude
+	payload.Receiver.Address.Coordinates.Longitude = order.Longitude
+	payload.Price.DeliveryFee = order.Delivery_Fee
+	return json.Marshal(payload)
+}
+
+func (grabfoodAdapter) StatusPayload(externalID string, status string) ([]byte, error) {
+	name, err := grabfoodStatuses.external(status)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]string{"orderID": externalID, "state": name})
+}
+
+func (grabfoodAdapter) ParseStatus(body []byte) (string, string, error) {
+	var payload struct {
+		OrderID string `json:"orderID"`
+		State   string `json:"state"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return "", "", err
+	}
+	status, err := grabfoodStatuses.internal(payload.State)
+	return payload.OrderID, status, err
+}
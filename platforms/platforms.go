@@ -0,0 +1,164 @@
+// Package platforms translates between our orders and the webhooks of
+// third-party delivery platforms. Each platform has an Adapter, registered by
+// name, that reads its order payloads and writes its status callbacks.
+package platforms
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureTolerance is how far the timestamp of a signed request may be from
+// the receiver's clock. Older requests are rejected as replays.
+const SignatureTolerance = 5 * time.Minute
+
+// Order types of an ExternalOrder.
+const (
+	OrderTypeDelivery = "delivery"
+	OrderTypePickup   = "pickup"
+)
+
+// ExternalOrder is a platform order in a platform-neutral shape.
+type ExternalOrder struct {
+	External_ID    string
+	Cancelled      bool
+	Order_Type     string
+	Customer_Name  string
+	Customer_Phone string
+	Address        string
+	Latitude       float64
+	Longitude      float64
+	Delivery_Fee   float64
+	Note           string
+	Scheduled_For  time.Time
+	Items          []ExternalItem
+}
+
+// ExternalItem is an item of a platform order, identified by the platform's own
+// ID. Options are the add-ons chosen with it.
+type ExternalItem struct {
+	External_ID string
+	Quantity    int
+	Note        string
+	Options     []ExternalItem
+}
+
+// Adapter reads and writes the payloads of one platform. Statuses are our order
+// status codes, see models.Order.
+type Adapter interface {
+	// SignatureHeader is the header carrying the HMAC of the request, see Sign.
+	SignatureHeader() string
+	// TimestampHeader is the header carrying the Unix time the request was signed at.
+	TimestampHeader() string
+	ParseOrder(body []byte) (ExternalOrder, error)
+	EncodeOrder(order ExternalOrder) ([]byte, error)
+	StatusPayload(externalID string, status string) ([]byte, error)
+	ParseStatus(body []byte) (string, string, error)
+}
+
+var adapters = map[string]Adapter{}
+
+func register(name string, adapter Adapter) {
+	adapters[name] = adapter
+}
+
+// Get returns the adapter of a platform.
+func Get(name string) (Adapter, bool) {
+	adapter, ok := adapters[strings.ToLower(name)]
+	return adapter, ok
+}
+
+// Names lists the platforms there are adapters for.
+func Names() []string {
+	names := []string{}
+	for name := range adapters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Sign returns the hex HMAC-SHA256 of "<timestamp>.<body>" under a shared
+// secret, for a body sent at the given Unix time.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature checks a signature made by Sign in constant time, and that
+// it was made within SignatureTolerance of now. A "sha256=" prefix, as some
+// platforms send, is accepted.
+func VerifySignature(secret string, timestamp string, body []byte, signature string, now time.Time) bool {
+	signature = strings.TrimPrefix(strings.TrimSpace(signature), "sha256=")
+	if secret == "" || signature == "" {
+		return false
+	}
+	signedAt, err := strconv.ParseInt(strings.TrimSpace(timestamp), 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := now.Sub(time.Unix(signedAt, 0)); age > SignatureTolerance || age < -SignatureTolerance {
+		return false
+	}
+	return hmac.Equal([]byte(Sign(secret, signedAt, body)), []byte(strings.ToLower(signature)))
+}
+
+// SendStatus posts an order's new status to a platform's callback URL, signed
+// with the platform's secret.
+func SendStatus(adapter Adapter, callbackURL string, secret string, externalID string, status string) error {
+	payload, err := adapter.StatusPayload(externalID, status)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, callbackURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(adapter.TimestampHeader(), strconv.FormatInt(timestamp, 10))
+	req.Header.Set(adapter.SignatureHeader(), Sign(secret, timestamp, payload))
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("platform responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// statusNames maps our order status codes to a platform's status names.
+type statusNames map[string]string
+
+func (names statusNames) external(status string) (string, error) {
+	name, ok := names[status]
+	if !ok {
+		return "", fmt.Errorf("status %s is not reported to the platform", status)
+	}
+	return name, nil
+}
+
+func (names statusNames) internal(name string) (string, error) {
+	for status, external := range names {
+		if external == name {
+			return status, nil
+		}
+	}
+	return "", fmt.Errorf("unknown platform status: %s", name)
+}
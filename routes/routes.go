@@ -2,64 +2,192 @@ package routes
 
 import (
 	controllers "nano_food_api/controllers"
+	database "nano_food_api/database"
+	helpers "nano_food_api/helpers"
+	middlewares "nano_food_api/middlewares"
+	storage "nano_food_api/storage"
 
 	"github.com/gin-gonic/gin"
 )
 
 type RouteGroups struct {
-	Public    *gin.RouterGroup
-	Auth      gin.IRoutes
-	Assistant gin.IRoutes
-	Manager   gin.IRoutes
-	Admin     gin.IRoutes
-	Root      gin.IRoutes
+	Public   *gin.RouterGroup
+	Auth     gin.IRoutes
+	Customer gin.IRoutes
 }
 
 func UserRoutes(r *RouteGroups) {
 	r.Public.POST("/register", controllers.RegisterUser())
 	r.Public.POST("/verify", controllers.VerifyUser())
+	r.Public.POST("/resend-verification", controllers.ResendVerificationCode())
 	r.Public.POST("/login", controllers.LoginUser())
+	r.Public.POST("/login/2fa", controllers.LoginTwoFactor())
+	r.Public.POST("/login/2fa/setup", controllers.SetupTwoFactorLogin())
+	r.Public.POST("/login/2fa/confirm", controllers.ConfirmTwoFactorLogin())
+	r.Public.POST("/refresh-token", controllers.RefreshToken())
+	r.Public.GET("/terminal/staff", controllers.GetTerminalStaff())
+	r.Public.POST("/pin-login", controllers.PinLogin())
+	r.Public.POST("/forgot-password", controllers.ForgotPassword())
+	r.Public.POST("/reset-password", controllers.ResetPassword())
 
 	r.Auth.GET("/me", controllers.GetCurrentUser())
-	r.Auth.PUT("/update-user-info", controllers.UpdateUserInfo())
-	r.Auth.PUT("/update-user-password", controllers.UpdateUserPassword())
-	r.Auth.PUT("/upload-avatar", controllers.UploadAvatar())
+	r.Auth.POST("/logout", controllers.Logout())
+	r.Auth.POST("/logout-all", controllers.LogoutAllDevices())
+	r.Auth.GET("/my-sessions", controllers.GetMySessions())
+	r.Auth.DELETE("/my-sessions/:session_id", controllers.RevokeMySession())
+	r.Auth.PUT("/update-user-info", middlewares.AuditSelf("user", database.UserCollection), controllers.UpdateUserInfo())
+	r.Auth.PUT("/update-user-password", middlewares.AuditSelf("user", database.UserCollection), controllers.UpdateUserPassword())
+	r.Auth.PUT("/upload-avatar", middlewares.AuditSelf("user", database.UserCollection), controllers.UploadAvatar())
 
-	r.Manager.GET("/get-branch-users/:branch_id", controllers.GetAllBranchUsers())
-	r.Manager.PUT("/update-user-role", controllers.UpdateUserRole())
+	r.Auth.GET("/get-branch-users/:branch_id", middlewares.RequirePermission(helpers.PermUserView), controllers.GetAllBranchUsers())
+	r.Auth.PUT("/update-user-role", middlewares.RequirePermission(helpers.PermUserEdit), middlewares.Audit("user", database.UserCollection, "user_id"), controllers.UpdateUserRole())
+	r.Auth.GET("/get-branch-sessions/:branch_id", middlewares.RequirePermission(helpers.PermUserView), controllers.GetBranchSessions())
+	r.Auth.PUT("/set-staff-pin", middlewares.RequirePermission(helpers.PermUserEdit), middlewares.Audit("user", database.UserCollection, "user_id"), controllers.SetStaffPin())
+	r.Auth.PUT("/unlock-user", middlewares.RequirePermission(helpers.PermUserEdit), middlewares.Audit("user", database.UserCollection, "user_id"), controllers.UnlockUser())
+	r.Auth.POST("/2fa/setup", controllers.SetupTwoFactor())
+	r.Auth.POST("/2fa/confirm", middlewares.AuditSelf("user", database.UserCollection), controllers.ConfirmTwoFactor())
+	r.Auth.POST("/2fa/disable", middlewares.AuditSelf("user", database.UserCollection), controllers.DisableTwoFactor())
+	r.Auth.POST("/2fa/recovery-codes", controllers.RegenerateRecoveryCodes())
 
-	r.Admin.DELETE("/delete-user/:user_id/:branch_id", controllers.DeleteUser())
-	r.Admin.POST("/create-user", controllers.CreateUser())
-	r.Admin.PUT("/update-user-branch", controllers.UpdateUserBranch())
-	r.Root.GET("/get-all-users", controllers.GetAllUsers())
+	r.Auth.DELETE("/delete-user/:user_id/:branch_id", middlewares.RequirePermission(helpers.PermUserDelete), middlewares.Audit("user", database.UserCollection, "user_id"), controllers.DeleteUser())
+	r.Auth.PUT("/update-user-branch", middlewares.RequirePermission(helpers.PermUserTransfer), middlewares.Audit("user", database.UserCollection, "user_id"), controllers.UpdateUserBranch())
+	r.Auth.GET("/get-all-users", middlewares.RequirePermission(helpers.PermUserViewAll), controllers.GetAllUsers())
+}
+
+func InvitationRoutes(r *RouteGroups) {
+	r.Public.GET("/invitation", controllers.GetInvitation())
+	r.Public.POST("/accept-invitation", controllers.AcceptInvitation())
+
+	r.Auth.POST("/invite-user", middlewares.RequirePermission(helpers.PermUserCreate), middlewares.Audit("invitation", database.InvitationCollection, ""), controllers.InviteUser())
+	r.Auth.GET("/get-branch-invitations/:branch_id", middlewares.RequirePermission(helpers.PermUserCreate), controllers.GetBranchInvitations())
+	r.Auth.PUT("/resend-invitation/:invitation_id", middlewares.RequirePermission(helpers.PermUserCreate), middlewares.Audit("invitation", database.InvitationCollection, "invitation_id"), controllers.ResendInvitation())
+	r.Auth.PUT("/revoke-invitation/:invitation_id", middlewares.RequirePermission(helpers.PermUserCreate), middlewares.Audit("invitation", database.InvitationCollection, "invitation_id"), controllers.RevokeInvitation())
+}
+
+func TimeClockRoutes(r *RouteGroups) {
+	r.Auth.POST("/clock-in", controllers.ClockIn())
+	r.Auth.POST("/clock-out", controllers.ClockOut())
+	r.Auth.POST("/start-break", controllers.StartBreak())
+	r.Auth.POST("/end-break", controllers.EndBreak())
+	r.Auth.GET("/my-time-entries", controllers.GetMyTimeEntries())
+	r.Auth.GET("/get-branch-time-entries/:branch_id", middlewares.RequirePermission(helpers.PermUserView), controllers.GetBranchTimeEntries())
+	r.Auth.PUT("/update-time-entry/:time_entry_id", middlewares.RequirePermission(helpers.PermUserEdit), middlewares.Audit("time_entry", database.TimeEntryCollection, "time_entry_id"), controllers.UpdateTimeEntry())
+	r.Auth.GET("/get-hours-report/:branch_id", middlewares.RequirePermission(helpers.PermReportView), controllers.GetHoursReport())
+}
+
+func ShiftRoutes(r *RouteGroups) {
+	r.Auth.GET("/my-shifts", controllers.GetMyShifts())
+	r.Auth.GET("/get-branch-roster/:branch_id", controllers.GetBranchRoster())
+	r.Auth.GET("/get-branch-attendance/:branch_id", middlewares.RequirePermission(helpers.PermUserView), controllers.GetBranchAttendance())
+	r.Auth.POST("/create-shift", middlewares.RequirePermission(helpers.PermUserEdit), middlewares.Audit("shift", database.ShiftCollection, ""), controllers.CreateShift())
+	r.Auth.POST("/copy-branch-roster", middlewares.RequirePermission(helpers.PermUserEdit), middlewares.Audit("shift", database.ShiftCollection, ""), controllers.CopyBranchRoster())
+	r.Auth.PUT("/update-shift/:shift_id", middlewares.RequirePermission(helpers.PermUserEdit), middlewares.Audit("shift", database.ShiftCollection, "shift_id"), controllers.UpdateShift())
+	r.Auth.DELETE("/delete-shift/:shift_id", middlewares.RequirePermission(helpers.PermUserEdit), middlewares.Audit("shift", database.ShiftCollection, "shift_id"), controllers.DeleteShift())
+}
+
+func AuditLogRoutes(r *RouteGroups) {
+	r.Auth.GET("/get-audit-logs", middlewares.RequirePermission(helpers.PermAuditView), controllers.GetAuditLogs())
+	r.Auth.GET("/verify-audit-logs", middlewares.RequirePermission(helpers.PermAuditView), controllers.VerifyAuditLogs())
+}
+
+func CustomerRoutes(r *RouteGroups) {
+	r.Public.POST("/customer/request-otp", controllers.RequestCustomerOTP())
+	r.Public.POST("/customer/verify-otp", controllers.VerifyCustomerOTP())
+
+	r.Customer.GET("/customer/me", controllers.GetCustomerProfile())
+	r.Customer.PUT("/customer/me", controllers.UpdateCustomerProfile())
+	r.Customer.POST("/customer/logout-all", controllers.CustomerLogoutAll())
+	r.Customer.GET("/customer/orders", controllers.GetCustomerOrders())
+	r.Customer.GET("/customer/favourites", controllers.GetCustomerFavourites())
+	r.Customer.POST("/customer/favourites/:menu_id", controllers.AddCustomerFavourite())
+	r.Customer.DELETE("/customer/favourites/:menu_id", controllers.RemoveCustomerFavourite())
+	r.Customer.POST("/customer/addresses", controllers.AddCustomerAddress())
+	r.Customer.PUT("/customer/addresses/:address_id", controllers.UpdateCustomerAddress())
+	r.Customer.DELETE("/customer/addresses/:address_id", controllers.DeleteCustomerAddress())
+
+	r.Auth.GET("/search-customers", middlewares.RequirePermission(helpers.PermOrderCreate), controllers.SearchCustomers())
+	r.Auth.GET("/get-customer/:customer_id", middlewares.RequirePermission(helpers.PermOrderCreate), controllers.GetCustomer())
+	r.Auth.POST("/create-customer", middlewares.RequirePermission(helpers.PermOrderCreate), middlewares.Audit("customer", database.CustomerCollection, ""), controllers.CreateCustomer())
+	r.Auth.PUT("/attach-order-customer/:order_id", middlewares.RequirePermission(helpers.PermOrderCreate), middlewares.Audit("order", database.OrderCollection, "order_id"), controllers.AttachOrderCustomer())
+}
+
+func LoyaltyRoutes(r *RouteGroups) {
+	r.Customer.GET("/customer/loyalty", controllers.GetMyLoyalty())
+
+	r.Auth.GET("/get-loyalty-settings", controllers.GetLoyaltySettings())
+	r.Auth.PUT("/update-loyalty-settings", middlewares.AuditDocument("loyalty_settings", database.LoyaltySettingsCollection, helpers.LoyaltySettingsID), controllers.UpdateLoyaltySettings())
+	r.Auth.GET("/get-customer-loyalty/:customer_id", middlewares.RequirePermission(helpers.PermOrderCreate), controllers.GetCustomerLoyalty())
+	r.Auth.POST("/adjust-customer-points/:customer_id", middlewares.RequirePermission(helpers.PermLoyaltyAdjust), middlewares.Audit("customer", database.CustomerCollection, "customer_id"), controllers.AdjustCustomerPoints())
+}
+
+func GiftCardRoutes(r *RouteGroups) {
+	r.Customer.GET("/customer/gift-cards", controllers.GetMyGiftCards())
+	r.Customer.POST("/customer/gift-cards", controllers.AddMyGiftCard())
+
+	r.Auth.POST("/gift-card-balance", middlewares.RequirePermission(helpers.PermSaleCreate), controllers.GetGiftCardBalance())
+	r.Auth.GET("/get-gift-cards", middlewares.RequirePermission(helpers.PermGiftCardManage), controllers.GetGiftCards())
+	r.Auth.GET("/get-gift-card/:gift_card_id", middlewares.RequirePermission(helpers.PermGiftCardManage), controllers.GetGiftCard())
+	r.Auth.POST("/issue-gift-card", middlewares.RequirePermission(helpers.PermGiftCardManage), middlewares.Audit("gift_card", database.GiftCardCollection, ""), controllers.IssueGiftCard())
+	r.Auth.PUT("/update-gift-card-status/:gift_card_id", middlewares.RequirePermission(helpers.PermGiftCardManage), middlewares.Audit("gift_card", database.GiftCardCollection, "gift_card_id"), controllers.UpdateGiftCardStatus())
+}
+
+func RoleRoutes(r *RouteGroups) {
+	r.Auth.GET("/get-permissions", controllers.GetPermissions())
+	r.Auth.GET("/get-branch-roles/:branch_id", middlewares.RequirePermission(helpers.PermRoleManage), controllers.GetBranchRoles())
+	r.Auth.POST("/create-role", middlewares.RequirePermission(helpers.PermRoleManage), middlewares.Audit("role", database.RoleCollection, ""), controllers.CreateRole())
+	r.Auth.PUT("/update-role/:role_id", middlewares.RequirePermission(helpers.PermRoleManage), middlewares.Audit("role", database.RoleCollection, "role_id"), controllers.UpdateRole())
+	r.Auth.DELETE("/delete-role/:role_id", middlewares.RequirePermission(helpers.PermRoleManage), middlewares.Audit("role", database.RoleCollection, "role_id"), controllers.DeleteRole())
+	r.Auth.PUT("/assign-user-role", middlewares.RequirePermission(helpers.PermRoleManage), middlewares.Audit("user", database.UserCollection, "user_id"), controllers.AssignUserRole())
+}
+
+func TerminalRoutes(r *RouteGroups) {
+	r.Auth.POST("/register-terminal", middlewares.RequirePermission(helpers.PermTerminalManage), middlewares.Audit("terminal", database.TerminalCollection, ""), controllers.RegisterTerminal())
+	r.Auth.GET("/get-branch-terminals/:branch_id", middlewares.RequirePermission(helpers.PermTerminalManage), controllers.GetBranchTerminals())
+	r.Auth.DELETE("/delete-terminal/:terminal_id", middlewares.RequirePermission(helpers.PermTerminalManage), middlewares.Audit("terminal", database.TerminalCollection, "terminal_id"), controllers.DeleteTerminal())
 }
 
 func BranchRoutes(r *RouteGroups) {
 	r.Public.GET("/get-one-branch/:branch_id", controllers.GetOneBranch())
+	r.Public.POST("/delivery-quote/:branch_id", controllers.GetDeliveryQuote())
 
-	r.Admin.PUT("/update-branch/:branch_id", controllers.UpdateBranch())
-	r.Admin.POST("/create-branch", controllers.CreateBranch())
-	r.Admin.GET("/get-all-branches", controllers.GetBranches())
+	r.Auth.GET("/my-branches", controllers.GetMyBranches())
+	r.Auth.POST("/switch-branch", controllers.SwitchBranch())
+	r.Auth.GET("/get-branches-summary", middlewares.RequirePermission(helpers.PermReportView), controllers.GetBranchesSummary())
+	r.Auth.GET("/get-staff-performance/:branch_id", middlewares.RequirePermission(helpers.PermReportView), controllers.GetStaffPerformance())
+	r.Auth.GET("/get-tip-distribution/:branch_id", middlewares.RequirePermission(helpers.PermReportView), controllers.GetTipDistribution())
+	r.Auth.POST("/request-sales-report/:branch_id", middlewares.RequirePermission(helpers.PermReportView), middlewares.Audit("job", database.JobCollection, ""), controllers.RequestSalesReport())
+	r.Auth.GET("/get-sales-report/:job_id", middlewares.RequirePermission(helpers.PermReportView), controllers.GetSalesReport())
+	r.Auth.PUT("/add-branch-membership", middlewares.RequirePermission(helpers.PermUserTransfer), middlewares.Audit("user", database.UserCollection, "user_id"), controllers.AddBranchMembership())
+	r.Auth.DELETE("/remove-branch-membership/:user_id/:branch_id", middlewares.RequirePermission(helpers.PermUserTransfer), middlewares.Audit("user", database.UserCollection, "user_id"), controllers.RemoveBranchMembership())
 
-	r.Root.DELETE("/delete-branch/:branch_id", controllers.DeleteBranch())
+	r.Auth.PUT("/update-branch/:branch_id", middlewares.RequirePermission(helpers.PermBranchEdit), middlewares.Audit("branch", database.BranchCollection, "branch_id"), controllers.UpdateBranch())
+	r.Auth.PUT("/update-branch-delivery-settings/:branch_id", middlewares.RequirePermission(helpers.PermBranchEdit), middlewares.Audit("branch", database.BranchCollection, "branch_id"), controllers.UpdateBranchDeliverySettings())
+	r.Auth.PUT("/update-branch-preorder-policy/:branch_id", middlewares.RequirePermission(helpers.PermBranchEdit), middlewares.Audit("branch", database.BranchCollection, "branch_id"), controllers.UpdateBranchPreorderPolicy())
+	r.Auth.PUT("/update-branch-tip-policy/:branch_id", middlewares.RequirePermission(helpers.PermBranchEdit), middlewares.Audit("branch", database.BranchCollection, "branch_id"), controllers.UpdateBranchTipPolicy())
+	r.Auth.POST("/create-branch", middlewares.RequirePermission(helpers.PermBranchCreate), middlewares.Audit("branch", database.BranchCollection, ""), controllers.CreateBranch())
+	r.Auth.GET("/get-all-branches", middlewares.RequirePermission(helpers.PermBranchViewAll), controllers.GetBranches())
+
+	r.Auth.DELETE("/delete-branch/:branch_id", middlewares.RequirePermission(helpers.PermBranchDelete), middlewares.Audit("branch", database.BranchCollection, "branch_id"), controllers.DeleteBranch())
 }
 
 func CategoryRoutes(r *RouteGroups) {
 	r.Public.GET("/get-all-categories/:branch_id", controllers.GetAllCategories())
 	r.Public.GET("/get-one-category/:category_id", controllers.GetOneCategory())
 
-	r.Manager.PUT("/update-category/:category_id", controllers.UpdateCategory())
-	r.Manager.POST("/create-category", controllers.CreateCategory())
-	r.Admin.DELETE("/delete-category/:category_id", controllers.DeleteCategory())
+	r.Auth.PUT("/update-category/:category_id", middlewares.RequirePermission(helpers.PermCategoryEdit), middlewares.Audit("category", database.CategoryCollection, "category_id"), controllers.UpdateCategory())
+	r.Auth.POST("/create-category", middlewares.RequirePermission(helpers.PermCategoryEdit), middlewares.Audit("category", database.CategoryCollection, ""), controllers.CreateCategory())
+	r.Auth.DELETE("/delete-category/:category_id", middlewares.RequirePermission(helpers.PermCategoryDelete), middlewares.Audit("category", database.CategoryCollection, "category_id"), controllers.DeleteCategory())
 }
 
 func TableRoutes(r *RouteGroups) {
 	r.Public.GET("/get-all-tables/:branch_id", controllers.GetAllTables())
 	r.Public.GET("/get-one-table/:table_id", controllers.GetOneTable())
 
-	r.Manager.PUT("/update-table/:table_id", controllers.UpdateTable())
-	r.Manager.POST("/create-table", controllers.CreateTable())
-	r.Admin.DELETE("/delete-table/:table_id", controllers.DeleteTable())
+	r.Auth.PUT("/update-table/:table_id", middlewares.RequirePermission(helpers.PermTableEdit), middlewares.Audit("table", database.TableCollection, "table_id"), controllers.UpdateTable())
+	r.Auth.POST("/create-table", middlewares.RequirePermission(helpers.PermTableEdit), middlewares.Audit("table", database.TableCollection, ""), controllers.CreateTable())
+	r.Auth.GET("/get-table-qr/:table_id", middlewares.RequirePermission(helpers.PermTableEdit), controllers.GetTableQRCode())
+	r.Auth.PUT("/rotate-table-qr/:table_id", middlewares.RequirePermission(helpers.PermTableEdit), middlewares.Audit("table", database.TableCollection, "table_id"), controllers.RotateTableQRCode())
+	r.Auth.DELETE("/delete-table/:table_id", middlewares.RequirePermission(helpers.PermTableDelete), middlewares.Audit("table", database.TableCollection, "table_id"), controllers.DeleteTable())
 }
 
 func MenuRoutes(r *RouteGroups) {
@@ -68,33 +196,78 @@ func MenuRoutes(r *RouteGroups) {
 	r.Public.GET("/get-one-menu/:menu_id", controllers.GetOneMenu())
 	r.Public.GET("/search-menu", controllers.SearchMenu())
 
-	r.Manager.PUT("/update-menu/:menu_id", controllers.UpdateMenu())
-	r.Manager.POST("/create-menu", controllers.CreateMenu())
-	r.Admin.DELETE("/delete-menu/:menu_id", controllers.DeleteMenu())
+	r.Auth.PUT("/update-menu/:menu_id", middlewares.RequirePermission(helpers.PermMenuEdit), middlewares.Audit("menu", database.MenuCollection, "menu_id"), controllers.UpdateMenu())
+	r.Auth.POST("/create-menu", middlewares.RequirePermission(helpers.PermMenuEdit), middlewares.Audit("menu", database.MenuCollection, ""), controllers.CreateMenu())
+	r.Auth.DELETE("/delete-menu/:menu_id", middlewares.RequirePermission(helpers.PermMenuDelete), middlewares.Audit("menu", database.MenuCollection, "menu_id"), controllers.DeleteMenu())
 }
 
 func AddOnRoutes(r *RouteGroups) {
 	r.Public.GET("/get-all-addons", controllers.GetAllAddOns())
 	r.Public.GET("/get-one-addon/:add_on_id", controllers.GetOneAddOn())
 
-	r.Manager.POST("/create-addon", controllers.AddMenuAddOn())
-	r.Manager.PUT("/update-addon/:add_on_id", controllers.UpdateMenuAddOn())
-	r.Manager.DELETE("/delete-addon/:add_on_id", controllers.RemoveMenuAddOn())
+	r.Auth.POST("/create-addon", middlewares.RequirePermission(helpers.PermMenuEdit), middlewares.Audit("add_on", database.AddOnCollection, ""), controllers.AddMenuAddOn())
+	r.Auth.PUT("/update-addon/:add_on_id", middlewares.RequirePermission(helpers.PermMenuEdit), middlewares.Audit("add_on", database.AddOnCollection, "add_on_id"), controllers.UpdateMenuAddOn())
+	r.Auth.DELETE("/delete-addon/:add_on_id", middlewares.RequirePermission(helpers.PermMenuEdit), middlewares.Audit("add_on", database.AddOnCollection, "add_on_id"), controllers.RemoveMenuAddOn())
 }
 
 func OrderRoutes(r *RouteGroups) {
-	r.Public.GET("/get-all-orders", controllers.GetAllOrders())
-	r.Public.GET("/get-one-order/:order_id", controllers.GetOneOrder())
-	r.Public.POST("/create-order", controllers.CreateOrder())
+	r.Auth.GET("/get-all-orders", controllers.GetAllOrders())
+	r.Auth.GET("/get-one-order/:order_id", controllers.GetOneOrder())
+
+	r.Auth.POST("/create-order", middlewares.RequirePermission(helpers.PermOrderCreate), middlewares.Audit("order", database.OrderCollection, ""), controllers.CreateOrder())
+	r.Auth.PUT("/confirm-order/:order_id", middlewares.RequirePermission(helpers.PermOrderConfirm), middlewares.Audit("order", database.OrderCollection, "order_id"), controllers.ConfirmOrder())
+	r.Auth.PUT("/update-order/:order_id", middlewares.RequirePermission(helpers.PermOrderEdit), middlewares.Audit("order", database.OrderCollection, "order_id"), controllers.UpdateOrder())
+	r.Auth.GET("/my-deliveries", controllers.GetMyDeliveries())
+	r.Auth.GET("/get-preorder-slots/:branch_id", controllers.GetPreorderSlots())
+	r.Auth.PUT("/reschedule-order/:order_id", middlewares.RequirePermission(helpers.PermOrderEdit), middlewares.Audit("order", database.OrderCollection, "order_id"), controllers.RescheduleOrder())
+	r.Auth.PUT("/assign-order-driver/:order_id", middlewares.RequirePermission(helpers.PermOrderEdit), middlewares.Audit("order", database.OrderCollection, "order_id"), controllers.AssignOrderDriver())
+	r.Auth.PUT("/update-delivery-status/:order_id", middlewares.Audit("order", database.OrderCollection, "order_id"), controllers.UpdateDeliveryStatus())
+	r.Auth.DELETE("/delete-order/:order_id", middlewares.RequirePermission(helpers.PermOrderVoid), middlewares.Audit("order", database.OrderCollection, "order_id"), controllers.DeleteOrder())
+}
+
+func GuestRoutes(r *RouteGroups) {
+	r.Public.GET("/guest/menu/:table_token", controllers.GetGuestMenu())
+	r.Public.GET("/guest/orders/:table_token", controllers.GetGuestOrders())
+	r.Public.POST("/guest/order/:table_token", middlewares.Audit("order", database.OrderCollection, ""), controllers.CreateGuestOrder())
+}
+
+func PlatformRoutes(r *RouteGroups) {
+	r.Public.POST("/webhooks/platform/:platform_id", middlewares.Audit("order", database.OrderCollection, ""), controllers.ReceivePlatformWebhook())
+
+	r.Auth.GET("/get-delivery-platforms", middlewares.RequirePermission(helpers.PermBranchEdit), controllers.GetDeliveryPlatforms())
+	r.Auth.POST("/create-delivery-platform", middlewares.RequirePermission(helpers.PermBranchEdit), middlewares.Audit("delivery_platform", database.DeliveryPlatformCollection, ""), controllers.CreateDeliveryPlatform())
+	r.Auth.PUT("/update-delivery-platform/:platform_id", middlewares.RequirePermission(helpers.PermBranchEdit), middlewares.Audit("delivery_platform", database.DeliveryPlatformCollection, "platform_id"), controllers.UpdateDeliveryPlatform())
+	r.Auth.DELETE("/delete-delivery-platform/:platform_id", middlewares.RequirePermission(helpers.PermBranchEdit), middlewares.Audit("delivery_platform", database.DeliveryPlatformCollection, "platform_id"), controllers.DeleteDeliveryPlatform())
+}
+
+func WebhookRoutes(r *RouteGroups) {
+	r.Auth.GET("/get-webhook-endpoints", middlewares.RequirePermission(helpers.PermWebhookManage), controllers.GetWebhookEndpoints())
+	r.Auth.POST("/create-webhook-endpoint", middlewares.RequirePermission(helpers.PermWebhookManage), middlewares.Audit("webhook_endpoint", database.WebhookEndpointCollection, ""), controllers.CreateWebhookEndpoint())
+	r.Auth.PUT("/update-webhook-endpoint/:endpoint_id", middlewares.RequirePermission(helpers.PermWebhookManage), middlewares.Audit("webhook_endpoint", database.WebhookEndpointCollection, "endpoint_id"), controllers.UpdateWebhookEndpoint())
+	r.Auth.DELETE("/delete-webhook-endpoint/:endpoint_id", middlewares.RequirePermission(helpers.PermWebhookManage), middlewares.Audit("webhook_endpoint", database.WebhookEndpointCollection, "endpoint_id"), controllers.DeleteWebhookEndpoint())
+
+	r.Auth.GET("/get-webhook-deliveries", middlewares.RequirePermission(helpers.PermWebhookManage), controllers.GetWebhookDeliveries())
+	r.Auth.GET("/get-webhook-dead-letters", middlewares.RequirePermission(helpers.PermWebhookManage), controllers.GetWebhookDeadLetters())
+	r.Auth.POST("/replay-webhook-delivery/:delivery_id", middlewares.RequirePermission(helpers.PermWebhookManage), middlewares.Audit("webhook_delivery", database.WebhookDeliveryCollection, ""), controllers.ReplayWebhookDelivery())
+}
+
+func JobRoutes(r *RouteGroups) {
+	r.Auth.GET("/get-jobs", middlewares.RequirePermission(helpers.PermJobManage), controllers.GetJobs())
+	r.Auth.GET("/get-job-stats", middlewares.RequirePermission(helpers.PermJobManage), controllers.GetJobStats())
+	r.Auth.GET("/get-job/:job_id", middlewares.RequirePermission(helpers.PermJobManage), controllers.GetJob())
+	r.Auth.PUT("/retry-job/:job_id", middlewares.RequirePermission(helpers.PermJobManage), middlewares.Audit("job", database.JobCollection, "job_id"), controllers.RetryJob())
+	r.Auth.PUT("/cancel-job/:job_id", middlewares.RequirePermission(helpers.PermJobManage), middlewares.Audit("job", database.JobCollection, "job_id"), controllers.CancelJob())
+}
 
-	r.Manager.PUT("/update-order/:order_id", controllers.UpdateOrder())
-	r.Admin.DELETE("/delete-order/:order_id", controllers.DeleteOrder())
+func StorageRoutes(r *RouteGroups) {
+	r.Public.GET(storage.LocalRoute+"/*key", controllers.ServeStoredFile())
 }
 
 func SaleRoutes(r *RouteGroups) {
-	r.Manager.GET("/get-all-sales", controllers.GetAllSales())
-	r.Public.GET("/get-one-sale/:sale_id", controllers.GetOneSale())
-	r.Public.POST("/create-sale", controllers.CreateSale())
+	r.Auth.GET("/get-all-sales", middlewares.RequirePermission(helpers.PermSaleView), controllers.GetAllSales())
+	r.Auth.GET("/get-one-sale/:sale_id", controllers.GetOneSale())
+	r.Auth.POST("/create-sale", middlewares.RequirePermission(helpers.PermSaleCreate), middlewares.Audit("sale", database.SaleCollection, ""), controllers.CreateSale())
 
-	r.Admin.DELETE("/delete-sale/:sale_id", controllers.DeleteSale())
+	r.Auth.PUT("/refund-sale/:sale_id", middlewares.RequirePermission(helpers.PermSaleRefund), middlewares.Audit("sale", database.SaleCollection, "sale_id"), controllers.RefundSale())
+	r.Auth.DELETE("/delete-sale/:sale_id", middlewares.RequirePermission(helpers.PermSaleDelete), middlewares.Audit("sale", database.SaleCollection, "sale_id"), controllers.DeleteSale())
 }
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	cloudStorage "cloud.google.com/go/storage"
+	firebase "firebase.google.com/go"
+	"google.golang.org/api/option"
+)
+
+// Firebase keeps files in the Firebase Storage (Google Cloud Storage) bucket
+// of a project. Uploaded files are readable by anyone.
+type Firebase struct {
+	bucket     *cloudStorage.BucketHandle
+	bucketName string
+}
+
+func NewFirebase(ctx context.Context, credentialsFile string, bucketName string) (*Firebase, error) {
+	if bucketName == "" {
+		return nil, fmt.Errorf("STORAGE_BUCKET is not set")
+	}
+
+	app, err := firebase.NewApp(ctx, &firebase.Config{StorageBucket: bucketName}, option.WithCredentialsFile(credentialsFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize Firebase app: %v", err)
+	}
+	client, err := app.Storage(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Firebase Storage: %v", err)
+	}
+	bucket, err := client.DefaultBucket()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get default bucket: %v", err)
+	}
+
+	return &Firebase{bucket: bucket, bucketName: bucketName}, nil
+}
+
+func (f *Firebase) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) (string, error) {
+	object := f.bucket.Object(key)
+	writer := object.NewWriter(ctx)
+	writer.ContentType = contentType
+
+	if _, err := io.Copy(writer, body); err != nil {
+		writer.Close()
+		return "", err
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+
+	if err := object.ACL().Set(ctx, cloudStorage.AllUsers, cloudStorage.RoleReader); err != nil {
+		return "", fmt.Errorf("failed to set public read access: %v", err)
+	}
+	return f.PublicURL(key), nil
+}
+
+func (f *Firebase) Delete(ctx context.Context, key string) error {
+	err := f.bucket.Object(keyOf(f.PublicURL(""), key)).Delete(ctx)
+	if err != nil && !errors.Is(err, cloudStorage.ErrObjectNotExist) {
+		return fmt.Errorf("failed to delete file from Firebase Storage: %v", err)
+	}
+	return nil
+}
+
+func (f *Firebase) PublicURL(key string) string {
+	return joinURL("https://storage.googleapis.com/"+f.bucketName, key)
+}
+
+func (f *Firebase) SignedURL(ctx context.Context, key string, expires time.Duration) (string, error) {
+	return f.bucket.SignedURL(keyOf(f.PublicURL(""), key), &cloudStorage.SignedURLOptions{
+		Method:  "GET",
+		Expires: time.Now().Add(expires),
+		Scheme:  cloudStorage.SigningSchemeV4,
+	})
+}
@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestLocal(t *testing.T) (*Local, string) {
+	dir := t.TempDir()
+	local, err := NewLocal(dir, "http://localhost:8000/files", "signing-key")
+	if err != nil {
+		t.Fatalf("NewLocal: %v", err)
+	}
+	return local, dir
+}
+
+func TestLocalPath(t *testing.T) {
+	local, dir := newTestLocal(t)
+
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"menu_images/pizza.png", filepath.Join(dir, "menu_images", "pizza.png")},
+		{"/avatars/a.png", filepath.Join(dir, "avatars", "a.png")},
+		{"../../etc/passwd", filepath.Join(dir, "etc", "passwd")},
+		{"menu_images/../../../secret", filepath.Join(dir, "secret")},
+	}
+	for _, tt := range tests {
+		got, err := local.Path(tt.key)
+		if err != nil {
+			t.Fatalf("Path(%q): %v", tt.key, err)
+		}
+		if got != tt.want {
+			t.Errorf("Path(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+
+	for _, key := range []string{"", "/", ".."} {
+		if _, err := local.Path(key); err == nil {
+			t.Errorf("Path(%q) should be refused", key)
+		}
+	}
+}
+
+func TestLocalPutAndDelete(t *testing.T) {
+	local, dir := newTestLocal(t)
+	ctx := context.Background()
+
+	url, err := local.Put(ctx, "menu_images/pizza.png", strings.NewReader("pizza"), 5, "image/png")
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if url != "http://localhost:8000/files/menu_images/pizza.png" {
+		t.Errorf("Put returned %q", url)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "menu_images", "pizza.png"))
+	if err != nil || string(content) != "pizza" {
+		t.Fatalf("stored file = %q, %v", content, err)
+	}
+
+	// A traversal key is kept inside the storage directory
+	if _, err := local.Put(ctx, "../escaped.txt", strings.NewReader("x"), 1, "text/plain"); err != nil {
+		t.Fatalf("Put traversal key: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "escaped.txt")); !os.IsNotExist(err) {
+		t.Errorf("traversal key escaped the storage directory")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escaped.txt")); err != nil {
+		t.Errorf("traversal key was not stored inside the storage directory: %v", err)
+	}
+
+	// Files are deleted by their URL or their key, and deleting twice is fine
+	if err := local.Delete(ctx, url); err != nil {
+		t.Fatalf("Delete by URL: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "menu_images", "pizza.png")); !os.IsNotExist(err) {
+		t.Errorf("file still exists after Delete")
+	}
+	if err := local.Delete(ctx, "menu_images/pizza.png"); err != nil {
+		t.Errorf("Delete of a missing file: %v", err)
+	}
+	if err := local.Delete(ctx, "../escaped.txt"); err != nil {
+		t.Fatalf("Delete traversal key: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escaped.txt")); !os.IsNotExist(err) {
+		t.Errorf("traversal key was not deleted")
+	}
+}
+
+func TestLocalSignedURL(t *testing.T) {
+	local, _ := newTestLocal(t)
+
+	tests := []struct {
+		name    string
+		expires time.Duration
+		valid   bool
+	}{
+		{"valid", time.Minute, true},
+		{"expired", -time.Minute, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signedURL, err := local.SignedURL(context.Background(), "private/report.csv", tt.expires)
+			if err != nil {
+				t.Fatalf("SignedURL: %v", err)
+			}
+			query := signedURL[strings.Index(signedURL, "?")+1:]
+			params := map[string]string{}
+			for _, pair := range strings.Split(query, "&") {
+				name, value, _ := strings.Cut(pair, "=")
+				params[name] = value
+			}
+
+			if got := local.VerifySignature("private/report.csv", params["expires"], params["signature"]); got != tt.valid {
+				t.Errorf("VerifySignature = %v, want %v", got, tt.valid)
+			}
+			if local.VerifySignature("private/other.csv", params["expires"], params["signature"]) {
+				t.Errorf("signature is valid for another key")
+			}
+		})
+	}
+}
+
+func TestIsPrivate(t *testing.T) {
+	tests := map[string]bool{
+		"private/report.csv":         true,
+		"/private/report.csv":        true,
+		"menu_images/../private/a":   true,
+		"menu_images/pizza.png":      false,
+		"privately/public.png":       false,
+		"menu_images/private/public": false,
+	}
+	for key, want := range tests {
+		if got := IsPrivate(key); got != want {
+			t.Errorf("IsPrivate(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
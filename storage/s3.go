@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Config configures an S3-compatible store. PublicURL defaults to the
+// path-style URL of the bucket; set it when files are served through a CDN
+// or a virtual-hosted bucket domain.
+type S3Config struct {
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	Region    string
+	Bucket    string
+	UseSSL    bool
+	PublicURL string
+}
+
+// S3 keeps files in a bucket of Amazon S3 or a compatible store such as MinIO.
+// Public reads are granted by the bucket policy, not per file.
+type S3 struct {
+	client    *minio.Client
+	bucket    string
+	publicURL string
+}
+
+func NewS3(config S3Config) (*S3, error) {
+	if config.Endpoint == "" || config.Bucket == "" {
+		return nil, fmt.Errorf("S3_ENDPOINT and STORAGE_BUCKET must be set")
+	}
+
+	client, err := minio.New(config.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(config.AccessKey, config.SecretKey, ""),
+		Secure: config.UseSSL,
+		Region: config.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %v", err)
+	}
+
+	publicURL := config.PublicURL
+	if publicURL == "" {
+		scheme := "http"
+		if config.UseSSL {
+			scheme = "https"
+		}
+		publicURL = fmt.Sprintf("%s://%s/%s", scheme, config.Endpoint, config.Bucket)
+	}
+
+	return &S3{client: client, bucket: config.Bucket, publicURL: publicURL}, nil
+}
+
+func (s *S3) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) (string, error) {
+	_, err := s.client.PutObject(ctx, s.bucket, key, body, size, minio.PutObjectOptions{ContentType: contentType})
+	if err != nil {
+		return "", err
+	}
+	return s.PublicURL(key), nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucket, keyOf(s.PublicURL(""), key), minio.RemoveObjectOptions{})
+}
+
+func (s *S3) PublicURL(key string) string {
+	return joinURL(s.publicURL, key)
+}
+
+func (s *S3) SignedURL(ctx context.Context, key string, expires time.Duration) (string, error) {
+	signed, err := s.client.PresignedGetObject(ctx, s.bucket, keyOf(s.PublicURL(""), key), expires, nil)
+	if err != nil {
+		return "", err
+	}
+	return signed.String(), nil
+}
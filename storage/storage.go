@@ -0,0 +1,110 @@
+// Package storage keeps uploaded files such as menu images and avatars. Files
+// are addressed by key, for example "menu_covers/1700000000_pizza.png", and
+// documents store the public URL that Put returns. The driver is chosen by
+// STORAGE_DRIVER and set up once at startup.
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Storage is a place to keep uploaded files.
+type Storage interface {
+	// Put stores a file under key and returns its public URL. size is -1
+	// when unknown.
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) (string, error)
+	// Delete removes a file by its key or by the URL Put returned. Deleting a
+	// file that does not exist is not an error.
+	Delete(ctx context.Context, key string) error
+	// PublicURL is the permanent URL of a file.
+	PublicURL(key string) string
+	// SignedURL is a URL of a file that stops working after expires.
+	SignedURL(ctx context.Context, key string, expires time.Duration) (string, error)
+}
+
+// Driver names for STORAGE_DRIVER.
+const (
+	DriverFirebase = "firebase"
+	DriverGCS      = "gcs"
+	DriverLocal    = "local"
+	DriverS3       = "s3"
+)
+
+// LocalRoute is where the API serves the files of the local driver.
+const LocalRoute = "/files"
+
+// PrivatePrefix starts the keys of files that the local driver only serves
+// through signed URLs.
+const PrivatePrefix = "private/"
+
+// IsPrivate tells whether a key is under PrivatePrefix.
+func IsPrivate(key string) bool {
+	return strings.HasPrefix(path.Clean("/"+key), "/"+PrivatePrefix)
+}
+
+// NewFromEnv sets up the driver named by STORAGE_DRIVER, Firebase by default.
+//
+//	firebase, gcs: STORAGE_BUCKET, FIREBASE_CREDENTIALS
+//	local:         STORAGE_LOCAL_DIR, STORAGE_PUBLIC_URL, STORAGE_SIGNING_KEY
+//	s3:            STORAGE_BUCKET, S3_ENDPOINT, S3_ACCESS_KEY, S3_SECRET_KEY,
+//	               S3_REGION, S3_USE_SSL, STORAGE_PUBLIC_URL
+func NewFromEnv(ctx context.Context) (Storage, error) {
+	driver := strings.ToLower(os.Getenv("STORAGE_DRIVER"))
+	switch driver {
+	case "", DriverFirebase, DriverGCS:
+		credentials := os.Getenv("FIREBASE_CREDENTIALS")
+		if credentials == "" {
+			credentials = "keys/se-reactjs-firebase-adminsdk.json"
+		}
+		return NewFirebase(ctx, credentials, os.Getenv("STORAGE_BUCKET"))
+
+	case DriverLocal:
+		dir := os.Getenv("STORAGE_LOCAL_DIR")
+		if dir == "" {
+			dir = "uploads"
+		}
+		publicURL := os.Getenv("STORAGE_PUBLIC_URL")
+		if publicURL == "" {
+			port := os.Getenv("PORT")
+			if port == "" {
+				port = "8000"
+			}
+			publicURL = "http://localhost:" + port + LocalRoute
+		}
+		return NewLocal(dir, publicURL, os.Getenv("STORAGE_SIGNING_KEY"))
+
+	case DriverS3:
+		useSSL, err := strconv.ParseBool(os.Getenv("S3_USE_SSL"))
+		if err != nil {
+			useSSL = true
+		}
+		return NewS3(S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("STORAGE_BUCKET"),
+			UseSSL:    useSSL,
+			PublicURL: os.Getenv("STORAGE_PUBLIC_URL"),
+		})
+	}
+	return nil, fmt.Errorf("unknown storage driver %q", driver)
+}
+
+// keyOf turns the public URL of a file back into its key. Keys are returned
+// as they are.
+func keyOf(publicURL string, key string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(key, publicURL), "/")
+}
+
+// joinURL appends a key to a base URL.
+func joinURL(base string, key string) string {
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(key, "/")
+}
@@ -9,29 +9,48 @@ import (
 )
 
 type SignedDetails struct {
-	Email   string
-	User_ID string
-	Role    int
+	Email      string
+	User_ID    string
+	Role       int
+	Session_ID string
+	Branch_ID  string
 	jwt.StandardClaims
 }
 
 var SECRET_KEY = os.Getenv("SECRET_KEY")
 var TOKEN_EXPIRE = os.Getenv("TOKEN_EXPIRE")
+var ACCESS_TOKEN_EXPIRE = os.Getenv("ACCESS_TOKEN_EXPIRE")
 var TOKEN_EXPIRE_INT int
 
-func TokenGenerator(email string, userId string, role int) (signedtoken string, err error) {
+// RefreshTokenExpiry is how long a login session (and its refresh token) lives,
+// TOKEN_EXPIRE days from now.
+func RefreshTokenExpiry() time.Time {
 	TOKEN_EXPIRE_INT, err := strconv.Atoi(TOKEN_EXPIRE)
-
 	if err != nil {
 		TOKEN_EXPIRE_INT = 1
 	}
 
+	return time.Now().Local().Add(time.Duration(TOKEN_EXPIRE_INT) * time.Hour * 24)
+}
+
+// TokenGenerator issues a short-lived access token (ACCESS_TOKEN_EXPIRE minutes)
+// bound to a login session so it stops working once the session is revoked. The
+// branch claim is what requests are authorized against.
+func TokenGenerator(email string, userId string, role int, branchId string, sessionId string) (signedtoken string, err error) {
+	accessTokenExpire, err := strconv.Atoi(ACCESS_TOKEN_EXPIRE)
+	if err != nil {
+		accessTokenExpire = 15
+	}
+
 	claims := &SignedDetails{
-		Email:   email,
-		User_ID: userId,
-		Role:    role,
+		Email:      email,
+		User_ID:    userId,
+		Role:       role,
+		Session_ID: sessionId,
+		Branch_ID:  branchId,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Local().Add(time.Duration(TOKEN_EXPIRE_INT) * time.Hour * 24).Unix(),
+			Subject:   "access",
+			ExpiresAt: time.Now().Local().Add(time.Duration(accessTokenExpire) * time.Minute).Unix(),
 		},
 	}
 
@@ -42,6 +61,34 @@ func TokenGenerator(email string, userId string, role int) (signedtoken string,
 	return token, err
 }
 
+// TerminalTokenGenerator issues the access token for a PIN login on a registered
+// terminal. It lives PIN_TOKEN_EXPIRE minutes and is scoped to the terminal's branch.
+func TerminalTokenGenerator(email string, userId string, role int, sessionId string, branchId string) (signedtoken string, expiresAt time.Time, err error) {
+	pinTokenExpire, err := strconv.Atoi(os.Getenv("PIN_TOKEN_EXPIRE"))
+	if err != nil {
+		pinTokenExpire = 60
+	}
+	expiresAt = time.Now().Local().Add(time.Duration(pinTokenExpire) * time.Minute)
+
+	claims := &SignedDetails{
+		Email:      email,
+		User_ID:    userId,
+		Role:       role,
+		Session_ID: sessionId,
+		Branch_ID:  branchId,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   "access",
+			ExpiresAt: expiresAt.Unix(),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(SECRET_KEY))
+	if err != nil {
+		return "", expiresAt, err
+	}
+	return token, expiresAt, err
+}
+
 func ValidateToken(signedtoken string) (claims *SignedDetails, msg string) {
 	token, err := jwt.ParseWithClaims(signedtoken, &SignedDetails{}, func(token *jwt.Token) (interface{}, error) {
 		return []byte(SECRET_KEY), nil
@@ -52,7 +99,141 @@ func ValidateToken(signedtoken string) (claims *SignedDetails, msg string) {
 		return
 	}
 	claims, ok := token.Claims.(*SignedDetails)
-	if !ok {
+	if !ok || claims.Subject != "access" || claims.Session_ID == "" {
+		msg = "The Token is invalid"
+		return
+	}
+	if claims.ExpiresAt < time.Now().Local().Unix() {
+		msg = "token is expired"
+		return
+	}
+	return claims, msg
+}
+
+type TableDetails struct {
+	Branch_ID string
+	Table_ID  string
+	Nonce     string
+	jwt.StandardClaims
+}
+
+// TableTokenGenerator signs the token printed on a table's QR code. It does not
+// expire; rotating the table nonce is what invalidates previously printed codes.
+func TableTokenGenerator(branchId string, tableId string, nonce string) (signedtoken string, err error) {
+	claims := &TableDetails{
+		Branch_ID: branchId,
+		Table_ID:  tableId,
+		Nonce:     nonce,
+		StandardClaims: jwt.StandardClaims{
+			Subject:  "table",
+			IssuedAt: time.Now().Local().Unix(),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(SECRET_KEY))
+	if err != nil {
+		return "", err
+	}
+	return token, err
+}
+
+func ValidateTableToken(signedtoken string) (claims *TableDetails, msg string) {
+	token, err := jwt.ParseWithClaims(signedtoken, &TableDetails{}, func(token *jwt.Token) (interface{}, error) {
+		return []byte(SECRET_KEY), nil
+	})
+
+	if err != nil {
+		msg = err.Error()
+		return
+	}
+	claims, ok := token.Claims.(*TableDetails)
+	if !ok || claims.Subject != "table" || claims.Table_ID == "" || claims.Nonce == "" {
+		msg = "The Token is invalid"
+		return
+	}
+	return claims, msg
+}
+
+// PreAuthTokenGenerator issues the token returned by a password login that still
+// needs a second factor. It is only accepted by the two-factor login endpoints.
+func PreAuthTokenGenerator(userId string) (signedtoken string, err error) {
+	claims := &SignedDetails{
+		User_ID: userId,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   "pre_auth",
+			ExpiresAt: time.Now().Local().Add(5 * time.Minute).Unix(),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(SECRET_KEY))
+	if err != nil {
+		return "", err
+	}
+	return token, err
+}
+
+func ValidatePreAuthToken(signedtoken string) (claims *SignedDetails, msg string) {
+	token, err := jwt.ParseWithClaims(signedtoken, &SignedDetails{}, func(token *jwt.Token) (interface{}, error) {
+		return []byte(SECRET_KEY), nil
+	})
+
+	if err != nil {
+		msg = err.Error()
+		return
+	}
+	claims, ok := token.Claims.(*SignedDetails)
+	if !ok || claims.Subject != "pre_auth" || claims.User_ID == "" {
+		msg = "The Token is invalid"
+		return
+	}
+	if claims.ExpiresAt < time.Now().Local().Unix() {
+		msg = "token is expired"
+		return
+	}
+	return claims, msg
+}
+
+type CustomerDetails struct {
+	Customer_ID string
+	jwt.StandardClaims
+}
+
+// CustomerTokenGenerator issues the token a customer signs in with. It lives
+// CUSTOMER_TOKEN_EXPIRE days and is only accepted on customer endpoints.
+func CustomerTokenGenerator(customerId string) (signedtoken string, expiresAt time.Time, err error) {
+	customerTokenExpire, err := strconv.Atoi(os.Getenv("CUSTOMER_TOKEN_EXPIRE"))
+	if err != nil {
+		customerTokenExpire = 30
+	}
+	expiresAt = time.Now().Local().Add(time.Duration(customerTokenExpire) * time.Hour * 24)
+
+	claims := &CustomerDetails{
+		Customer_ID: customerId,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   "customer",
+			IssuedAt:  time.Now().Local().Unix(),
+			ExpiresAt: expiresAt.Unix(),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(SECRET_KEY))
+	if err != nil {
+		return "", expiresAt, err
+	}
+	return token, expiresAt, err
+}
+
+func ValidateCustomerToken(signedtoken string) (claims *CustomerDetails, msg string) {
+	token, err := jwt.ParseWithClaims(signedtoken, &CustomerDetails{}, func(token *jwt.Token) (interface{}, error) {
+		return []byte(SECRET_KEY), nil
+	})
+
+	if err != nil {
+		msg = err.Error()
+		return
+	}
+	claims, ok := token.Claims.(*CustomerDetails)
+	if !ok || claims.Subject != "customer" || claims.Customer_ID == "" {
 		msg = "The Token is invalid"
 		return
 	}